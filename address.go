@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Address fields are held in FormData as a JSON object string and saved as a
+// nested object. The model fills them in one go with ADDRESS Field {json}.
+
+// postalAddress is the normalized shape of an [address] field
+type postalAddress struct {
+	Street     string `json:"street"`
+	Street2    string `json:"street2,omitempty"`
+	City       string `json:"city"`
+	State      string `json:"state,omitempty"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+// isAddressField reports whether a field was declared with the [address] type
+func isAddressField(form ConfigurationForm, name string) bool {
+	f, ok := formFieldByName(form, name)
+	return ok && f.Type == "address"
+}
+
+// addressFieldNames are the form's fields declared with the [address] type
+func addressFieldNames(form ConfigurationForm) []string {
+	var names []string
+	for _, f := range formFields(form) {
+		if f.Type == "address" {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+// parseAddress reads an ADDRESS value, trimming each part, upper-casing the
+// state and country codes and defaulting the country to region. It fails
+// when the JSON is malformed or the street, city or postal code is missing.
+func parseAddress(value, region string) (postalAddress, error) {
+	var addr postalAddress
+	if err := json.Unmarshal([]byte(strings.TrimSpace(value)), &addr); err != nil {
+		return addr, fmt.Errorf("not a JSON address object: %v", err)
+	}
+	addr.Street = strings.Join(strings.Fields(addr.Street), " ")
+	addr.Street2 = strings.Join(strings.Fields(addr.Street2), " ")
+	addr.City = strings.Join(strings.Fields(addr.City), " ")
+	addr.State = strings.TrimSpace(addr.State)
+	if len(addr.State) <= 3 {
+		addr.State = strings.ToUpper(addr.State)
+	}
+	addr.PostalCode = strings.ToUpper(strings.Join(strings.Fields(addr.PostalCode), " "))
+	addr.Country = strings.ToUpper(strings.TrimSpace(addr.Country))
+	if addr.Country == "" {
+		addr.Country = strings.ToUpper(region)
+	}
+
+	var missing []string
+	if addr.Street == "" {
+		missing = append(missing, "street")
+	}
+	if addr.City == "" {
+		missing = append(missing, "city")
+	}
+	if addr.PostalCode == "" {
+		missing = append(missing, "postal_code")
+	}
+	if len(missing) > 0 {
+		return addr, fmt.Errorf("missing %s", strings.Join(missing, ", "))
+	}
+	if len(addr.Country) != 2 {
+		return addr, fmt.Errorf("country %q is not a two-letter code", addr.Country)
+	}
+	return addr, nil
+}
+
+func encodeAddress(addr postalAddress) string {
+	data, _ := json.Marshal(addr)
+	return string(data)
+}
+
+// decodeAddress turns a stored address back into an object for the saved
+// record, keeping the raw string if it isn't one
+func decodeAddress(value string) interface{} {
+	var addr map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &addr); err != nil {
+		return value
+	}
+	return addr
+}
+
+// addressLine formats a saved address on one line for display
+func addressLine(addr map[string]interface{}) string {
+	part := func(key string) string {
+		s, _ := addr[key].(string)
+		return s
+	}
+	var parts []string
+	for _, p := range []string{part("street"), part("street2"), part("city"),
+		strings.TrimSpace(part("state") + " " + part("postal_code")), part("country")} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// addressPrompt tells the model how to fill the form's address fields
+func addressPrompt(form ConfigurationForm) (string, bool) {
+	names := addressFieldNames(form)
+	if len(names) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf(
+		"These fields are postal addresses: %s.\n"+
+			"Once you have the whole address, set it on one line with a JSON object like:\n"+
+			`ADDRESS %s {"street": "12 Main St", "street2": "Apt 4", "city": "Springfield", "state": "IL", "postal_code": "62701", "country": "US"}`+"\n"+
+			"street, city and postal_code are required; street2 and state may be left out, and country is a two-letter code.\n"+
+			"An address missing a required part is ignored, so ask for it first.",
+		strings.Join(names, ", "), names[0],
+	), true
+}
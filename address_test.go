@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// addressForm is the test form with a postal address field
+func addressForm() ConfigurationForm {
+	form := testForm("f")
+	form.Fields += "\nHome: {{.Home}} [address]"
+	return form
+}
+
+func TestParseAddress(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    postalAddress
+		wantErr string
+	}{
+		{"complete", `{"street": "12 Main St", "city": "Springfield", "state": "IL", "postal_code": "62701", "country": "US"}`,
+			postalAddress{Street: "12 Main St", City: "Springfield", State: "IL", PostalCode: "62701", Country: "US"}, ""},
+		{"normalized", `{"street": " 10  Downing St ", "city": "London", "postal_code": "sw1a  2aa", "country": "gb"}`,
+			postalAddress{Street: "10 Downing St", City: "London", PostalCode: "SW1A 2AA", Country: "GB"}, ""},
+		{"country from region", `{"street": "1 Rue de Rivoli", "city": "Paris", "state": "idf", "postal_code": "75001"}`,
+			postalAddress{Street: "1 Rue de Rivoli", City: "Paris", State: "IDF", PostalCode: "75001", Country: "FR"}, ""},
+		{"long state kept", `{"street": "1 Elm St", "city": "Austin", "state": "Texas", "postal_code": "73301"}`,
+			postalAddress{Street: "1 Elm St", City: "Austin", State: "Texas", PostalCode: "73301", Country: "FR"}, ""},
+		{"missing parts", `{"street": "12 Main St"}`, postalAddress{}, "missing city, postal_code"},
+		{"bad country", `{"street": "12 Main St", "city": "Springfield", "postal_code": "62701", "country": "USA"}`, postalAddress{}, "not a two-letter code"},
+		{"not json", `12 Main St, Springfield`, postalAddress{}, "not a JSON address object"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAddress(tt.value, "fr")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("parseAddress = %+v, %v; want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressLine(t *testing.T) {
+	tests := []struct {
+		addr map[string]interface{}
+		want string
+	}{
+		{map[string]interface{}{"street": "12 Main St", "street2": "Apt 4", "city": "Springfield", "state": "IL", "postal_code": "62701", "country": "US"},
+			"12 Main St, Apt 4, Springfield, IL 62701, US"},
+		{map[string]interface{}{"street": "10 Downing St", "city": "London", "postal_code": "SW1A 2AA", "country": "GB"},
+			"10 Downing St, London, SW1A 2AA, GB"},
+	}
+	for _, tt := range tests {
+		if got := addressLine(tt.addr); got != tt.want {
+			t.Errorf("addressLine = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestAddressCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		field string
+		want  string
+	}{
+		{"set", `ADDRESS Home {"street": "12 Main St", "city": "Springfield", "postal_code": "62701", "country": "us"}`,
+			"Home", `{"street":"12 Main St","city":"Springfield","postal_code":"62701","country":"US"}`},
+		{"incomplete", `ADDRESS Home {"street": "12 Main St"}`, "Home", ""},
+		{"not an address field", `ADDRESS FirstName {"street": "12 Main St", "city": "Springfield", "postal_code": "62701"}`, "FirstName", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeChat(t, tt.reply+"\nSAY Thanks")
+			config := testConfig(t, addressForm())
+			client := newClientID()
+			r := postJSON("/form/f/chat", `{"message": "I live at 12 Main St"}`)
+			r.Header.Set(sessionIDHeader, client)
+			handleChat(httptest.NewRecorder(), r, config, "f")
+			if got := callerSession(config, "f", r).FormData[tt.field]; got != tt.want {
+				t.Errorf("%s = %q, want %q", tt.field, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressIsSavedAsAnObject(t *testing.T) {
+	config := testConfig(t, addressForm())
+	savedRecord(t, config, "f", map[string]string{
+		"FirstName": "Ann",
+		"License":   "A1",
+		"Home":      `{"street":"12 Main St","city":"Springfield","postal_code":"62701","country":"US"}`,
+	})
+	path, _ := storedRecordPath(config, "f", "A1")
+	record, _ := readRecord(path)
+	want := map[string]interface{}{"street": "12 Main St", "city": "Springfield", "postal_code": "62701", "country": "US"}
+	if !reflect.DeepEqual(record["Home"], want) {
+		t.Errorf("saved Home = %#v, want %#v", record["Home"], want)
+	}
+}
+
+func TestAddressPromptOnlyForFormsWithAddresses(t *testing.T) {
+	if _, ok := addressPrompt(testForm("f")); ok {
+		t.Error("address prompt for a form without address fields")
+	}
+	if prompt, ok := addressPrompt(addressForm()); !ok || !strings.Contains(prompt, "ADDRESS Home {") {
+		t.Errorf("address prompt = %q, %v", prompt, ok)
+	}
+}
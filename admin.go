@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Environment variable holding the bearer token for the admin endpoints;
+// they are not served when it is unset
+const adminTokenEnv = "GOCHAT_ADMIN_TOKEN"
+
+// Replaces secrets in the configuration export
+const redacted = "[redacted]"
+
+// effectiveSettings are the values the server runs with once defaults and
+// the environment are applied, alongside the configuration as loaded
+type effectiveSettings struct {
+	Mode           string `json:"mode" xml:"mode"`
+	DataDir        string `json:"data_dir" xml:"data_dir"`
+	SaveFormat     string `json:"save_format" xml:"save_format"`
+	Timezone       string `json:"timezone" xml:"timezone"`
+	SSEKeepalive   string `json:"sse_keepalive" xml:"sse_keepalive"`
+	RequestTimeout string `json:"request_timeout" xml:"request_timeout"`
+	MaxHeaderBytes int    `json:"max_header_bytes" xml:"max_header_bytes"`
+	MaxBodyBytes   int64  `json:"max_body_bytes" xml:"max_body_bytes"`
+	OpenAIAPIKey   string `json:"openai_api_key" xml:"openai_api_key"`
+}
+
+// configExport is the body of GET /admin/config
+type configExport struct {
+	XMLName       xml.Name          `json:"-" xml:"effective_config"`
+	Effective     effectiveSettings `json:"effective" xml:"effective"`
+	Configuration Configuration     `json:"configuration" xml:"configuration"`
+}
+
+// redactURL hides credentials in a URL: the password and any query values
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || raw == "" {
+		return raw
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	if u.RawQuery != "" {
+		query := u.Query()
+		for k := range query {
+			query.Set(k, redacted)
+		}
+		u.RawQuery = query.Encode()
+	}
+	return u.String()
+}
+
+// redactSecret reports a secret's presence without its value
+func redactSecret(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return redacted
+}
+
+// exportConfig resolves defaults and environment settings and redacts secrets
+func exportConfig(config Configuration) configExport {
+	config.HTTPProxy = redactURL(config.HTTPProxy)
+	sinks := make([]SinkConfig, len(config.Sinks.Sink))
+	for i, sink := range config.Sinks.Sink {
+		sink.URL = redactURL(sink.URL)
+		sinks[i] = sink
+	}
+	config.Sinks.Sink = sinks
+	forms := make([]ConfigurationForm, len(config.Forms.Form))
+	for i, form := range config.Forms.Form {
+		steps := make([]PipelineStep, len(form.Pipeline.Step))
+		for j, step := range form.Pipeline.Step {
+			step.URL = redactURL(step.URL)
+			steps[j] = step
+		}
+		form.Pipeline.Step = steps
+		forms[i] = form
+	}
+	config.Forms.Form = forms
+
+	mode := config.Mode
+	if mode == "" {
+		mode = "forms"
+	}
+	saveFormat := config.SaveFormat
+	if _, ok := recordFormats[saveFormat]; !ok {
+		saveFormat = "json"
+	}
+	return configExport{
+		Effective: effectiveSettings{
+			Mode:           mode,
+			DataDir:        dataDir(config),
+			SaveFormat:     saveFormat,
+			Timezone:       config.location().String(),
+			SSEKeepalive:   config.KeepaliveInterval().String(),
+			RequestTimeout: config.RequestTimeoutDuration().String(),
+			MaxHeaderBytes: config.Server.HeaderLimit(),
+			MaxBodyBytes:   config.Server.BodyLimit(),
+			OpenAIAPIKey:   redactSecret(os.Getenv("OPENAI_API_KEY")),
+		},
+		Configuration: config,
+	}
+}
+
+// adminAuthorized checks the request's bearer token against GOCHAT_ADMIN_TOKEN
+func adminAuthorized(r *http.Request) bool {
+	token := os.Getenv(adminTokenEnv)
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// allowAdmin answers requests that may not use the admin endpoints: 404 when
+// no admin token is set, 401 without the right token. It reports whether the
+// request may go ahead.
+func allowAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if os.Getenv(adminTokenEnv) == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	if !adminAuthorized(r) {
+		log.Printf("🔐 ADMIN: rejected %s from %s", r.URL.Path, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleAdminConfig returns the configuration the server actually loaded, as
+// JSON or, when the client asks for it, XML
+func handleAdminConfig(w http.ResponseWriter, r *http.Request, config Configuration) {
+	if !allowAdmin(w, r) {
+		return
+	}
+
+	export := exportConfig(config)
+	if r.URL.Query().Get("format") == "xml" || strings.Contains(r.Header.Get("Accept"), "xml") {
+		w.Header().Set("Content-Type", "application/xml")
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(export); err != nil {
+			log.Printf("❌ ERROR: Failed to encode config: %v", err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(export); err != nil {
+		log.Printf("❌ ERROR: Failed to encode config: %v", err)
+	}
+}
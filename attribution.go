@@ -0,0 +1,43 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// captureAttribution records the form's configured query parameters and, if
+// asked, the referrer from the request that opened the form. Only values that
+// are present are kept, so a later plain visit doesn't erase the attribution.
+func captureAttribution(w http.ResponseWriter, config Configuration, formName string, r *http.Request) {
+	form := config.FormByName(formName)
+	params := splitFieldList(form.CaptureParams)
+	if len(params) == 0 && !form.CaptureReferrer {
+		return
+	}
+
+	captured := make(map[string]string)
+	query := r.URL.Query()
+	for _, param := range params {
+		if value := strings.TrimSpace(query.Get(param)); value != "" {
+			captured[param] = value
+		}
+	}
+	if referrer := r.Referer(); form.CaptureReferrer && referrer != "" {
+		captured["referrer"] = referrer
+	}
+	if len(captured) == 0 {
+		return
+	}
+
+	session := getOrCreateSession(w, config, formName, r)
+	session.turnMu.Lock()
+	defer session.turnMu.Unlock()
+	if session.Attribution == nil {
+		session.Attribution = make(map[string]string)
+	}
+	for k, v := range captured {
+		session.Attribution[k] = v
+	}
+	log.Printf("🏷️ META [%s]: captured %v", formName, captured)
+}
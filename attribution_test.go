@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCaptureAttribution(t *testing.T) {
+	form := testForm("f")
+	form.CaptureParams = "utm_source, utm_campaign"
+	form.CaptureReferrer = true
+	config := testConfig(t, form)
+	client := newClientID()
+	open := func(url, referrer string) {
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set(sessionIDHeader, client)
+		if referrer != "" {
+			r.Header.Set("Referer", referrer)
+		}
+		captureAttribution(httptest.NewRecorder(), config, "f", r)
+	}
+
+	open("/form/f?utm_source=mail&utm_campaign=spring&other=x", "https://news.example.com/")
+	// A later plain visit keeps what was captured
+	open("/form/f", "")
+	open("/form/f?utm_campaign=summer", "")
+
+	r := httptest.NewRequest(http.MethodGet, "/form/f", nil)
+	r.Header.Set(sessionIDHeader, client)
+	session := callerSession(config, "f", r)
+	if session == nil {
+		t.Fatal("no session was started")
+	}
+	want := map[string]string{"utm_source": "mail", "utm_campaign": "summer", "referrer": "https://news.example.com/"}
+	if !reflect.DeepEqual(session.Attribution, want) {
+		t.Fatalf("attribution = %v, want %v", session.Attribution, want)
+	}
+
+	session.FormData = map[string]string{"FirstName": "Ann", "License": "A1"}
+	if err := saveSession(config, "f", session, newTurnResult(config, "f", session)); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := storedRecordPath(config, "f", "A1")
+	record, _ := readRecord(path)
+	if meta, _ := record["_meta"].(map[string]interface{}); meta["utm_source"] != "mail" {
+		t.Errorf("saved _meta = %v", record["_meta"])
+	}
+}
+
+func TestCaptureAttributionWithoutParamsStartsNoSession(t *testing.T) {
+	config := testConfig(t, testForm("f"))
+	r := httptest.NewRequest(http.MethodGet, "/form/f?utm_source=mail", nil)
+	r.Header.Set(sessionIDHeader, newClientID())
+	captureAttribution(httptest.NewRecorder(), config, "f", r)
+	if callerSession(config, "f", r) != nil {
+		t.Error("a session was started for a form that captures nothing")
+	}
+}
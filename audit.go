@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// promptLogEntry is one request to the model as recorded in a form's prompt log
+type promptLogEntry struct {
+	Time     time.Time     `json:"time"`
+	Form     string        `json:"form"`
+	Model    string        `json:"model"`
+	Kind     string        `json:"kind"`
+	Messages []ChatMessage `json:"messages"`
+}
+
+// Serializes appends to the prompt logs
+var promptLogMu sync.Mutex
+
+// promptLogPath is the form's append-only prompt log
+func promptLogPath(config Configuration, formName string) string {
+	return filepath.Join(formDataDir(config, formName), "prompts.jsonl")
+}
+
+// logPrompt appends the exact messages sent to the model for a turn to the
+// form's prompt log, with scrub rules applied, when the form has log_prompts.
+// kind is "turn" for the turn's request, "reprompt" for a protocol retry
+// or "rephrase" for a repeated reply.
+func logPrompt(config Configuration, formName, kind string, messages []ChatMessage) {
+	if !config.FormByName(formName).LogPrompts {
+		return
+	}
+	entry := promptLogEntry{
+		Time:     time.Now().UTC(),
+		Form:     formName,
+		Model:    config.Model,
+		Kind:     kind,
+		Messages: make([]ChatMessage, len(messages)),
+	}
+	for i, msg := range messages {
+		entry.Messages[i] = ChatMessage{Role: msg.Role, Content: scrubText(msg.Content)}
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("❌ AUDIT [%s]: %v", formName, err)
+		return
+	}
+
+	promptLogMu.Lock()
+	defer promptLogMu.Unlock()
+	if err := os.MkdirAll(formDataDir(config, formName), 0755); err != nil {
+		log.Printf("❌ AUDIT [%s]: %v", formName, err)
+		return
+	}
+	f, err := os.OpenFile(promptLogPath(config, formName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("❌ AUDIT [%s]: %v", formName, err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		log.Printf("❌ AUDIT [%s]: %v", formName, err)
+	}
+}
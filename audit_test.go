@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPromptLog(t *testing.T) {
+	useScrubRules(t, ScrubRule{Name: "ssn", Pattern: `\d{3}-\d{2}-\d{4}`})
+	tests := []struct {
+		name        string
+		logPrompts  bool
+		wantEntries int
+	}{
+		{"enabled", true, 1},
+		{"disabled", false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeChat(t, "SAY Thanks")
+			form := testForm("f")
+			form.LogPrompts = tt.logPrompts
+			config := testConfig(t, form)
+
+			handleChat(httptest.NewRecorder(), postJSON("/form/f/chat", `{"message": "my license is 555-55-5555"}`), config, "f")
+			content, err := os.ReadFile(promptLogPath(config, "f"))
+			if tt.wantEntries == 0 {
+				if err == nil {
+					t.Errorf("prompt log written while disabled:\n%s", content)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			var entry promptLogEntry
+			if len(lines) != tt.wantEntries || json.Unmarshal([]byte(lines[0]), &entry) != nil {
+				t.Fatalf("prompt log =\n%s", content)
+			}
+			last := entry.Messages[len(entry.Messages)-1]
+			if entry.Kind != "turn" || entry.Model != "gpt-test" || last.Content != "my license is [REDACTED]" {
+				t.Errorf("entry = %+v, want the scrubbed turn", entry)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+// Branding is how a form's pages look. The global branding applies to every
+// form, and each form may override any part of it.
+type Branding struct {
+	SiteTitle    string `xml:"site_title"`
+	LogoURL      string `xml:"logo_url"`
+	PrimaryColor string `xml:"primary_color"`
+}
+
+// Used when no primary color is configured
+const defaultPrimaryColor = "#007bff"
+
+// BrandingFor resolves a form's branding: its own settings first, then the
+// global branding, then site_title and the default color
+func (c Configuration) BrandingFor(form ConfigurationForm) Branding {
+	return Branding{
+		SiteTitle:    firstNonEmpty(form.Branding.SiteTitle, c.Branding.SiteTitle, c.SiteTitle),
+		LogoURL:      firstNonEmpty(form.Branding.LogoURL, c.Branding.LogoURL),
+		PrimaryColor: firstNonEmpty(form.Branding.PrimaryColor, c.Branding.PrimaryColor, defaultPrimaryColor),
+	}
+}
+
+// firstNonEmpty returns the first of values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestBrandingFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		global Branding
+		form   Branding
+		want   Branding
+	}{
+		{
+			"defaults",
+			Branding{}, Branding{},
+			Branding{SiteTitle: "Site", PrimaryColor: defaultPrimaryColor},
+		},
+		{
+			"global branding",
+			Branding{SiteTitle: "Acme", LogoURL: "/logo.png", PrimaryColor: "#111"}, Branding{},
+			Branding{SiteTitle: "Acme", LogoURL: "/logo.png", PrimaryColor: "#111"},
+		},
+		{
+			"form overrides part of it",
+			Branding{SiteTitle: "Acme", LogoURL: "/logo.png", PrimaryColor: "#111"}, Branding{PrimaryColor: "#222"},
+			Branding{SiteTitle: "Acme", LogoURL: "/logo.png", PrimaryColor: "#222"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{SiteTitle: "Site", Branding: tt.global}
+			if got := config.BrandingFor(ConfigurationForm{Branding: tt.form}); got != tt.want {
+				t.Errorf("BrandingFor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// CircuitBreakerConfig stops calls to the AI service after repeated failures.
+// Its state is saved in the data directory so a restart honors an open circuit.
+type CircuitBreakerConfig struct {
+	// Consecutive failures that open the circuit; zero disables the breaker
+	Failures int `xml:"failures"`
+	// How long the circuit stays open before a call is let through (default 30s)
+	Cooldown string `xml:"cooldown"`
+}
+
+// Used when the breaker's cooldown is not configured
+const defaultBreakerCooldown = 30 * time.Second
+
+// CooldownDuration parses the cooldown, falling back to the default
+func (c CircuitBreakerConfig) CooldownDuration() time.Duration {
+	if d, err := time.ParseDuration(c.Cooldown); err == nil && d > 0 {
+		return d
+	}
+	return defaultBreakerCooldown
+}
+
+var errCircuitOpen = errors.New("AI service circuit is open")
+
+// breakerState is what is persisted across restarts
+type breakerState struct {
+	Failures  int       `json:"failures"`
+	OpenUntil time.Time `json:"open_until"`
+}
+
+// circuitBreaker counts consecutive AI service failures. Once the circuit
+// is open calls fail fast until the cooldown passes; a single trial call then
+// goes through while the others keep failing fast, and its failure reopens
+// the circuit straight away.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	path      string
+	state     breakerState
+	// Set while the half-open circuit's trial call is in flight; it lapses
+	// after a cooldown so a trial that never reports back can't wedge it
+	trialUntil time.Time
+}
+
+// Global AI service breaker, nil when circuit_breaker is not configured
+var aiBreaker *circuitBreaker
+
+// breakerStatePath is where the breaker's state is kept
+func breakerStatePath(config Configuration) string {
+	return filepath.Join(dataDir(config), "circuit.json")
+}
+
+// newCircuitBreaker creates a breaker, restoring the state saved at path
+func newCircuitBreaker(config CircuitBreakerConfig, path string) *circuitBreaker {
+	b := &circuitBreaker{threshold: config.Failures, cooldown: config.CooldownDuration(), path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return b
+	}
+	if err := json.Unmarshal(data, &b.state); err != nil {
+		log.Printf("⚠️ BREAKER: ignoring unreadable state in %s: %v", path, err)
+		b.state = breakerState{}
+		return b
+	}
+	if time.Now().Before(b.state.OpenUntil) {
+		log.Printf("🔌 BREAKER: circuit restored open until %s", b.state.OpenUntil.Format(time.RFC3339))
+	}
+	return b
+}
+
+// configureCircuitBreaker creates the AI service breaker when it is enabled
+func configureCircuitBreaker(config Configuration) {
+	if config.CircuitBreaker.Failures > 0 {
+		aiBreaker = newCircuitBreaker(config.CircuitBreaker, breakerStatePath(config))
+	}
+}
+
+// allow reports errCircuitOpen while the circuit is open, and while it is
+// half-open for every call but the trial one
+func (b *circuitBreaker) allow(now time.Time) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if now.Before(b.state.OpenUntil) {
+		return fmt.Errorf("%w until %s", errCircuitOpen, b.state.OpenUntil.Format(time.RFC3339))
+	}
+	if b.state.Failures >= b.threshold {
+		if now.Before(b.trialUntil) {
+			return fmt.Errorf("%w while a trial call is in flight", errCircuitOpen)
+		}
+		b.trialUntil = now.Add(b.cooldown)
+	}
+	return nil
+}
+
+// abandon gives up the trial slot of a call that ended without an outcome,
+// such as one the user cancelled
+func (b *circuitBreaker) abandon() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialUntil = time.Time{}
+}
+
+// record counts a call's outcome, opening the circuit at the threshold
+func (b *circuitBreaker) record(failed bool, now time.Time) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trialUntil = time.Time{}
+	previous := b.state
+	if !failed {
+		b.state = breakerState{}
+	} else {
+		b.state.Failures++
+		if b.state.Failures >= b.threshold {
+			b.state.OpenUntil = now.Add(b.cooldown)
+			log.Printf("🔌 BREAKER: %d consecutive failures, circuit open for %s", b.state.Failures, b.cooldown)
+		}
+	}
+	if b.state != previous {
+		b.save()
+	}
+}
+
+// save persists the state; the caller holds mu
+func (b *circuitBreaker) save() {
+	data, err := json.Marshal(b.state)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(b.path), 0755)
+	}
+	if err == nil {
+		err = os.WriteFile(b.path, data, 0644)
+	}
+	if err != nil {
+		log.Printf("⚠️ BREAKER: failed to save state: %v", err)
+	}
+}
+
+// status describes the breaker for /healthz
+func (b *circuitBreaker) status(now time.Time) map[string]interface{} {
+	if b == nil {
+		return map[string]interface{}{"state": "disabled"}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	status := map[string]interface{}{"state": "closed", "failures": b.state.Failures}
+	switch {
+	case now.Before(b.state.OpenUntil):
+		status["state"] = "open"
+		status["open_until"] = b.state.OpenUntil
+	case b.state.Failures >= b.threshold:
+		status["state"] = "half-open"
+	}
+	return status
+}
+
+// doChatRequest sends a request to the AI service through the breaker. Network
+// errors and 5xx or 429 responses count as failures; a cancelled request doesn't.
+func doChatRequest(req *http.Request) (*http.Response, error) {
+	if err := aiBreaker.allow(time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := chatClient.Do(req)
+	// A stream's request_timeout cancels with a deadline as the cause, which
+	// counts as a failure like any other timeout
+	if err != nil && errors.Is(context.Cause(req.Context()), context.Canceled) {
+		aiBreaker.abandon()
+		return nil, err
+	}
+	aiBreaker.record(err != nil || resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests, time.Now())
+	return resp, err
+}
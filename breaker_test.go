@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	type step struct {
+		op       string // allow, fail, ok or abandon
+		at       time.Duration
+		wantOpen bool
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"below the threshold", []step{
+			{"fail", 0, false}, {"allow", 0, false},
+		}},
+		{"opens at the threshold", []step{
+			{"fail", 0, false}, {"fail", 0, false}, {"allow", time.Second, true},
+		}},
+		{"success resets the count", []step{
+			{"fail", 0, false}, {"ok", 0, false}, {"fail", 0, false}, {"allow", 0, false},
+		}},
+		{"single trial once half-open", []step{
+			{"fail", 0, false}, {"fail", 0, false},
+			{"allow", time.Minute, false}, {"allow", time.Minute, true},
+		}},
+		{"failed trial reopens", []step{
+			{"fail", 0, false}, {"fail", 0, false},
+			{"allow", time.Minute, false}, {"fail", time.Minute, false}, {"allow", time.Minute + time.Second, true},
+		}},
+		{"successful trial closes", []step{
+			{"fail", 0, false}, {"fail", 0, false},
+			{"allow", time.Minute, false}, {"ok", time.Minute, false}, {"allow", time.Minute, false}, {"allow", time.Minute, false},
+		}},
+		{"abandoned trial frees the slot", []step{
+			{"fail", 0, false}, {"fail", 0, false},
+			{"allow", time.Minute, false}, {"abandon", time.Minute, false}, {"allow", time.Minute, false},
+		}},
+		{"trial that never reports back lapses", []step{
+			{"fail", 0, false}, {"fail", 0, false},
+			{"allow", time.Minute, false}, {"allow", 2 * time.Minute, false},
+		}},
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "circuit.json")
+			b := newCircuitBreaker(CircuitBreakerConfig{Failures: 2, Cooldown: "30s"}, path)
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				switch s.op {
+				case "allow":
+					err := b.allow(now)
+					if open := errors.Is(err, errCircuitOpen); open != s.wantOpen {
+						t.Fatalf("step %d: allow = %v, want open %v", i, err, s.wantOpen)
+					}
+				case "fail", "ok":
+					b.record(s.op == "fail", now)
+				case "abandon":
+					b.abandon()
+				}
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerStateSurvivesRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "circuit.json")
+	config := CircuitBreakerConfig{Failures: 1, Cooldown: "1h"}
+	newCircuitBreaker(config, path).record(true, time.Now())
+
+	restarted := newCircuitBreaker(config, path)
+	if err := restarted.allow(time.Now()); !errors.Is(err, errCircuitOpen) {
+		t.Errorf("restored breaker allow = %v, want the circuit open", err)
+	}
+	if state := restarted.status(time.Now())["state"]; state != "open" {
+		t.Errorf("status state = %v, want open", state)
+	}
+}
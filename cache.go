@@ -0,0 +1,181 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCacheConfig controls the optional completion cache
+type ResponseCacheConfig struct {
+	Size int    `xml:"size"`
+	TTL  string `xml:"ttl"`
+}
+
+// TTLDuration parses the configured TTL, with zero meaning entries never expire
+func (c ResponseCacheConfig) TTLDuration() time.Duration {
+	if c.TTL == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(c.TTL)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+type cacheEntry struct {
+	key     string
+	resp    *ChatResponse
+	expires time.Time
+}
+
+// ResponseCache is a small LRU of completions keyed on (model, messages)
+type ResponseCache struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	order *list.List
+	items map[string]*list.Element
+}
+
+func NewResponseCache(size int, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		size:  size,
+		ttl:   ttl,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func responseCacheKey(model string, messages []ChatMessage) string {
+	data, _ := json.Marshal(struct {
+		Model    string        `json:"model"`
+		Messages []ChatMessage `json:"messages"`
+	}{model, messages})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *ResponseCache) Get(key string) (*ChatResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.resp, true
+}
+
+func (c *ResponseCache) Put(key string, resp *ChatResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var expires time.Time
+	if c.ttl > 0 {
+		expires = time.Now().Add(c.ttl)
+	}
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.resp = resp
+		entry.expires = expires
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, resp: resp, expires: expires})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// Global response cache, nil when caching is not configured
+var responseCache *ResponseCache
+
+// cachingEnabled reports whether completions for this form may be served from cache.
+// Only deterministic (temperature 0) completions are safe to reuse.
+func cachingEnabled(config Configuration, form ConfigurationForm) bool {
+	return responseCache != nil &&
+		form.CacheResponses &&
+		config.Temperature != nil &&
+		*config.Temperature == 0
+}
+
+// cachedChatGPT wraps callChatGPT with the response cache when the form allows
+// it, or asks the form's ensemble, which is never cached
+func cachedChatGPT(ctx context.Context, config Configuration, form ConfigurationForm, messages []ChatMessage) (*ChatResponse, error) {
+	if form.Ensemble.Enabled() {
+		return ensembleChatGPT(ctx, config, form, messages)
+	}
+	if !cachingEnabled(config, form) {
+		return callChatGPT(ctx, config, messages)
+	}
+
+	key := responseCacheKey(config.Model, messages)
+	if resp, ok := responseCache.Get(key); ok {
+		log.Printf("⚡ CACHE [%s]: hit", form.Name)
+		hit := *resp
+		hit.Cached = true
+		return &hit, nil
+	}
+
+	resp, err := callChatGPT(ctx, config, messages)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.empty() && !resp.filtered() {
+		responseCache.Put(key, resp)
+	}
+	return resp, nil
+}
+
+// cachedStreamChatGPT is cachedChatGPT for streamed turns. It shares the
+// cache with /chat: a hit is handed to onDelta in one piece, and a streamed
+// reply is stored once it is complete. A form's ensemble is asked without
+// streaming and the chosen reply is handed over in one piece the same way.
+func cachedStreamChatGPT(ctx context.Context, config Configuration, form ConfigurationForm, messages []ChatMessage, onDelta func(string)) (streamedReply, error) {
+	if form.Ensemble.Enabled() {
+		resp, err := ensembleChatGPT(ctx, config, form, messages)
+		if err != nil {
+			return streamedReply{}, err
+		}
+		reply := streamedReply{Content: resp.Choices[0].Message.Content, Reasoning: resp.Reasoning(), Usage: resp.Usage}
+		onDelta(reply.Content)
+		return reply, nil
+	}
+	if !cachingEnabled(config, form) {
+		return streamChatGPT(ctx, config, messages, onDelta)
+	}
+
+	key := responseCacheKey(config.Model, messages)
+	if resp, ok := responseCache.Get(key); ok {
+		log.Printf("⚡ CACHE [%s]: hit", form.Name)
+		reply := streamedReply{Content: resp.Choices[0].Message.Content, Reasoning: resp.Reasoning(), Usage: resp.Usage, Cached: true}
+		onDelta(reply.Content)
+		return reply, nil
+	}
+
+	reply, err := streamChatGPT(ctx, config, messages, onDelta)
+	if err != nil || strings.TrimSpace(reply.Content) == "" {
+		return reply, err
+	}
+	responseCache.Put(key, reply.response(config.Model))
+	return reply, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewResponseCache(2, 0)
+	for _, key := range []string{"a", "b"} {
+		cache.Put(key, &ChatResponse{Model: key})
+	}
+	cache.Get("a")
+	cache.Put("c", &ChatResponse{Model: "c"})
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+	}
+	for _, tt := range tests {
+		if _, ok := cache.Get(tt.key); ok != tt.want {
+			t.Errorf("Get(%q) found = %v, want %v", tt.key, ok, tt.want)
+		}
+	}
+}
+
+func TestResponseCacheExpires(t *testing.T) {
+	cache := NewResponseCache(1, time.Millisecond)
+	cache.Put("a", &ChatResponse{})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := cache.Get("a"); ok {
+		t.Error("expired entry was returned")
+	}
+}
+
+func TestCachingEnabled(t *testing.T) {
+	zero, warm := 0.0, 0.7
+	previous := responseCache
+	t.Cleanup(func() { responseCache = previous })
+
+	tests := []struct {
+		name        string
+		cache       bool
+		optIn       bool
+		temperature *float64
+		want        bool
+	}{
+		{"deterministic and opted in", true, true, &zero, true},
+		{"no cache configured", false, true, &zero, false},
+		{"form not opted in", true, false, &zero, false},
+		{"temperature unset", true, true, nil, false},
+		{"temperature above zero", true, true, &warm, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responseCache = nil
+			if tt.cache {
+				responseCache = NewResponseCache(4, 0)
+			}
+			config := Configuration{Temperature: tt.temperature}
+			form := ConfigurationForm{CacheResponses: tt.optIn}
+			if got := cachingEnabled(config, form); got != tt.want {
+				t.Errorf("cachingEnabled = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedChatGPTServesRepeatsFromCache(t *testing.T) {
+	fake := fakeChat(t, "SAY hello")
+	previous := responseCache
+	t.Cleanup(func() { responseCache = previous })
+	responseCache = NewResponseCache(4, 0)
+
+	zero := 0.0
+	form := ConfigurationForm{Name: "f", CacheResponses: true}
+	config := testConfig(t, form)
+	config.Temperature = &zero
+
+	tests := []struct {
+		message    string
+		wantCached bool
+		wantCalls  int
+	}{
+		{"hi", false, 1},
+		{"hi", true, 1},
+		{"hello", false, 2},
+	}
+	for _, tt := range tests {
+		resp, err := cachedChatGPT(context.Background(), config, form, []ChatMessage{{Role: "user", Content: tt.message}})
+		if err != nil {
+			t.Fatalf("%q: %v", tt.message, err)
+		}
+		if resp.Cached != tt.wantCached || fake.calls() != tt.wantCalls {
+			t.Errorf("%q: cached = %v after %d calls, want %v after %d", tt.message, resp.Cached, fake.calls(), tt.wantCached, tt.wantCalls)
+		}
+	}
+}
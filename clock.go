@@ -0,0 +1,36 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// location is the configured timezone, or the server's local time when unset or invalid
+func (c Configuration) location() *time.Location {
+	if c.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(c.Timezone)
+	if err != nil {
+		log.Printf("⚠️ Unknown timezone %q, using local time", c.Timezone)
+		return time.Local
+	}
+	return loc
+}
+
+// timeOfDay buckets a clock time as morning, afternoon or evening
+func timeOfDay(t time.Time) string {
+	switch hour := t.Hour(); {
+	case hour >= 5 && hour < 12:
+		return "morning"
+	case hour >= 12 && hour < 17:
+		return "afternoon"
+	default:
+		return "evening"
+	}
+}
+
+// localTimeOfDay is the time of day now in the configured timezone
+func localTimeOfDay(config Configuration) string {
+	return timeOfDay(time.Now().In(config.location()))
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeOfDay(t *testing.T) {
+	tests := []struct {
+		hour int
+		want string
+	}{
+		{0, "evening"},
+		{4, "evening"},
+		{5, "morning"},
+		{11, "morning"},
+		{12, "afternoon"},
+		{16, "afternoon"},
+		{17, "evening"},
+		{23, "evening"},
+	}
+	for _, tt := range tests {
+		at := time.Date(2024, 5, 1, tt.hour, 30, 0, 0, time.UTC)
+		if got := timeOfDay(at); got != tt.want {
+			t.Errorf("timeOfDay(%02d:30) = %s, want %s", tt.hour, got, tt.want)
+		}
+	}
+}
+
+func TestTimezone(t *testing.T) {
+	if loc := (Configuration{Timezone: "Asia/Tokyo"}).location(); loc.String() != "Asia/Tokyo" {
+		t.Errorf("location = %s, want Asia/Tokyo", loc)
+	}
+	if loc := (Configuration{Timezone: "Mars/Olympus"}).location(); loc != time.Local {
+		t.Errorf("unknown timezone gave %s, want local time", loc)
+	}
+	if err := validateConfig(Configuration{Timezone: "Mars/Olympus"}); err == nil {
+		t.Error("validateConfig accepted an unknown timezone")
+	}
+
+	prompt := buildSystemPrompt(Configuration{Timezone: "UTC"}, ConfigurationForm{Prompt: "%s%s%s"}, "")
+	if want := "It is " + localTimeOfDay(Configuration{Timezone: "UTC"}) + " for the user"; !strings.Contains(prompt, want) {
+		t.Errorf("prompt %q does not say %q", prompt, want)
+	}
+}
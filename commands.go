@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// CustomCommand declares a form specific verb the model may use, such as
+// ESCALATE or SCHEDULE. The command's argument and the current form data are
+// delivered to the named sink. The text describes the command to the model.
+type CustomCommand struct {
+	Verb        string `xml:"verb,attr"`
+	Sink        string `xml:"sink,attr"`
+	Description string `xml:",chardata"`
+}
+
+// commandHandler applies one custom command, returning any fields it changed
+type commandHandler func(t *turnResult, cmd assistantCommand) map[string]string
+
+// Custom verbs are upper case words, so they read like the built in commands
+var customVerbPattern = regexp.MustCompile(`^[A-Z][A-Z_]*$`)
+
+// isBuiltinVerb reports whether verb is one of the protocol's own commands
+func isBuiltinVerb(verb string) bool {
+	for _, builtin := range commandVerbs {
+		if verb == builtin {
+			return true
+		}
+	}
+	return false
+}
+
+// customCommandHandlers is the form's registry of custom verbs
+func customCommandHandlers(form ConfigurationForm) map[string]commandHandler {
+	handlers := make(map[string]commandHandler, len(form.Commands.Command))
+	for _, c := range form.Commands.Command {
+		handlers[c.Verb] = sinkCommandHandler(c)
+	}
+	return handlers
+}
+
+// formVerbs are all the verbs the form's replies may use
+func formVerbs(form ConfigurationForm) []string {
+	verbs := append([]string{}, commandVerbs...)
+	for _, c := range form.Commands.Command {
+		verbs = append(verbs, c.Verb)
+	}
+	return verbs
+}
+
+// sinkCommandHandler delivers the command to its sink, like a saved record
+// but tagged with the verb and argument
+func sinkCommandHandler(c CustomCommand) commandHandler {
+	return func(t *turnResult, cmd assistantCommand) map[string]string {
+		data := make(map[string]string, len(t.session.FormData))
+		for k, v := range t.session.FormData {
+			data[k] = v
+		}
+		record := SinkRecord{
+			Form:     t.form.Name,
+			Data:     data,
+			SavedAt:  time.Now().UTC(),
+			Command:  cmd.Verb,
+			Argument: cmd.Value,
+		}
+		log.Printf("📣 [%s]: \"%s %s\"", t.form.Name, cmd.Verb, cmd.Value)
+		go func() {
+			if err := deliverRecord(c.Sink, record); err != nil {
+				log.Printf("❌ SINK [%s]: %s delivery to %s failed: %v", t.form.Name, cmd.Verb, c.Sink, err)
+				return
+			}
+			log.Printf("📤 SINK [%s]: %s delivered to %s", t.form.Name, cmd.Verb, c.Sink)
+		}()
+		return nil
+	}
+}
+
+// customCommandPrompt tells the model about the form's custom verbs
+func customCommandPrompt(form ConfigurationForm) (string, bool) {
+	if len(form.Commands.Command) == 0 {
+		return "", false
+	}
+	lines := []string{"You can also use these commands, each on its own line:"}
+	for _, c := range form.Commands.Command {
+		lines = append(lines, fmt.Sprintf("%s <details>: %s", c.Verb, strings.TrimSpace(c.Description)))
+	}
+	return strings.Join(lines, "\n"), true
+}
+
+// validateCustomCommands checks a form's custom verbs
+func validateCustomCommands(form ConfigurationForm) error {
+	seen := make(map[string]bool)
+	for _, c := range form.Commands.Command {
+		switch {
+		case !customVerbPattern.MatchString(c.Verb):
+			return fmt.Errorf("command verb %q must be upper case letters", c.Verb)
+		case isBuiltinVerb(c.Verb):
+			return fmt.Errorf("command verb %s is a built in command", c.Verb)
+		case seen[c.Verb]:
+			return fmt.Errorf("duplicate command verb %s", c.Verb)
+		case c.Sink == "":
+			return fmt.Errorf("command %s needs a sink", c.Verb)
+		}
+		seen[c.Verb] = true
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateCustomCommands(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []CustomCommand
+		wantErr  string
+	}{
+		{"valid", []CustomCommand{{Verb: "ESCALATE", Sink: "crm"}, {Verb: "CALL_BACK", Sink: "crm"}}, ""},
+		{"lower case", []CustomCommand{{Verb: "escalate", Sink: "crm"}}, "upper case"},
+		{"built in", []CustomCommand{{Verb: "SAVE", Sink: "crm"}}, "built in"},
+		{"duplicate", []CustomCommand{{Verb: "ESCALATE", Sink: "crm"}, {Verb: "ESCALATE", Sink: "log"}}, "duplicate"},
+		{"no sink", []CustomCommand{{Verb: "ESCALATE"}}, "needs a sink"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var form ConfigurationForm
+			form.Commands.Command = tt.commands
+			err := validateCustomCommands(form)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("validateCustomCommands = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCustomCommandIsDeliveredToItsSink(t *testing.T) {
+	delivered := make(recordingSink, 1)
+	useSinks(t, map[string]Sink{"crm": delivered})
+	form := testForm("f")
+	form.Commands.Command = []CustomCommand{{Verb: "ESCALATE", Sink: "crm"}}
+	config := testConfig(t, form)
+	session := &ChatSession{FormData: map[string]string{"FirstName": "Ann"}}
+
+	turn := applyResponse(config, "f", session, "SAY Connecting you\nESCALATE wants a human")
+	if turn.ResponseText() != "Connecting you" {
+		t.Errorf("reply = %q", turn.ResponseText())
+	}
+	select {
+	case record := <-delivered:
+		if record.Command != "ESCALATE" || record.Argument != "wants a human" || record.Data["FirstName"] != "Ann" {
+			t.Errorf("delivered %+v", record)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ESCALATE was not delivered")
+	}
+}
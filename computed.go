@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// ComputedField is derived from other form values by a template, e.g.
+// <field name="FullName">{{.FirstName}} {{.LastName}}</field>
+type ComputedField struct {
+	Name     string `xml:"name,attr"`
+	Template string `xml:",chardata"`
+}
+
+// The var function is rebound to the session's variables for each evaluation
+var computedFuncs = texttemplate.FuncMap{
+	"age": ageFrom,
+	"var": func(string) string { return "" },
+}
+
+// ageFrom returns whole years since a YYYY-MM-DD date, or "" if it doesn't parse
+func ageFrom(date string) string {
+	return ageOn(date, time.Now())
+}
+
+// ageOn is ageFrom as of now. Birthdays compare by month and day, since the
+// day of the year shifts by one after February in leap years; someone born
+// on February 29 turns a year older on March 1 in other years.
+func ageOn(date string, now time.Time) string {
+	born, err := time.Parse("2006-01-02", strings.TrimSpace(date))
+	if err != nil {
+		return ""
+	}
+	years := now.Year() - born.Year()
+	if now.Month() < born.Month() || now.Month() == born.Month() && now.Day() < born.Day() {
+		years--
+	}
+	if years < 0 {
+		return ""
+	}
+	return strconv.Itoa(years)
+}
+
+var fieldReferencePattern = regexp.MustCompile(`\.(\w+)`)
+
+// orderComputedFields sorts computed fields so each comes after the computed
+// fields it references, failing on a dependency cycle
+func orderComputedFields(fields []ComputedField) ([]ComputedField, error) {
+	byName := make(map[string]ComputedField, len(fields))
+	for _, f := range fields {
+		byName[f.Name] = f
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	ordered := make([]ComputedField, 0, len(fields))
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			return fmt.Errorf("computed field cycle: %s -> %s", strings.Join(path, " -> "), name)
+		}
+		state[name] = visiting
+		for _, ref := range fieldReferencePattern.FindAllStringSubmatch(byName[name].Template, -1) {
+			if _, computed := byName[ref[1]]; computed {
+				if err := visit(ref[1], append(path, name)); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = done
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+
+	for _, f := range fields {
+		if err := visit(f.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+func parseComputedTemplate(f ComputedField) (*texttemplate.Template, error) {
+	return texttemplate.New(f.Name).Funcs(computedFuncs).Option("missingkey=zero").Parse(strings.TrimSpace(f.Template))
+}
+
+// validateComputedFields checks templates parse and dependencies are acyclic
+func validateComputedFields(form ConfigurationForm) error {
+	for _, f := range form.ComputedFields.Field {
+		if _, err := parseComputedTemplate(f); err != nil {
+			return fmt.Errorf("computed field %s: %v", f.Name, err)
+		}
+	}
+	_, err := orderComputedFields(form.ComputedFields.Field)
+	return err
+}
+
+// recomputeFields evaluates the form's computed fields against formData in
+// dependency order, storing the results and returning those that changed.
+// Templates read session variables with {{var "name"}}.
+func recomputeFields(form ConfigurationForm, formData, vars map[string]string) map[string]string {
+	changed := make(map[string]string)
+	ordered, err := orderComputedFields(form.ComputedFields.Field)
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: %v", form.Name, err)
+		return changed
+	}
+
+	for _, f := range ordered {
+		tmpl, err := parseComputedTemplate(f)
+		if err != nil {
+			log.Printf("❌ ERROR [%s]: computed field %s: %v", form.Name, f.Name, err)
+			continue
+		}
+		tmpl.Funcs(texttemplate.FuncMap{"var": func(name string) string { return vars[name] }})
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, formData); err != nil {
+			log.Printf("❌ ERROR [%s]: computed field %s: %v", form.Name, f.Name, err)
+			continue
+		}
+		value := strings.TrimSpace(buf.String())
+		if value != formData[f.Name] {
+			formData[f.Name] = value
+			changed[f.Name] = value
+		}
+	}
+	return changed
+}
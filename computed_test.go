@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgeOn(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	tests := []struct {
+		born string
+		on   string
+		want string
+	}{
+		{"1990-06-15", "2024-06-14", "33"},
+		{"1990-06-15", "2024-06-15", "34"},
+		// March 1 is day 61 in a leap year and day 60 otherwise
+		{"2001-03-01", "2024-02-29", "22"},
+		{"2001-03-01", "2024-03-01", "23"},
+		{"2000-02-29", "2023-02-28", "22"},
+		{"2000-02-29", "2023-03-01", "23"},
+		{"2000-02-29", "2024-02-29", "24"},
+		{"2030-01-01", "2024-01-01", ""},
+		{"not a date", "2024-01-01", ""},
+	}
+	for _, tt := range tests {
+		if got := ageOn(tt.born, day(tt.on)); got != tt.want {
+			t.Errorf("ageOn(%s, %s) = %q, want %q", tt.born, tt.on, got, tt.want)
+		}
+	}
+}
+
+func TestOrderComputedFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		fields  []ComputedField
+		want    []string
+		wantErr string
+	}{
+		{
+			"dependencies first",
+			[]ComputedField{{Name: "Greeting", Template: "Hi {{.FullName}}"}, {Name: "FullName", Template: "{{.FirstName}} {{.LastName}}"}},
+			[]string{"FullName", "Greeting"},
+			"",
+		},
+		{
+			"cycle",
+			[]ComputedField{{Name: "A", Template: "{{.B}}"}, {Name: "B", Template: "{{.A}}"}},
+			nil,
+			"cycle: A -> B -> A",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ordered, err := orderComputedFields(tt.fields)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			var names []string
+			for _, f := range ordered {
+				names = append(names, f.Name)
+			}
+			if err != nil || !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("order = %v, %v, want %v", names, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecomputeFieldsReturnsChanges(t *testing.T) {
+	var form ConfigurationForm
+	form.ComputedFields.Field = []ComputedField{
+		{Name: "Greeting", Template: `Hi {{.FullName}} ({{var "lang"}})`},
+		{Name: "FullName", Template: "{{.FirstName}} {{.LastName}}"},
+	}
+	formData := map[string]string{"FirstName": "Ann", "LastName": "Lee", "FullName": "Ann Lee"}
+
+	changed := recomputeFields(form, formData, map[string]string{"lang": "en"})
+	want := map[string]string{"Greeting": "Hi Ann Lee (en)"}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed = %v, want %v", changed, want)
+	}
+	if formData["Greeting"] != "Hi Ann Lee (en)" {
+		t.Errorf("Greeting = %q was not stored", formData["Greeting"])
+	}
+}
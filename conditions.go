@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	texttemplate "text/template"
+)
+
+// FieldCondition makes a field apply only when a template over the other
+// values yields true, e.g.
+// <condition field="Endorsement">{{eq .LicenseClass "commercial"}}</condition>
+type FieldCondition struct {
+	Field string `xml:"field,attr"`
+	When  string `xml:",chardata"`
+}
+
+func parseConditionTemplate(c FieldCondition) (*texttemplate.Template, error) {
+	return texttemplate.New(c.Field).Funcs(computedFuncs).Parse(strings.TrimSpace(c.When))
+}
+
+// fieldApplies reports whether the field's condition, if it has one, holds for
+// formData. Anything but an empty or "false" result holds, and a condition that
+// fails to run does too, so a broken condition asks a question rather than skipping it.
+func fieldApplies(form ConfigurationForm, name string, formData map[string]string) bool {
+	for _, c := range form.FieldConditions.Condition {
+		if c.Field != name {
+			continue
+		}
+		tmpl, err := parseConditionTemplate(c)
+		if err != nil {
+			log.Printf("❌ ERROR [%s]: condition for %s: %v", form.Name, name, err)
+			return true
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, formData); err != nil {
+			log.Printf("❌ ERROR [%s]: condition for %s: %v", form.Name, name, err)
+			return true
+		}
+		result := strings.TrimSpace(buf.String())
+		return result != "" && result != "false"
+	}
+	return true
+}
+
+// skippedFields lists the form's fields whose conditions do not hold
+func skippedFields(form ConfigurationForm, formData map[string]string) []string {
+	var skipped []string
+	for _, field := range formFields(form) {
+		if !fieldApplies(form, field.Name, formData) {
+			skipped = append(skipped, field.Name)
+		}
+	}
+	return skipped
+}
+
+// skipInstruction tells the model which fields not to ask about, given the answers so far
+func skipInstruction(form ConfigurationForm, formData map[string]string) (string, bool) {
+	skipped := skippedFields(form, formData)
+	if len(skipped) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("These fields do not apply given the answers so far. Do not ask for them: %s.",
+		strings.Join(skipped, ", ")), true
+}
+
+// validateFieldConditions checks that each condition names a field and parses
+func validateFieldConditions(form ConfigurationForm) error {
+	for _, c := range form.FieldConditions.Condition {
+		if _, ok := formFieldByName(form, c.Field); !ok {
+			return fmt.Errorf("condition for unknown field %q", c.Field)
+		}
+		if _, err := parseConditionTemplate(c); err != nil {
+			return fmt.Errorf("condition for %s: %v", c.Field, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// conditionalForm is testForm with Endorsement asked only for commercial licenses
+func conditionalForm(when string) ConfigurationForm {
+	form := testForm("f")
+	form.Fields += "\nLicenseClass: {{.LicenseClass}}\nEndorsement: {{.Endorsement}}"
+	form.FieldConditions.Condition = []FieldCondition{{Field: "Endorsement", When: when}}
+	return form
+}
+
+func TestFieldApplies(t *testing.T) {
+	tests := []struct {
+		name  string
+		when  string
+		class string
+		want  bool
+	}{
+		{"holds", `{{eq .LicenseClass "commercial"}}`, "commercial", true},
+		{"does not hold", `{{eq .LicenseClass "commercial"}}`, "regular", false},
+		{"not answered yet", `{{eq .LicenseClass "commercial"}}`, "", false},
+		{"empty result", `{{if .LicenseClass}}yes{{end}}`, "", false},
+		{"any other result", `{{.LicenseClass}}`, "regular", true},
+		{"broken condition asks anyway", `{{call .LicenseClass}}`, "regular", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			formData := map[string]string{}
+			if tt.class != "" {
+				formData["LicenseClass"] = tt.class
+			}
+			if got := fieldApplies(conditionalForm(tt.when), "Endorsement", formData); got != tt.want {
+				t.Errorf("fieldApplies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkippedFieldsAreNotMissing(t *testing.T) {
+	form := conditionalForm(`{{eq .LicenseClass "commercial"}}`)
+	tests := []struct {
+		class       string
+		wantSkipped []string
+		wantMissing []string
+	}{
+		{"regular", []string{"Endorsement"}, nil},
+		{"commercial", nil, []string{"Endorsement"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.class, func(t *testing.T) {
+			formData := map[string]string{"FirstName": "Ann", "License": "A1", "LicenseClass": tt.class}
+			if got := skippedFields(form, formData); !reflect.DeepEqual(got, tt.wantSkipped) {
+				t.Errorf("skippedFields = %v, want %v", got, tt.wantSkipped)
+			}
+			if got := missingFields(form, formData); !reflect.DeepEqual(got, tt.wantMissing) {
+				t.Errorf("missingFields = %v, want %v", got, tt.wantMissing)
+			}
+			_, told := skipInstruction(form, formData)
+			if told != (tt.wantSkipped != nil) {
+				t.Errorf("skip instruction sent = %v", told)
+			}
+		})
+	}
+}
+
+func TestValidateFieldConditions(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition FieldCondition
+		wantErr   bool
+	}{
+		{"valid", FieldCondition{Field: "Endorsement", When: `{{eq .LicenseClass "commercial"}}`}, false},
+		{"unknown field", FieldCondition{Field: "Shoe", When: "true"}, true},
+		{"bad template", FieldCondition{Field: "Endorsement", When: "{{eq .LicenseClass"}, true},
+	}
+	for _, tt := range tests {
+		form := conditionalForm("")
+		form.FieldConditions.Condition = []FieldCondition{tt.condition}
+		if err := validateFieldConditions(form); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateFieldConditions = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
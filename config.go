@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Used when max_forms is not configured
+const defaultMaxForms = 100
+
+// Form names become URL path segments and directory names
+var formNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Folders under data_dir holding the server's own state, which a form's
+// records must not be mixed with
+var reservedFormNames = map[string]bool{"debug": true, "sink_queue": true}
+
+// RegionFor is the phone/address region for a form, falling back to the global default
+func (c Configuration) RegionFor(form ConfigurationForm) string {
+	if form.DefaultRegion != "" {
+		return form.DefaultRegion
+	}
+	if c.DefaultRegion != "" {
+		return c.DefaultRegion
+	}
+	return defaultPhoneRegion
+}
+
+// ChatOnly reports whether the server runs in plain chat mode, where replies
+// are shown as they are and nothing is parsed as a command or saved
+func (c Configuration) ChatOnly() bool {
+	return c.Mode == "chat"
+}
+
+// Model families that accept the reasoning_effort parameter
+var reasoningModelPrefixes = []string{"o1", "o3", "o4", "gpt-5"}
+
+// supportsReasoningEffort reports whether a model accepts reasoning_effort
+func supportsReasoningEffort(model string) bool {
+	for _, prefix := range reasoningModelPrefixes {
+		if model == prefix || strings.HasPrefix(model, prefix+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// Used when sse_keepalive is not configured
+const defaultKeepaliveInterval = 15 * time.Second
+
+// KeepaliveInterval is how long a stream may be quiet before a keepalive is sent; zero disables them
+func (c Configuration) KeepaliveInterval() time.Duration {
+	if c.SSEKeepalive == "" {
+		return defaultKeepaliveInterval
+	}
+	d, _ := time.ParseDuration(c.SSEKeepalive)
+	return d
+}
+
+// RequestTimeoutDuration is how long an AI service call may wait for a reply;
+// a streamed reply gets it again after each chunk. Zero means no limit.
+func (c Configuration) RequestTimeoutDuration() time.Duration {
+	d, _ := time.ParseDuration(c.RequestTimeout)
+	return d
+}
+
+// Used when response_budget_message is not configured
+const defaultResponseBudgetMessage = "This is taking longer than usual, please wait..."
+
+// ResponseBudgetDuration is how long a streamed reply may take before the interim
+// message is sent; zero means no soft budget
+func (c Configuration) ResponseBudgetDuration() time.Duration {
+	d, _ := time.ParseDuration(c.ResponseBudget)
+	return d
+}
+
+// SlowResponseMessage is the interim message sent once the response budget runs out
+func (c Configuration) SlowResponseMessage() string {
+	if c.ResponseBudgetMessage == "" {
+		return defaultResponseBudgetMessage
+	}
+	return c.ResponseBudgetMessage
+}
+
+// ForForm applies the form's overrides of the model settings, currently its seed
+func (c Configuration) ForForm(form ConfigurationForm) Configuration {
+	if form.ModelSeed != nil {
+		c.Seed = form.ModelSeed
+	}
+	return c
+}
+
+// modelAllowed checks a model against allowed_models; an empty list allows any model
+func (c Configuration) modelAllowed(model string) bool {
+	allowed := splitFieldList(c.AllowedModels)
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, m := range allowed {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// validateListenAddr checks a host:port listen address such as :8080 or 127.0.0.1:8080
+func validateListenAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("%q is not a host:port address such as :8080", addr)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil || port == "" {
+		return fmt.Errorf("%q has no valid port", addr)
+	}
+	return nil
+}
+
+// validateBaseURL checks that base_url is an absolute http or https URL, as
+// the links and QR codes built from it need
+func validateBaseURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("%q does not parse: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must start with http:// or https://", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("%q must not have a query or fragment", raw)
+	}
+	return nil
+}
+
+// validateConfig rejects configuration mistakes at startup rather than on first use
+func validateConfig(config Configuration) error {
+	if config.BindAddr != "" {
+		if err := validateListenAddr(config.BindAddr); err != nil {
+			return fmt.Errorf("invalid bind_addr: %v", err)
+		}
+	}
+	if config.Server.HTTPRedirectAddr != "" {
+		if err := validateListenAddr(config.Server.HTTPRedirectAddr); err != nil {
+			return fmt.Errorf("invalid server http_redirect_addr: %v", err)
+		}
+	}
+	if config.BaseURL != "" {
+		if err := validateBaseURL(config.BaseURL); err != nil {
+			return fmt.Errorf("invalid base_url: %v", err)
+		}
+	}
+	if !config.modelAllowed(config.Model) {
+		return fmt.Errorf("model %q is not in allowed_models", config.Model)
+	}
+	if config.HTTPProxy != "" {
+		if _, err := parseProxyURL(config.HTTPProxy); err != nil {
+			return fmt.Errorf("invalid http_proxy: %v", err)
+		}
+	}
+	if config.MaxSetsPerTurn < 0 {
+		return fmt.Errorf("max_sets_per_turn must not be negative")
+	}
+	switch config.ReasoningEffort {
+	case "", "minimal", "low", "medium", "high":
+	default:
+		return fmt.Errorf("reasoning_effort must be minimal, low, medium or high, not %q", config.ReasoningEffort)
+	}
+	if config.Timezone != "" {
+		if _, err := time.LoadLocation(config.Timezone); err != nil {
+			return fmt.Errorf("invalid timezone %q: %v", config.Timezone, err)
+		}
+	}
+	switch config.SaveFormat {
+	case "", "json", "yaml":
+	default:
+		return fmt.Errorf("save_format must be json or yaml, not %q", config.SaveFormat)
+	}
+	switch config.Mode {
+	case "", "forms", "chat":
+	default:
+		return fmt.Errorf("mode must be forms or chat, not %q", config.Mode)
+	}
+	if config.SummarizeHistory.Model != "" && !config.modelAllowed(config.SummarizeHistory.Model) {
+		return fmt.Errorf("summarize_history model %q is not in allowed_models", config.SummarizeHistory.Model)
+	}
+	for _, m := range config.Messages.Message {
+		if _, ok := builtinMessages[m.Key]; !ok {
+			return fmt.Errorf("unknown message key %q", m.Key)
+		}
+		if _, err := texttemplate.New(m.Key).Parse(m.Text); err != nil {
+			return fmt.Errorf("message %s (%s): %v", m.Key, m.Lang, err)
+		}
+	}
+	if err := validatePromptLeakGuard(config.PromptLeakGuard); err != nil {
+		return err
+	}
+	if err := validateNormalizeSteps(config.NormalizeValues); err != nil {
+		return fmt.Errorf("normalize_values: %v", err)
+	}
+	if config.SubmissionSummaryModel != "" && !config.modelAllowed(config.SubmissionSummaryModel) {
+		return fmt.Errorf("submission_summary_model %q is not in allowed_models", config.SubmissionSummaryModel)
+	}
+	if config.SummarizeHistory.MaxChars < 0 || config.SummarizeHistory.KeepRecent < 0 {
+		return fmt.Errorf("summarize_history max_chars and keep_recent must not be negative")
+	}
+	if config.DefaultRegion != "" && !validRegion(config.DefaultRegion) {
+		return fmt.Errorf("unknown default_region %q", config.DefaultRegion)
+	}
+	if (config.Server.TLSCertFile == "") != (config.Server.TLSKeyFile == "") {
+		return fmt.Errorf("server needs both tls_cert_file and tls_key_file, or neither")
+	}
+	if config.Server.HTTPRedirectAddr != "" && !config.Server.TLSEnabled() {
+		return fmt.Errorf("server http_redirect_addr needs tls_cert_file and tls_key_file")
+	}
+	if config.Server.HSTSMaxAge != "" {
+		if d, err := time.ParseDuration(config.Server.HSTSMaxAge); err != nil || d <= 0 {
+			return fmt.Errorf("invalid server hsts_max_age %q", config.Server.HSTSMaxAge)
+		}
+	}
+	for _, entry := range splitFieldList(config.Server.TrustedProxies) {
+		if _, err := parseProxyEntry(entry); err != nil {
+			return fmt.Errorf("invalid server trusted_proxies entry %q", entry)
+		}
+	}
+	if config.Server.MaxHeaderBytes < 0 || config.Server.MaxBodyBytes < 0 {
+		return fmt.Errorf("server max_header_bytes and max_body_bytes must not be negative")
+	}
+	if config.BasePath != "" && (!strings.HasPrefix(config.BasePath, "/") || strings.ContainsAny(config.BasePath, "?#")) {
+		return fmt.Errorf("base_path %q must be an absolute path such as /gochat", config.BasePath)
+	}
+	if config.SSEKeepalive != "" {
+		if _, err := time.ParseDuration(config.SSEKeepalive); err != nil {
+			return fmt.Errorf("invalid sse_keepalive %q: %v", config.SSEKeepalive, err)
+		}
+	}
+	for _, form := range config.Forms.Form {
+		if path := config.IdentityCookieFor(form).Path; !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("form %s: identity_cookie path %q must start with /", form.Name, path)
+		}
+		switch form.SaveLimitPolicy {
+		case "", "reject", "ignore":
+		default:
+			return fmt.Errorf("form %s: save_limit_policy must be reject or ignore, not %q", form.Name, form.SaveLimitPolicy)
+		}
+		for _, model := range splitFieldList(form.Ensemble.Models) {
+			if !config.modelAllowed(model) {
+				return fmt.Errorf("form %s: ensemble model %q is not in allowed_models", form.Name, model)
+			}
+		}
+		if form.MaxSavesPerKey < 0 {
+			return fmt.Errorf("form %s: max_saves_per_key must not be negative", form.Name)
+		}
+	}
+	switch config.RepeatedReply {
+	case "", "rephrase", "note":
+	default:
+		return fmt.Errorf("repeated_reply must be rephrase or note, not %q", config.RepeatedReply)
+	}
+	if config.StreamResume != "" {
+		if d, err := time.ParseDuration(config.StreamResume); err != nil || d <= 0 {
+			return fmt.Errorf("invalid stream_resume %q", config.StreamResume)
+		}
+	}
+	if config.SinkHealthInterval != "" {
+		if d, err := time.ParseDuration(config.SinkHealthInterval); err != nil || d <= 0 {
+			return fmt.Errorf("invalid sink_health_interval %q", config.SinkHealthInterval)
+		}
+	}
+	if config.SinkRetry.MaxAttempts < 0 {
+		return fmt.Errorf("sink_retry max_attempts must not be negative")
+	}
+	for name, value := range map[string]string{
+		"sink_retry backoff":     config.SinkRetry.Backoff,
+		"sink_retry max_backoff": config.SinkRetry.MaxBackoff,
+	} {
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid %s %q", name, value)
+		}
+	}
+	if err := validateWarehouseExport(config.WarehouseExport); err != nil {
+		return err
+	}
+	if config.CircuitBreaker.Failures < 0 {
+		return fmt.Errorf("circuit_breaker failures must not be negative")
+	}
+	if config.CircuitBreaker.Cooldown != "" {
+		if d, err := time.ParseDuration(config.CircuitBreaker.Cooldown); err != nil || d <= 0 {
+			return fmt.Errorf("invalid circuit_breaker cooldown %q", config.CircuitBreaker.Cooldown)
+		}
+	}
+	if config.ShutdownTimeout != "" {
+		if d, err := time.ParseDuration(config.ShutdownTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("invalid shutdown_timeout %q", config.ShutdownTimeout)
+		}
+	}
+	if config.ShareExpiry != "" {
+		if d, err := time.ParseDuration(config.ShareExpiry); err != nil || d <= 0 {
+			return fmt.Errorf("invalid share_expiry %q", config.ShareExpiry)
+		}
+	}
+	if config.PrefillExpiry != "" {
+		if d, err := time.ParseDuration(config.PrefillExpiry); err != nil || d <= 0 {
+			return fmt.Errorf("invalid prefill_expiry %q", config.PrefillExpiry)
+		}
+	}
+	if config.MinTurnInterval != "" {
+		if d, err := time.ParseDuration(config.MinTurnInterval); err != nil || d < 0 {
+			return fmt.Errorf("invalid min_turn_interval %q", config.MinTurnInterval)
+		}
+	}
+	if config.ResponseBudget != "" {
+		budget, err := time.ParseDuration(config.ResponseBudget)
+		if err != nil || budget <= 0 {
+			return fmt.Errorf("invalid response_budget %q: must be a positive duration", config.ResponseBudget)
+		}
+		if timeout, err := time.ParseDuration(config.RequestTimeout); err == nil && timeout > 0 && budget >= timeout {
+			return fmt.Errorf("response_budget %s must be shorter than request_timeout %s", config.ResponseBudget, config.RequestTimeout)
+		}
+	}
+	for name, value := range map[string]string{
+		"session_idle_ttl": config.SessionIdleTTL,
+		"max_session_age":  config.MaxSessionAge,
+	} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("invalid %s %q: %v", name, value, err)
+		}
+	}
+
+	maxForms := config.MaxForms
+	if maxForms <= 0 {
+		maxForms = defaultMaxForms
+	}
+	if len(config.Forms.Form) > maxForms {
+		return fmt.Errorf("%d forms configured, more than max_forms %d", len(config.Forms.Form), maxForms)
+	}
+
+	seen := make(map[string]bool)
+	for _, form := range config.Forms.Form {
+		if !formNamePattern.MatchString(form.Name) {
+			return fmt.Errorf("form name %q must contain only letters, digits, '-' and '_'", form.Name)
+		}
+		if reservedFormNames[strings.ToLower(form.Name)] {
+			return fmt.Errorf("form name %q is reserved for the server's own data", form.Name)
+		}
+		if seen[form.Name] {
+			return fmt.Errorf("duplicate form name %q", form.Name)
+		}
+		seen[form.Name] = true
+
+		if form.DefaultRegion != "" && !validRegion(form.DefaultRegion) {
+			return fmt.Errorf("form %s: unknown default_region %q", form.Name, form.DefaultRegion)
+		}
+		switch form.ConcurrentTurns {
+		case "", "wait", "reject":
+		default:
+			return fmt.Errorf("form %s: concurrent_turns must be wait or reject, not %q", form.Name, form.ConcurrentTurns)
+		}
+		if err := validateComputedFields(form); err != nil {
+			return fmt.Errorf("form %s: %v", form.Name, err)
+		}
+		for _, t := range splitFieldList(form.UploadTypes) {
+			if _, _, err := mime.ParseMediaType(t); err != nil {
+				return fmt.Errorf("form %s: invalid upload_types entry %q", form.Name, t)
+			}
+		}
+		if form.MaxUploadBytes < 0 {
+			return fmt.Errorf("form %s: max_upload_bytes must not be negative", form.Name)
+		}
+		if err := validateTemperatureSchedule(form); err != nil {
+			return fmt.Errorf("form %s: %v", form.Name, err)
+		}
+		if err := validateFieldConditions(form); err != nil {
+			return fmt.Errorf("form %s: %v", form.Name, err)
+		}
+		for _, n := range form.Normalize {
+			if err := validateNormalizeSteps(n.Steps); err != nil {
+				return fmt.Errorf("form %s: %v", form.Name, err)
+			}
+		}
+		if err := validateCustomCommands(form); err != nil {
+			return fmt.Errorf("form %s: %v", form.Name, err)
+		}
+		if err := validatePipeline(config, form); err != nil {
+			return fmt.Errorf("form %s: %v", form.Name, err)
+		}
+		for _, name := range splitFieldList(form.PromptIncludes) {
+			if _, ok := config.snippetByName(name); !ok {
+				return fmt.Errorf("form %s: unknown prompt snippet %q", form.Name, name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegionFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		global string
+		form   string
+		want   string
+	}{
+		{"form overrides global", "GB", "DE", "DE"},
+		{"global default", "GB", "", "GB"},
+		{"built-in default", "", "", defaultPhoneRegion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{DefaultRegion: tt.global}
+			if got := config.RegionFor(ConfigurationForm{DefaultRegion: tt.form}); got != tt.want {
+				t.Errorf("RegionFor = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateConfigLimitsForms(t *testing.T) {
+	forms := func(names ...string) []ConfigurationForm {
+		var list []ConfigurationForm
+		for _, name := range names {
+			list = append(list, testForm(name))
+		}
+		return list
+	}
+	tests := []struct {
+		name     string
+		maxForms int
+		forms    []ConfigurationForm
+		wantErr  bool
+	}{
+		{"within the limit", 2, forms("a", "b"), false},
+		{"over the limit", 1, forms("a", "b"), true},
+		{"default limit", 0, forms("a", "b", "c"), false},
+		{"duplicate name", 0, forms("a", "a"), true},
+		{"name with a slash", 0, forms("a/b"), true},
+		{"name with a space", 0, forms("a b"), true},
+		{"dashes and underscores", 0, forms("visit-2_b"), false},
+		{"debug store folder", 0, forms("debug"), true},
+		{"sink queue folder", 0, forms("Sink_Queue"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, tt.forms...)
+			config.MaxForms = tt.maxForms
+			if err := validateConfig(config); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfigRejectsUnknownSnippets(t *testing.T) {
+	form := testForm("f")
+	form.PromptIncludes = "tone"
+	config := testConfig(t, form)
+	if err := validateConfig(config); err == nil || !strings.Contains(err.Error(), `"tone"`) {
+		t.Errorf("validateConfig = %v, want an unknown snippet error", err)
+	}
+	config.PromptSnippets.Snippet = []PromptSnippet{{Name: "tone", Text: "Be brief."}}
+	if err := validateConfig(config); err != nil {
+		t.Errorf("validateConfig = %v", err)
+	}
+}
+
+func TestKeepaliveInterval(t *testing.T) {
+	tests := []struct {
+		setting string
+		want    time.Duration
+	}{
+		{"", defaultKeepaliveInterval},
+		{"5s", 5 * time.Second},
+		{"0", 0},
+	}
+	for _, tt := range tests {
+		config := Configuration{SSEKeepalive: tt.setting}
+		if got := config.KeepaliveInterval(); got != tt.want {
+			t.Errorf("KeepaliveInterval(%q) = %s, want %s", tt.setting, got, tt.want)
+		}
+	}
+	if err := validateConfig(Configuration{SSEKeepalive: "soon"}); err == nil {
+		t.Error("validateConfig accepted sse_keepalive \"soon\"")
+	}
+}
+
+func TestModelAllowed(t *testing.T) {
+	tests := []struct {
+		allowed string
+		model   string
+		want    bool
+	}{
+		{"", "anything", true},
+		{"gpt-4o, gpt-4o-mini", "gpt-4o-mini", true},
+		{"gpt-4o, gpt-4o-mini", "gpt-4", false},
+		{"gpt-4o", "gpt-4o-2024", false},
+	}
+	for _, tt := range tests {
+		config := Configuration{AllowedModels: tt.allowed}
+		if got := config.modelAllowed(tt.model); got != tt.want {
+			t.Errorf("modelAllowed(%q) with %q = %v, want %v", tt.model, tt.allowed, got, tt.want)
+		}
+	}
+}
+
+func TestValidateConfigChecksTheModel(t *testing.T) {
+	config := Configuration{Model: "gpt-4", AllowedModels: "gpt-4o"}
+	if err := validateConfig(config); err == nil || !strings.Contains(err.Error(), "allowed_models") {
+		t.Errorf("validateConfig = %v, want the model rejected", err)
+	}
+}
+
+func TestValidateConfigMode(t *testing.T) {
+	for mode, wantErr := range map[string]bool{"": false, "forms": false, "chat": false, "survey": true} {
+		if err := validateConfig(Configuration{Mode: mode}); (err != nil) != wantErr {
+			t.Errorf("mode %q: validateConfig = %v, want error %v", mode, err, wantErr)
+		}
+	}
+}
+
+func TestValidateConfigReasoningEffort(t *testing.T) {
+	for effort, wantErr := range map[string]bool{"": false, "minimal": false, "high": false, "max": true} {
+		if err := validateConfig(Configuration{ReasoningEffort: effort}); (err != nil) != wantErr {
+			t.Errorf("reasoning_effort %q: validateConfig = %v, want error %v", effort, err, wantErr)
+		}
+	}
+}
+
+func TestValidateConfigIdentityCookiePath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"", false},
+		{"/forms", false},
+		{"forms", true},
+	}
+	for _, tt := range tests {
+		form := testForm("f")
+		form.IdentityCookie.Path = tt.path
+		config := testConfig(t, form)
+		if err := validateConfig(config); (err != nil) != tt.wantErr {
+			t.Errorf("path %q: validateConfig = %v, want error %v", tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestValidateConfigMessages(t *testing.T) {
+	tests := []struct {
+		name    string
+		message LocalizedMessage
+		wantErr bool
+	}{
+		{"translation", LocalizedMessage{Key: "message_too_long", Lang: "it", Text: "Al massimo {{.Max}} caratteri"}, false},
+		{"unknown key", LocalizedMessage{Key: "greeting", Lang: "it", Text: "Ciao"}, true},
+		{"bad template", LocalizedMessage{Key: "message_too_long", Lang: "it", Text: "{{.Max"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, testForm("f"))
+			config.Messages.Message = []LocalizedMessage{tt.message}
+			if err := validateConfig(config); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateConfigAddresses(t *testing.T) {
+	tests := []struct {
+		name     string
+		bindAddr string
+		baseURL  string
+		wantErr  bool
+	}{
+		{"unset", "", "", false},
+		{"all interfaces", ":8080", "http://localhost:8080", false},
+		{"host and port", "127.0.0.1:8080", "https://forms.example.org", false},
+		{"ipv6", "[::1]:8443", "https://forms.example.org/gochat", false},
+		{"no port", "127.0.0.1", "", true},
+		{"empty port", "127.0.0.1:", "", true},
+		{"port out of range", ":99999", "", true},
+		{"no scheme", "", "forms.example.org", true},
+		{"other scheme", "", "ftp://forms.example.org", true},
+		{"no host", "", "https://", true},
+		{"query", "", "https://forms.example.org/?x=1", true},
+		{"fragment", "", "https://forms.example.org/#top", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, testForm("f"))
+			config.BindAddr = tt.bindAddr
+			config.BaseURL = tt.baseURL
+			if err := validateConfig(config); (err != nil) != tt.wantErr {
+				t.Errorf("validateConfig = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
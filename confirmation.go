@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// confirmationField is one labelled value on the confirmation page
+type confirmationField struct {
+	Label string
+	Value string
+}
+
+// confirmationValue formats a saved value for display, joining list items
+// and writing addresses on one line
+func confirmationValue(raw interface{}) string {
+	switch v := raw.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			items = append(items, confirmationValue(item))
+		}
+		return strings.Join(items, ", ")
+	case map[string]interface{}:
+		return addressLine(v)
+	}
+	data, _ := json.Marshal(raw)
+	return string(data)
+}
+
+// confirmationFields labels a saved record's values, form fields first and
+// then computed fields
+func confirmationFields(form ConfigurationForm, record map[string]interface{}) []confirmationField {
+	fields := make([]confirmationField, 0, len(formFields(form)))
+	for _, f := range formFields(form) {
+		fields = append(fields, confirmationField{Label: f.Label, Value: confirmationValue(record[f.Name])})
+	}
+	for _, computed := range form.ComputedFields.Field {
+		fields = append(fields, confirmationField{Label: computed.Name, Value: confirmationValue(record[computed.Name])})
+	}
+	return fields
+}
+
+// recordSavedAt is when a record file was last written, zero if unknown
+func recordSavedAt(filename string) time.Time {
+	if info, err := os.Stat(filename); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// handleConfirmation renders a saved submission as a printable receipt using
+// the confirmation_page template. Only the holder of the form's signed
+// identity cookie may see it (after re-identifying, where the form requires
+// it), so ?key= must match the cookie when given.
+func handleConfirmation(w http.ResponseWriter, r *http.Request, config Configuration, formName string) {
+	form := config.FormByName(formName)
+	held, ok := recordOwner(config, form, r)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		key = held
+	}
+	if key != held {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	filename, err := storedRecordPath(config, formName, key)
+	if err != nil {
+		http.Error(w, "Invalid record key", http.StatusBadRequest)
+		return
+	}
+	record, err := readRecord(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "Record not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to read %s: %v", formName, filename, err)
+		http.Error(w, "Failed to read record", http.StatusInternalServerError)
+		return
+	}
+
+	pageHTML := templateHTML(config, "confirmation_page", r)
+	if pageHTML == "" {
+		http.Error(w, "No confirmation page configured", http.StatusNotFound)
+		return
+	}
+
+	tmpl, err := template.New("confirmation").Parse(pageHTML)
+	if err != nil {
+		log.Printf("Template error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.Execute(w, map[string]interface{}{
+		"SiteTitle":    config.BrandingFor(form).SiteTitle,
+		"Branding":     config.BrandingFor(form),
+		"Form":         formName,
+		"SubmissionID": confirmationValue(record["_submission_id"]),
+		"SavedAt":      recordSavedAt(filename).Format("2006-01-02 15:04"),
+		"Fields":       confirmationFields(form, record),
+	}); err != nil {
+		log.Printf("Template error: %v", err)
+	}
+}
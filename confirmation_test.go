@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConfirmationValue(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  interface{}
+		want string
+	}{
+		{"missing", nil, ""},
+		{"text", "Ann", "Ann"},
+		{"list", []interface{}{"peanuts", "dust"}, "peanuts, dust"},
+		{"number", 3.5, "3.5"},
+	}
+	for _, tt := range tests {
+		if got := confirmationValue(tt.raw); got != tt.want {
+			t.Errorf("%s: confirmationValue = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConfirmationPage(t *testing.T) {
+	config := testConfig(t, testForm("f"))
+	page := `<templates><template name="confirmation_page">` +
+		`<![CDATA[{{.SubmissionID}}{{range .Fields}}|{{.Label}}={{.Value}}{{end}}]]></template></templates>`
+	if err := xml.Unmarshal([]byte(page), &config.Templates); err != nil {
+		t.Fatal(err)
+	}
+	owner := savedRecord(t, config, "f", map[string]string{"FirstName": "<Ann>", "License": "A1"})
+	tests := []struct {
+		name       string
+		cookie     *http.Cookie
+		query      string
+		wantStatus int
+	}{
+		{"owner", owner, "", http.StatusOK},
+		{"another key", owner, "?key=B2", http.StatusForbidden},
+		{"no identity", nil, "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/form/f/confirmation"+tt.query, nil)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			w := httptest.NewRecorder()
+			handleConfirmation(w, r, config, "f")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			body := w.Body.String()
+			if !strings.Contains(body, "|FirstName=&lt;Ann&gt;|License=A1") || strings.HasPrefix(body, "|") {
+				t.Errorf("page = %s, want the escaped fields after the submission ID", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// Suffix of the FormData key recording when a consent field was first set
+const consentedAtSuffix = "_consented_at"
+
+// requiresConsent reports whether a field may only be set after the user acknowledged it
+func requiresConsent(form ConfigurationForm, name string) bool {
+	for _, field := range splitFieldList(form.ConsentFields) {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isConsentTimestamp reports whether name is the timestamp of one of the form's consent fields
+func isConsentTimestamp(form ConfigurationForm, name string) bool {
+	field, ok := strings.CutSuffix(name, consentedAtSuffix)
+	return ok && requiresConsent(form, field)
+}
+
+// consentPrompt tells the model how to handle the form's consent fields
+func consentPrompt(form ConfigurationForm) (string, bool) {
+	fields := splitFieldList(form.ConsentFields)
+	if len(fields) == 0 {
+		return "", false
+	}
+	return "These fields need the user's explicit consent before they are recorded: " +
+		strings.Join(fields, ", ") + ".\n" +
+		"Ask the user to agree first. Once they do, send a line like: CONSENT " + fields[0] +
+		"\nbefore you SET the field. Values set without consent are discarded.", true
+}
+
+// acknowledgeConsent records that the user agreed to give a consent field
+func (s *ChatSession) acknowledgeConsent(field string) {
+	if s.Consented == nil {
+		s.Consented = make(map[string]bool)
+	}
+	s.Consented[field] = true
+}
+
+// checkConsent reports whether a SET or APPEND to field may go ahead. The
+// first accepted value of a consent field records its consent timestamp,
+// which is added to updates.
+func checkConsent(form ConfigurationForm, session *ChatSession, field string, updates map[string]string) bool {
+	if !requiresConsent(form, field) {
+		return true
+	}
+	if !session.Consented[field] {
+		log.Printf("⚠️ [%s]: Dropping value for %s, the user has not consented", form.Name, field)
+		return false
+	}
+	key := field + consentedAtSuffix
+	if session.FormData[key] == "" {
+		session.FormData[key] = time.Now().UTC().Format(time.RFC3339)
+		updates[key] = session.FormData[key]
+		log.Printf("✅ [%s]: Consent for %s recorded at %s", form.Name, field, session.FormData[key])
+	}
+	return true
+}
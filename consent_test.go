@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConsentFields(t *testing.T) {
+	tests := []struct {
+		name          string
+		reply         string
+		wantLicense   string
+		wantConsentAt bool
+	}{
+		{"SET without consent is dropped", "SET License A1", "", false},
+		{"CONSENT then SET", "CONSENT License\nSET License A1", "A1", true},
+		{"CONSENT after the SET is too late", "SET License A1\nCONSENT License", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.ConsentFields = "License"
+			config := testConfig(t, form)
+			session := &ChatSession{FormData: map[string]string{}}
+
+			turn := applyResponse(config, "f", session, tt.reply)
+			if got := session.FormData["License"]; got != tt.wantLicense {
+				t.Errorf("License = %q, want %q", got, tt.wantLicense)
+			}
+			consentedAt := session.FormData["License"+consentedAtSuffix]
+			if (consentedAt != "") != tt.wantConsentAt {
+				t.Fatalf("consent timestamp = %q, want one %v", consentedAt, tt.wantConsentAt)
+			}
+			if tt.wantConsentAt {
+				if _, err := time.Parse(time.RFC3339, consentedAt); err != nil {
+					t.Errorf("consent timestamp %q: %v", consentedAt, err)
+				}
+				if turn.FormUpdates["License"+consentedAtSuffix] != consentedAt {
+					t.Errorf("updates %v do not report the consent timestamp", turn.FormUpdates)
+				}
+			}
+		})
+	}
+}
+
+func TestConsentTimestampIsKeptFromTheFirstValue(t *testing.T) {
+	form := testForm("f")
+	form.ConsentFields = "License"
+	config := testConfig(t, form)
+	session := &ChatSession{FormData: map[string]string{"License" + consentedAtSuffix: "2024-01-01T00:00:00Z"}}
+	session.acknowledgeConsent("License")
+
+	applyResponse(config, "f", session, "SET License B2")
+	if got := session.FormData["License"+consentedAtSuffix]; got != "2024-01-01T00:00:00Z" {
+		t.Errorf("consent timestamp changed to %s", got)
+	}
+	if !isConsentTimestamp(form, "License"+consentedAtSuffix) || isConsentTimestamp(form, "FirstName"+consentedAtSuffix) {
+		t.Error("isConsentTimestamp does not follow consent_fields")
+	}
+}
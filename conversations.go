@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// conversationExport is one line of /admin/conversations.jsonl
+type conversationExport struct {
+	Form         string        `json:"form"`
+	Key          string        `json:"key"`
+	SubmissionID string        `json:"submission_id,omitempty"`
+	SavedAt      time.Time     `json:"saved_at"`
+	Transcript   []ChatMessage `json:"transcript"`
+}
+
+// storedConversations calls emit for each saved record of the form that kept
+// its transcript (store_transcript), in key order
+func storedConversations(config Configuration, formName string, emit func(conversationExport) error) error {
+	entries, err := os.ReadDir(formDataDir(config, formName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !isRecordFile(entry.Name()) {
+			continue
+		}
+		filename := filepath.Join(formDataDir(config, formName), entry.Name())
+		record, err := readRecord(filename)
+		if err != nil {
+			log.Printf("⚠️ EXPORT [%s]: skipping %s: %v", formName, entry.Name(), err)
+			continue
+		}
+		raw, ok := record["_transcript"]
+		if !ok {
+			continue
+		}
+		// Round trip so JSON and YAML records decode the same way
+		var transcript []ChatMessage
+		data, err := json.Marshal(raw)
+		if err == nil {
+			err = json.Unmarshal(data, &transcript)
+		}
+		if err != nil {
+			log.Printf("⚠️ EXPORT [%s]: bad transcript in %s: %v", formName, entry.Name(), err)
+			continue
+		}
+		conversation := conversationExport{
+			Form:         formName,
+			Key:          strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+			SubmissionID: confirmationValue(record["_submission_id"]),
+			Transcript:   transcript,
+		}
+		if info, err := entry.Info(); err == nil {
+			conversation.SavedAt = info.ModTime().UTC()
+		}
+		if err := emit(conversation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleConversationExport streams the stored transcripts of every form, or
+// of ?form=, one JSON object per line. ?sample=0.1 keeps about a tenth of them.
+// Transcripts were scrubbed when they were saved.
+func handleConversationExport(w http.ResponseWriter, r *http.Request, config Configuration) {
+	if !allowAdmin(w, r) {
+		return
+	}
+	sample := 1.0
+	if s := r.URL.Query().Get("sample"); s != "" {
+		var err error
+		if sample, err = strconv.ParseFloat(s, 64); err != nil || sample <= 0 || sample > 1 {
+			http.Error(w, "sample must be a fraction between 0 and 1", http.StatusBadRequest)
+			return
+		}
+	}
+	only := r.URL.Query().Get("form")
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	enc := json.NewEncoder(w)
+	exported := 0
+	for _, form := range config.Forms.Form {
+		if only != "" && form.Name != only {
+			continue
+		}
+		err := storedConversations(config, form.Name, func(c conversationExport) error {
+			if sample < 1 && rand.Float64() >= sample {
+				return nil
+			}
+			if err := enc.Encode(c); err != nil {
+				return err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+			exported++
+			return nil
+		})
+		if err != nil {
+			log.Printf("❌ EXPORT [%s]: %v", form.Name, err)
+			return
+		}
+	}
+	log.Printf("📤 EXPORT: streamed %d conversations", exported)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConversationExport(t *testing.T) {
+	t.Setenv(adminTokenEnv, "letmein")
+	config := testConfig(t, testForm("a"), testForm("b"))
+	records := map[string]string{
+		"a/1.json": `{"License": "1", "_submission_id": "S1", "_transcript": [{"role": "user", "content": "hi"}]}`,
+		"a/2.json": `{"License": "2"}`,
+		"a/3.json": `not json`,
+		"b/4.json": `{"License": "4", "_transcript": [{"role": "assistant", "content": "hello"}]}`,
+	}
+	for name, content := range records {
+		filename := filepath.Join(config.DataDir, name)
+		os.MkdirAll(filepath.Dir(filename), 0755)
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantKeys   []string
+	}{
+		{"every form", "", http.StatusOK, []string{"a/1", "b/4"}},
+		{"one form", "?form=b", http.StatusOK, []string{"b/4"}},
+		{"sample everything", "?sample=1", http.StatusOK, []string{"a/1", "b/4"}},
+		{"bad sample", "?sample=2", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/conversations.jsonl"+tt.query, nil)
+			r.Header.Set("Authorization", "Bearer letmein")
+			w := httptest.NewRecorder()
+			handleConversationExport(w, r, config)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			var keys []string
+			for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+				if w.Code != http.StatusOK || line == "" {
+					break
+				}
+				var c conversationExport
+				if err := json.Unmarshal([]byte(line), &c); err != nil {
+					t.Fatalf("bad line %q: %v", line, err)
+				}
+				if len(c.Transcript) != 1 || c.SavedAt.IsZero() {
+					t.Errorf("conversation %+v", c)
+				}
+				keys = append(keys, c.Form+"/"+c.Key)
+			}
+			if strings.Join(keys, ",") != strings.Join(tt.wantKeys, ",") {
+				t.Errorf("exported %v, want %v", keys, tt.wantKeys)
+			}
+		})
+	}
+}
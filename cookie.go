@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// IdentityCookie names and scopes the cookie that links later forms to a
+// saved record. The global settings apply to every form, and each form may
+// override any part of them.
+type IdentityCookie struct {
+	Name   string `xml:"name"`
+	Domain string `xml:"domain"`
+	Path   string `xml:"path"`
+	// Also read cookies holding a bare record key, as set before cookies were
+	// signed; meant only while those cookies are still around, as anyone can
+	// set one by hand
+	AcceptUnsigned bool `xml:"accept_unsigned"`
+}
+
+// IdentityCookieFor resolves a form's identity cookie: its own settings first,
+// then the global ones, then the primary key as the name (its fields joined by
+// "_" for a composite key) and the base path
+func (c Configuration) IdentityCookieFor(form ConfigurationForm) IdentityCookie {
+	return IdentityCookie{
+		Name:   firstNonEmpty(form.IdentityCookie.Name, c.IdentityCookie.Name, strings.Join(primaryKeyFields(form), "_")),
+		Domain: firstNonEmpty(form.IdentityCookie.Domain, c.IdentityCookie.Domain),
+		Path:   firstNonEmpty(form.IdentityCookie.Path, c.IdentityCookie.Path, c.Path("/")),
+
+		AcceptUnsigned: form.IdentityCookie.AcceptUnsigned || c.IdentityCookie.AcceptUnsigned,
+	}
+}
+
+// Environment variable holding the key identity cookies are signed with
+const cookieSecretEnv = "GOCHAT_COOKIE_SECRET"
+
+// checkCookieSecret requires GOCHAT_COOKIE_SECRET wherever records are saved
+// and identity cookies set, so returning users are still recognized after a
+// restart
+func checkCookieSecret(config Configuration) error {
+	if config.ChatOnly() || os.Getenv(cookieSecretEnv) != "" {
+		return nil
+	}
+	return fmt.Errorf("%s must be set to sign identity cookies", cookieSecretEnv)
+}
+
+// The signing key, from GOCHAT_COOKIE_SECRET; checkCookieSecret stops the
+// server from starting without it, so the random key is only used in chat
+// mode, where no identity cookie is set
+var (
+	cookieKeyOnce sync.Once
+	cookieKey     []byte
+)
+
+func cookieSigningKey() []byte {
+	cookieKeyOnce.Do(func() {
+		if secret := os.Getenv(cookieSecretEnv); secret != "" {
+			cookieKey = []byte(secret)
+			return
+		}
+		log.Printf("⚠️ COOKIE: %s is not set, identity cookies will stop working on restart", cookieSecretEnv)
+		cookieKey = make([]byte, 32)
+		if _, err := rand.Read(cookieKey); err != nil {
+			panic(err)
+		}
+	})
+	return cookieKey
+}
+
+func identitySignature(name, key string) string {
+	mac := hmac.New(sha256.New, cookieSigningKey())
+	mac.Write([]byte(name + "\x00" + key))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signIdentity encodes a record key for the named cookie as <key>.<signature>,
+// so the cookie can only ever carry a key this server handed out
+func signIdentity(name, key string) string {
+	return key + "." + identitySignature(name, key)
+}
+
+var errForgedIdentity = errors.New("identity cookie signature does not match")
+
+// identityCookieValue reads the key from the form's identity cookie, refusing
+// a cookie whose signature does not match, such as one set by hand, unless
+// accept_unsigned takes it as a key set before cookies were signed
+func identityCookieValue(config Configuration, form ConfigurationForm, r *http.Request) (string, error) {
+	settings := config.IdentityCookieFor(form)
+	c, err := r.Cookie(settings.Name)
+	if err != nil {
+		return "", err
+	}
+	i := strings.LastIndex(c.Value, ".")
+	if i >= 0 && hmac.Equal([]byte(c.Value[i+1:]), []byte(identitySignature(settings.Name, c.Value[:i]))) {
+		return c.Value[:i], nil
+	}
+	if settings.AcceptUnsigned {
+		log.Printf("⚠️ COOKIE [%s]: accepting an unsigned identity cookie", form.Name)
+		return c.Value, nil
+	}
+	return "", errForgedIdentity
+}
+
+// recordOwner is the key of the form's record the caller may see: the one in
+// their signed identity cookie. Where the record is only shown after
+// re-identification, the cookie alone is not enough and the caller must also
+// have proved the key in their session.
+func recordOwner(config Configuration, form ConfigurationForm, r *http.Request) (string, bool) {
+	key, err := identityCookieValue(config, form, r)
+	if err != nil || key == "" {
+		return "", false
+	}
+	if reauthRequired(config, form) && !reidentifiedFor(config, form, r, key) {
+		log.Printf("🔒 [%s]: record withheld until the user restates their key", form.Name)
+		return "", false
+	}
+	return key, true
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdentityCookieFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		global IdentityCookie
+		form   IdentityCookie
+		key    string
+		want   IdentityCookie
+	}{
+		{"defaults", IdentityCookie{}, IdentityCookie{}, "License", IdentityCookie{Name: "License", Path: "/intake/"}},
+		{"composite key", IdentityCookie{}, IdentityCookie{}, "License,State", IdentityCookie{Name: "License_State", Path: "/intake/"}},
+		{
+			"global settings", IdentityCookie{Name: "who", Domain: "example.com", Path: "/"}, IdentityCookie{}, "License",
+			IdentityCookie{Name: "who", Domain: "example.com", Path: "/"},
+		},
+		{
+			"form overrides part of them", IdentityCookie{Name: "who", Domain: "example.com"}, IdentityCookie{Name: "driver"}, "License",
+			IdentityCookie{Name: "driver", Domain: "example.com", Path: "/intake/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{BasePath: "/intake", IdentityCookie: tt.global}
+			form := ConfigurationForm{PrimaryKey: tt.key, IdentityCookie: tt.form}
+			if got := config.IdentityCookieFor(form); got != tt.want {
+				t.Errorf("IdentityCookieFor = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdentityCookieValue(t *testing.T) {
+	form := testForm("f")
+	form.IdentityCookie.Name = "driver"
+	config := testConfig(t, form)
+	tests := []struct {
+		name    string
+		cookie  *http.Cookie
+		want    string
+		wantErr error
+	}{
+		{"signed", &http.Cookie{Name: "driver", Value: signIdentity("driver", "A1")}, "A1", nil},
+		{"set by hand", &http.Cookie{Name: "driver", Value: "A1"}, "", errForgedIdentity},
+		{"other key's signature", &http.Cookie{Name: "driver", Value: "B2." + identitySignature("driver", "A1")}, "", errForgedIdentity},
+		{"signed for another cookie", &http.Cookie{Name: "driver", Value: signIdentity("License", "A1")}, "", errForgedIdentity},
+		{"missing", nil, "", http.ErrNoCookie},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/form/f", nil)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			got, err := identityCookieValue(config, form, r)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("identityCookieValue = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIdentityCookieValueAcceptUnsigned(t *testing.T) {
+	tests := []struct {
+		name    string
+		global  bool
+		form    bool
+		value   string
+		want    string
+		wantErr error
+	}{
+		{"legacy cookie refused", false, false, "A1", "", errForgedIdentity},
+		{"legacy cookie accepted", true, false, "A1", "A1", nil},
+		{"accepted for one form", false, true, "A1", "A1", nil},
+		{"legacy key with a dot", true, false, "ann.lee", "ann.lee", nil},
+		{"signed cookie", true, false, signIdentity("driver", "A1"), "A1", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.IdentityCookie = IdentityCookie{Name: "driver", AcceptUnsigned: tt.form}
+			config := testConfig(t, form)
+			config.IdentityCookie.AcceptUnsigned = tt.global
+			r := httptest.NewRequest(http.MethodGet, "/form/f", nil)
+			r.AddCookie(&http.Cookie{Name: "driver", Value: tt.value})
+			got, err := identityCookieValue(config, form, r)
+			if got != tt.want || !errors.Is(err, tt.wantErr) {
+				t.Errorf("identityCookieValue = %q, %v, want %q, %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckCookieSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		secret  string
+		wantErr bool
+	}{
+		{"set", "", "s3cret", false},
+		{"missing", "", "", true},
+		{"chat mode sets no cookies", "chat", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(cookieSecretEnv, tt.secret)
+			config := testConfig(t, testForm("f"))
+			config.Mode = tt.mode
+			if err := checkCookieSecret(config); (err != nil) != tt.wantErr {
+				t.Errorf("checkCookieSecret = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Device classes that templates can have variants for, e.g. chat_form_mobile
+var deviceClasses = []string{"mobile", "tablet", "kiosk", "desktop"}
+
+// deviceClass classifies the client from ?view= when it names a known class,
+// otherwise from the User-Agent. Kiosks can only be chosen with ?view=kiosk.
+func deviceClass(r *http.Request) string {
+	view := strings.ToLower(r.URL.Query().Get("view"))
+	for _, class := range deviceClasses {
+		if view == class {
+			return class
+		}
+	}
+	ua := r.Header.Get("User-Agent")
+	switch {
+	case strings.Contains(ua, "iPad") || strings.Contains(ua, "Tablet") ||
+		(strings.Contains(ua, "Android") && !strings.Contains(ua, "Mobile")):
+		return "tablet"
+	case strings.Contains(ua, "Mobi") || strings.Contains(ua, "iPhone"):
+		return "mobile"
+	}
+	return "desktop"
+}
+
+// templateHTML finds the named template, preferring the variant for the
+// client's device class, such as chat_form_mobile, when one is configured
+func templateHTML(config Configuration, name string, r *http.Request) string {
+	variant := name + "_" + deviceClass(r)
+	var html string
+	for _, tmpl := range config.Templates.Template {
+		switch tmpl.Name {
+		case variant:
+			return tmpl.HTML
+		case name:
+			if html == "" {
+				html = tmpl.HTML
+			}
+		}
+	}
+	return html
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const (
+	iPhoneUA  = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148"
+	iPadUA    = "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X)"
+	androidUA = "Mozilla/5.0 (Linux; Android 14; SM-X700)"
+	phoneUA   = "Mozilla/5.0 (Linux; Android 14; Pixel 8) Mobile Safari/537.36"
+	desktopUA = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)"
+)
+
+func TestDeviceClass(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		ua    string
+		want  string
+	}{
+		{"iPhone", "", iPhoneUA, "mobile"},
+		{"Android phone", "", phoneUA, "mobile"},
+		{"iPad", "", iPadUA, "tablet"},
+		{"Android tablet", "", androidUA, "tablet"},
+		{"desktop", "", desktopUA, "desktop"},
+		{"no user agent", "", "", "desktop"},
+		{"view overrides the user agent", "?view=Desktop", iPhoneUA, "desktop"},
+		{"kiosk only by view", "?view=kiosk", desktopUA, "kiosk"},
+		{"unknown view", "?view=watch", iPhoneUA, "mobile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/form/f"+tt.query, nil)
+			r.Header.Set("User-Agent", tt.ua)
+			if got := deviceClass(r); got != tt.want {
+				t.Errorf("deviceClass = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemplateHTML(t *testing.T) {
+	var config Configuration
+	templates := `<templates>` +
+		`<template name="chat_form">default</template>` +
+		`<template name="chat_form_mobile">mobile</template>` +
+		`<template name="home_page_tablet">tablet home</template>` +
+		`</templates>`
+	if err := xml.Unmarshal([]byte(templates), &config.Templates); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		template string
+		ua       string
+		want     string
+	}{
+		{"variant for the device", "chat_form", iPhoneUA, "mobile"},
+		{"no variant for the device", "chat_form", iPadUA, "default"},
+		{"variant without a default", "home_page", iPadUA, "tablet home"},
+		{"not configured", "home_page", desktopUA, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("User-Agent", tt.ua)
+			if got := templateHTML(config, tt.template, r); got != tt.want {
+				t.Errorf("templateHTML = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ensemble sends a form's turns to several models at once and keeps the
+// reply that best follows the command protocol
+type Ensemble struct {
+	// Comma separated models to ask; fewer than two disables the ensemble
+	Models string `xml:"models"`
+	// How long to wait for the models (default 30s); slower replies are dropped
+	Timeout string `xml:"timeout"`
+}
+
+// Used when the ensemble's timeout is not configured
+const defaultEnsembleTimeout = 30 * time.Second
+
+// Enabled reports whether there are at least two models to compare
+func (e Ensemble) Enabled() bool {
+	return len(splitFieldList(e.Models)) > 1
+}
+
+// TimeoutDuration parses the timeout, falling back to the default
+func (e Ensemble) TimeoutDuration() time.Duration {
+	if d, err := time.ParseDuration(e.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultEnsembleTimeout
+}
+
+// protocolScore rates how well a reply follows the command protocol: one
+// point for each line of commands, minus one for each other non-blank line
+func protocolScore(config Configuration, form ConfigurationForm, content string) int {
+	if config.ChatOnly() {
+		if strings.TrimSpace(content) == "" {
+			return 0
+		}
+		return 1
+	}
+	score := 0
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if len(parseCommands(config, form, line)) > 0 {
+			score++
+		} else {
+			score--
+		}
+	}
+	return score
+}
+
+// ensembleChatGPT asks each of the form's ensemble models for a reply
+// concurrently and returns the highest scoring one. Ties go to the model
+// listed first. Only the chosen reply is applied by the caller.
+func ensembleChatGPT(ctx context.Context, config Configuration, form ConfigurationForm, messages []ChatMessage) (*ChatResponse, error) {
+	models := splitFieldList(form.Ensemble.Models)
+	ctx, cancel := context.WithTimeout(ctx, form.Ensemble.TimeoutDuration())
+	defer cancel()
+
+	resps := make([]*ChatResponse, len(models))
+	errs := make([]error, len(models))
+	var wg sync.WaitGroup
+	for i, model := range models {
+		wg.Add(1)
+		go func(i int, model string) {
+			defer wg.Done()
+			c := config
+			c.Model = model
+			resps[i], errs[i] = callChatGPT(ctx, c, messages)
+		}(i, model)
+	}
+	wg.Wait()
+
+	best, bestScore := -1, 0
+	for i, resp := range resps {
+		if errs[i] != nil || resp == nil || len(resp.Choices) == 0 {
+			log.Printf("⚠️ ENSEMBLE [%s]: %s gave no reply: %v", form.Name, models[i], errs[i])
+			continue
+		}
+		score := protocolScore(config, form, resp.Choices[0].Message.Content)
+		log.Printf("🎯 ENSEMBLE [%s]: %s scored %d", form.Name, models[i], score)
+		if best == -1 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best == -1 {
+		// Models that answered with nothing leave no error of their own
+		if err := errors.Join(errs...); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w from any of %s", errEmptyReply, strings.Join(models, ", "))
+	}
+	if resps[best].Model == "" {
+		resps[best].Model = models[best]
+	}
+	log.Printf("🎯 ENSEMBLE [%s]: using the reply from %s", form.Name, models[best])
+	return resps[best], nil
+}
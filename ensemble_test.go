@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestProtocolScore(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		content string
+		want    int
+	}{
+		{"commands", "", "SAY Hi\n\nSET FirstName Ann", 2},
+		{"prose costs a point", "", "Sure thing!\nSAY Hi", 0},
+		{"only prose", "", "Hello there", -1},
+		{"chat mode reply", "chat", "Hello there", 1},
+		{"chat mode blank reply", "chat", "  ", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{Mode: tt.mode}
+			if got := protocolScore(config, testForm("f"), tt.content); got != tt.want {
+				t.Errorf("protocolScore = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsembleKeepsTheBestReply(t *testing.T) {
+	tests := []struct {
+		name      string
+		replies   map[string]string // by model; missing models fail
+		wantModel string
+		wantErr   error
+	}{
+		{"most compliant wins", map[string]string{"m1": "Hi!", "m2": "SAY Hi\nSET FirstName Ann", "m3": "SAY Hi"}, "m2", nil},
+		{"ties go to the first listed", map[string]string{"m1": "SAY Hi", "m2": "SAY Hello", "m3": "SAY Hey"}, "m1", nil},
+		{"failed models are skipped", map[string]string{"m3": "Hi!"}, "m3", nil},
+		{"no model replies", map[string]string{"m1": "", "m2": "", "m3": ""}, "", errEmptyReply},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OPENAI_API_KEY", "test-key")
+			previous := chatClient.Transport
+			t.Cleanup(func() { chatClient.Transport = previous })
+			chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				var request struct{ Model string }
+				json.NewDecoder(r.Body).Decode(&request)
+				reply, ok := tt.replies[request.Model]
+				if !ok {
+					return jsonResponse(r, http.StatusBadRequest, `{"error": {"message": "no"}}`), nil
+				}
+				if reply == "" {
+					return jsonResponse(r, http.StatusOK, `{"choices": []}`), nil
+				}
+				var resp ChatResponse
+				json.Unmarshal([]byte(completion(reply)), &resp)
+				resp.Model = ""
+				data, _ := json.Marshal(resp)
+				return jsonResponse(r, http.StatusOK, string(data)), nil
+			})
+			form := testForm("f")
+			form.Ensemble = Ensemble{Models: "m1, m2, m3"}
+			config := testConfig(t, form)
+
+			resp, err := ensembleChatGPT(context.Background(), config, form, []ChatMessage{{Role: "user", Content: "hi"}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ensembleChatGPT error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && resp.Model != tt.wantModel {
+				t.Errorf("kept the reply from %s, want %s", resp.Model, tt.wantModel)
+			}
+		})
+	}
+}
+
+func TestStreamedTurnsAskTheEnsemble(t *testing.T) {
+	fake := fakeChat(t)
+	chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, request)
+		fake.mu.Unlock()
+		if request["model"] == "m2" {
+			return jsonResponse(r, http.StatusOK, completion("SET FirstName Ann\nSAY Hi Ann")), nil
+		}
+		return jsonResponse(r, http.StatusOK, completion("Hi!")), nil
+	})
+	form := testForm("f")
+	form.Ensemble = Ensemble{Models: "m1, m2"}
+	config := testConfig(t, form)
+
+	var names []string
+	for _, event := range streamEvents(t, config, "f", "I'm Ann") {
+		names = append(names, event.Name)
+		if event.Name == "update" && event.Data["FirstName"] != "Ann" {
+			t.Errorf("update %v, want FirstName Ann from m2", event.Data)
+		}
+	}
+	if want := []string{"typing", "update", "message", "done"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("events %v, want %v", names, want)
+	}
+	models := map[interface{}]bool{}
+	for _, request := range fake.requests {
+		models[request["model"]] = true
+		if request["stream"] == true {
+			t.Errorf("ensemble request to %v was streamed", request["model"])
+		}
+	}
+	if len(models) != 2 || !models["m1"] || !models["m2"] {
+		t.Errorf("asked %v, want m1 and m2", models)
+	}
+}
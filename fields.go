@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// formFieldByName finds a field declared in the form's form_fields
+func formFieldByName(form ConfigurationForm, name string) (FormField, bool) {
+	for _, f := range formFields(form) {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return FormField{}, false
+}
+
+// isListField reports whether a field was declared with the [list] type
+func isListField(form ConfigurationForm, name string) bool {
+	f, ok := formFieldByName(form, name)
+	return ok && f.Type == "list"
+}
+
+// isProtectedField reports whether the form forbids the model from setting a field
+func isProtectedField(form ConfigurationForm, name string) bool {
+	for _, protected := range splitFieldList(form.ProtectedFields) {
+		if protected == name {
+			return true
+		}
+	}
+	return false
+}
+
+// List fields are held in FormData as a JSON array string and saved as a real array.
+
+func decodeList(value string) []string {
+	var items []string
+	if strings.TrimSpace(value) == "" {
+		return items
+	}
+	if err := json.Unmarshal([]byte(value), &items); err != nil {
+		return []string{value}
+	}
+	return items
+}
+
+func encodeList(items []string) string {
+	if items == nil {
+		items = []string{}
+	}
+	data, _ := json.Marshal(items)
+	return string(data)
+}
+
+// listFromSet interprets a SET on a list field: a JSON array replaces the
+// list, anything else becomes a one item list
+func listFromSet(value string) string {
+	var items []string
+	if err := json.Unmarshal([]byte(value), &items); err == nil {
+		return encodeList(items)
+	}
+	return encodeList([]string{value})
+}
+
+// appendToList adds one item to a list field's stored value
+func appendToList(current, item string) string {
+	return encodeList(append(decodeList(current), item))
+}
+
+// contextValue converts a saved record value to its FormData form,
+// reporting false for values that aren't form data
+func contextValue(raw interface{}) (string, bool) {
+	switch v := raw.(type) {
+	case string:
+		return v, true
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return "", false
+			}
+			items = append(items, s)
+		}
+		return encodeList(items), true
+	case map[string]interface{}:
+		data, _ := json.Marshal(v)
+		return string(data), true
+	}
+	return "", false
+}
+
+// listFieldNames are the form's fields declared with the [list] type
+func listFieldNames(form ConfigurationForm) []string {
+	var names []string
+	for _, f := range formFields(form) {
+		if f.Type == "list" {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
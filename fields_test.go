@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestListFieldValues(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"SET with an array replaces the list", listFromSet(`["a", "b"]`), `["a","b"]`},
+		{"SET with plain text is a one item list", listFromSet("a, b"), `["a, b"]`},
+		{"APPEND to nothing", appendToList("", "a"), `["a"]`},
+		{"APPEND to a list", appendToList(`["a"]`, "b"), `["a","b"]`},
+		{"APPEND to a stray scalar keeps it", appendToList("a", "b"), `["a","b"]`},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestContextValue(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    interface{}
+		want   string
+		wantOK bool
+	}{
+		{"string", "Ann", "Ann", true},
+		{"list", []interface{}{"a", "b"}, `["a","b"]`, true},
+		{"mixed list", []interface{}{"a", 1.0}, "", false},
+		{"number", 3.0, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := contextValue(tt.raw)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("%s: contextValue = %q, %v, want %q, %v", tt.name, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestAppendOnlyFillsListFields(t *testing.T) {
+	form := testForm("f")
+	form.Fields += "\nAllergies: {{.Allergies}} (like peanuts) [list]"
+	config := testConfig(t, form)
+	session := &ChatSession{FormData: map[string]string{}}
+
+	turn := applyResponse(config, "f", session, "APPEND Allergies peanuts\nAPPEND Allergies dust\nAPPEND FirstName Ann")
+	if got := session.FormData["Allergies"]; got != `["peanuts","dust"]` {
+		t.Errorf("Allergies = %s", got)
+	}
+	if _, ok := session.FormData["FirstName"]; ok || turn.Commands != 2 {
+		t.Errorf("APPEND to a plain field was applied: %v, %d commands", session.FormData, turn.Commands)
+	}
+}
+
+func TestProtectedFieldsAreNotSetByTheModel(t *testing.T) {
+	tests := []struct {
+		name      string
+		protected string
+		reply     string
+		field     string
+		want      string
+	}{
+		{"SET to a protected field", "License", "SET License 999", "License", "A1"},
+		{"APPEND to a protected field", "License, Allergies", "APPEND Allergies dust", "Allergies", ""},
+		{"SET to another field", "Allergies", "SET License 999", "License", "999"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.Fields += "\nAllergies: {{.Allergies}} [list]"
+			form.ProtectedFields = tt.protected
+			config := testConfig(t, form)
+			session := &ChatSession{FormData: map[string]string{"License": "A1"}}
+
+			turn := applyResponse(config, "f", session, tt.reply)
+			if got := session.FormData[tt.field]; got != tt.want {
+				t.Errorf("%s = %q, want %q (updates %v)", tt.field, got, tt.want, turn.FormUpdates)
+			}
+		})
+	}
+}
+
+func TestMaxSetsPerTurn(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  map[string]string
+	}{
+		{"no limit", 0, map[string]string{"FirstName": "Ann", "License": "A1", "Allergies": `["dust"]`}},
+		{"over the limit", 2, map[string]string{"FirstName": "Ann", "License": "A1"}},
+		{"APPEND counts too", 1, map[string]string{"FirstName": "Ann"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.Fields += "\nAllergies: {{.Allergies}} [list]"
+			config := testConfig(t, form)
+			config.MaxSetsPerTurn = tt.limit
+			session := &ChatSession{FormData: map[string]string{}}
+
+			turn := applyResponse(config, "f", session, "SET FirstName Ann\nSAY Thanks\nSET License A1\nAPPEND Allergies dust")
+			if !reflect.DeepEqual(session.FormData, tt.want) {
+				t.Errorf("form data = %v, want %v", session.FormData, tt.want)
+			}
+			if turn.ResponseText() != "Thanks" {
+				t.Errorf("SAY was dropped: %q", turn.ResponseText())
+			}
+		})
+	}
+}
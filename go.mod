@@ -5,4 +5,5 @@ go 1.22.5
 require (
 	github.com/sashabaranov/go-openai v1.35.7
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	gopkg.in/yaml.v3 v3.0.1
 )
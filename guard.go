@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Used when the jailbreak guard has patterns but no refusal
+const defaultJailbreakRefusal = "I can only help with filling in this form."
+
+// JailbreakGuard refuses user messages that match obvious prompt injection or
+// system prompt extraction patterns, without sending them to the model
+type JailbreakGuard struct {
+	Patterns []string `xml:"pattern"`
+	Refusal  string   `xml:"refusal"`
+}
+
+// RefusalText is the reply sent for a refused message
+func (g JailbreakGuard) RefusalText() string {
+	if strings.TrimSpace(g.Refusal) != "" {
+		return strings.TrimSpace(g.Refusal)
+	}
+	return defaultJailbreakRefusal
+}
+
+// Global compiled jailbreak patterns, empty when the guard is not configured
+var jailbreakPatterns []*regexp.Regexp
+
+// compileJailbreakGuard compiles the guard's patterns, matching case-insensitively
+func compileJailbreakGuard(guard JailbreakGuard) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(guard.Patterns))
+	for _, pattern := range guard.Patterns {
+		re, err := regexp.Compile("(?i)" + strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("jailbreak pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// detectJailbreak returns the first pattern the message matches
+func detectJailbreak(message string) (string, bool) {
+	for _, re := range jailbreakPatterns {
+		if re.MatchString(message) {
+			return re.String(), true
+		}
+	}
+	return "", false
+}
+
+// cannedReply answers messages that should not reach the model: blank
+// messages when an empty_message_reply is set, and suspected jailbreaks
+func cannedReply(config Configuration, formName, message string) (string, bool) {
+	if config.EmptyMessageReply != "" && strings.TrimSpace(message) == "" {
+		log.Printf("💤 EMPTY [%s]: \"%s\"", formName, config.EmptyMessageReply)
+		return config.EmptyMessageReply, true
+	}
+	if pattern, ok := detectJailbreak(message); ok {
+		log.Printf("🚫 JAILBREAK [%s]: refused message matching %s", formName, pattern)
+		return config.JailbreakGuard.RefusalText(), true
+	}
+	return "", false
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Used when summarize_history does not set keep_recent
+const defaultKeepRecent = 6
+
+// HistorySummary compacts long conversations: once the history after the
+// system prompt exceeds MaxChars, everything but the last KeepRecent messages
+// is replaced by a model-written summary. Model may name a cheaper model.
+type HistorySummary struct {
+	MaxChars   int    `xml:"max_chars"`
+	KeepRecent int    `xml:"keep_recent"`
+	Model      string `xml:"model"`
+}
+
+// Enabled reports whether history compaction is configured
+func (h HistorySummary) Enabled() bool {
+	return h.MaxChars > 0
+}
+
+func (h HistorySummary) keepRecent() int {
+	if h.KeepRecent > 0 {
+		return h.KeepRecent
+	}
+	return defaultKeepRecent
+}
+
+const summaryPrompt = `Summarize the conversation below for the assistant that is continuing it.
+Keep every value the user gave, every question still open, and anything the user was promised.
+Reply with the summary only.`
+
+// Prefix of the system message that replaces the summarized turns
+const summaryHeader = "Summary of the conversation so far:\n"
+
+// historyChars is the size of the history after the system prompt
+func historyChars(messages []ChatMessage) int {
+	total := 0
+	for _, msg := range messages[1:] {
+		total += len(msg.Content)
+	}
+	return total
+}
+
+// compactHistory replaces older turns with a summary when the session's
+// history is over budget. On failure the history is left as it was.
+func compactHistory(ctx context.Context, config Configuration, formName string, session *ChatSession) {
+	h := config.SummarizeHistory
+	if !h.Enabled() || len(session.Messages) <= h.keepRecent()+1 || historyChars(session.Messages) <= h.MaxChars {
+		return
+	}
+
+	split := len(session.Messages) - h.keepRecent()
+	older := session.Messages[1:split]
+	var transcript strings.Builder
+	for _, msg := range older {
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+	}
+
+	summaryConfig := config
+	if h.Model != "" {
+		summaryConfig.Model = h.Model
+	}
+	resp, err := callChatGPT(ctx, summaryConfig, []ChatMessage{
+		{Role: "system", Content: summaryPrompt},
+		{Role: "user", Content: transcript.String()},
+	})
+	if err != nil || len(resp.Choices) == 0 {
+		log.Printf("❌ SUMMARY [%s]: keeping full history, summary failed: %v", formName, err)
+		return
+	}
+
+	summary := strings.TrimSpace(resp.Choices[0].Message.Content)
+	messages := []ChatMessage{session.Messages[0], {Role: "system", Content: summaryHeader + summary}}
+	session.Messages = append(messages, session.Messages[split:]...)
+	log.Printf("🗜️ SUMMARY [%s]: replaced %d messages with a summary", formName, len(older))
+}
+
+const submissionSummaryPrompt = `Summarize the conversation below in one short paragraph for a reviewer of the submitted form.
+Mention what the user wanted and anything unusual or left unresolved. Reply with the summary only.`
+
+// summarizeSubmission asks the model for a one-paragraph summary of the
+// session's scrubbed transcript, using submission_summary_model when set.
+// A failed call only loses the summary, reported as false.
+func summarizeSubmission(ctx context.Context, config Configuration, formName string, session *ChatSession) (string, bool) {
+	var transcript strings.Builder
+	for _, msg := range sessionTranscript(session) {
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+	}
+	if transcript.Len() == 0 {
+		return "", false
+	}
+
+	summaryConfig := config
+	if config.SubmissionSummaryModel != "" {
+		summaryConfig.Model = config.SubmissionSummaryModel
+	}
+	resp, err := callChatGPT(ctx, summaryConfig, []ChatMessage{
+		{Role: "system", Content: submissionSummaryPrompt},
+		{Role: "user", Content: transcript.String()},
+	})
+	if err != nil || len(resp.Choices) == 0 {
+		log.Printf("❌ SUMMARY [%s]: saving without a summary, summary failed: %v", formName, err)
+		return "", false
+	}
+	summary := strings.TrimSpace(resp.Choices[0].Message.Content)
+	return summary, summary != ""
+}
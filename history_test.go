@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// conversation is a system prompt followed by n alternating user and assistant messages
+func conversation(n int) []ChatMessage {
+	messages := []ChatMessage{{Role: "system", Content: "prompt"}}
+	for i := 0; i < n; i++ {
+		role := "user"
+		if i%2 == 1 {
+			role = "assistant"
+		}
+		messages = append(messages, ChatMessage{Role: role, Content: strings.Repeat("x", 10)})
+	}
+	return messages
+}
+
+func TestCompactHistory(t *testing.T) {
+	tests := []struct {
+		name         string
+		summary      HistorySummary
+		messages     int
+		failing      bool
+		wantMessages int
+		wantCalls    int
+	}{
+		{"disabled", HistorySummary{}, 10, false, 11, 0},
+		{"under budget", HistorySummary{MaxChars: 1000}, 10, false, 11, 0},
+		{"too few to summarize", HistorySummary{MaxChars: 10, KeepRecent: 10}, 10, false, 11, 0},
+		{"over budget", HistorySummary{MaxChars: 50, KeepRecent: 4}, 10, false, 6, 1},
+		{"summary fails", HistorySummary{MaxChars: 50, KeepRecent: 4}, 10, true, 11, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakeChat(t, "They gave their name.")
+			if tt.failing {
+				chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					fake.mu.Lock()
+					fake.requests = append(fake.requests, nil)
+					fake.mu.Unlock()
+					return jsonResponse(r, http.StatusInternalServerError, `{"error": "down"}`), nil
+				})
+			}
+			config := testConfig(t)
+			config.SummarizeHistory = tt.summary
+			session := &ChatSession{Messages: conversation(tt.messages)}
+			last := session.Messages[len(session.Messages)-1]
+
+			compactHistory(context.Background(), config, "f", session)
+			if len(session.Messages) != tt.wantMessages || fake.calls() != tt.wantCalls {
+				t.Fatalf("%d messages after %d calls, want %d after %d", len(session.Messages), fake.calls(), tt.wantMessages, tt.wantCalls)
+			}
+			if session.Messages[0].Content != "prompt" || session.Messages[len(session.Messages)-1] != last {
+				t.Errorf("the system prompt or the recent turns were lost: %+v", session.Messages)
+			}
+			if tt.wantMessages < tt.messages+1 && session.Messages[1].Content != summaryHeader+"They gave their name." {
+				t.Errorf("summary message = %q", session.Messages[1].Content)
+			}
+		})
+	}
+}
+
+func TestSubmissionSummary(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		model       string
+		reply       string
+		wantSummary interface{}
+		wantModel   interface{}
+	}{
+		{"disabled", false, "", "A summary.", nil, nil},
+		{"summarized", true, "", "  Ann renewed her license.  ", "Ann renewed her license.", "gpt-test"},
+		{"summary model", true, "gpt-cheap", "Ann renewed her license.", "Ann renewed her license.", "gpt-cheap"},
+		{"empty summary is left out", true, "", "", nil, "gpt-test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakeChat(t, tt.reply)
+			form := testForm("f")
+			form.SummarizeSubmission = tt.enabled
+			config := testConfig(t, form)
+			config.SubmissionSummaryModel = tt.model
+			session := &ChatSession{
+				FormData: map[string]string{"FirstName": "Ann", "License": "A1"},
+				Messages: conversation(2),
+			}
+			if err := saveSession(config, "f", session, newTurnResult(config, "f", session)); err != nil {
+				t.Fatal(err)
+			}
+			path, _ := storedRecordPath(config, "f", "A1")
+			record, _ := readRecord(path)
+			if record["_summary"] != tt.wantSummary {
+				t.Errorf("_summary = %v, want %v", record["_summary"], tt.wantSummary)
+			}
+			var model interface{}
+			if fake.calls() > 0 {
+				model = fake.requests[0]["model"]
+			}
+			if model != tt.wantModel {
+				t.Errorf("summarized with %v, want %v", model, tt.wantModel)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	texttemplate "text/template"
+)
+
+// LanguageEnforcement appends a per-turn instruction pinning the reply language
+type LanguageEnforcement struct {
+	Enabled bool `xml:"enabled"`
+	// Template over {{.Lang}}, the language name of the session's locale
+	Instruction string `xml:"instruction"`
+	// Log replies that look like a different language
+	Check bool `xml:"check"`
+}
+
+const defaultLanguageInstruction = "Respond only in {{.Lang}}, using the command protocol."
+
+var languageNames = map[string]string{
+	"en": "English",
+	"es": "Spanish",
+	"fr": "French",
+	"de": "German",
+	"it": "Italian",
+	"pt": "Portuguese",
+	"nl": "Dutch",
+	"zh": "Chinese",
+	"ja": "Japanese",
+	"ko": "Korean",
+	"vi": "Vietnamese",
+	"ar": "Arabic",
+	"ru": "Russian",
+}
+
+// requestLocale picks the session locale from ?lang= or the first Accept-Language tag
+func requestLocale(r *http.Request) string {
+	locale := r.URL.Query().Get("lang")
+	if locale == "" {
+		locale = strings.Split(r.Header.Get("Accept-Language"), ",")[0]
+		locale = strings.Split(locale, ";")[0]
+	}
+	locale = strings.ToLower(strings.TrimSpace(locale))
+	if locale == "" {
+		return "en"
+	}
+	return locale
+}
+
+// languageName maps a locale such as "es-MX" to the language name used in prompts
+func languageName(locale string) string {
+	base := strings.Split(strings.ReplaceAll(locale, "_", "-"), "-")[0]
+	if name, ok := languageNames[base]; ok {
+		return name
+	}
+	return "English"
+}
+
+// languageInstruction renders the enforcement instruction for the session's locale
+func languageInstruction(config Configuration, session *ChatSession) (string, bool) {
+	if !config.EnforceLanguage.Enabled {
+		return "", false
+	}
+	instruction := strings.TrimSpace(config.EnforceLanguage.Instruction)
+	if instruction == "" {
+		instruction = defaultLanguageInstruction
+	}
+	tmpl, err := texttemplate.New("language").Parse(instruction)
+	if err != nil {
+		log.Printf("❌ ERROR: Failed to parse language instruction: %v", err)
+		return "", false
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, map[string]string{"Lang": languageName(session.Locale)}); err != nil {
+		log.Printf("❌ ERROR: Failed to render language instruction: %v", err)
+		return "", false
+	}
+	return buf.String(), true
+}
+
+// Common words used to guess the language of a reply
+var languageStopwords = map[string][]string{
+	"en": {"the", "and", "you", "your", "what", "is", "please", "thank"},
+	"es": {"el", "la", "que", "de", "por", "favor", "usted", "su", "gracias", "cuál"},
+	"fr": {"le", "la", "les", "vous", "votre", "est", "merci", "quel", "quelle"},
+	"de": {"der", "die", "das", "und", "sie", "ihre", "ist", "bitte", "danke"},
+	"it": {"il", "che", "di", "per", "lei", "suo", "grazie", "qual"},
+	"pt": {"o", "que", "de", "por", "favor", "você", "seu", "obrigado", "qual"},
+}
+
+// guessLanguage returns the most likely base language of text among those it
+// knows stopwords for, or "" when there isn't enough signal
+func guessLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r > 127)
+	})
+	best, bestScore := "", 0
+	for lang, stopwords := range languageStopwords {
+		score := 0
+		for _, word := range words {
+			for _, stop := range stopwords {
+				if word == stop {
+					score++
+				}
+			}
+		}
+		if score > bestScore {
+			best, bestScore = lang, score
+		}
+	}
+	if bestScore < 2 {
+		return ""
+	}
+	return best
+}
+
+// checkReplyLanguage logs SAY text that looks like a different language than the session's
+func checkReplyLanguage(config Configuration, formName string, session *ChatSession, messages []string) {
+	if !config.EnforceLanguage.Enabled || !config.EnforceLanguage.Check {
+		return
+	}
+	expected := strings.Split(session.Locale, "-")[0]
+	if _, known := languageStopwords[expected]; !known {
+		return
+	}
+	if got := guessLanguage(strings.Join(messages, " ")); got != "" && got != expected {
+		log.Printf("🌐 LANGUAGE [%s]: expected %s reply, looks like %s", formName, expected, got)
+	}
+}
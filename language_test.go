@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestLocale(t *testing.T) {
+	tests := []struct {
+		url            string
+		acceptLanguage string
+		want           string
+	}{
+		{"/form/f?lang=es-MX", "fr-FR", "es-mx"},
+		{"/form/f", "fr-FR,fr;q=0.9,en;q=0.8", "fr-fr"},
+		{"/form/f", "de;q=0.9", "de"},
+		{"/form/f", "", "en"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.url, nil)
+		r.Header.Set("Accept-Language", tt.acceptLanguage)
+		if got := requestLocale(r); got != tt.want {
+			t.Errorf("requestLocale(%s, %q) = %q, want %q", tt.url, tt.acceptLanguage, got, tt.want)
+		}
+	}
+}
+
+func TestLanguageInstruction(t *testing.T) {
+	tests := []struct {
+		name        string
+		enforcement LanguageEnforcement
+		locale      string
+		want        string
+		wantOK      bool
+	}{
+		{"disabled", LanguageEnforcement{}, "es", "", false},
+		{"default instruction", LanguageEnforcement{Enabled: true}, "es-MX", "Respond only in Spanish, using the command protocol.", true},
+		{"custom instruction", LanguageEnforcement{Enabled: true, Instruction: "Reply in {{.Lang}}."}, "fr_CA", "Reply in French.", true},
+		{"unknown locale falls back to English", LanguageEnforcement{Enabled: true, Instruction: "{{.Lang}}"}, "xx", "English", true},
+		{"broken template", LanguageEnforcement{Enabled: true, Instruction: "{{.Lang"}, "es", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{EnforceLanguage: tt.enforcement}
+			got, ok := languageInstruction(config, &ChatSession{Locale: tt.locale})
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("languageInstruction = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGuessLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Thank you, what is your name please?", "en"},
+		{"Gracias, ¿cuál es su nombre por favor?", "es"},
+		{"Merci, quel est votre nom ?", "fr"},
+		{"OK", ""},
+	}
+	for _, tt := range tests {
+		if got := guessLanguage(tt.text); got != tt.want {
+			t.Errorf("guessLanguage(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
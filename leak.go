@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+)
+
+// PromptLeakGuard stops replies that quote the system prompt back to the
+// user. A reply's overlap is the share of its runs of leakNgram words that
+// also appear in the system prompt.
+type PromptLeakGuard struct {
+	// Overlap from 0 to 1 at which a reply counts as a leak; zero disables the guard
+	Threshold float64 `xml:"threshold"`
+	// "block" (default) replaces the reply, "redact" masks the quoted words
+	Action string `xml:"action"`
+	// Reply sent instead of a blocked one
+	Reply string `xml:"reply"`
+}
+
+// Length of the word runs compared with the system prompt
+const leakNgram = 5
+
+// Used when the guard's reply is not configured
+const defaultLeakReply = "Sorry, I can't share that. Let's get back to your form."
+
+// leakWord normalizes a word for comparison
+func leakWord(word string) string {
+	return strings.ToLower(strings.TrimFunc(word, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}))
+}
+
+// promptNgrams indexes the word runs of the system prompt
+func promptNgrams(prompt string) map[string]bool {
+	words := strings.Fields(prompt)
+	ngrams := make(map[string]bool)
+	for i := 0; i+leakNgram <= len(words); i++ {
+		run := make([]string, leakNgram)
+		for j := range run {
+			run[j] = leakWord(words[i+j])
+		}
+		ngrams[strings.Join(run, " ")] = true
+	}
+	return ngrams
+}
+
+// promptOverlap measures how much of message is quoted from prompt, also
+// reporting which of message's words fall in a quoted run
+func promptOverlap(message, prompt string) (float64, []bool) {
+	words := strings.Fields(message)
+	quoted := make([]bool, len(words))
+	if len(words) < leakNgram {
+		return 0, quoted
+	}
+	ngrams := promptNgrams(prompt)
+	matches, total := 0, 0
+	for i := 0; i+leakNgram <= len(words); i++ {
+		run := make([]string, leakNgram)
+		for j := range run {
+			run[j] = leakWord(words[i+j])
+		}
+		total++
+		if ngrams[strings.Join(run, " ")] {
+			matches++
+			for j := i; j < i+leakNgram; j++ {
+				quoted[j] = true
+			}
+		}
+	}
+	return float64(matches) / float64(total), quoted
+}
+
+// guardPromptLeak checks a SAY against the session's system prompt, returning
+// the text to show the user: unchanged, redacted or replaced by the refusal
+func guardPromptLeak(config Configuration, formName string, session *ChatSession, message string) string {
+	guard := config.PromptLeakGuard
+	if guard.Threshold <= 0 || len(session.Messages) == 0 || session.Messages[0].Role != "system" {
+		return message
+	}
+	overlap, quoted := promptOverlap(message, session.Messages[0].Content)
+	if overlap < guard.Threshold {
+		return message
+	}
+	log.Printf("🕵️ LEAK [%s]: reply overlaps the system prompt by %.0f%%, %s", formName, overlap*100, firstNonEmpty(guard.Action, "block"))
+	if guard.Action != "redact" {
+		return firstNonEmpty(guard.Reply, defaultLeakReply)
+	}
+	var kept []string
+	for i, word := range strings.Fields(message) {
+		switch {
+		case !quoted[i]:
+			kept = append(kept, word)
+		case i == 0 || !quoted[i-1]:
+			kept = append(kept, redacted)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// validatePromptLeakGuard checks the guard's threshold and action
+func validatePromptLeakGuard(guard PromptLeakGuard) error {
+	if guard.Threshold < 0 || guard.Threshold > 1 {
+		return fmt.Errorf("prompt_leak_guard threshold must be between 0 and 1")
+	}
+	switch guard.Action {
+	case "", "block", "redact":
+	default:
+		return fmt.Errorf("prompt_leak_guard action must be block or redact, not %q", guard.Action)
+	}
+	return nil
+}
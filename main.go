@@ -2,15 +2,25 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"log"
+	"mime"
+	"net"
 	"net/http"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	qrcode "github.com/skip2/go-qrcode"
 )
@@ -23,6 +33,110 @@ type ConfigurationForm struct {
 	ContextForm string `xml:"context_form"`
 	NextForm    string `xml:"next_form"`
 	PrimaryKey  string `xml:"primary_key"`
+	// Set to false to take the form offline while keeping its config
+	Enabled *bool `xml:"enabled"`
+	// Listed in /sitemap.xml and open to crawlers in /robots.txt
+	Public bool `xml:"public"`
+	// JSON Schema file to read the fields from instead of form_fields
+	FieldsSchema string `xml:"fields_schema"`
+	// What to do with a message sent while the previous one is still in flight:
+	// "wait" (default) to process it afterwards, or "reject" with 409 Conflict
+	ConcurrentTurns string `xml:"concurrent_turns"`
+	// Serve repeated identical prompts from the response cache (requires temperature 0)
+	CacheResponses bool `xml:"cache_responses"`
+	// Rendered against FormData when the AI backend is unavailable
+	OfflineTemplate string `xml:"offline_template"`
+	// Rendered against the loaded context to welcome back a returning user
+	ReturningGreeting string `xml:"returning_greeting"`
+	// Comma separated fields that are expected to hold data the scrubber would mask
+	ScrubExempt string `xml:"scrub_exempt"`
+	// Overrides the global default region for this form
+	DefaultRegion string `xml:"default_region"`
+	// Comma separated fields the model may never SET or APPEND; only server
+	// side logic (context, seeds, computed fields) can fill them
+	ProtectedFields string `xml:"protected_fields"`
+	// Content types accepted for [file] fields, checked against the file's
+	// sniffed content, e.g. image/png,image/jpeg,application/pdf; empty disables uploads
+	UploadTypes string `xml:"upload_types"`
+	// Largest upload accepted, in bytes (default 5 MiB)
+	MaxUploadBytes int64 `xml:"max_upload_bytes"`
+	// Comma separated fields the user must explicitly agree to give; the model
+	// sends CONSENT Field first, and the first value records Field_consented_at
+	ConsentFields string `xml:"consent_fields"`
+	// JSON file of initial form_data and messages for new sessions
+	Seed string `xml:"seed"`
+	// Longest chat message accepted, in characters, also shown as a counter on
+	// the chat page (0 for no limit)
+	MaxMessageChars int `xml:"max_message_chars"`
+	// Times a record may be saved under one primary key value (0 for no limit);
+	// further saves are rejected, or with save_limit_policy "ignore" dropped
+	// while the stored record is kept
+	MaxSavesPerKey  int    `xml:"max_saves_per_key"`
+	SaveLimitPolicy string `xml:"save_limit_policy"`
+	// Ask several models at once and keep the reply that best follows the protocol
+	Ensemble Ensemble `xml:"ensemble"`
+	// Seed for this form's completions, overriding the global seed
+	ModelSeed *int `xml:"model_seed"`
+	// Temperatures for later stages of the conversation, by turn or completeness
+	TemperatureSchedule struct {
+		Step []TemperatureStep `xml:"step"`
+	} `xml:"temperature_schedule"`
+	// Add a format examples section built from the fields' examples to the prompt
+	PromptExamples bool `xml:"prompt_examples"`
+	// Comma separated prompt snippets appended to this form's system prompt
+	PromptIncludes string `xml:"prompt_includes"`
+	// Comma separated named sinks that receive each saved record
+	Sinks string `xml:"sinks"`
+	// Save the captured data if the AI service times out once every field has a value
+	SaveOnTimeout bool `xml:"save_on_timeout"`
+	// Append every prompt sent to the model to <data_dir>/<form>/prompts.jsonl for audit
+	LogPrompts bool `xml:"log_prompts"`
+	// Keep the scrubbed conversation in the saved record under _transcript
+	StoreTranscript bool `xml:"store_transcript"`
+	// Clean-up steps for captured values, for the whole form or per field
+	Normalize []FieldNormalization `xml:"normalize"`
+	// Save a model-written summary of the conversation under _summary
+	SummarizeSubmission bool `xml:"summarize_submission"`
+	// Let users create signed, expiring links to a read-only view of their record
+	ShareLinks bool `xml:"share_links"`
+	// Let the model offer suggested replies with QUICKREPLIES, shown as chips
+	QuickReplies bool `xml:"quick_replies"`
+	// Let the model offer options for a field with SUGGEST, shown as chips
+	Suggestions bool `xml:"suggestions"`
+	// Let the model keep working state outside the form data with VAR
+	SessionVars bool `xml:"session_vars"`
+	// Steps run in order after each save: pdf, email and webhook
+	Pipeline struct {
+		Step []PipelineStep `xml:"step"`
+	} `xml:"pipeline"`
+	// Chat rate limit per IP for this form, replacing the global rate_limit
+	RateLimit RateLimitConfig `xml:"rate_limit"`
+	// Site title, logo and primary color for this form's pages, over the global branding
+	Branding Branding `xml:"branding"`
+	// Withhold the context record until the user restates the key in their cookie
+	RequireReauth bool `xml:"require_reauth"`
+	// Name, domain and path of this form's identity cookie, over the global settings
+	IdentityCookie IdentityCookie `xml:"identity_cookie"`
+	// Comma separated query parameters (e.g. utm_source) and, optionally, the
+	// referrer captured when the form is opened and saved under _meta
+	CaptureParams   string `xml:"capture_params"`
+	CaptureReferrer bool   `xml:"capture_referrer"`
+	// Have the model self-check the data and block SAVE unless it passes
+	VerifyBeforeSave bool `xml:"verify_before_save"`
+	// Form specific verbs, each delivered to a named sink
+	Commands struct {
+		Command []CustomCommand `xml:"command"`
+	} `xml:"commands"`
+	// Upgrades applied to older saved records when they are read back
+	Migrations FieldMigrations `xml:"migrations"`
+	// Fields derived from other values, recomputed after every SET
+	ComputedFields struct {
+		Field []ComputedField `xml:"field"`
+	} `xml:"computed_fields"`
+	// Fields asked for only when a condition over the other values holds
+	FieldConditions struct {
+		Condition []FieldCondition `xml:"condition"`
+	} `xml:"field_conditions"`
 }
 
 // Configuration structures
@@ -31,9 +145,152 @@ type Configuration struct {
 	SystemPrompt string   `xml:"system_prompt"`
 	XMLName      xml.Name `xml:"configuration"`
 	SiteTitle    string   `xml:"site_title"`
-	BindAddr     string   `xml:"bind_addr"`
-	BaseURL      string   `xml:"base_url"`
-	Templates    struct {
+	// Logo and primary color for form pages; forms may override it
+	Branding Branding `xml:"branding"`
+	// Name, domain and path of the identity cookie set on SAVE; forms may override it
+	IdentityCookie IdentityCookie `xml:"identity_cookie"`
+	BindAddr       string         `xml:"bind_addr"`
+	BaseURL        string         `xml:"base_url"`
+	// Path the app is mounted at behind a reverse proxy, e.g. /gochat (default /)
+	BasePath string `xml:"base_path"`
+	// "forms" (default) for guided data capture, or "chat" for a plain
+	// conversational deployment without commands, saving or QR codes
+	Mode string `xml:"mode"`
+	// Listener settings: TLS (which enables HTTP/2) and request size limits
+	Server ServerConfig `xml:"server"`
+	// Root directory for stored data, one subdirectory per form (defaults to "forms")
+	DataDir string `xml:"data_dir"`
+	// File format of saved records: json (default) or yaml
+	SaveFormat string `xml:"save_format"`
+	// Saved data is disposable (demos); an unwritable data dir only warns
+	Ephemeral bool `xml:"ephemeral"`
+	// Have the chat page use the server-sent events endpoint
+	Streaming bool `xml:"streaming"`
+	// Sessions expire after this much inactivity, and regardless of activity
+	// after max_session_age (Go durations, empty means no limit)
+	SessionIdleTTL string `xml:"session_idle_ttl"`
+	MaxSessionAge  string `xml:"max_session_age"`
+	// Quiet time before a keepalive comment is sent on a stream (default 15s, 0 disables)
+	SSEKeepalive string `xml:"sse_keepalive"`
+	// How long a finished stream can be resumed after a dropped connection (Go
+	// duration, empty disables resuming)
+	StreamResume string `xml:"stream_resume"`
+	// IANA timezone (e.g. America/New_York) for the time of day given to greetings; defaults to the server's
+	Timezone string `xml:"timezone"`
+	// Region (ISO 3166 code) assumed for phone numbers entered without a country code
+	DefaultRegion string `xml:"default_region"`
+	// Upper bound on configured forms, guarding against runaway generated configs
+	MaxForms int `xml:"max_forms"`
+	// Comma separated origins allowed to call the chat endpoints cross-origin ("*" for any)
+	AllowedOrigins string          `xml:"allowed_origins"`
+	RateLimit      RateLimitConfig `xml:"rate_limit"`
+	// Re-prompt once when a reply contains no protocol commands
+	RepromptOnViolation bool `xml:"reprompt_on_violation"`
+	// When a reply repeats the previous one word for word: "rephrase" asks the
+	// model once for another, "note" sends repeated_reply_note instead
+	RepeatedReply     string `xml:"repeated_reply"`
+	RepeatedReplyNote string `xml:"repeated_reply_note"`
+	// Periodic reminder of the command protocol in long conversations
+	ProtocolReminder ProtocolReminder `xml:"protocol_reminder"`
+	// Summarize older turns once the history grows past a size budget
+	SummarizeHistory HistorySummary `xml:"summarize_history"`
+	// Most SET and APPEND commands applied from one reply; the rest are dropped (0 means no limit)
+	MaxSetsPerTurn int `xml:"max_sets_per_turn"`
+	// Characters that may join several commands on one reply line, e.g. ";|"
+	CommandSeparators string `xml:"command_separators"`
+	// Also accept form encoded chat requests with a message field
+	AcceptFormEncoded bool `xml:"accept_form_encoded"`
+	// Add a meta object (model, latency, tokens, retries) to chat responses
+	DebugMeta bool `xml:"debug_meta"`
+	// Log the reasoning of models that return it, scrubbed, for debugging
+	LogReasoning bool `xml:"log_reasoning"`
+	// Keep every raw AI service response in <data_dir>/debug, by request ID
+	DebugStoreResponses bool `xml:"debug_store_responses"`
+	// Total size the stored responses may reach before the oldest are removed (default 50 MiB)
+	DebugStoreMaxBytes int64 `xml:"debug_store_max_bytes"`
+	// Canned reply to an empty or blank message, sent without calling the model
+	EmptyMessageReply string `xml:"empty_message_reply"`
+	// Refuse messages matching jailbreak patterns without calling the model
+	JailbreakGuard JailbreakGuard `xml:"jailbreak_guard"`
+	// Pin replies to the session's language with a per-turn instruction
+	EnforceLanguage LanguageEnforcement `xml:"enforce_language"`
+	// Translations of the validation messages shown to users
+	Messages struct {
+		Message []LocalizedMessage `xml:"message"`
+	} `xml:"messages"`
+	PromptSnippets struct {
+		Snippet []PromptSnippet `xml:"snippet"`
+	} `xml:"prompt_snippets"`
+	// Named destinations that forms deliver saved records to
+	Sinks struct {
+		Sink []SinkConfig `xml:"sink"`
+	} `xml:"sinks"`
+	// How often sinks are checked for reachability, starting at startup (Go
+	// duration, empty disables the checks)
+	SinkHealthInterval string `xml:"sink_health_interval"`
+	// Retry queue for failed sink deliveries, with a dead-letter file
+	SinkRetry SinkRetryConfig `xml:"sink_retry"`
+	// Mail server for email pipeline steps
+	SMTP SMTPConfig `xml:"smtp"`
+	// Periodic batch export of saved records to an S3-compatible bucket
+	WarehouseExport WarehouseExportConfig `xml:"warehouse_export"`
+	// Clean-up steps applied to every SET value, e.g. trim,quotes,spaces
+	NormalizeValues string `xml:"normalize_values"`
+	// How long links from the inbound prefill endpoint stay valid (default 24h)
+	PrefillExpiry string `xml:"prefill_expiry"`
+	// How long share links stay valid (default 168h)
+	ShareExpiry string `xml:"share_expiry"`
+	// Told to in-flight conversations when the server shuts down
+	ShutdownMessage string `xml:"shutdown_message"`
+	// How long in-flight requests get to finish on shutdown (default 10s)
+	ShutdownTimeout string `xml:"shutdown_timeout"`
+	// Values a session needs to be drafted at shutdown (default 1, -1 disables drafts)
+	ShutdownDraftMinFields int `xml:"shutdown_draft_min_fields"`
+	// Block or redact replies that quote the system prompt
+	PromptLeakGuard PromptLeakGuard `xml:"prompt_leak_guard"`
+	// Tokens a session may use in all before the conversation is ended; zero means no cap
+	MaxSessionTokens int `xml:"max_session_tokens"`
+	// Reply once a session has used max_session_tokens
+	SessionTokenCapMessage string `xml:"session_token_cap_message"`
+	// Model for the summaries of forms with summarize_submission, e.g. a cheaper one
+	SubmissionSummaryModel string `xml:"submission_summary_model"`
+	// Page served while maintenance mode is switched on at /admin/maintenance
+	Maintenance MaintenanceConfig `xml:"maintenance"`
+	// Friendly bodies for 429 and 503 responses
+	ErrorPages struct {
+		Page []ErrorPage `xml:"page"`
+	} `xml:"error_pages"`
+	// Reasoning effort (minimal, low, medium or high) for reasoning models;
+	// not sent for models that don't accept it
+	ReasoningEffort string `xml:"reasoning_effort"`
+	// Proxy for calls to the AI service and sinks, with any credentials in the
+	// URL (defaults to the HTTPS_PROXY/HTTP_PROXY environment)
+	HTTPProxy string `xml:"http_proxy"`
+	// Comma separated models that may be used; empty allows any
+	AllowedModels string `xml:"allowed_models"`
+	// Longest an AI service call may take, as a Go duration (e.g. 60s)
+	RequestTimeout string `xml:"request_timeout"`
+	// Shortest time between a session's turns (Go duration); faster turns get
+	// turn_interval_reply without calling the model
+	MinTurnInterval   string `xml:"min_turn_interval"`
+	TurnIntervalReply string `xml:"turn_interval_reply"`
+	// Soft budget after which a streaming client is told the reply is slow;
+	// must be shorter than request_timeout
+	ResponseBudget        string `xml:"response_budget"`
+	ResponseBudgetMessage string `xml:"response_budget_message"`
+	// Extra attempts when the AI service answers with no choices
+	MaxRetries int `xml:"max_retries"`
+	// Optional sampling temperature; omitted from requests when unset
+	Temperature *float64 `xml:"temperature"`
+	// Set when a request's X-GoChat-Temperature replaced the temperature
+	temperatureOverridden bool
+	// Optional seed for reproducible completions; forms may override it with model_seed
+	Seed          *int                `xml:"seed"`
+	ResponseCache ResponseCacheConfig `xml:"response_cache"`
+	// Fail fast after repeated AI service failures, across restarts
+	CircuitBreaker CircuitBreakerConfig `xml:"circuit_breaker"`
+	Scrubber       ScrubberConfig       `xml:"scrubber"`
+	Templates      struct {
 		Template []struct {
 			Name string `xml:"name,attr"`
 			HTML string `xml:",chardata"`
@@ -44,6 +301,11 @@ type Configuration struct {
 	} `xml:"forms"`
 }
 
+// IsEnabled reports whether the form is online; forms are enabled unless set to false
+func (f ConfigurationForm) IsEnabled() bool {
+	return f.Enabled == nil || *f.Enabled
+}
+
 func (c Configuration) FormByName(formName string) ConfigurationForm {
 	for _, form := range c.Forms.Form {
 		if form.Name == formName {
@@ -59,27 +321,114 @@ type ChatMessage struct {
 	Content string `json:"content"`
 }
 
+// ChatChoice is one completion in a ChatResponse
+type ChatChoice struct {
+	Message struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+		// The thinking channel of reasoning models, kept apart from the answer
+		ReasoningContent string `json:"reasoning_content"`
+		Reasoning        string `json:"reasoning"`
+	} `json:"message"`
+	FinishReason string `json:"finish_reason"`
+}
+
 type ChatResponse struct {
-	Choices []struct {
-		Message struct {
-			Role    string `json:"role"`
-			Content string `json:"content"`
-		} `json:"message"`
-	} `json:"choices"`
+	Choices []ChatChoice `json:"choices"`
+	Model   string       `json:"model"`
+	Usage   TokenUsage   `json:"usage"`
+	// Identifies the backend configuration, which together with the seed
+	// determines whether completions are reproducible
+	SystemFingerprint string `json:"system_fingerprint"`
+
+	// How many requests it took to get this response, and whether it came from the cache
+	Attempts int  `json:"-"`
+	Cached   bool `json:"-"`
+}
+
+// filtered reports whether the service's content filter stopped the reply
+func (r *ChatResponse) filtered() bool {
+	return len(r.Choices) > 0 && r.Choices[0].FinishReason == "content_filter"
 }
 
+// empty reports whether the response has no reply to use: no choices, or a
+// blank one that the content filter did not cause
+func (r *ChatResponse) empty() bool {
+	return len(r.Choices) == 0 || (strings.TrimSpace(r.Choices[0].Message.Content) == "" && !r.filtered())
+}
+
+var errEmptyReply = errors.New("AI service returned no reply")
+
 type ChatSession struct {
 	Messages []ChatMessage
 	FormData map[string]string
+	Locale   string
+	// Number of user messages so far
+	Turns      int
+	CreatedAt  time.Time
+	LastActive time.Time
+	// Returning greeting not yet shown to the user
+	Greeting string
+	// Reference of the last saved record, for the user to quote
+	SubmissionID string
+	// Consent fields the user has agreed to give
+	Consented map[string]bool
+	// Query parameters and referrer captured when the form was opened
+	Attribution map[string]string
+	// The user restated their key, so context withheld by require_reauth was loaded
+	Reidentified bool
+	// When the last turn that reached the model started, for min_turn_interval
+	LastTurnAt time.Time
+	// Tokens used by the session's turns, for max_session_tokens
+	TokensUsed int
+	// Working state set by VAR, usable in computed fields but never saved
+	Vars map[string]string
+
+	// Held for the duration of a turn so concurrent messages can't interleave
+	turnMu sync.Mutex
+	// Cancels the in-flight turn, if any
+	cancelMu sync.Mutex
+	cancel   context.CancelFunc
 }
 
-// Global session storage
-var chatSessions = make(map[string]*ChatSession)
+// addUserMessage records a user message in the session history
+func (s *ChatSession) addUserMessage(content string) {
+	s.Messages = append(s.Messages, ChatMessage{
+		Role:    "user",
+		Content: content,
+	})
+	s.Turns++
+	s.LastActive = time.Now()
+}
+
+// dropUserMessage takes back the last user message when its turn was cancelled
+func (s *ChatSession) dropUserMessage() {
+	if n := len(s.Messages); n > 0 && s.Messages[n-1].Role == "user" {
+		s.Messages = s.Messages[:n-1]
+		s.Turns--
+	}
+}
+
+// addAssistantMessage records the model's reply so later turns see what it asked
+func (s *ChatSession) addAssistantMessage(content string) {
+	s.Messages = append(s.Messages, ChatMessage{
+		Role:    "assistant",
+		Content: content,
+	})
+}
+
+// Global session storage, one session per client and form
+var chatSessions = make(map[sessionKey]*ChatSession)
 
 type FormField struct {
 	Label   string
 	Name    string
 	Example string
+	Type    string
+	// Allowed values, when the field is an enumeration
+	Options []string
+	// Optional fields don't need a value for the form to be complete
+	Optional bool
 }
 
 func parseFormFields(fieldsStr string) []FormField {
@@ -107,6 +456,14 @@ func parseFormFields(fieldsStr string) []FormField {
 		}
 		name := nameMatch[1]
 
+		// Extract field type from a trailing [type] marker
+		fieldType := ""
+		typeMatch := regexp.MustCompile(`\s*\[(\w+)\]\s*$`).FindStringSubmatchIndex(parts[1])
+		if typeMatch != nil {
+			fieldType = parts[1][typeMatch[2]:typeMatch[3]]
+			parts[1] = parts[1][:typeMatch[0]]
+		}
+
 		// Extract example if present
 		example := ""
 		if idx := strings.Index(parts[1], "(like "); idx != -1 {
@@ -117,6 +474,7 @@ func parseFormFields(fieldsStr string) []FormField {
 			Label:   label,
 			Name:    name,
 			Example: example,
+			Type:    fieldType,
 		})
 	}
 
@@ -135,6 +493,64 @@ func main() {
 		log.Fatalf("Error parsing config: %v", err)
 	}
 
+	if err := validateConfig(config); err != nil {
+		log.Fatalf("Invalid config: %v", err)
+	}
+
+	if err := loadFormSchemas(&config); err != nil {
+		log.Fatalf("Error loading fields schema: %v", err)
+	}
+
+	if config.ResponseCache.Size > 0 {
+		responseCache = NewResponseCache(config.ResponseCache.Size, config.ResponseCache.TTLDuration())
+		log.Printf("Response cache enabled: size=%d ttl=%s", config.ResponseCache.Size, config.ResponseCache.TTL)
+	}
+
+	configureRateLimits(config)
+	configureCircuitBreaker(config)
+
+	if config.RequestTimeout != "" {
+		if _, err := time.ParseDuration(config.RequestTimeout); err != nil {
+			log.Fatalf("Invalid request_timeout %q: %v", config.RequestTimeout, err)
+		}
+	}
+
+	if err := configureOutbound(config); err != nil {
+		log.Fatalf("Invalid http_proxy: %v", err)
+	}
+
+	startSessionSweeper(config)
+
+	if config.ChatOnly() {
+		log.Printf("Chat mode: form commands, saving and QR codes are disabled")
+	} else if err := checkDataDirWritable(dataDir(config)); err != nil {
+		if !config.Ephemeral {
+			log.Fatalf("Data directory %s is not writable: %v", dataDir(config), err)
+		}
+		log.Printf("WARNING: Data directory %s is not writable, forms cannot be saved: %v", dataDir(config), err)
+	}
+	if err := checkCookieSecret(config); err != nil {
+		log.Fatalf("Error in identity cookie config: %v", err)
+	}
+
+	if sinks, err = buildSinks(config); err != nil {
+		log.Fatalf("Error in sinks config: %v", err)
+	}
+	startSinkHealthChecks(config, sinks)
+	configureSinkQueue(config)
+	configureWarehouseExport(config)
+	configureMaintenance(config)
+	configureShareLinks(config)
+	restoreDrafts(config)
+
+	if scrubRules, err = compileScrubRules(config.Scrubber); err != nil {
+		log.Fatalf("Error in scrubber config: %v", err)
+	}
+
+	if jailbreakPatterns, err = compileJailbreakGuard(config.JailbreakGuard); err != nil {
+		log.Fatalf("Error in jailbreak guard config: %v", err)
+	}
+
 	// Home page handler
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/" {
@@ -143,13 +559,7 @@ func main() {
 		}
 
 		// Find home template
-		var homeHTML string
-		for _, tmpl := range config.Templates.Template {
-			if tmpl.Name == "home_page" {
-				homeHTML = tmpl.HTML
-				break
-			}
-		}
+		homeHTML := templateHTML(config, "home_page", r)
 
 		tmpl := template.Must(template.New("home").Parse(homeHTML))
 		tmpl.Execute(w, config)
@@ -157,8 +567,17 @@ func main() {
 
 	// QR code handler
 	http.HandleFunc("/qr/", func(w http.ResponseWriter, r *http.Request) {
+		if config.ChatOnly() {
+			http.NotFound(w, r)
+			return
+		}
 		formPath := strings.TrimPrefix(r.URL.Path, "/qr/")
-		formURL := config.BaseURL + "/form/" + formPath
+		for _, form := range config.Forms.Form {
+			if form.Name == formPath && !formAvailable(w, r, config, form.Name) {
+				return
+			}
+		}
+		formURL := config.FormURL(formPath)
 
 		png, err := qrcode.Encode(formURL, qrcode.Medium, 256)
 		if err != nil {
@@ -179,21 +598,32 @@ func main() {
 
 		// Form page handler
 		http.HandleFunc(formPath, func(w http.ResponseWriter, r *http.Request) {
-			var formHTML string
-			for _, tmpl := range config.Templates.Template {
-				if tmpl.Name == "chat_form" {
-					formHTML = tmpl.HTML
-					break
-				}
+			if !allowMethods(w, r, config, http.MethodGet, http.MethodHead) || !formAvailable(w, r, config, formName) {
+				return
+			}
+
+			if r.Method == http.MethodGet {
+				captureAttribution(w, config, formName, r)
+			}
+			initialData, prefilled := openPrefill(w, config, formName, r)
+			if !prefilled {
+				initialData = getContextData(config, formName, r)
 			}
 
+			formHTML := templateHTML(config, "chat_form", r)
+
 			// Parse form fields and log them
-			fields := parseFormFields(form.Fields)
+			fields := formFields(form)
 			//log.Printf("Parsed fields: %+v", fields)
 
 			data := map[string]interface{}{
 				"Fields":      fields,
-				"InitialData": getContextData(config, formName, r),
+				"InitialData": initialData,
+				"Streaming":   config.Streaming,
+				"Branding":    config.BrandingFor(form),
+				// The chat page's counter and the chat endpoints share this limit
+				"MaxMessageChars": form.MaxMessageChars,
+				"InitialTurn":     runQueryTurn(w, r, config, formName),
 			}
 			//log.Printf("Template data: %+v", data)
 
@@ -205,215 +635,1339 @@ func main() {
 
 		// Chat endpoint
 		http.HandleFunc(formPath+"/chat", func(w http.ResponseWriter, r *http.Request) {
-			if r.Method != http.MethodPost {
-				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			if !allowMethods(w, r, config, http.MethodPost) || !formAvailable(w, r, config, formName) ||
+				!allowRate(w, r, config, formName) {
 				return
 			}
+			limitBody(w, r, config)
 			handleChat(w, r, config, formName)
 		})
+
+		// Inbound prefill from an external system, behind GOCHAT_ADMIN_TOKEN
+		http.HandleFunc(formPath+"/prefill", func(w http.ResponseWriter, r *http.Request) {
+			if !allowMethods(w, r, config, http.MethodPost) || !formAvailable(w, r, config, formName) {
+				return
+			}
+			handleInboundPrefill(w, r, config, formName)
+		})
+
+		// Files for the form's [file] fields
+		http.HandleFunc(formPath+"/upload", func(w http.ResponseWriter, r *http.Request) {
+			if !allowMethods(w, r, config, http.MethodPost) || !formAvailable(w, r, config, formName) ||
+				!allowRate(w, r, config, formName) {
+				return
+			}
+			handleUpload(w, r, config, formName)
+		})
+
+		// Share link to the record saved under the identity cookie
+		http.HandleFunc(formPath+"/share", func(w http.ResponseWriter, r *http.Request) {
+			if !allowMethods(w, r, config, http.MethodPost) || !formAvailable(w, r, config, formName) {
+				return
+			}
+			handleCreateShare(w, r, config, formName)
+		})
+
+		// Printable receipt for the submission saved under the identity cookie
+		http.HandleFunc(formPath+"/confirmation", func(w http.ResponseWriter, r *http.Request) {
+			if !allowMethods(w, r, config, http.MethodGet, http.MethodHead) || !formAvailable(w, r, config, formName) {
+				return
+			}
+			handleConfirmation(w, r, config, formName)
+		})
+
+		// Starts a new session from a saved record so it can be amended
+		http.HandleFunc(formPath+"/resume", func(w http.ResponseWriter, r *http.Request) {
+			if !allowMethods(w, r, config, http.MethodPost) || !formAvailable(w, r, config, formName) {
+				return
+			}
+			handleResume(w, r, config, formName)
+		})
+
+		// Cancels the session's in-flight turn
+		http.HandleFunc(formPath+"/chat/cancel", func(w http.ResponseWriter, r *http.Request) {
+			if !allowMethods(w, r, config, http.MethodPost) || !formAvailable(w, r, config, formName) {
+				return
+			}
+			handleCancel(w, r, config, formName)
+		})
+
+		// Discards the session, e.g. once max_session_tokens has ended it
+		http.HandleFunc(formPath+"/chat/reset", func(w http.ResponseWriter, r *http.Request) {
+			if !allowMethods(w, r, config, http.MethodPost) || !formAvailable(w, r, config, formName) {
+				return
+			}
+			handleReset(w, r, formName)
+		})
+
+		// Streaming chat endpoint
+		http.HandleFunc(formPath+"/chat/stream", func(w http.ResponseWriter, r *http.Request) {
+			if !allowMethods(w, r, config, http.MethodPost) || !formAvailable(w, r, config, formName) ||
+				!allowRate(w, r, config, formName) {
+				return
+			}
+			limitBody(w, r, config)
+			handleChatStream(w, r, config, formName)
+		})
+
+		// Reconnect to a stream whose connection dropped (stream_resume)
+		http.HandleFunc(formPath+"/chat/stream/resume", func(w http.ResponseWriter, r *http.Request) {
+			if !allowMethods(w, r, config, http.MethodGet) || !formAvailable(w, r, config, formName) {
+				return
+			}
+			handleStreamResume(w, r, formName)
+		})
 	}
 
-	log.Printf("Server starting on %s", config.BindAddr)
-	log.Fatal(http.ListenAndServe(config.BindAddr, nil))
+	// Search engine indexing of public forms
+	http.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		handleSitemap(w, r, config)
+	})
+	http.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		handleRobots(w, r, config)
+	})
+
+	// Health check
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		handleHealth(w, r, config)
+	})
+
+	// Machine-readable description of these endpoints
+	http.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		handleOpenAPI(w, r, config)
+	})
+
+	// Effective configuration for operators, behind GOCHAT_ADMIN_TOKEN
+	http.HandleFunc("/admin/config", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, config, http.MethodGet, http.MethodHead) {
+			return
+		}
+		handleAdminConfig(w, r, config)
+	})
+	http.HandleFunc("/admin/conversations.jsonl", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, config, http.MethodGet) {
+			return
+		}
+		handleConversationExport(w, r, config)
+	})
+	// Read-only views of records shared by their owners
+	http.HandleFunc("/share/", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, config, http.MethodGet, http.MethodHead, http.MethodDelete) {
+			return
+		}
+		handleShare(w, r, config)
+	})
+	http.HandleFunc("/admin/maintenance", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethods(w, r, config, http.MethodGet, http.MethodPost) {
+			return
+		}
+		handleAdminMaintenance(w, r, config)
+	})
+
+	if err := serveUntilSignalled(newServer(config, mountAt(config, maintenanceGate(config, http.DefaultServeMux))), config); err != nil {
+		log.Fatal(err)
+	}
 }
 
 func getContextData(config Configuration, formName string, r *http.Request) string {
+	if config.FormByName(formName).RequireReauth {
+		log.Printf("🔒 [%s]: context withheld until the user restates their key", formName)
+		return ""
+	}
+	return loadContextData(config, formName, r)
+}
+
+// loadContextData reads the context form's record named by the identity cookie
+func loadContextData(config Configuration, formName string, r *http.Request) string {
 	cfn := config.FormByName(formName).ContextForm
-	pk := config.FormByName(cfn).PrimaryKey
-	c, err := r.Cookie(pk)
+	key, err := identityCookieValue(config, config.FormByName(cfn), r)
 	if err != nil {
-		log.Printf("contextData cookie %s error: %v\n", pk, err)
+		log.Printf("contextData cookie %s error: %v\n", config.IdentityCookieFor(config.FormByName(cfn)).Name, err)
+		return ""
+	}
+	contextFileName, err := storedRecordPath(config, cfn, key)
+	if err != nil {
+		log.Printf("contextData error: %v\n", err)
 		return ""
 	}
-	contextFileName := fmt.Sprintf(
-		"forms/%s-%s.json",
-		cfn,
-		c.Value,
-	)
 	log.Printf("contextFileName: %s\n", contextFileName)
-	var data []byte
-	if data, err = os.ReadFile(contextFileName); err == nil {
-		log.Printf("contextData: %s\n", string(data))
-		return string(data)
+	var record map[string]interface{}
+	if record, err = readRecord(contextFileName); err == nil {
+		data := stripRecordMetadata(record)
+		log.Printf("contextData: %s\n", data)
+		return migrateRecordJSON(config.FormByName(cfn), data)
 	}
 	log.Printf("contextData error: %v\n", err)
 	return ""
 }
 
-func handleChat(w http.ResponseWriter, r *http.Request, config Configuration, formName string) {
-	log.Printf("=== Chat request received for form: %s ===", formName)
+// chatRequest is the body posted to a form's chat endpoints
+type chatRequest struct {
+	Message string `json:"message"`
+}
+
+var (
+	errUnsupportedMediaType = errors.New("unsupported media type")
+	errEmptyBody            = errors.New("empty request body")
+	errMessageTooLong       = errors.New("message too long")
+)
 
-	var chatReq struct {
-		Message string `json:"message"`
+// checkMessageLength enforces the form's max_message_chars, counted in characters
+func checkMessageLength(form ConfigurationForm, message string) error {
+	if form.MaxMessageChars > 0 && utf8.RuneCountInString(message) > form.MaxMessageChars {
+		return fmt.Errorf("%w: more than %d characters", errMessageTooLong, form.MaxMessageChars)
+	}
+	return nil
+}
+
+// decodeChatRequest reads a JSON chat request, or a form encoded one when the
+// configuration allows it for simple clients
+func decodeChatRequest(r *http.Request, config Configuration) (chatRequest, error) {
+	var chatReq chatRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json":
+		err := json.NewDecoder(r.Body).Decode(&chatReq)
+		if errors.Is(err, io.EOF) {
+			return chatReq, errEmptyBody
+		}
+		return chatReq, err
+	case mediaType == "application/x-www-form-urlencoded" && config.AcceptFormEncoded:
+		if err := r.ParseForm(); err != nil {
+			return chatReq, err
+		}
+		chatReq.Message = r.PostForm.Get("message")
+		return chatReq, nil
+	default:
+		return chatReq, fmt.Errorf("%w %q", errUnsupportedMediaType, mediaType)
+	}
+}
+
+// writeDecodeError answers a chat request that decodeChatRequest rejected
+func writeDecodeError(w http.ResponseWriter, r *http.Request, config Configuration, formName string, err error) {
+	log.Printf("ERROR [%s]: Failed to decode chat request: %v", formName, err)
+	if errors.Is(err, errUnsupportedMediaType) {
+		accepted := "application/json"
+		if config.AcceptFormEncoded {
+			accepted += " or application/x-www-form-urlencoded"
+		}
+		http.Error(w, "Unsupported Media Type: Content-Type must be "+accepted, http.StatusUnsupportedMediaType)
+		return
+	}
+	if errors.Is(err, errEmptyBody) {
+		http.Error(w, "Bad request: empty request body", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errMessageTooLong) {
+		http.Error(w, config.localize(requestLocale(r), "message_too_long", map[string]interface{}{
+			"Max": config.FormByName(formName).MaxMessageChars,
+		}), http.StatusBadRequest)
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&chatReq); err != nil {
-		log.Printf("ERROR [%s]: Failed to decode chat request: %v", formName, err)
-		http.Error(w, "Bad request", http.StatusBadRequest)
+	if isBodyTooLarge(err) {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
 		return
 	}
+	http.Error(w, "Bad request", http.StatusBadRequest)
+}
 
-	log.Printf("👤 USER [%s]: %s", formName, chatReq.Message)
+// getOrCreateSession returns the caller's chat session of the form, starting
+// one with the system prompt and any context data if this is their first
+// turn. It must be called before the response is written.
+func getOrCreateSession(w http.ResponseWriter, config Configuration, formName string, r *http.Request) *ChatSession {
+	key := sessionKey{formName, ensureClientID(w, r, config)}
+	chatSessionsMu.Lock()
+	defer chatSessionsMu.Unlock()
+
+	session := chatSessions[key]
+	if session != nil && !config.sessionLimits().expired(session, time.Now()) {
+		return session
+	}
 
-	// Get or create session
-	session := chatSessions[formName]
-	if session == nil {
-		log.Printf("📝 Creating new chat session for form: %s", formName)
-
-		// Load initial system prompt with context data
-		contextData := getContextData(config, formName, r)
-		log.Printf("Initial context data: %s", contextData)
-
-		session = &ChatSession{
-			Messages: []ChatMessage{
-				{
-					Role: "system",
-					Content: fmt.Sprintf(
-						config.FormByName(formName).Prompt,
-						config.SystemPrompt,
-						config.FormByName(formName).Fields,
-						contextData,
-					),
-				},
+	log.Printf("📝 Creating new chat session for form: %s", formName)
+
+	// Load initial system prompt with context data
+	contextData := getContextData(config, formName, r)
+	log.Printf("Initial context data: %s", contextData)
+
+	session = &ChatSession{
+		Messages: []ChatMessage{
+			{
+				Role:    "system",
+				Content: buildSystemPrompt(config, config.FormByName(formName), contextData),
 			},
-			FormData: make(map[string]string),
+		},
+		FormData:   make(map[string]string),
+		Locale:     requestLocale(r),
+		CreatedAt:  time.Now(),
+		LastActive: time.Now(),
+	}
+
+	// Start from the developer seed, if any; context data takes precedence over it
+	if seedFile := strings.TrimSpace(config.FormByName(formName).Seed); seedFile != "" {
+		if seed, err := loadSessionSeed(seedFile); err != nil {
+			log.Printf("❌ ERROR [%s]: Failed to load seed: %v", formName, err)
+		} else {
+			applySeed(session, seed)
+			log.Printf("🌱 Seeded session for %s from %s", formName, seedFile)
 		}
+	}
 
-		// Pre-populate form data from context if available
-		if contextData != "" {
-			var contextMap map[string]string
-			if err := json.Unmarshal([]byte(contextData), &contextMap); err == nil {
-				for k, v := range contextMap {
-					session.FormData[k] = v
-					log.Printf("Pre-populated %s: %s from context", k, v)
-				}
+	// Pre-populate form data from context if available
+	if contextData != "" {
+		session.prefill(contextData, "context")
+
+		// Welcome back a user whose previous record was found
+		if greeting, ok := renderFormDataTemplate(config, config.FormByName(formName), "returning greeting",
+			config.FormByName(formName).ReturningGreeting, session.FormData); ok {
+			session.Greeting = greeting
+			session.addAssistantMessage("SAY " + greeting)
+			log.Printf("👋 [%s]: Returning greeting \"%s\"", formName, greeting)
+		}
+	}
+
+	chatSessions[key] = session
+	return session
+}
+
+// prefill copies the fields of a JSON record into the session's form data
+func (s *ChatSession) prefill(data, source string) {
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return
+	}
+	for k, raw := range record {
+		if v, ok := contextValue(raw); ok {
+			s.FormData[k] = v
+			log.Printf("Pre-populated %s: %s from %s", k, v, source)
+		}
+	}
+}
+
+// takeGreeting returns the session's pending returning greeting, once
+func (s *ChatSession) takeGreeting() string {
+	greeting := s.Greeting
+	s.Greeting = ""
+	return greeting
+}
+
+// outgoingMessages is the history sent to the model for this turn, plus
+// per-turn instructions that are not kept in the session history
+func outgoingMessages(config Configuration, form ConfigurationForm, session *ChatSession) []ChatMessage {
+	messages := session.Messages[:len(session.Messages):len(session.Messages)]
+	if reminder, ok := protocolReminder(config, session); ok {
+		messages = append(messages, ChatMessage{
+			Role:    "system",
+			Content: reminder,
+		})
+	}
+	if instruction, ok := languageInstruction(config, session); ok {
+		messages = append(messages, ChatMessage{
+			Role:    "system",
+			Content: instruction,
+		})
+	}
+	if vars, ok := varsInstruction(session); ok {
+		messages = append(messages, ChatMessage{
+			Role:    "system",
+			Content: vars,
+		})
+	}
+	if skip, ok := skipInstruction(form, session.FormData); ok && !config.ChatOnly() {
+		messages = append(messages, ChatMessage{
+			Role:    "system",
+			Content: skip,
+		})
+	}
+	return messages
+}
+
+// assistantCommand is a single parsed line of the AI command protocol
+type assistantCommand struct {
+	Verb  string
+	Field string
+	Value string
+}
+
+// parseCommandLine recognizes SAY, SET, APPEND, ADDRESS, VAR, CONSENT, SUGGEST, QUICKREPLIES and SAVE lines, ignoring anything else
+func parseCommandLine(line string) (assistantCommand, bool) {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "SET "), strings.HasPrefix(line, "APPEND "), strings.HasPrefix(line, "ADDRESS "), strings.HasPrefix(line, "VAR "):
+		verb, rest, _ := strings.Cut(line, " ")
+		parts := strings.SplitN(rest, " ", 2)
+		if len(parts) == 2 {
+			return assistantCommand{
+				Verb:  verb,
+				Field: strings.TrimSpace(parts[0]),
+				Value: strings.TrimSpace(parts[1]),
+			}, true
+		}
+	case strings.HasPrefix(line, "SAY "):
+		return assistantCommand{
+			Verb:  "SAY",
+			Value: strings.TrimSpace(strings.TrimPrefix(line, "SAY ")),
+		}, true
+	case strings.HasPrefix(line, "CONSENT "):
+		return assistantCommand{
+			Verb:  "CONSENT",
+			Field: strings.TrimSpace(strings.TrimPrefix(line, "CONSENT ")),
+		}, true
+	case strings.HasPrefix(line, "SUGGEST "):
+		field, options, ok := strings.Cut(strings.TrimSpace(strings.TrimPrefix(line, "SUGGEST ")), " ")
+		if ok {
+			return assistantCommand{
+				Verb:  "SUGGEST",
+				Field: field,
+				Value: strings.TrimSpace(options),
+			}, true
+		}
+	case strings.HasPrefix(line, "QUICKREPLIES "):
+		return assistantCommand{
+			Verb:  "QUICKREPLIES",
+			Value: strings.TrimSpace(strings.TrimPrefix(line, "QUICKREPLIES ")),
+		}, true
+	case line == "SAVE":
+		return assistantCommand{Verb: "SAVE"}, true
+	}
+	return assistantCommand{}, false
+}
+
+// Verbs that may start a command after a separator
+var commandVerbs = []string{"SET", "APPEND", "ADDRESS", "SAY", "SAVE", "CONSENT", "SUGGEST", "QUICKREPLIES", "VAR"}
+
+// startsWithCommand reports whether text begins with one of verbs
+func startsWithCommand(text string, verbs []string) bool {
+	text = strings.TrimSpace(text)
+	for _, verb := range verbs {
+		if rest, ok := strings.CutPrefix(text, verb); ok && (rest == "" || rest[0] == ' ') {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCommands parses one line of a reply, which may hold several commands
+// joined by the configured command_separators (e.g. "SET Name John; SET City NYC").
+// A line is only split where the next segment starts with a command, and never
+// inside double quotes, so values may still contain separators.
+func parseCommands(config Configuration, form ConfigurationForm, line string) []assistantCommand {
+	var commands []assistantCommand
+	for _, segment := range splitCommandLine(line, config.CommandSeparators, formVerbs(form)) {
+		cmd, ok := parseCommandLine(segment)
+		if !ok {
+			cmd, ok = parseCustomCommand(form, segment)
+		}
+		if ok {
+			if config.CommandSeparators != "" {
+				cmd.Value = unquoteValue(cmd.Value)
 			}
+			commands = append(commands, cmd)
+		}
+	}
+	return commands
+}
+
+// parseCustomCommand recognizes a line starting with one of the form's custom verbs
+func parseCustomCommand(form ConfigurationForm, line string) (assistantCommand, bool) {
+	verb, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	for _, c := range form.Commands.Command {
+		if c.Verb == verb {
+			return assistantCommand{Verb: verb, Value: strings.TrimSpace(rest)}, true
+		}
+	}
+	return assistantCommand{}, false
+}
+
+func splitCommandLine(line, separators string, verbs []string) []string {
+	if separators == "" {
+		return []string{line}
+	}
+	var segments []string
+	inQuote := false
+	start := 0
+	for i, c := range line {
+		switch {
+		case c == '"':
+			inQuote = !inQuote
+		case !inQuote && strings.ContainsRune(separators, c) && startsWithCommand(line[i+1:], verbs):
+			segments = append(segments, line[start:i])
+			start = i + 1
 		}
+	}
+	return append(segments, line[start:])
+}
+
+// unquoteValue removes the double quotes around a value that was quoted to protect separators
+func unquoteValue(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// turnResult accumulates the effect of the commands in one assistant response
+type turnResult struct {
+	config  Configuration
+	form    ConfigurationForm
+	session *ChatSession
+	// The form's custom verbs
+	handlers map[string]commandHandler
+
+	Messages    []string
+	FormUpdates map[string]string
+	// Options offered for fields by SUGGEST, not yet chosen by the user
+	Suggestions map[string][]string
+	// Replies offered to the user by QUICKREPLIES, sent as their message when tapped
+	QuickReplies []string
+	ShouldSave   bool
+	// Number of protocol commands applied
+	Commands int
+	// Number of SET and APPEND commands seen, for max_sets_per_turn
+	sets int
+	// The turn took the session to max_session_tokens
+	Ended bool
+	// How the reply was produced, returned when debug_meta is on
+	Meta turnMeta
+	// The model's reasoning for the reply, if it returned any; never sent to the user
+	Reasoning string
+	// Shown to the user after the reply, such as a failed post-save step
+	Notice string
+}
 
-		chatSessions[formName] = session
+func newTurnResult(config Configuration, formName string, session *ChatSession) *turnResult {
+	return &turnResult{
+		config:      config,
+		form:        config.FormByName(formName),
+		session:     session,
+		handlers:    customCommandHandlers(config.FormByName(formName)),
+		FormUpdates: make(map[string]string),
 	}
+}
+
+// ResponseText is the first SAY of the turn
+func (t *turnResult) ResponseText() string {
+	if len(t.Messages) == 0 {
+		return ""
+	}
+	return t.Messages[0]
+}
+
+// apply runs one command against the session, returning the fields it changed
+func (t *turnResult) apply(cmd assistantCommand) map[string]string {
+	t.Commands++
+	updates := make(map[string]string)
+	setsField := cmd.Verb == "SET" || cmd.Verb == "APPEND" || cmd.Verb == "ADDRESS"
+	if setsField {
+		t.sets++
+		if limit := t.config.MaxSetsPerTurn; limit > 0 && t.sets > limit {
+			log.Printf("⚠️ [%s]: Dropping %s %s, over the limit of %d per turn", t.form.Name, cmd.Verb, cmd.Field, limit)
+			return updates
+		}
+	}
+	if setsField &&
+		(isProtectedField(t.form, cmd.Field) || isConsentTimestamp(t.form, cmd.Field)) {
+		log.Printf("🛡️ [%s]: Dropping %s to protected field %s: %q", t.form.Name, cmd.Verb, cmd.Field, cmd.Value)
+		return updates
+	}
+	if setsField && !checkConsent(t.form, t.session, cmd.Field, updates) {
+		return updates
+	}
+	switch cmd.Verb {
+	case "SET":
+		value := normalizeFieldValue(t.config, t.form, cmd.Field, cmd.Value)
+		t.session.FormData[cmd.Field] = value
+		updates[cmd.Field] = value
+		for field, computed := range recomputeFields(t.form, t.session.FormData, t.session.Vars) {
+			updates[field] = computed
+		}
+	case "VAR":
+		if !t.form.SessionVars {
+			t.Commands--
+			break
+		}
+		t.session.setVar(cmd.Field, cmd.Value)
+		log.Printf("📝 [%s]: \"VAR %s %s\"", t.form.Name, cmd.Field, cmd.Value)
+		for field, computed := range recomputeFields(t.form, t.session.FormData, t.session.Vars) {
+			updates[field] = computed
+		}
+	case "APPEND":
+		if !isListField(t.form, cmd.Field) {
+			log.Printf("⚠️ [%s]: Ignoring APPEND to non-list field %s", t.form.Name, cmd.Field)
+			t.Commands--
+			break
+		}
+		value := appendToList(t.session.FormData[cmd.Field], cleanValue(t.config, t.form, cmd.Field, cmd.Value))
+		t.session.FormData[cmd.Field] = value
+		updates[cmd.Field] = value
+		for field, computed := range recomputeFields(t.form, t.session.FormData, t.session.Vars) {
+			updates[field] = computed
+		}
+	case "ADDRESS":
+		if !isAddressField(t.form, cmd.Field) {
+			log.Printf("⚠️ [%s]: Ignoring ADDRESS to non-address field %s", t.form.Name, cmd.Field)
+			t.Commands--
+			break
+		}
+		addr, err := parseAddress(cmd.Value, t.config.RegionFor(t.form))
+		if err != nil {
+			log.Printf("⚠️ [%s]: Ignoring ADDRESS %s: %v", t.form.Name, cmd.Field, err)
+			t.Commands--
+			break
+		}
+		value := encodeAddress(addr)
+		t.session.FormData[cmd.Field] = value
+		updates[cmd.Field] = value
+		for field, computed := range recomputeFields(t.form, t.session.FormData, t.session.Vars) {
+			updates[field] = computed
+		}
+	case "SAY":
+		t.Messages = append(t.Messages, guardPromptLeak(t.config, t.form.Name, t.session, cmd.Value))
+		log.Printf("💬 [%s]: \"SAY %s\"", t.form.Name, cmd.Value)
+	case "CONSENT":
+		if !requiresConsent(t.form, cmd.Field) {
+			log.Printf("⚠️ [%s]: Ignoring CONSENT for %s, which does not need consent", t.form.Name, cmd.Field)
+			t.Commands--
+			break
+		}
+		t.session.acknowledgeConsent(cmd.Field)
+		log.Printf("🤝 [%s]: \"CONSENT %s\"", t.form.Name, cmd.Field)
+	case "SUGGEST":
+		// Options are only offered; the field is set once the user picks one
+		options := splitSuggestions(cmd.Value)
+		if !t.form.Suggestions || len(options) == 0 {
+			t.Commands--
+			break
+		}
+		if t.Suggestions == nil {
+			t.Suggestions = make(map[string][]string)
+		}
+		t.Suggestions[cmd.Field] = options
+		log.Printf("💡 [%s]: \"SUGGEST %s %s\"", t.form.Name, cmd.Field, strings.Join(options, "|"))
+	case "QUICKREPLIES":
+		// Conversation shortcuts only; nothing is set until the user sends one
+		replies := splitSuggestions(strings.Trim(cmd.Value, "<>"))
+		if !t.form.QuickReplies || len(replies) == 0 {
+			t.Commands--
+			break
+		}
+		t.QuickReplies = replies
+		log.Printf("💬 [%s]: \"QUICKREPLIES %s\"", t.form.Name, strings.Join(replies, "|"))
+	case "SAVE":
+		t.ShouldSave = true
+		log.Printf("💾 [%s]: \"SAVE\"", t.form.Name)
+	default:
+		if handler, ok := t.handlers[cmd.Verb]; ok {
+			for field, value := range handler(t, cmd) {
+				updates[field] = value
+			}
+		}
+	}
+	for field, value := range updates {
+		t.FormUpdates[field] = value
+	}
+	return updates
+}
+
+// splitSuggestions splits SUGGEST options on "|", dropping blanks
+func splitSuggestions(value string) []string {
+	var options []string
+	for _, option := range strings.Split(value, "|") {
+		if option = strings.TrimSpace(option); option != "" {
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// normalizeFieldValue converts a SET value to the canonical format for the field's type
+func normalizeFieldValue(config Configuration, form ConfigurationForm, field, value string) string {
+	value = cleanValue(config, form, field, value)
+	f, ok := formFieldByName(form, field)
+	if !ok {
+		return value
+	}
+	switch f.Type {
+	case "tel":
+		if phone, ok := normalizePhone(value, config.RegionFor(form)); ok {
+			return phone
+		}
+		log.Printf("⚠️ [%s]: Could not normalize phone number for %s: %q", form.Name, field, value)
+	case "list":
+		return listFromSet(value)
+	case "address":
+		if addr, err := parseAddress(value, config.RegionFor(form)); err == nil {
+			return encodeAddress(addr)
+		}
+		log.Printf("⚠️ [%s]: Could not read address for %s: %q", form.Name, field, value)
+	}
+	return value
+}
+
+var (
+	errInvalidRecordKey = errors.New("invalid record key")
+	errSaveLimitReached = errors.New("save limit reached for this record")
+)
+
+// saveSession writes the session's form data to the form's data directory.
+// Values changed by the scrubber are added to the turn's updates.
+func saveSession(config Configuration, formName string, session *ChatSession, turn *turnResult) error {
+	if err := verifyBeforeSave(config, config.FormByName(formName), session); err != nil {
+		return err
+	}
+
+	key := recordKey(config.FormByName(formName), session.FormData)
+	filename, err := formRecordPath(config, formName, key)
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to save form: %v", formName, err)
+		return err
+	}
+	log.Printf("💾 SAVE [%s]: Saving to %s", formName, filename)
+
+	stored, _ := storedRecordPath(config, formName, key)
+	existing, _ := readRecord(stored)
+	saves := recordSaveCount(existing)
+	if limit := config.FormByName(formName).MaxSavesPerKey; limit > 0 && saves >= limit {
+		if config.FormByName(formName).SaveLimitPolicy == "ignore" {
+			log.Printf("🧱 SAVE [%s]: %s already saved %d times, keeping the stored record", formName, filename, saves)
+			session.SubmissionID, _ = existing["_submission_id"].(string)
+			return nil
+		}
+		log.Printf("🧱 SAVE [%s]: %s already saved %d times, rejecting", formName, filename, saves)
+		return errSaveLimitReached
+	}
+
+	for field, value := range scrubFormData(config.FormByName(formName), session.FormData) {
+		turn.FormUpdates[field] = value
+	}
+
+	record := make(map[string]interface{}, len(session.FormData)+1)
+	for k, v := range session.FormData {
+		record[k] = v
+	}
+	for _, name := range listFieldNames(config.FormByName(formName)) {
+		if value, ok := session.FormData[name]; ok {
+			record[name] = decodeList(value)
+		}
+	}
+	for _, name := range addressFieldNames(config.FormByName(formName)) {
+		if value, ok := session.FormData[name]; ok {
+			record[name] = decodeAddress(value)
+		}
+	}
+	if config.FormByName(formName).StoreTranscript {
+		record["_transcript"] = sessionTranscript(session)
+	}
+	if config.FormByName(formName).SummarizeSubmission {
+		if summary, ok := summarizeSubmission(context.Background(), config, formName, session); ok {
+			record["_summary"] = summary
+		}
+	}
+	// Updates to a record keep their reference
+	submissionID, _ := existing["_submission_id"].(string)
+	if submissionID == "" {
+		submissionID = newSubmissionID()
+	}
+	record["_submission_id"] = submissionID
+	record["_saves"] = saves + 1
+	if len(session.Attribution) > 0 {
+		record["_meta"] = session.Attribution
+	}
+
+	encoded, err := config.recordFormat().marshal(record)
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to marshal form data: %v", formName, err)
+		return err
+	}
+
+	if err := os.MkdirAll(formDataDir(config, formName), 0755); err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to create forms directory: %v", formName, err)
+		return err
+	}
+
+	if err := os.WriteFile(filename, encoded, 0644); err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to write to %s: %v", formName, filename, err)
+		return err
+	}
+	// A record saved in the old format or layout is now migrated to the new one
+	if stored != filename {
+		if err := os.Remove(stored); err != nil && !os.IsNotExist(err) {
+			log.Printf("⚠️ SAVE [%s]: Failed to remove %s after migrating it: %v", formName, stored, err)
+		}
+	}
+
+	// Let the assistant quote the reference if the user asks for it later
+	if session.SubmissionID != submissionID {
+		session.SubmissionID = submissionID
+		session.Messages = append(session.Messages, ChatMessage{
+			Role:    "system",
+			Content: fmt.Sprintf("The form has been saved with submission ID %s. Give it to the user as their confirmation number if they ask.", submissionID),
+		})
+	}
+	log.Printf("🧾 SAVE [%s]: Submission ID %s", formName, submissionID)
+
+	deliverToSinks(config.FormByName(formName), session.FormData)
+	// The save has succeeded by now; a failed step is only reported, so a
+	// retried SAVE doesn't write the record and fire the sinks again
+	if err := runPipeline(config, config.FormByName(formName), filename, session.FormData, submissionID); err != nil {
+		turn.Notice = pipelineErrorMessage
+	}
+	return nil
+}
+
+// identityCookie links later forms to the saved record through its primary key
+func identityCookie(config Configuration, formName string, session *ChatSession) *http.Cookie {
+	form := config.FormByName(formName)
+	settings := config.IdentityCookieFor(form)
+	return &http.Cookie{
+		Path:   settings.Path,
+		Domain: settings.Domain,
+		Name:   settings.Name,
+		Value:  signIdentity(settings.Name, recordKey(form, session.FormData)),
+	}
+}
+
+// runChatTurn sends one user message to the model and applies the commands in
+// its reply, failing with errEmptyReply if the model returned no choices. The caller must
+// hold the session's turn lock. If ctx is cancelled before the reply arrives
+// the user message is taken back out of the history.
+func runChatTurn(ctx context.Context, config Configuration, formName string, session *ChatSession, message string) (*turnResult, error) {
+	config = config.ForForm(config.FormByName(formName))
 
 	// Add user message to history
-	session.Messages = append(session.Messages, ChatMessage{
-		Role:    "user",
-		Content: chatReq.Message,
-	})
+	session.addUserMessage(message)
+	compactHistory(ctx, config, formName, session)
+	config = config.ForTurn(config.FormByName(formName), session)
 
 	// Call ChatGPT
-	resp, err := callChatGPT(config, session.Messages)
+	meta := turnMeta{Model: config.Model}
+	start := time.Now()
+	messages := outgoingMessages(config, config.FormByName(formName), session)
+	logPrompt(config, formName, "turn", messages)
+	resp, err := cachedChatGPT(ctx, config, config.FormByName(formName), messages)
+	if ctx.Err() != nil {
+		session.dropUserMessage()
+		return nil, ctx.Err()
+	}
 	if err != nil {
-		log.Printf("❌ ERROR [%s]: ChatGPT error: %v", formName, err)
-		http.Error(w, "AI service error", http.StatusInternalServerError)
-		return
+		return nil, err
+	}
+	if resp == nil || len(resp.Choices) == 0 {
+		return nil, errEmptyReply
+	}
+	meta.record(resp)
+	if resp.filtered() {
+		// The message stays out of the history so later turns aren't stopped by it too
+		log.Printf("🚫 FILTERED [%s]: the AI service's content filter stopped the reply", formName)
+		session.dropUserMessage()
+		turn := newTurnResult(config, formName, session)
+		turn.Messages = append(turn.Messages, contentFilterReply)
+		turn.Meta = meta
+		return turn, nil
 	}
 
-	if len(resp.Choices) > 0 {
-		aiMessage := resp.Choices[0].Message
-		log.Printf("🤖 AI [%s]: \"%s\"", formName, aiMessage.Content)
+	content := resp.Choices[0].Message.Content
+	reasoning := resp.Reasoning()
+	log.Printf("🤖 AI [%s]: \"%s\"", formName, content)
+	logReasoning(config, formName, reasoning)
+	turn := applyResponse(config, formName, session, content)
 
-		// Parse and log commands from AI response
-		lines := strings.Split(aiMessage.Content, "\n")
-		var responseText string
-		formUpdates := make(map[string]string)
-		var shouldSave bool
+	// A reply with no commands at all is wasted; ask once for a compliant one
+	if config.RepromptOnViolation && turn.Commands == 0 &&
+		len(missingFields(config.FormByName(formName), session.FormData)) > 0 {
+		log.Printf("🔁 REPROMPT [%s]: reply used no commands", formName)
+		retry := append(outgoingMessages(config, config.FormByName(formName), session),
+			ChatMessage{Role: "assistant", Content: content},
+			ChatMessage{Role: "system", Content: protocolViolationPrompt},
+		)
+		meta.Reprompted = true
+		logPrompt(config, formName, "reprompt", retry)
+		if resp, err := callChatGPT(ctx, config, retry); err == nil && len(resp.Choices) > 0 {
+			meta.record(resp)
+			content = resp.Choices[0].Message.Content
+			reasoning = resp.Reasoning()
+			log.Printf("🤖 AI [%s] (reprompt): \"%s\"", formName, content)
+			logReasoning(config, formName, reasoning)
+			turn = applyResponse(config, formName, session, content)
+		}
+	}
 
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
+	// The model sometimes repeats its previous reply word for word
+	if isRepeatedReply(config, session, content) && config.RepeatedReply == "rephrase" {
+		log.Printf("🔂 REPEAT [%s]: asking for a rephrase", formName)
+		retry := append(outgoingMessages(config, config.FormByName(formName), session),
+			ChatMessage{Role: "assistant", Content: content},
+			ChatMessage{Role: "system", Content: rephrasePrompt},
+		)
+		meta.Reprompted = true
+		logPrompt(config, formName, "rephrase", retry)
+		if resp, err := callChatGPT(ctx, config, retry); err == nil && len(resp.Choices) > 0 {
+			meta.record(resp)
+			rephrased := resp.Choices[0].Message.Content
+			log.Printf("🤖 AI [%s] (rephrase): \"%s\"", formName, rephrased)
+			logReasoning(config, formName, resp.Reasoning())
+			if kept, ok := rephraseReply(config, turn, content, rephrased); ok {
+				content = kept
+				reasoning = resp.Reasoning()
 			}
+		}
+	}
+	if isRepeatedReply(config, session, content) {
+		content = collapseRepeat(config, formName, turn)
+	}
+	session.addAssistantMessage(content)
+	meta.LatencyMS = time.Since(start).Milliseconds()
+	turn.Meta = meta
+	turn.Reasoning = reasoning
+	turn.Ended = session.addTokens(config, formName, meta.Tokens.TotalTokens)
 
-			switch {
-			case strings.HasPrefix(line, "SET "):
-				parts := strings.SplitN(strings.TrimPrefix(line, "SET "), " ", 2)
-				if len(parts) == 2 {
-					field := strings.TrimSpace(parts[0])
-					value := strings.TrimSpace(parts[1])
-					formUpdates[field] = value
-					session.FormData[field] = value
-				}
-			case strings.HasPrefix(line, "SAY "):
-				text := strings.TrimSpace(strings.TrimPrefix(line, "SAY "))
-				if responseText == "" {
-					responseText = text
-				}
-				log.Printf("💬 [%s]: \"%s\"", formName, line)
-			case line == "SAVE":
-				shouldSave = true
-				log.Printf("💾 [%s]: \"%s\"", formName, line)
-			}
+	checkReplyLanguage(config, formName, session, turn.Messages)
+	return turn, nil
+}
+
+// Shown when the AI service's content filter stops a reply
+const contentFilterReply = "I'm sorry, I can't respond to that. Could you put it another way?"
+
+// Sent once when a reply contains no protocol commands
+const protocolViolationPrompt = "Your last reply did not follow the protocol. You must respond using the command protocol: every line must start with SAY, SET or SAVE."
+
+// applyResponse parses the commands in an AI reply and applies them to the session
+func applyResponse(config Configuration, formName string, session *ChatSession, content string) *turnResult {
+	turn := newTurnResult(config, formName, session)
+	if config.ChatOnly() {
+		turn.apply(assistantCommand{Verb: "SAY", Value: strings.TrimSpace(content)})
+		return turn
+	}
+	for _, line := range strings.Split(content, "\n") {
+		for _, cmd := range parseCommands(config, turn.form, line) {
+			turn.apply(cmd)
 		}
+	}
+	return turn
+}
 
-		// Handle form saving
-		if shouldSave {
-			filename := fmt.Sprintf("forms/%s-%s.json", formName, session.FormData["License"])
-			log.Printf("💾 SAVE [%s]: Saving to %s", formName, filename)
+func handleChat(w http.ResponseWriter, r *http.Request, config Configuration, formName string) {
+	log.Printf("=== Chat request received for form: %s ===", formName)
+	config = applyModelOverrides(config, formName, r)
 
-			// Change this part to save the actual form data
-			formJSON, err := json.MarshalIndent(session.FormData, "", "    ")
-			if err != nil {
-				log.Printf("❌ ERROR [%s]: Failed to marshal form data: %v", formName, err)
-				http.Error(w, "Failed to save form", http.StatusInternalServerError)
+	chatReq, err := decodeChatRequest(r, config)
+	if err == nil {
+		err = checkMessageLength(config.FormByName(formName), chatReq.Message)
+	}
+	if err != nil {
+		writeDecodeError(w, r, config, formName, err)
+		return
+	}
+
+	log.Printf("👤 USER [%s]: %s", formName, chatReq.Message)
+
+	if reply, ok := cannedReply(config, formName, chatReq.Message); ok {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": reply,
+			"updates": map[string]string{},
+		})
+		return
+	}
+
+	session := getOrCreateSession(w, config, formName, r)
+	if !lockSession(w, config, formName, session) {
+		return
+	}
+	defer session.turnMu.Unlock()
+
+	if session.tokenCapReached(config) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": config.TokenCapMessageText(),
+			"updates": map[string]string{},
+			"ended":   true,
+		})
+		return
+	}
+
+	if session.throttleTurn(config, formName, time.Now()) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":   config.TurnIntervalReplyText(),
+			"updates":   map[string]string{},
+			"throttled": true,
+		})
+		return
+	}
+
+	ctx, endTurn := session.beginTurn(r.Context())
+	defer endTurn()
+
+	reidentify(config, formName, session, r, chatReq.Message)
+	turn, err := runChatTurn(ctx, config, formName, session, chatReq.Message)
+	if errors.Is(err, context.Canceled) {
+		log.Printf("🛑 CANCEL [%s]: turn cancelled", formName)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message":   "",
+			"updates":   map[string]string{},
+			"cancelled": true,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: ChatGPT error: %v", formName, err)
+		if isTimeout(err) {
+			if turn, ok := saveOnTimeout(config, formName, session); ok {
+				http.SetCookie(w, identityCookie(config, formName, session))
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"message":       timeoutSavedMessage,
+					"updates":       turn.FormUpdates,
+					"saved":         true,
+					"degraded":      true,
+					"submission_id": session.SubmissionID,
+				})
 				return
 			}
+		}
+		if message, ok := renderOfflineTemplate(config, config.FormByName(formName), session.FormData); ok {
+			log.Printf("📴 OFFLINE [%s]: \"%s\"", formName, message)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message": message,
+				"updates": map[string]string{},
+				"offline": true,
+			})
+			return
+		}
+		writeErrorResponse(w, r, config, http.StatusServiceUnavailable)
+		return
+	}
 
-			if err := os.MkdirAll("forms", 0755); err != nil {
-				log.Printf("❌ ERROR [%s]: Failed to create forms directory: %v", formName, err)
-				http.Error(w, "Failed to create forms directory", http.StatusInternalServerError)
+	if turn != nil {
+		// Handle form saving
+		if turn.ShouldSave {
+			var verr *verificationError
+			err := saveSession(config, formName, session, turn)
+			if errors.As(err, &verr) {
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"message":      verr.Message(config, session.Locale),
+					"updates":      turn.FormUpdates,
+					"saved":        false,
+					"verification": verr.Reasons,
+				})
 				return
 			}
-
-			if err := os.WriteFile(filename, formJSON, 0644); err != nil {
-				log.Printf("❌ ERROR [%s]: Failed to write to %s: %v", formName, filename, err)
-				http.Error(w, "Failed to save form", http.StatusInternalServerError)
+			if err != nil {
+				status := http.StatusInternalServerError
+				if errors.Is(err, errInvalidRecordKey) {
+					status = http.StatusBadRequest
+				}
+				if errors.Is(err, errSaveLimitReached) {
+					status = http.StatusConflict
+				}
+				http.Error(w, "Failed to save form", status)
 				return
 			}
+			http.SetCookie(w, identityCookie(config, formName, session))
+		}
 
-			//Set the cookie for the primary key
-			pk := config.FormByName(formName).PrimaryKey
-			http.SetCookie(w, &http.Cookie{
-				Path:  "/",
-				Name:  pk,
-				Value: session.FormData[pk],
-			})
+		response := map[string]interface{}{
+			"message": turn.ResponseText(),
+			"updates": turn.FormUpdates,
+		}
+		if turn.ShouldSave {
+			response["submission_id"] = session.SubmissionID
+		}
+		if len(turn.Suggestions) > 0 {
+			response["suggestions"] = turn.Suggestions
+		}
+		if len(turn.QuickReplies) > 0 {
+			response["quick_replies"] = turn.QuickReplies
 		}
+		if config.DebugMeta {
+			response["meta"] = turn.Meta
+		}
+		if turn.Ended {
+			response["ended"] = true
+			response["end_message"] = config.TokenCapMessageText()
+		}
+		if greeting := session.takeGreeting(); greeting != "" {
+			response["greeting"] = greeting
+		}
+		if notice := turnNotice(config, turn); notice != "" {
+			response["notice"] = notice
+		}
+		json.NewEncoder(w).Encode(response)
+	}
+}
 
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"message": responseText,
-			"updates": formUpdates,
-		})
+// missingFields lists the form's fields that have no value yet
+func missingFields(form ConfigurationForm, formData map[string]string) []string {
+	var missing []string
+	for _, field := range formFields(form) {
+		if !field.Optional && strings.TrimSpace(formData[field.Name]) == "" && fieldApplies(form, field.Name, formData) {
+			missing = append(missing, field.Name)
+		}
+	}
+	return missing
+}
+
+// Sent in place of the reply when save_on_timeout saved the form
+const timeoutSavedMessage = "Our assistant is taking too long to respond, but your information has been saved."
+
+// saveOnTimeout saves a session whose data is already complete when the AI
+// service times out, so a user who answered everything doesn't lose it.
+// It returns the save's turn and whether it saved; /chat and the stream
+// each answer the request from it.
+func saveOnTimeout(config Configuration, formName string, session *ChatSession) (*turnResult, bool) {
+	form := config.FormByName(formName)
+	if !form.SaveOnTimeout || config.ChatOnly() {
+		return nil, false
+	}
+	if missing := missingFields(form, session.FormData); len(missing) > 0 {
+		log.Printf("⏱️ TIMEOUT [%s]: not saving, missing %s", formName, strings.Join(missing, ", "))
+		return nil, false
+	}
+
+	turn := newTurnResult(config, formName, session)
+	if err := saveSession(config, formName, session, turn); err != nil {
+		return nil, false
+	}
+	log.Printf("⏱️ TIMEOUT [%s]: saved form data without the assistant", formName)
+	return turn, true
+}
+
+// Longest ?q= message accepted on the form page
+const maxQueryMessageLength = 500
+
+// sanitizeQueryMessage cleans a message supplied in the form page URL
+func sanitizeQueryMessage(q string) string {
+	q = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, q)
+	q = strings.TrimSpace(q)
+	if runes := []rune(q); len(runes) > maxQueryMessageLength {
+		q = string(runes[:maxQueryMessageLength])
 	}
+	return q
 }
 
-func callChatGPT(config Configuration, messages []ChatMessage) (*ChatResponse, error) {
+// runQueryTurn runs the first chat turn from a ?q= message on the form page,
+// returning the exchange for the page to display. It follows the same turn
+// policy as /chat: nothing is run while another turn holds a session whose
+// form rejects concurrent turns, and a throttled turn gets the interval reply.
+func runQueryTurn(w http.ResponseWriter, r *http.Request, config Configuration, formName string) map[string]interface{} {
+	q := sanitizeQueryMessage(r.URL.Query().Get("q"))
+	if q == "" || r.Method != http.MethodGet {
+		return nil
+	}
+
+	log.Printf("👤 USER [%s] (query): %s", formName, q)
+
+	session := getOrCreateSession(w, config, formName, r)
+	if !acquireTurn(config, formName, session) {
+		return nil
+	}
+	defer session.turnMu.Unlock()
+
+	if session.tokenCapReached(config) {
+		return nil
+	}
+	if session.throttleTurn(config, formName, time.Now()) {
+		return map[string]interface{}{
+			"message": q,
+			"reply": map[string]interface{}{
+				"message": config.TurnIntervalReplyText(),
+				"updates": map[string]string{},
+			},
+		}
+	}
+
+	ctx, endTurn := session.beginTurn(r.Context())
+	defer endTurn()
+
+	reidentify(config, formName, session, r, q)
+	turn, err := runChatTurn(ctx, config, formName, session, q)
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: ChatGPT error: %v", formName, err)
+		return nil
+	}
+	if turn == nil {
+		return nil
+	}
+
+	if turn.ShouldSave {
+		if err := saveSession(config, formName, session, turn); err == nil {
+			http.SetCookie(w, identityCookie(config, formName, session))
+		}
+	}
+
+	reply := map[string]interface{}{
+		"message": turn.ResponseText(),
+		"updates": turn.FormUpdates,
+	}
+	if greeting := session.takeGreeting(); greeting != "" {
+		reply["greeting"] = greeting
+	}
+	return map[string]interface{}{
+		"message": q,
+		"reply":   reply,
+	}
+}
+
+// renderOfflineTemplate produces a deterministic reply from the form's offline template
+func renderOfflineTemplate(config Configuration, form ConfigurationForm, formData map[string]string) (string, bool) {
+	return renderFormDataTemplate(config, form, "offline", form.OfflineTemplate, formData)
+}
+
+// renderFormDataTemplate renders one of the form's text templates over its
+// form data, plus TimeOfDay (morning, afternoon or evening in the configured
+// timezone), reporting false if the template is unset or fails
+func renderFormDataTemplate(config Configuration, form ConfigurationForm, kind, text string, formData map[string]string) (string, bool) {
+	if strings.TrimSpace(text) == "" {
+		return "", false
+	}
+	tmpl, err := texttemplate.New(kind).Parse(text)
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to parse %s template: %v", form.Name, kind, err)
+		return "", false
+	}
+	var buf bytes.Buffer
+	data := make(map[string]string, len(formData)+1)
+	data["TimeOfDay"] = localTimeOfDay(config)
+	for k, v := range formData {
+		data[k] = v
+	}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to render %s template: %v", form.Name, kind, err)
+		return "", false
+	}
+	return strings.TrimSpace(buf.String()), true
+}
+
+// newChatGPTRequest builds a chat completions request for the configured model
+func newChatGPTRequest(ctx context.Context, config Configuration, messages []ChatMessage, stream bool) (*http.Request, error) {
+	if !config.modelAllowed(config.Model) {
+		return nil, fmt.Errorf("model %q is not in allowed_models", config.Model)
+	}
+
 	apiKey := os.Getenv("OPENAI_API_KEY")
 	if apiKey == "" {
 		return nil, fmt.Errorf("OPENAI_API_KEY environment variable not set")
 	}
 
-	requestBody, err := json.Marshal(map[string]interface{}{
+	body := map[string]interface{}{
 		"model":    config.Model,
 		"messages": messages,
-	})
+	}
+	if config.Temperature != nil {
+		body["temperature"] = *config.Temperature
+	}
+	if config.Seed != nil {
+		body["seed"] = *config.Seed
+	}
+	if config.ReasoningEffort != "" && supportsReasoningEffort(config.Model) {
+		body["reasoning_effort"] = config.ReasoningEffort
+	}
+	if stream {
+		body["stream"] = true
+		if config.MaxSessionTokens > 0 {
+			// Streamed replies only report their usage when asked to
+			body["stream_options"] = map[string]bool{"include_usage": true}
+		}
+	}
+
+	requestBody, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(requestBody))
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+apiKey)
+	return req, nil
+}
+
+// Client for AI service calls. It has no timeout of its own: request_timeout
+// is applied per call as a context deadline, so a long streamed reply that
+// keeps arriving is not cut off.
+var chatClient = &http.Client{}
+
+// isTimeout reports whether err is the AI service call running out of time
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func callChatGPT(ctx context.Context, config Configuration, messages []ChatMessage) (*ChatResponse, error) {
+	var chatResp *ChatResponse
+	var err error
+	for attempt := 0; attempt <= config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			// A cancelled turn stops waiting rather than sitting out the backoff
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Duration(attempt) * 500 * time.Millisecond):
+			}
+		}
+		chatResp, err = requestChatGPT(ctx, config, messages)
+		if err != nil {
+			return nil, err
+		}
+		chatResp.Attempts = attempt + 1
+		// A 200 with no reply is a transient upstream hiccup; a content
+		// filter stop is a real answer and is returned as is.
+		if !chatResp.empty() {
+			return chatResp, nil
+		}
+		log.Printf("⚠️ ChatGPT returned no reply (attempt %d of %d, finish reason %q)", attempt+1, config.MaxRetries+1, finishReason(chatResp))
+	}
+	return nil, fmt.Errorf("%w after %d attempts", errEmptyReply, config.MaxRetries+1)
+}
+
+// finishReason is why the model stopped, for logging
+func finishReason(r *ChatResponse) string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	return r.Choices[0].FinishReason
+}
+
+func requestChatGPT(ctx context.Context, config Configuration, messages []ChatMessage) (*ChatResponse, error) {
+	if timeout := config.RequestTimeoutDuration(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := newChatGPTRequest(ctx, config, messages, false)
+	if err != nil {
+		return nil, err
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := doChatRequest(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		storeRawResponse(config, resp, body, ".json")
+		return nil, fmt.Errorf("chat request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
 	var chatResp ChatResponse
+	if config.DebugStoreResponses {
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		storeRawResponse(config, resp, body, ".json")
+		if err := json.Unmarshal(body, &chatResp); err != nil {
+			return nil, err
+		}
+		return &chatResp, nil
+	}
 	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// roundTripFunc answers the AI service's requests in tests
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// fakeAI stands in for the completions API, answering with bodies in turn
+// (the last one repeated) and keeping the request bodies it was sent
+type fakeAI struct {
+	mu       sync.Mutex
+	bodies   []string
+	requests []map[string]interface{}
+}
+
+// calls is how many completion requests were made
+func (f *fakeAI) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.requests)
+}
+
+// fakeChat routes chatClient to a fakeAI whose completions say replies in turn
+func fakeChat(t *testing.T, replies ...string) *fakeAI {
+	t.Helper()
+	bodies := make([]string, len(replies))
+	for i, reply := range replies {
+		bodies[i] = completion(reply)
+	}
+	return fakeCompletions(t, bodies...)
+}
+
+// fakeCompletions routes chatClient to a fakeAI answering with the raw bodies
+// in turn, for the rest of the test
+func fakeCompletions(t *testing.T, bodies ...string) *fakeAI {
+	t.Helper()
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	fake := &fakeAI{bodies: bodies}
+	previous := chatClient.Transport
+	t.Cleanup(func() { chatClient.Transport = previous })
+	chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var request map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&request)
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, request)
+		body := completion("")
+		if len(fake.bodies) > 0 {
+			body = fake.bodies[0]
+			if len(fake.bodies) > 1 {
+				fake.bodies = fake.bodies[1:]
+			}
+		}
+		fake.mu.Unlock()
+		return jsonResponse(r, http.StatusOK, body), nil
+	})
+	return fake
+}
+
+// completion is a completion whose only choice says content
+func completion(content string) string {
+	var choice ChatChoice
+	choice.Message.Role = "assistant"
+	choice.Message.Content = content
+	choice.FinishReason = "stop"
+	data, _ := json.Marshal(ChatResponse{Choices: []ChatChoice{choice}, Model: "gpt-test"})
+	return string(data)
+}
+
+func jsonResponse(r *http.Request, status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    r,
+	}
+}
+
+// postJSON is a JSON POST to path, as the chat page sends it
+func postJSON(path, body string) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	return r
+}
+
+// testConfig is a configuration with the given forms and a temporary data directory
+func testConfig(t *testing.T, forms ...ConfigurationForm) Configuration {
+	t.Helper()
+	var config Configuration
+	config.Model = "gpt-test"
+	config.DataDir = t.TempDir()
+	config.Forms.Form = forms
+	return config
+}
+
+// testForm is a registration form keyed by License that loads its own
+// records as context, like the shipped registration form
+func testForm(name string) ConfigurationForm {
+	return ConfigurationForm{
+		Name:        name,
+		ContextForm: name,
+		PrimaryKey:  "License",
+		Fields:      "FirstName: {{.FirstName}} (like John)\nLicense: {{.License}} (like 555-55-5555)",
+		Prompt:      "%s\n%s\n%s",
+	}
+}
+
+func TestRenderOfflineTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		data     map[string]string
+		want     string
+		wantOK   bool
+	}{
+		{"fills form data", "Sorry {{.FirstName}}, we are offline.", map[string]string{"FirstName": "Ann"}, "Sorry Ann, we are offline.", true},
+		{"trims the result", "  back soon \n", nil, "back soon", true},
+		{"unset", "", nil, "", false},
+		{"does not parse", "{{.FirstName", nil, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.OfflineTemplate = tt.template
+			got, ok := renderOfflineTemplate(testConfig(t, form), form, tt.data)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("renderOfflineTemplate = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestChatFallsBackToOfflineTemplate(t *testing.T) {
+	fakeChat(t)
+	chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return jsonResponse(r, http.StatusInternalServerError, `{"error": "down"}`), nil
+	})
+	form := testForm("f")
+	form.OfflineTemplate = "We are offline, please try later."
+	config := testConfig(t, form)
+
+	w := httptest.NewRecorder()
+	handleChat(w, postJSON("/form/f/chat", `{"message": "hi"}`), config, "f")
+	var reply map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &reply)
+	if w.Code != http.StatusOK || reply["offline"] != true || reply["message"] != form.OfflineTemplate {
+		t.Errorf("got %d %v, want the offline template", w.Code, reply)
+	}
+}
+
+func TestCallChatGPTRetriesEmptyReplies(t *testing.T) {
+	const (
+		noChoices = `{"choices": []}`
+		blank     = `{"choices": [{"message": {"role": "assistant", "content": " "}, "finish_reason": "stop"}]}`
+		filtered  = `{"choices": [{"message": {"role": "assistant", "content": ""}, "finish_reason": "content_filter"}]}`
+	)
+	tests := []struct {
+		name         string
+		bodies       []string
+		maxRetries   int
+		wantErr      error
+		wantFiltered bool
+		wantAttempts int
+	}{
+		{"reply first time", []string{completion("SAY hi")}, 1, nil, false, 1},
+		{"empty then a reply", []string{noChoices, completion("SAY hi")}, 1, nil, false, 2},
+		{"blank every time", []string{blank}, 1, errEmptyReply, false, 2},
+		{"no retries", []string{noChoices}, 0, errEmptyReply, false, 1},
+		{"content filter is an answer", []string{filtered}, 1, nil, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakeCompletions(t, tt.bodies...)
+			config := testConfig(t)
+			config.MaxRetries = tt.maxRetries
+			resp, err := callChatGPT(context.Background(), config, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && resp.filtered() != tt.wantFiltered {
+				t.Errorf("filtered = %v, want %v", resp.filtered(), tt.wantFiltered)
+			}
+			if fake.calls() != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", fake.calls(), tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestContentFilterStopIsNotKeptInTheHistory(t *testing.T) {
+	fakeCompletions(t, `{"choices": [{"message": {"role": "assistant", "content": ""}, "finish_reason": "content_filter"}]}`)
+	config := testConfig(t, testForm("f"))
+	session := &ChatSession{FormData: map[string]string{}, Messages: []ChatMessage{{Role: "system", Content: "prompt"}}}
+	turn, err := runChatTurn(context.Background(), config, "f", session, "something filtered")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if turn.ResponseText() != contentFilterReply || len(session.Messages) != 1 {
+		t.Errorf("reply %q with history %v", turn.ResponseText(), session.Messages)
+	}
+}
+
+func TestSanitizeQueryMessage(t *testing.T) {
+	long := strings.Repeat("é", maxQueryMessageLength+10)
+	tests := []struct {
+		name string
+		q    string
+		want string
+	}{
+		{"plain", "hello there", "hello there"},
+		{"control characters become spaces", "hi\r\nBcc:\tme", "hi  Bcc: me"},
+		{"trimmed", "  hi  ", "hi"},
+		{"capped by characters, not bytes", long, strings.Repeat("é", maxQueryMessageLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeQueryMessage(tt.q); got != tt.want {
+				t.Errorf("sanitizeQueryMessage = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunQueryTurn(t *testing.T) {
+	tests := []struct {
+		name      string
+		busy      bool
+		throttled bool
+		wantCalls int
+		wantReply interface{}
+	}{
+		{"runs the turn", false, false, 1, "Hello Ann"},
+		{"turn in progress", true, false, 0, nil},
+		{"too soon after the last turn", false, true, 0, defaultTurnIntervalReply},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakeChat(t, "SAY Hello Ann")
+			form := testForm("f")
+			form.ConcurrentTurns = "reject"
+			config := testConfig(t, form)
+			config.MinTurnInterval = "1m"
+			r := httptest.NewRequest(http.MethodGet, "/form/f?q=I'm+Ann", nil)
+			r.Header.Set(sessionIDHeader, newClientID())
+			session := getOrCreateSession(httptest.NewRecorder(), config, "f", r)
+			if tt.throttled {
+				session.LastTurnAt = time.Now()
+			}
+			if tt.busy {
+				session.turnMu.Lock()
+				defer session.turnMu.Unlock()
+			}
+
+			exchange := runQueryTurn(httptest.NewRecorder(), r, config, "f")
+			if fake.calls() != tt.wantCalls {
+				t.Errorf("%d AI calls, want %d", fake.calls(), tt.wantCalls)
+			}
+			var got interface{}
+			if exchange != nil {
+				got = exchange["reply"].(map[string]interface{})["message"]
+			}
+			if got != tt.wantReply {
+				t.Errorf("reply = %v, want %v", got, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestDecodeChatRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		formEncoded bool
+		want        string
+		wantErr     error
+	}{
+		{"json", "application/json", `{"message": "hi"}`, false, "hi", nil},
+		{"json with charset", "application/json; charset=utf-8", `{"message": "hi"}`, false, "hi", nil},
+		{"form encoded when allowed", "application/x-www-form-urlencoded", "message=hi+there", true, "hi there", nil},
+		{"form encoded when not allowed", "application/x-www-form-urlencoded", "message=hi", false, "", errUnsupportedMediaType},
+		{"plain text", "text/plain", "hi", true, "", errUnsupportedMediaType},
+		{"no content type", "", `{"message": "hi"}`, false, "", errUnsupportedMediaType},
+		{"empty json body", "application/json", "", false, "", errEmptyBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/form/f/chat", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", tt.contentType)
+			req, err := decodeChatRequest(r, Configuration{AcceptFormEncoded: tt.formEncoded})
+			if !errors.Is(err, tt.wantErr) || req.Message != tt.want {
+				t.Errorf("decodeChatRequest = %q, %v, want %q, %v", req.Message, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteDecodeError(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+	}{
+		{fmt.Errorf("%w %q", errUnsupportedMediaType, "text/plain"), http.StatusUnsupportedMediaType},
+		{errors.New("unexpected EOF"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		writeDecodeError(w, httptest.NewRequest(http.MethodPost, "/form/f/chat", nil), Configuration{}, "f", tt.err)
+		if w.Code != tt.wantStatus {
+			t.Errorf("%v: status %d, want %d", tt.err, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestSaveOnTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		formData map[string]string
+		wantSave bool
+	}{
+		{"disabled", false, map[string]string{"FirstName": "Ann", "License": "A1"}, false},
+		{"incomplete", true, map[string]string{"License": "A1"}, false},
+		{"complete", true, map[string]string{"FirstName": "Ann", "License": "A1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.SaveOnTimeout = tt.enabled
+			config := testConfig(t, form)
+			session := &ChatSession{FormData: tt.formData}
+			turn, saved := saveOnTimeout(config, "f", session)
+			if saved != tt.wantSave || (turn != nil) != tt.wantSave {
+				t.Fatalf("saveOnTimeout = %v, %v, want saved %v", turn, saved, tt.wantSave)
+			}
+			path, _ := storedRecordPath(config, "f", "A1")
+			if _, err := os.Stat(path); (err == nil) != tt.wantSave {
+				t.Errorf("record at %s exists = %v, want %v", path, err == nil, tt.wantSave)
+			}
+		})
+	}
+}
+
+func TestDisallowedModelIsNeverRequested(t *testing.T) {
+	fake := fakeChat(t, "SAY hi")
+	config := testConfig(t)
+	config.AllowedModels = "gpt-4o"
+	if _, err := requestChatGPT(context.Background(), config, nil); err == nil {
+		t.Fatal("requestChatGPT succeeded with a model outside allowed_models")
+	}
+	if n := fake.calls(); n != 0 {
+		t.Errorf("AI service called %d times", n)
+	}
+}
+
+func TestRepromptOnViolation(t *testing.T) {
+	complete := map[string]string{"FirstName": "Ann", "License": "A1"}
+	tests := []struct {
+		name      string
+		enabled   bool
+		first     string
+		formData  map[string]string
+		wantCalls int
+	}{
+		{"disabled", false, "Hello, what is your name?", nil, 1},
+		{"reply without commands", true, "Hello, what is your name?", nil, 2},
+		{"reply with commands", true, "SAY Hello, what is your name?", nil, 1},
+		{"nothing left to ask", true, "All done.", complete, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakeChat(t, tt.first, "SAY Hello\nSET FirstName Bob")
+			config := testConfig(t, testForm("f"))
+			config.RepromptOnViolation = tt.enabled
+			formData := map[string]string{}
+			for k, v := range tt.formData {
+				formData[k] = v
+			}
+			session := &ChatSession{FormData: formData}
+			if _, err := runChatTurn(context.Background(), config, "f", session, "hi"); err != nil {
+				t.Fatal(err)
+			}
+			if calls := fake.calls(); calls != tt.wantCalls {
+				t.Fatalf("AI service called %d times, want %d", calls, tt.wantCalls)
+			}
+			if tt.wantCalls == 2 {
+				last := fmt.Sprint(fake.requests[1]["messages"])
+				if !strings.Contains(last, protocolViolationPrompt) {
+					t.Errorf("reprompt %s does not ask for the protocol", last)
+				}
+				if session.FormData["FirstName"] != "Bob" {
+					t.Errorf("reprompted reply was not applied: %v", session.FormData)
+				}
+			}
+		})
+	}
+}
+
+func TestReturningGreetingIsSentOnce(t *testing.T) {
+	fakeChat(t, "SAY What brings you in today?")
+	form := testForm("f")
+	form.ReturningGreeting = "Welcome back, {{.FirstName}}!"
+	config := testConfig(t, form)
+	saved := &ChatSession{FormData: map[string]string{"FirstName": "Ann", "License": "A1"}}
+	if err := saveSession(config, "f", saved, newTurnResult(config, "f", saved)); err != nil {
+		t.Fatal(err)
+	}
+	identity := identityCookie(config, "f", saved)
+	client := newClientID()
+
+	tests := []struct {
+		name         string
+		identified   bool
+		wantGreeting interface{}
+	}{
+		{"first turn of a returning user", true, "Welcome back, Ann!"},
+		{"later turn", true, nil},
+		{"new user", false, nil},
+	}
+	for _, tt := range tests {
+		r := postJSON("/form/f/chat", `{"message": "hi"}`)
+		if tt.identified {
+			r.AddCookie(identity)
+			r.Header.Set(sessionIDHeader, client)
+		}
+		w := httptest.NewRecorder()
+		handleChat(w, r, config, "f")
+		var reply map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &reply)
+		if reply["greeting"] != tt.wantGreeting {
+			t.Errorf("%s: greeting = %v, want %v", tt.name, reply["greeting"], tt.wantGreeting)
+		}
+	}
+}
+
+func TestParseCommandsWithSeparators(t *testing.T) {
+	tests := []struct {
+		name       string
+		separators string
+		line       string
+		want       []assistantCommand
+	}{
+		{
+			"no separators configured",
+			"", "SET City NYC; SET State NY",
+			[]assistantCommand{{Verb: "SET", Field: "City", Value: "NYC; SET State NY"}},
+		},
+		{
+			"split before each command",
+			";|", "SET City NYC; SET State NY | SAY Thanks",
+			[]assistantCommand{{Verb: "SET", Field: "City", Value: "NYC"}, {Verb: "SET", Field: "State", Value: "NY"}, {Verb: "SAY", Value: "Thanks"}},
+		},
+		{
+			"separator inside a value",
+			";", "SAY Got it; thanks",
+			[]assistantCommand{{Verb: "SAY", Value: "Got it; thanks"}},
+		},
+		{
+			"quoted value",
+			";", `SET Notes "call; SAVE later"; SAVE`,
+			[]assistantCommand{{Verb: "SET", Field: "Notes", Value: "call; SAVE later"}, {Verb: "SAVE"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{CommandSeparators: tt.separators}
+			got := parseCommands(config, testForm("f"), tt.line)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCommands = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatModeShowsRepliesAsTheyAre(t *testing.T) {
+	tests := []struct {
+		mode        string
+		wantText    string
+		wantUpdates int
+	}{
+		{"forms", "Hi Ann", 1},
+		{"chat", "SAY Hi Ann\nSET FirstName Ann\nSAVE", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			config := testConfig(t, testForm("f"))
+			config.Mode = tt.mode
+			session := &ChatSession{FormData: map[string]string{}}
+			turn := applyResponse(config, "f", session, "SAY Hi Ann\nSET FirstName Ann\nSAVE")
+			if turn.ResponseText() != tt.wantText || len(session.FormData) != tt.wantUpdates {
+				t.Errorf("reply %q with form data %v", turn.ResponseText(), session.FormData)
+			}
+			if tt.mode == "chat" && turn.ShouldSave {
+				t.Error("chat mode saved the form")
+			}
+		})
+	}
+}
+
+func TestReasoningEffortIsOnlySentToReasoningModels(t *testing.T) {
+	tests := []struct {
+		model  string
+		effort string
+		want   interface{}
+	}{
+		{"o3-mini", "low", "low"},
+		{"gpt-5", "minimal", "minimal"},
+		{"gpt-4o", "low", nil},
+		{"o3-mini", "", nil},
+		{"o10", "high", nil},
+	}
+	for _, tt := range tests {
+		fake := fakeChat(t, "SAY hi")
+		config := testConfig(t)
+		config.Model = tt.model
+		config.ReasoningEffort = tt.effort
+		if _, err := requestChatGPT(context.Background(), config, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got := fake.requests[0]["reasoning_effort"]; got != tt.want {
+			t.Errorf("%s with %q: reasoning_effort = %v, want %v", tt.model, tt.effort, got, tt.want)
+		}
+	}
+}
+
+func TestChatRequestBodyErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantBody string
+	}{
+		{"empty body", "", "Bad request: empty request body\n"},
+		{"malformed JSON", `{"message": `, "Bad request\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakeChat(t, "SAY hi")
+			w := httptest.NewRecorder()
+			handleChat(w, postJSON("/form/f/chat", tt.body), testConfig(t, testForm("f")), "f")
+			if w.Code != http.StatusBadRequest || w.Body.String() != tt.wantBody || fake.calls() != 0 {
+				t.Errorf("got %d %q after %d AI calls, want 400 %q", w.Code, w.Body, fake.calls(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSuggestOffersOptionsWithoutSetting(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		reply       string
+		suggestions map[string][]string
+	}{
+		{"offered", true, "SUGGEST FirstName Ann | Bob |", map[string][]string{"FirstName": {"Ann", "Bob"}}},
+		{"no options", true, "SUGGEST FirstName  | ", nil},
+		{"form without suggestions", false, "SUGGEST FirstName Ann|Bob", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.Suggestions = tt.enabled
+			config := testConfig(t, form)
+			session := &ChatSession{FormData: map[string]string{}}
+			turn := applyResponse(config, "f", session, tt.reply)
+			if !reflect.DeepEqual(turn.Suggestions, tt.suggestions) {
+				t.Errorf("Suggestions = %v, want %v", turn.Suggestions, tt.suggestions)
+			}
+			if len(session.FormData) != 0 {
+				t.Errorf("SUGGEST set %v", session.FormData)
+			}
+		})
+	}
+}
+
+func TestCheckMessageLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		max     int
+		message string
+		wantErr bool
+	}{
+		{"no limit", 0, strings.Repeat("a", 5000), false},
+		{"at the limit", 5, "hello", false},
+		{"over the limit", 5, "hello!", true},
+		{"counted in characters", 5, "héllö", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkMessageLength(ConfigurationForm{MaxMessageChars: tt.max}, tt.message)
+			if errors.Is(err, errMessageTooLong) != tt.wantErr {
+				t.Errorf("checkMessageLength = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLongMessageIsRejected(t *testing.T) {
+	fake := fakeChat(t, "SAY Hello")
+	form := testForm("f")
+	form.MaxMessageChars = 3
+	config := testConfig(t, form)
+	w := httptest.NewRecorder()
+	handleChat(w, postJSON("/form/f/chat", `{"message": "hello"}`), config, "f")
+	if w.Code != http.StatusBadRequest || fake.calls() != 0 {
+		t.Errorf("status %d after %d AI calls, want 400 without calling the AI", w.Code, fake.calls())
+	}
+}
+
+func TestSeedIsSentToTheModel(t *testing.T) {
+	seed := func(n int) *int { return &n }
+	tests := []struct {
+		name     string
+		global   *int
+		form     *int
+		wantSeed interface{}
+	}{
+		{"no seed", nil, nil, nil},
+		{"global seed", seed(7), nil, 7.0},
+		{"form seed overrides it", seed(7), seed(0), 0.0},
+		{"form seed alone", nil, seed(42), 42.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := fakeChat(t, "SAY Hello")
+			form := testForm("f")
+			form.ModelSeed = tt.form
+			config := testConfig(t, form)
+			config.Seed = tt.global
+
+			handleChat(httptest.NewRecorder(), postJSON("/form/f/chat", `{"message": "hi"}`), config, "f")
+			if fake.calls() != 1 {
+				t.Fatalf("%d AI calls, want 1", fake.calls())
+			}
+			if got := fake.requests[0]["seed"]; got != tt.wantSeed {
+				t.Errorf("seed = %v, want %v", got, tt.wantSeed)
+			}
+		})
+	}
+}
+
+func TestQuickReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		reply   string
+		want    interface{}
+	}{
+		{"offered", true, "SAY Renew?\nQUICKREPLIES Yes | No |Tell me more", []interface{}{"Yes", "No", "Tell me more"}},
+		{"angle brackets", true, "SAY Renew?\nQUICKREPLIES <Yes|No>", []interface{}{"Yes", "No"}},
+		{"no replies", true, "SAY Renew?\nQUICKREPLIES  | ", nil},
+		{"form without quick replies", false, "SAY Renew?\nQUICKREPLIES Yes|No", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeChat(t, tt.reply)
+			form := testForm("f")
+			form.QuickReplies = tt.enabled
+			config := testConfig(t, form)
+			w := httptest.NewRecorder()
+			handleChat(w, postJSON("/form/f/chat", `{"message": "hi"}`), config, "f")
+			var reply map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &reply)
+			if !reflect.DeepEqual(reply["quick_replies"], tt.want) || reply["message"] != "Renew?" {
+				t.Errorf("reply %v, want quick_replies %v", reply, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceConfig is the page served while maintenance mode is on; when
+// unset the 503 error page is used
+type MaintenanceConfig struct {
+	Message string `xml:"message"`
+	HTML    string `xml:"html"`
+	// Keep the toggle in the data directory so it survives a restart
+	Persist bool `xml:"persist"`
+}
+
+// maintenanceState is the toggle set through POST /admin/maintenance
+type maintenanceState struct {
+	Enabled bool      `json:"enabled"`
+	Since   time.Time `json:"since,omitempty"`
+	// Replaces the configured message for this maintenance window
+	Message string `json:"message,omitempty"`
+}
+
+// Current maintenance mode, shared by every request
+var (
+	maintenanceMu sync.Mutex
+	maintenance   maintenanceState
+)
+
+// maintenanceStatePath is where the toggle is kept when persist is on
+func maintenanceStatePath(config Configuration) string {
+	return filepath.Join(dataDir(config), "maintenance.json")
+}
+
+// configureMaintenance restores a persisted maintenance toggle
+func configureMaintenance(config Configuration) {
+	if !config.Maintenance.Persist {
+		return
+	}
+	data, err := os.ReadFile(maintenanceStatePath(config))
+	if err != nil {
+		return
+	}
+	var state maintenanceState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Printf("⚠️ MAINTENANCE: ignoring unreadable state: %v", err)
+		return
+	}
+	setMaintenance(config, state)
+}
+
+// currentMaintenance copies the maintenance state
+func currentMaintenance() maintenanceState {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	return maintenance
+}
+
+// setMaintenance switches maintenance mode, saving it when persist is on
+func setMaintenance(config Configuration, state maintenanceState) {
+	maintenanceMu.Lock()
+	defer maintenanceMu.Unlock()
+	maintenance = state
+	if state.Enabled {
+		log.Printf("🚧 MAINTENANCE: on since %s", state.Since.Format(time.RFC3339))
+	} else {
+		log.Printf("✅ MAINTENANCE: off")
+	}
+	if !config.Maintenance.Persist {
+		return
+	}
+	data, err := json.Marshal(state)
+	if err == nil {
+		err = os.WriteFile(maintenanceStatePath(config), data, 0644)
+	}
+	if err != nil {
+		log.Printf("⚠️ MAINTENANCE: failed to save state: %v", err)
+	}
+}
+
+// Paths still served in maintenance mode, so health stays reportable and
+// operators can switch it off again
+var maintenanceExempt = []string{"/healthz", "/admin/", "/openapi.json", "/robots.txt"}
+
+// maintenanceGate answers every other request with the maintenance page
+// while maintenance mode is on
+func maintenanceGate(config Configuration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		state := currentMaintenance()
+		if !state.Enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+		for _, prefix := range maintenanceExempt {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		page := config.errorPage(http.StatusServiceUnavailable)
+		page.Message = firstNonEmpty(state.Message, config.Maintenance.Message, page.Message)
+		page.HTML = firstNonEmpty(config.Maintenance.HTML, page.HTML)
+		w.Header().Set("Retry-After", "300")
+		writeErrorPage(w, r, http.StatusServiceUnavailable, page)
+	})
+}
+
+// handleAdminMaintenance reports maintenance mode on GET and switches it on
+// POST with a body such as {"enabled": true, "message": "Back at 10:00"}
+func handleAdminMaintenance(w http.ResponseWriter, r *http.Request, config Configuration) {
+	if !allowAdmin(w, r) {
+		return
+	}
+	if r.Method == http.MethodPost {
+		limitBody(w, r, config)
+		var state maintenanceState
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if state.Enabled {
+			state.Since = time.Now().UTC()
+		} else {
+			state = maintenanceState{}
+		}
+		setMaintenance(config, state)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(currentMaintenance())
+}
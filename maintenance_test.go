@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useMaintenance sets the maintenance state for the rest of the test
+func useMaintenance(t *testing.T, state maintenanceState) {
+	saved := currentMaintenance()
+	t.Cleanup(func() { maintenance = saved })
+	maintenance = state
+}
+
+func TestMaintenanceGate(t *testing.T) {
+	tests := []struct {
+		name        string
+		state       maintenanceState
+		path        string
+		wantStatus  int
+		wantMessage string
+	}{
+		{"off", maintenanceState{}, "/form/f", http.StatusOK, ""},
+		{"on", maintenanceState{Enabled: true}, "/form/f", http.StatusServiceUnavailable, "Down for upgrades"},
+		{"window message", maintenanceState{Enabled: true, Message: "Back at 10:00"}, "/form/f/chat", http.StatusServiceUnavailable, "Back at 10:00"},
+		{"health stays up", maintenanceState{Enabled: true}, "/healthz", http.StatusOK, ""},
+		{"admin stays up", maintenanceState{Enabled: true}, "/admin/maintenance", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useMaintenance(t, tt.state)
+			config := testConfig(t, testForm("f"))
+			config.Maintenance.Message = "Down for upgrades"
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+			w := httptest.NewRecorder()
+			maintenanceGate(config, next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantMessage != "" && (!strings.Contains(w.Body.String(), tt.wantMessage) || w.Header().Get("Retry-After") == "") {
+				t.Errorf("body %q, Retry-After %q", w.Body.String(), w.Header().Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestAdminMaintenancePersists(t *testing.T) {
+	t.Setenv(adminTokenEnv, "letmein")
+	useMaintenance(t, maintenanceState{})
+	config := testConfig(t, testForm("f"))
+	config.Maintenance.Persist = true
+
+	tests := []struct {
+		name        string
+		body        string
+		wantEnabled bool
+	}{
+		{"switch on", `{"enabled": true, "message": "Back soon"}`, true},
+		{"switch off", `{"enabled": false, "message": "ignored"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := postJSON("/admin/maintenance", tt.body)
+			r.Header.Set("Authorization", "Bearer letmein")
+			w := httptest.NewRecorder()
+			handleAdminMaintenance(w, r, config)
+			var reply maintenanceState
+			json.Unmarshal(w.Body.Bytes(), &reply)
+			if reply.Enabled != tt.wantEnabled || reply.Since.IsZero() == tt.wantEnabled {
+				t.Errorf("reply %+v", reply)
+			}
+
+			// A restart restores the toggle
+			maintenance = maintenanceState{}
+			configureMaintenance(config)
+			if restored := currentMaintenance(); restored != reply {
+				t.Errorf("restored %+v, want %+v", restored, reply)
+			}
+		})
+	}
+}
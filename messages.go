@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	texttemplate "text/template"
+)
+
+// LocalizedMessage replaces or adds the text of a user-facing message for one
+// language, e.g. <message key="verification_failed" lang="es">...</message>
+type LocalizedMessage struct {
+	Key  string `xml:"key,attr"`
+	Lang string `xml:"lang,attr"`
+	Text string `xml:",chardata"`
+}
+
+// Built-in texts of the validation messages, by key and then language.
+// Each is a template; {{.Reasons}} and {{.Max}} are filled in where they apply.
+var builtinMessages = map[string]map[string]string{
+	"verification_failed": {
+		"en": "Before I can save this, please check: {{.Reasons}}",
+		"es": "Antes de guardar, por favor revise: {{.Reasons}}",
+		"fr": "Avant d'enregistrer, veuillez vérifier : {{.Reasons}}",
+		"de": "Bevor ich speichern kann, prüfen Sie bitte: {{.Reasons}}",
+	},
+	"verification_unavailable": {
+		"en": "the form could not be verified right now",
+		"es": "no se pudo verificar el formulario en este momento",
+		"fr": "le formulaire n'a pas pu être vérifié pour le moment",
+		"de": "das Formular konnte gerade nicht geprüft werden",
+	},
+	"message_too_long": {
+		"en": "Your message is too long: it can be at most {{.Max}} characters.",
+		"es": "Su mensaje es demasiado largo: puede tener como máximo {{.Max}} caracteres.",
+		"fr": "Votre message est trop long : il peut contenir au plus {{.Max}} caractères.",
+		"de": "Ihre Nachricht ist zu lang: sie darf höchstens {{.Max}} Zeichen haben.",
+	},
+}
+
+// messageText finds the text of a message for a locale: the configured
+// messages first, then the built-in ones, trying the full locale, its base
+// language and finally English
+func (c Configuration) messageText(locale, key string) string {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	for _, lang := range []string{locale, strings.Split(locale, "-")[0], "en"} {
+		for _, m := range c.Messages.Message {
+			if m.Key == key && strings.ToLower(m.Lang) == lang {
+				return strings.TrimSpace(m.Text)
+			}
+		}
+		if text, ok := builtinMessages[key][lang]; ok {
+			return text
+		}
+	}
+	return ""
+}
+
+// localize renders a message in the session's language
+func (c Configuration) localize(locale, key string, data map[string]interface{}) string {
+	text := c.messageText(locale, key)
+	tmpl, err := texttemplate.New(key).Parse(text)
+	if err != nil {
+		log.Printf("❌ ERROR: Failed to parse message %s for %s: %v", key, locale, err)
+		return text
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.Printf("❌ ERROR: Failed to render message %s for %s: %v", key, locale, err)
+		return text
+	}
+	return buf.String()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLocalize(t *testing.T) {
+	var config Configuration
+	config.Messages.Message = []LocalizedMessage{
+		{Key: "message_too_long", Lang: "es-MX", Text: "  Máximo {{.Max}} caracteres, por favor.  "},
+		{Key: "verification_failed", Lang: "pt", Text: "Antes de salvar, verifique: {{.Reasons}}"},
+	}
+	tests := []struct {
+		name   string
+		locale string
+		key    string
+		want   string
+	}{
+		{"built in", "es", "message_too_long", "Su mensaje es demasiado largo: puede tener como máximo 5 caracteres."},
+		{"base language", "fr-CA", "message_too_long", "Votre message est trop long : il peut contenir au plus 5 caractères."},
+		{"underscore locale", "de_DE", "verification_unavailable", "das Formular konnte gerade nicht geprüft werden"},
+		{"configured for a locale", "es_MX", "message_too_long", "Máximo 5 caracteres, por favor."},
+		{"configured language", "pt-BR", "verification_failed", "Antes de salvar, verifique: a; b"},
+		{"falls back to English", "ja", "verification_failed", "Before I can save this, please check: a; b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := map[string]interface{}{"Max": 5, "Reasons": "a; b"}
+			if got := config.localize(tt.locale, tt.key, data); got != tt.want {
+				t.Errorf("localize = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLongMessageIsRejectedInTheUsersLanguage(t *testing.T) {
+	fakeChat(t, "SAY Hola")
+	form := testForm("f")
+	form.MaxMessageChars = 3
+	config := testConfig(t, form)
+	r := postJSON("/form/f/chat", `{"message": "hola"}`)
+	r.Header.Set("Accept-Language", "es-ES,es;q=0.9")
+	w := httptest.NewRecorder()
+	handleChat(w, r, config, "f")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "como máximo 3 caracteres") {
+		t.Errorf("status %d: %q", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+// TokenUsage is the token accounting reported by the completions API
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// turnMeta describes how a turn's reply was produced, for debugging
+type turnMeta struct {
+	Model      string     `json:"model"`
+	LatencyMS  int64      `json:"latency_ms"`
+	Tokens     TokenUsage `json:"tokens"`
+	Attempts   int        `json:"attempts"`
+	Cached     bool       `json:"cached"`
+	Reprompted bool       `json:"reprompted"`
+	// Backend fingerprint reported with the completion, for reproducibility
+	SystemFingerprint string `json:"system_fingerprint,omitempty"`
+}
+
+// record adds one model response to the turn's totals
+func (m *turnMeta) record(resp *ChatResponse) {
+	if resp.Model != "" {
+		m.Model = resp.Model
+	}
+	m.Tokens.PromptTokens += resp.Usage.PromptTokens
+	m.Tokens.CompletionTokens += resp.Usage.CompletionTokens
+	m.Tokens.TotalTokens += resp.Usage.TotalTokens
+	m.Attempts += resp.Attempts
+	m.Cached = m.Cached || resp.Cached
+	if resp.SystemFingerprint != "" {
+		m.SystemFingerprint = resp.SystemFingerprint
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTurnMetaRecordAddsUp(t *testing.T) {
+	var meta turnMeta
+	meta.record(&ChatResponse{Model: "gpt-test-1", Usage: TokenUsage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}, Attempts: 2})
+	meta.record(&ChatResponse{Usage: TokenUsage{PromptTokens: 20, CompletionTokens: 1, TotalTokens: 21}, Attempts: 1, Cached: true, SystemFingerprint: "fp_1"})
+	want := turnMeta{
+		Model:             "gpt-test-1",
+		Tokens:            TokenUsage{PromptTokens: 30, CompletionTokens: 6, TotalTokens: 36},
+		Attempts:          3,
+		Cached:            true,
+		SystemFingerprint: "fp_1",
+	}
+	if meta != want {
+		t.Errorf("meta = %+v, want %+v", meta, want)
+	}
+}
+
+func TestChatReturnsMetaWhenEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		wantMeta bool
+	}{
+		{"debug_meta on", true, true},
+		{"debug_meta off", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeCompletions(t, `{"model": "gpt-test-1", "choices": [{"message": {"role": "assistant", "content": "SAY hi"}, "finish_reason": "stop"}], "usage": {"prompt_tokens": 7, "completion_tokens": 2, "total_tokens": 9}}`)
+			config := testConfig(t, testForm("f"))
+			config.DebugMeta = tt.enabled
+
+			w := httptest.NewRecorder()
+			handleChat(w, postJSON("/form/f/chat", `{"message": "hello"}`), config, "f")
+			var reply struct {
+				Meta *turnMeta `json:"meta"`
+			}
+			json.Unmarshal(w.Body.Bytes(), &reply)
+			if (reply.Meta != nil) != tt.wantMeta {
+				t.Fatalf("meta = %+v, want present %v", reply.Meta, tt.wantMeta)
+			}
+			if tt.wantMeta && (reply.Meta.Model != "gpt-test-1" || reply.Meta.Tokens.TotalTokens != 9 || reply.Meta.Attempts != 1) {
+				t.Errorf("meta = %+v", reply.Meta)
+			}
+		})
+	}
+}
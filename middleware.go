@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// allowedOrigin reports whether a browser origin may make credentialed calls
+// to the chat endpoints: the base URL's origin, or one listed in
+// allowed_origins. A "*" entry never counts here, since any site could then
+// act with the user's cookies.
+func allowedOrigin(config Configuration, origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if base, err := url.Parse(config.BaseURL); err == nil && base.Scheme+"://"+base.Host == origin {
+		return true
+	}
+	for _, allowed := range splitFieldList(config.AllowedOrigins) {
+		if allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// anyOrigin reports whether allowed_origins has "*", which lets any site call
+// the endpoints without credentials
+func anyOrigin(config Configuration) bool {
+	for _, allowed := range splitFieldList(config.AllowedOrigins) {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowMethods answers CORS preflight requests and rejects methods not in
+// methods with a 405 carrying an Allow header. It reports whether the
+// request should go on to the handler.
+func allowMethods(w http.ResponseWriter, r *http.Request, config Configuration, methods ...string) bool {
+	allow := strings.Join(append(methods, http.MethodOptions), ", ")
+
+	if origin := r.Header.Get("Origin"); allowedOrigin(config, origin) {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Expose-Headers", sessionIDHeader)
+		w.Header().Add("Vary", "Origin")
+	} else if origin != "" && anyOrigin(config) {
+		// Browsers send no cookies to a literal wildcard
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Expose-Headers", sessionIDHeader)
+	}
+
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", allow)
+		w.Header().Set("Access-Control-Allow-Methods", allow)
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+sessionIDHeader)
+		w.Header().Set("Access-Control-Max-Age", "600")
+		w.WriteHeader(http.StatusNoContent)
+		return false
+	}
+
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", allow)
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+// RateLimitConfig throttles chat requests per client IP
+type RateLimitConfig struct {
+	RequestsPerMinute float64 `xml:"requests_per_minute"`
+	Burst             float64 `xml:"burst"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket per key, refilled continuously
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	burst := config.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    config.RequestsPerMinute / 60,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Allow takes a token for key, reporting false if none are left
+func (l *rateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= 10000 {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// prune forgets buckets that have refilled completely, since they behave like new ones
+func (l *rateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// Global chat rate limiter, nil when rate limiting is not configured
+var chatRateLimiter *rateLimiter
+
+// Rate limiters of forms with their own rate_limit, which replaces the global
+// one for that form, so each (IP, form) pair has its own bucket
+var formRateLimiters = map[string]*rateLimiter{}
+
+// configureRateLimits creates the global limiter and one for each form with its own policy
+func configureRateLimits(config Configuration) {
+	if config.RateLimit.RequestsPerMinute > 0 {
+		chatRateLimiter = newRateLimiter(config.RateLimit)
+		log.Printf("Chat rate limit: %.0f requests/minute", config.RateLimit.RequestsPerMinute)
+	}
+	for _, form := range config.Forms.Form {
+		if form.RateLimit.RequestsPerMinute > 0 {
+			formRateLimiters[form.Name] = newRateLimiter(form.RateLimit)
+			log.Printf("Chat rate limit for %s: %.0f requests/minute", form.Name, form.RateLimit.RequestsPerMinute)
+		}
+	}
+}
+
+// rateLimiterFor is the limiter that applies to a form, nil if none does
+func rateLimiterFor(formName string) *rateLimiter {
+	if limiter, ok := formRateLimiters[formName]; ok {
+		return limiter
+	}
+	return chatRateLimiter
+}
+
+// clientIP is the address rate limits are kept for. Behind trusted_proxies it
+// is taken from X-Forwarded-For, walking back from the nearest hop past the
+// trusted proxies, so a client can't pick its address by sending the header.
+func clientIP(r *http.Request, config Configuration) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !config.Server.trustsProxy(host) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		host = hop
+		if !config.Server.trustsProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// allowRate enforces the chat rate limit, answering 429 when the client is over it
+func allowRate(w http.ResponseWriter, r *http.Request, config Configuration, formName string) bool {
+	limiter := rateLimiterFor(formName)
+	ip := clientIP(r, config)
+	if limiter == nil || limiter.Allow(ip) {
+		return true
+	}
+	log.Printf("🐢 THROTTLED [%s]: %s", formName, ip)
+	w.Header().Set("Retry-After", "60")
+	writeErrorResponse(w, r, config, http.StatusTooManyRequests)
+	return false
+}
+
+// ErrorPage is an operator supplied body for an error status
+type ErrorPage struct {
+	Status  int    `xml:"status,attr"`
+	Message string `xml:"message"`
+	HTML    string `xml:"html"`
+}
+
+var defaultErrorMessages = map[int]string{
+	http.StatusTooManyRequests:    "Too many requests, please try again in a minute.",
+	http.StatusServiceUnavailable: "The service is temporarily unavailable, please try again shortly.",
+}
+
+func (c Configuration) errorPage(status int) ErrorPage {
+	for _, page := range c.ErrorPages.Page {
+		if page.Status == status {
+			if page.Message == "" {
+				page.Message = defaultErrorMessages[status]
+			}
+			return page
+		}
+	}
+	return ErrorPage{Status: status, Message: defaultErrorMessages[status]}
+}
+
+// formAvailable answers requests for a disabled form with the 503 error
+// page, reporting whether the request may go ahead
+func formAvailable(w http.ResponseWriter, r *http.Request, config Configuration, formName string) bool {
+	if config.FormByName(formName).IsEnabled() {
+		return true
+	}
+	log.Printf("🚧 [%s]: form is disabled", formName)
+	writeErrorResponse(w, r, config, http.StatusServiceUnavailable)
+	return false
+}
+
+// writeErrorResponse sends the configured body for status, as HTML to page
+// loads and as JSON to everything else (the chat XHR calls)
+func writeErrorResponse(w http.ResponseWriter, r *http.Request, config Configuration, status int) {
+	writeErrorPage(w, r, status, config.errorPage(status))
+}
+
+// writeErrorPage sends page for status, as HTML or JSON like writeErrorResponse
+func writeErrorPage(w http.ResponseWriter, r *http.Request, status int, page ErrorPage) {
+	message := strings.TrimSpace(page.Message)
+	if message == "" {
+		message = http.StatusText(status)
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		if strings.TrimSpace(page.HTML) == "" {
+			fmt.Fprintf(w, "<!DOCTYPE html><html><body><p>%s</p></body></html>", template.HTMLEscapeString(message))
+			return
+		}
+		tmpl, err := template.New("error").Parse(page.HTML)
+		if err != nil {
+			log.Printf("Error page %d template error: %v", status, err)
+			return
+		}
+		tmpl.Execute(w, map[string]interface{}{"Status": status, "Message": message})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   http.StatusText(status),
+		"message": message,
+	})
+}
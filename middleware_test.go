@@ -0,0 +1,226 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowMethods(t *testing.T) {
+	config := Configuration{BaseURL: "https://forms.example.com", AllowedOrigins: "https://partner.example.com"}
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		wantOK      bool
+		wantStatus  int
+		wantAllow   string
+		wantOrigin  string
+		wantCreds   string
+		wantMethods string
+	}{
+		{"allowed method", http.MethodPost, "", true, http.StatusOK, "", "", "", ""},
+		{"head on a get route", http.MethodHead, "", true, http.StatusOK, "", "", "", ""},
+		{"wrong method", http.MethodDelete, "", false, http.StatusMethodNotAllowed, "POST, OPTIONS", "", "", ""},
+		{"preflight from the base url", http.MethodOptions, "https://forms.example.com", false, http.StatusNoContent, "POST, OPTIONS", "https://forms.example.com", "true", "POST, OPTIONS"},
+		{"listed origin", http.MethodPost, "https://partner.example.com", true, http.StatusOK, "", "https://partner.example.com", "true", ""},
+		{"unknown origin", http.MethodPost, "https://evil.example.com", true, http.StatusOK, "", "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			methods := []string{http.MethodPost}
+			if tt.method == http.MethodHead {
+				methods = []string{http.MethodGet, http.MethodHead}
+			}
+			r := httptest.NewRequest(tt.method, "/form/f/chat", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			if ok := allowMethods(w, r, config, methods...); ok != tt.wantOK {
+				t.Fatalf("allowMethods = %v, want %v", ok, tt.wantOK)
+			}
+			h := w.Header()
+			if w.Code != tt.wantStatus || h.Get("Allow") != tt.wantAllow || h.Get("Access-Control-Allow-Origin") != tt.wantOrigin ||
+				h.Get("Access-Control-Allow-Credentials") != tt.wantCreds || h.Get("Access-Control-Allow-Methods") != tt.wantMethods {
+				t.Errorf("got %d %v", w.Code, h)
+			}
+		})
+	}
+}
+
+func TestWildcardOriginNeverGetsCredentials(t *testing.T) {
+	config := Configuration{BaseURL: "https://forms.example.com", AllowedOrigins: "*"}
+	tests := []struct {
+		origin     string
+		wantOrigin string
+		wantCreds  string
+	}{
+		{"https://anywhere.example.com", "*", ""},
+		{"https://forms.example.com", "https://forms.example.com", "true"},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/form/f/chat", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		w := httptest.NewRecorder()
+		allowMethods(w, r, config, http.MethodPost)
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+			t.Errorf("%q: Allow-Origin %q, want %q", tt.origin, got, tt.wantOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.wantCreds {
+			t.Errorf("%q: Allow-Credentials %q, want %q", tt.origin, got, tt.wantCreds)
+		}
+	}
+}
+
+func TestWriteErrorResponse(t *testing.T) {
+	var config Configuration
+	config.ErrorPages.Page = []ErrorPage{
+		{Status: http.StatusTooManyRequests, Message: "Slow down"},
+		{Status: http.StatusServiceUnavailable, HTML: "<h1>{{.Status}}: {{.Message}}</h1>"},
+	}
+	tests := []struct {
+		name     string
+		status   int
+		accept   string
+		wantType string
+		wantBody string
+	}{
+		{"configured message as json", http.StatusTooManyRequests, "", "application/json", `{"error":"Too Many Requests","message":"Slow down"}` + "\n"},
+		{"configured message as html", http.StatusTooManyRequests, "text/html", "text/html; charset=utf-8", "<!DOCTYPE html><html><body><p>Slow down</p></body></html>"},
+		{"html template with the default message", http.StatusServiceUnavailable, "text/html", "text/html; charset=utf-8", "<h1>503: " + defaultErrorMessages[http.StatusServiceUnavailable] + "</h1>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/form/f", nil)
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			writeErrorResponse(w, r, config, tt.status)
+			if w.Code != tt.status || w.Header().Get("Content-Type") != tt.wantType || w.Body.String() != tt.wantBody {
+				t.Errorf("got %d %q %q", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestFormAvailable(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name       string
+		enabled    *bool
+		wantOK     bool
+		wantStatus int
+	}{
+		{"enabled by default", nil, true, http.StatusOK},
+		{"enabled", &enabled, true, http.StatusOK},
+		{"disabled", &disabled, false, http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.Enabled = tt.enabled
+			config := testConfig(t, form)
+			w := httptest.NewRecorder()
+			ok := formAvailable(w, httptest.NewRequest(http.MethodPost, "/form/f/chat", nil), config, "f")
+			if ok != tt.wantOK || w.Code != tt.wantStatus {
+				t.Errorf("formAvailable = %v with status %d, want %v, %d", ok, w.Code, tt.wantOK, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestFormRateLimits(t *testing.T) {
+	tests := []struct {
+		name        string
+		global      RateLimitConfig
+		form        RateLimitConfig
+		wantAllowed int
+	}{
+		{"no limits", RateLimitConfig{}, RateLimitConfig{}, 5},
+		{"global limit", RateLimitConfig{RequestsPerMinute: 1, Burst: 2}, RateLimitConfig{}, 2},
+		{"form limit replaces the global one", RateLimitConfig{RequestsPerMinute: 1, Burst: 2}, RateLimitConfig{RequestsPerMinute: 1, Burst: 3}, 3},
+		{"form limit without a global one", RateLimitConfig{}, RateLimitConfig{RequestsPerMinute: 1}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved, savedForms := chatRateLimiter, formRateLimiters
+			chatRateLimiter, formRateLimiters = nil, map[string]*rateLimiter{}
+			t.Cleanup(func() { chatRateLimiter, formRateLimiters = saved, savedForms })
+
+			form := testForm("f")
+			form.RateLimit = tt.form
+			config := testConfig(t, form)
+			config.RateLimit = tt.global
+			configureRateLimits(config)
+
+			allowed := 0
+			for i := 0; i < 5; i++ {
+				r := httptest.NewRequest(http.MethodPost, "/form/f/chat", nil)
+				w := httptest.NewRecorder()
+				if allowRate(w, r, config, "f") {
+					allowed++
+				} else if w.Code != http.StatusTooManyRequests {
+					t.Errorf("throttled with status %d", w.Code)
+				}
+			}
+			if allowed != tt.wantAllowed {
+				t.Errorf("allowed %d of 5, want %d", allowed, tt.wantAllowed)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		trusted   string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"header from an untrusted peer", "10.0.0.1", "203.0.113.7:5000", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"behind the proxy", "10.0.0.1", "10.0.0.1:5000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entry before the real client", "10.0.0.0/8", "10.0.0.1:5000", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.0/8", "10.0.0.1:5000", []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, "198.51.100.1"},
+		{"malformed hop", "10.0.0.1", "10.0.0.1:5000", []string{"not-an-ip"}, "10.0.0.1"},
+		{"no header", "10.0.0.1", "10.0.0.1:5000", nil, "10.0.0.1"},
+		{"ipv6 proxy", "::1", "[::1]:5000", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Configuration
+			config.Server.TrustedProxies = tt.trusted
+			r := httptest.NewRequest(http.MethodPost, "/form/f/chat", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := clientIP(r, config); got != tt.want {
+				t.Errorf("clientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientsBehindAProxyHaveTheirOwnBuckets(t *testing.T) {
+	saved, savedForms := chatRateLimiter, formRateLimiters
+	chatRateLimiter, formRateLimiters = nil, map[string]*rateLimiter{}
+	t.Cleanup(func() { chatRateLimiter, formRateLimiters = saved, savedForms })
+	config := testConfig(t, testForm("f"))
+	config.RateLimit = RateLimitConfig{RequestsPerMinute: 1, Burst: 1}
+	config.Server.TrustedProxies = "127.0.0.1"
+	configureRateLimits(config)
+
+	for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+		r := httptest.NewRequest(http.MethodPost, "/form/f/chat", nil)
+		r.RemoteAddr = "127.0.0.1:5000"
+		r.Header.Set("X-Forwarded-For", client)
+		if !allowRate(httptest.NewRecorder(), r, config, "f") {
+			t.Errorf("%s throttled by another client's requests", client)
+		}
+	}
+}
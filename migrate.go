@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+)
+
+// FieldMigrations upgrade records saved under an older version of a form's
+// fields when they are read back as context or for a resume
+type FieldMigrations struct {
+	Rename []FieldRename `xml:"rename"`
+	Drop   []FieldDrop   `xml:"drop"`
+	// Drop any field the form no longer declares
+	DropUnknown bool `xml:"drop_unknown"`
+}
+
+// FieldRename moves an old field's value to its new name
+type FieldRename struct {
+	From string `xml:"from,attr"`
+	To   string `xml:"to,attr"`
+}
+
+// FieldDrop removes a field that no longer exists
+type FieldDrop struct {
+	Field string `xml:"field,attr"`
+}
+
+// knownRecordField reports whether the form still produces a field: a
+// declared field, a computed field or a consent timestamp
+func knownRecordField(form ConfigurationForm, name string) bool {
+	if _, ok := formFieldByName(form, name); ok {
+		return true
+	}
+	for _, computed := range form.ComputedFields.Field {
+		if computed.Name == name {
+			return true
+		}
+	}
+	return isConsentTimestamp(form, name)
+}
+
+// migrateRecord applies the form's migrations to a decoded record in place,
+// reporting whether anything changed. Renames never overwrite a value that
+// is already present under the new name.
+func migrateRecord(form ConfigurationForm, record map[string]interface{}) bool {
+	changed := false
+	for _, rename := range form.Migrations.Rename {
+		value, ok := record[rename.From]
+		if !ok {
+			continue
+		}
+		if _, exists := record[rename.To]; !exists {
+			record[rename.To] = value
+		}
+		delete(record, rename.From)
+		changed = true
+	}
+	for _, drop := range form.Migrations.Drop {
+		if _, ok := record[drop.Field]; ok {
+			delete(record, drop.Field)
+			changed = true
+		}
+	}
+	if form.Migrations.DropUnknown {
+		for name := range record {
+			if !knownRecordField(form, name) {
+				delete(record, name)
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// migrateRecordJSON upgrades a record read from disk to the form's current fields
+func migrateRecordJSON(form ConfigurationForm, data string) string {
+	var record map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		return data
+	}
+	if !migrateRecord(form, record) {
+		return data
+	}
+	migrated, err := json.MarshalIndent(record, "", "    ")
+	if err != nil {
+		return data
+	}
+	log.Printf("🔀 MIGRATE [%s]: upgraded record to the current fields", form.Name)
+	return string(migrated)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMigrateRecord(t *testing.T) {
+	tests := []struct {
+		name        string
+		migrations  FieldMigrations
+		record      map[string]interface{}
+		want        map[string]interface{}
+		wantChanged bool
+	}{
+		{
+			"rename",
+			FieldMigrations{Rename: []FieldRename{{From: "Name", To: "FirstName"}}},
+			map[string]interface{}{"Name": "Ann", "License": "A1"},
+			map[string]interface{}{"FirstName": "Ann", "License": "A1"},
+			true,
+		},
+		{
+			"rename keeps a newer value",
+			FieldMigrations{Rename: []FieldRename{{From: "Name", To: "FirstName"}}},
+			map[string]interface{}{"Name": "Old", "FirstName": "Ann"},
+			map[string]interface{}{"FirstName": "Ann"},
+			true,
+		},
+		{
+			"drop",
+			FieldMigrations{Drop: []FieldDrop{{Field: "Fax"}}},
+			map[string]interface{}{"Fax": "123", "License": "A1"},
+			map[string]interface{}{"License": "A1"},
+			true,
+		},
+		{
+			"drop unknown keeps declared and consent fields",
+			FieldMigrations{DropUnknown: true},
+			map[string]interface{}{"License": "A1", "License_consented_at": "2024-01-01T00:00:00Z", "Pager": "1"},
+			map[string]interface{}{"License": "A1", "License_consented_at": "2024-01-01T00:00:00Z"},
+			true,
+		},
+		{
+			"already current",
+			FieldMigrations{Rename: []FieldRename{{From: "Name", To: "FirstName"}}},
+			map[string]interface{}{"FirstName": "Ann"},
+			map[string]interface{}{"FirstName": "Ann"},
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.ConsentFields = "License"
+			form.Migrations = tt.migrations
+			changed := migrateRecord(form, tt.record)
+			if changed != tt.wantChanged || !reflect.DeepEqual(tt.record, tt.want) {
+				t.Errorf("migrateRecord = %v, %v, want %v, %v", changed, tt.record, tt.wantChanged, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrateRecordJSONLeavesCurrentRecordsAlone(t *testing.T) {
+	form := testForm("f")
+	form.Migrations.Rename = []FieldRename{{From: "Name", To: "FirstName"}}
+	const current = `{"FirstName": "Ann"}`
+	if got := migrateRecordJSON(form, current); got != current {
+		t.Errorf("current record rewritten as %s", got)
+	}
+	if got := migrateRecordJSON(form, "not json"); got != "not json" {
+		t.Errorf("unreadable record rewritten as %s", got)
+	}
+}
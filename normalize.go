@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FieldNormalization sets the clean-up steps for one field, or for every field
+// of the form when it names none: <normalize field="Notes">trim</normalize>
+type FieldNormalization struct {
+	Field string `xml:"field,attr"`
+	Steps string `xml:",chardata"`
+}
+
+// Clean-up steps for captured values, applied in this order whichever order they are listed in
+var normalizeSteps = []string{"trim", "quotes", "spaces", "period"}
+
+// normalizeStepsFor is the list of steps for a field: its own, then the
+// form's, then the global normalize_values. "none" turns them off.
+func normalizeStepsFor(config Configuration, form ConfigurationForm, field string) []string {
+	steps, formSteps := "", ""
+	for _, n := range form.Normalize {
+		switch n.Field {
+		case field:
+			steps = n.Steps
+		case "":
+			formSteps = n.Steps
+		}
+	}
+	list := splitFieldList(firstNonEmpty(steps, formSteps, config.NormalizeValues))
+	if len(list) == 1 && list[0] == "none" {
+		return nil
+	}
+	return list
+}
+
+// cleanValue applies the field's clean-up steps to a value set by the model
+func cleanValue(config Configuration, form ConfigurationForm, field, value string) string {
+	steps := normalizeStepsFor(config, form, field)
+	if len(steps) == 0 {
+		return value
+	}
+	enabled := make(map[string]bool, len(steps))
+	for _, step := range steps {
+		enabled[step] = true
+	}
+	if enabled["trim"] {
+		value = strings.TrimSpace(value)
+	}
+	if enabled["quotes"] {
+		value = stripQuotes(strings.TrimSpace(value))
+	}
+	if enabled["spaces"] {
+		value = strings.Join(strings.Fields(value), " ")
+	}
+	if enabled["period"] {
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "."))
+	}
+	return value
+}
+
+// stripQuotes removes one pair of matching quotes around a value
+func stripQuotes(value string) string {
+	for _, pair := range [][2]string{{`"`, `"`}, {"'", "'"}, {"“", "”"}, {"‘", "’"}} {
+		if len(value) >= len(pair[0])+len(pair[1]) && strings.HasPrefix(value, pair[0]) && strings.HasSuffix(value, pair[1]) {
+			return value[len(pair[0]) : len(value)-len(pair[1])]
+		}
+	}
+	return value
+}
+
+// validateNormalizeSteps rejects unknown clean-up steps in a step list
+func validateNormalizeSteps(steps string) error {
+	list := splitFieldList(steps)
+	if len(list) == 1 && list[0] == "none" {
+		return nil
+	}
+	for _, step := range list {
+		known := false
+		for _, s := range normalizeSteps {
+			known = known || s == step
+		}
+		if !known {
+			return fmt.Errorf("unknown normalize step %q, expected %s or none", step, strings.Join(normalizeSteps, ", "))
+		}
+	}
+	return nil
+}
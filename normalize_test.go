@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeStepsFor(t *testing.T) {
+	form := ConfigurationForm{Normalize: []FieldNormalization{
+		{Steps: "trim, quotes"},
+		{Field: "Notes", Steps: "none"},
+		{Field: "City", Steps: "period"},
+	}}
+	config := Configuration{NormalizeValues: "spaces"}
+	tests := []struct {
+		form  ConfigurationForm
+		field string
+		want  []string
+	}{
+		{form, "City", []string{"period"}},
+		{form, "Notes", nil},
+		{form, "FirstName", []string{"trim", "quotes"}},
+		{ConfigurationForm{}, "FirstName", []string{"spaces"}},
+	}
+	for _, tt := range tests {
+		if got := normalizeStepsFor(config, tt.form, tt.field); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("normalizeStepsFor(%s) = %q, want %q", tt.field, got, tt.want)
+		}
+	}
+}
+
+func TestCleanValue(t *testing.T) {
+	tests := []struct {
+		steps string
+		value string
+		want  string
+	}{
+		{"", `  "Ann"  `, `  "Ann"  `},
+		{"trim", "  Ann  ", "Ann"},
+		{"quotes", ` "Ann" `, "Ann"},
+		{"quotes", "“Ann”", "Ann"},
+		{"quotes", `"Ann'`, `"Ann'`},
+		{"spaces", "New   York\tCity", "New York City"},
+		{"period", "New York. ", "New York"},
+		{"period, quotes, trim", ` "New York." `, "New York"},
+	}
+	for _, tt := range tests {
+		config := Configuration{NormalizeValues: tt.steps}
+		if got := cleanValue(config, ConfigurationForm{}, "City", tt.value); got != tt.want {
+			t.Errorf("cleanValue(%q) with %q = %q, want %q", tt.value, tt.steps, got, tt.want)
+		}
+	}
+}
+
+func TestValidateNormalizeSteps(t *testing.T) {
+	tests := []struct {
+		steps   string
+		wantErr bool
+	}{
+		{"", false},
+		{"none", false},
+		{"trim,quotes,spaces,period", false},
+		{"trim,lowercase", true},
+	}
+	for _, tt := range tests {
+		if err := validateNormalizeSteps(tt.steps); (err != nil) != tt.wantErr {
+			t.Errorf("validateNormalizeSteps(%q) = %v, want error %v", tt.steps, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSetValuesAreCleaned(t *testing.T) {
+	config := testConfig(t, testForm("f"))
+	config.NormalizeValues = "trim,quotes,period"
+	session := &ChatSession{FormData: map[string]string{}}
+	applyResponse(config, "f", session, "SET FirstName 'Ann.'")
+	if got := session.FormData["FirstName"]; got != "Ann" {
+		t.Errorf("FirstName = %q, want Ann", got)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// chatReply documents the body /chat answers with. Handlers build it as a
+// map, so only the fields that apply to a turn are present.
+type chatReply struct {
+	Message      string              `json:"message"`
+	Updates      map[string]string   `json:"updates"`
+	Greeting     string              `json:"greeting,omitempty"`
+	SubmissionID string              `json:"submission_id,omitempty"`
+	Suggestions  map[string][]string `json:"suggestions,omitempty"`
+	QuickReplies []string            `json:"quick_replies,omitempty"`
+	Meta         *turnMeta           `json:"meta,omitempty"`
+	Saved        bool                `json:"saved,omitempty"`
+	Verification []string            `json:"verification,omitempty"`
+	Cancelled    bool                `json:"cancelled,omitempty"`
+	Throttled    bool                `json:"throttled,omitempty"`
+	Offline      bool                `json:"offline,omitempty"`
+	Degraded     bool                `json:"degraded,omitempty"`
+	Ended        bool                `json:"ended,omitempty"`
+	EndMessage   string              `json:"end_message,omitempty"`
+	Notice       string              `json:"notice,omitempty"`
+}
+
+// apiRoute describes one endpoint for /openapi.json. Request and response
+// bodies are given as Go values whose types the schemas are derived from.
+type apiRoute struct {
+	path     string
+	method   string
+	summary  string
+	query    []string
+	request  interface{}
+	response interface{}
+	// Response media type when it isn't JSON
+	produces string
+	admin    bool
+}
+
+// The routes registered in main, under their base path
+var apiRoutes = []apiRoute{
+	{path: "/form/{form}", method: "get", summary: "Chat form page", query: []string{"q", "prefill"}, produces: "text/html"},
+	{path: "/form/{form}/chat", method: "post", summary: "Send a message and get the reply", request: chatRequest{}, response: chatReply{}},
+	{path: "/form/{form}/chat/stream", method: "post", summary: "Send a message and stream the reply as server-sent events", request: chatRequest{}, produces: "text/event-stream"},
+	{path: "/form/{form}/chat/stream/resume", method: "get", summary: "Replay a dropped stream after Last-Event-ID", query: []string{"token", "last_event_id"}, produces: "text/event-stream"},
+	{path: "/form/{form}/chat/cancel", method: "post", summary: "Cancel the pending turn", response: map[string]bool{}},
+	{path: "/form/{form}/chat/reset", method: "post", summary: "Discard the session and start over", response: map[string]bool{}},
+	{path: "/form/{form}/upload", method: "post", summary: "Upload a file for a [file] field", query: []string{"field"}, response: uploadReply{}},
+	{path: "/form/{form}/share", method: "post", summary: "Create a read-only share link to the saved record", query: []string{"scope"}, response: shareReply{}},
+	{path: "/share/{token}", method: "get", summary: "Read-only view of a shared record", produces: "text/html"},
+	{path: "/share/{token}", method: "delete", summary: "Revoke a share link", response: map[string]bool{}},
+	{path: "/form/{form}/resume", method: "post", summary: "Start the caller's session from their saved record", query: []string{"key"}, response: map[string]bool{}},
+	{path: "/form/{form}/prefill", method: "post", summary: "Prefill a session from another system and get a link to it", request: map[string]string{}, response: prefillReply{}, admin: true},
+	{path: "/form/{form}/confirmation", method: "get", summary: "Printable confirmation of the saved submission", produces: "text/html"},
+	{path: "/qr/{form}", method: "get", summary: "QR code linking to the form", produces: "image/png"},
+	{path: "/healthz", method: "get", summary: "Health check", response: map[string]interface{}{}},
+	{path: "/sitemap.xml", method: "get", summary: "Sitemap of public forms", produces: "application/xml"},
+	{path: "/robots.txt", method: "get", summary: "Crawler rules", produces: "text/plain"},
+	{path: "/openapi.json", method: "get", summary: "This document", response: map[string]interface{}{}},
+	{path: "/admin/config", method: "get", summary: "Effective configuration, secrets redacted", query: []string{"format"}, response: configExport{}, admin: true},
+	{path: "/admin/conversations.jsonl", method: "get", summary: "Stored transcripts, one JSON object per line", query: []string{"form", "sample"}, response: conversationExport{}, produces: "application/x-ndjson", admin: true},
+	{path: "/admin/maintenance", method: "get", summary: "Whether maintenance mode is on", response: maintenanceState{}, admin: true},
+	{path: "/admin/maintenance", method: "post", summary: "Switch maintenance mode on or off", request: maintenanceState{}, response: maintenanceState{}, admin: true},
+}
+
+// jsonSchema derives a JSON schema from a Go type using its json tags
+func jsonSchema(t reflect.Type) map[string]interface{} {
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = jsonSchema(field.Type)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+// openAPIDocument describes the endpoints as an OpenAPI 3 document
+func openAPIDocument(config Configuration) map[string]interface{} {
+	var formNames []string
+	for _, form := range config.Forms.Form {
+		formNames = append(formNames, form.Name)
+	}
+
+	paths := make(map[string]interface{})
+	for _, route := range apiRoutes {
+		var parameters []interface{}
+		if strings.Contains(route.path, "{form}") {
+			parameters = append(parameters, map[string]interface{}{
+				"name": "form", "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string", "enum": formNames},
+			})
+		}
+		if strings.Contains(route.path, "{token}") {
+			parameters = append(parameters, map[string]interface{}{
+				"name": "token", "in": "path", "required": true,
+				"schema": map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, name := range route.query {
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "query", "schema": map[string]interface{}{"type": "string"},
+			})
+		}
+
+		content := map[string]interface{}{}
+		mediaType := "application/json"
+		if route.produces != "" {
+			mediaType = route.produces
+		}
+		if route.response != nil {
+			content[mediaType] = map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(route.response))}
+		} else {
+			content[mediaType] = map[string]interface{}{}
+		}
+		operation := map[string]interface{}{
+			"summary":   route.summary,
+			"responses": map[string]interface{}{"200": map[string]interface{}{"description": "OK", "content": content}},
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		if route.request != nil {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content": map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": jsonSchema(reflect.TypeOf(route.request))},
+				},
+			}
+		}
+		if route.admin {
+			operation["security"] = []interface{}{map[string]interface{}{"adminToken": []string{}}}
+		}
+
+		item, _ := paths[route.path].(map[string]interface{})
+		if item == nil {
+			item = make(map[string]interface{})
+			paths[route.path] = item
+		}
+		item[route.method] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   firstNonEmpty(config.SiteTitle, "gochat"),
+			"version": "1.0.0",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": strings.TrimRight(config.BaseURL, "/") + config.Path("")}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"adminToken": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+// handleOpenAPI serves the OpenAPI description of the endpoints
+func handleOpenAPI(w http.ResponseWriter, r *http.Request, config Configuration) {
+	if !allowMethods(w, r, config, http.MethodGet, http.MethodHead) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(openAPIDocument(config))
+}
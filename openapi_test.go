@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestJSONSchema(t *testing.T) {
+	type example struct {
+		Name    string   `json:"name"`
+		Count   int      `json:"count,omitempty"`
+		Tags    []string `json:"tags"`
+		Skipped string   `json:"-"`
+		hidden  string
+		Extra   map[string]bool `json:"extra,omitempty"`
+		At      *time.Time      `json:"at,omitempty"`
+	}
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"string", "", `{"type":"string"}`},
+		{"integer", 0, `{"type":"integer"}`},
+		{"number", 0.5, `{"type":"number"}`},
+		{"time", time.Time{}, `{"format":"date-time","type":"string"}`},
+		{"map", map[string]int{}, `{"additionalProperties":{"type":"integer"},"type":"object"}`},
+		{"struct", example{}, `{"properties":{"at":{"format":"date-time","type":"string"},"count":{"type":"integer"},` +
+			`"extra":{"additionalProperties":{"type":"boolean"},"type":"object"},` +
+			`"name":{"type":"string"},"tags":{"items":{"type":"string"},"type":"array"}},"required":["name","tags"],"type":"object"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := json.Marshal(jsonSchema(reflect.TypeOf(tt.value)))
+			if string(got) != tt.want {
+				t.Errorf("jsonSchema = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	config := testConfig(t, testForm("a"), testForm("b"))
+	config.BaseURL = "https://forms.example.com/"
+	config.BasePath = "/intake"
+	w := httptest.NewRecorder()
+	handleOpenAPI(w, httptest.NewRequest(http.MethodGet, "/openapi.json", nil), config)
+
+	var doc struct {
+		Servers []struct{ URL string }
+		Paths   map[string]map[string]struct {
+			Parameters []struct {
+				Name   string
+				Schema struct{ Enum []string }
+			}
+			RequestBody map[string]interface{}
+			Security    []interface{}
+		}
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "https://forms.example.com/intake" {
+		t.Errorf("servers %+v", doc.Servers)
+	}
+	tests := []struct {
+		path      string
+		method    string
+		wantForms []string
+		wantBody  bool
+		wantAdmin bool
+	}{
+		{"/form/{form}/chat", "post", []string{"a", "b"}, true, false},
+		{"/healthz", "get", nil, false, false},
+		{"/admin/maintenance", "post", nil, true, true},
+	}
+	for _, tt := range tests {
+		operation, ok := doc.Paths[tt.path][tt.method]
+		if !ok {
+			t.Errorf("%s %s is not described", tt.method, tt.path)
+			continue
+		}
+		var forms []string
+		for _, p := range operation.Parameters {
+			if p.Name == "form" {
+				forms = p.Schema.Enum
+			}
+		}
+		if !reflect.DeepEqual(forms, tt.wantForms) || (operation.RequestBody != nil) != tt.wantBody || (operation.Security != nil) != tt.wantAdmin {
+			t.Errorf("%s %s: %+v", tt.method, tt.path, operation)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variable holding the token that unlocks per-request model overrides
+const debugTokenEnv = "GOCHAT_DEBUG_TOKEN"
+
+// Headers read by applyModelOverrides
+const (
+	debugTokenHeader  = "X-GoChat-Debug-Token"
+	modelHeader       = "X-GoChat-Model"
+	temperatureHeader = "X-GoChat-Temperature"
+)
+
+// applyModelOverrides returns config with the model and temperature taken from
+// the request's X-GoChat-Model and X-GoChat-Temperature headers, for trying
+// settings on one request without a config change. The headers are only
+// honored with the GOCHAT_DEBUG_TOKEN in X-GoChat-Debug-Token; otherwise, and
+// for a model outside allowed_models or a temperature outside 0-2, they are
+// ignored and logged.
+func applyModelOverrides(config Configuration, formName string, r *http.Request) Configuration {
+	model := strings.TrimSpace(r.Header.Get(modelHeader))
+	temperature := strings.TrimSpace(r.Header.Get(temperatureHeader))
+	if model == "" && temperature == "" {
+		return config
+	}
+	token := os.Getenv(debugTokenEnv)
+	given := r.Header.Get(debugTokenHeader)
+	if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		log.Printf("🔐 OVERRIDE [%s]: ignoring model override headers from %s without the debug token", formName, r.RemoteAddr)
+		return config
+	}
+	if model != "" {
+		if config.modelAllowed(model) {
+			config.Model = model
+		} else {
+			log.Printf("⚠️ OVERRIDE [%s]: ignoring model %q, which is not in allowed_models", formName, model)
+		}
+	}
+	if temperature != "" {
+		if t, err := strconv.ParseFloat(temperature, 64); err == nil && t >= 0 && t <= 2 {
+			config.Temperature = &t
+			config.temperatureOverridden = true
+		} else {
+			log.Printf("⚠️ OVERRIDE [%s]: ignoring temperature %q", formName, temperature)
+		}
+	}
+	if config.Temperature != nil {
+		log.Printf("🧪 OVERRIDE [%s]: model %s, temperature %g for this request", formName, config.Model, *config.Temperature)
+	} else {
+		log.Printf("🧪 OVERRIDE [%s]: model %s for this request", formName, config.Model)
+	}
+	return config
+}
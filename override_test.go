@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestModelOverrides(t *testing.T) {
+	tests := []struct {
+		name            string
+		token           string
+		model           string
+		temperature     string
+		wantModel       string
+		wantTemperature interface{}
+	}{
+		{"no headers", "letmein", "", "", "gpt-test", nil},
+		{"without the token", "", "gpt-4o", "0.2", "gpt-test", nil},
+		{"wrong token", "guess", "gpt-4o", "0.2", "gpt-test", nil},
+		{"model and temperature", "letmein", "gpt-4o", "0.2", "gpt-4o", 0.2},
+		{"model outside allowed_models", "letmein", "gpt-9", "", "gpt-test", nil},
+		{"temperature out of range", "letmein", "", "2.5", "gpt-test", nil},
+		{"temperature that isn't a number", "letmein", "", "warm", "gpt-test", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(debugTokenEnv, "letmein")
+			fake := fakeChat(t, "SAY Hello")
+			config := testConfig(t, testForm("f"))
+			config.AllowedModels = "gpt-test, gpt-4o"
+
+			r := postJSON("/form/f/chat", `{"message": "hi"}`)
+			r.Header.Set(debugTokenHeader, tt.token)
+			r.Header.Set(modelHeader, tt.model)
+			r.Header.Set(temperatureHeader, tt.temperature)
+			handleChat(httptest.NewRecorder(), r, config, "f")
+			if fake.calls() != 1 {
+				t.Fatalf("%d AI calls, want 1", fake.calls())
+			}
+			request := fake.requests[0]
+			if request["model"] != tt.wantModel || request["temperature"] != tt.wantTemperature {
+				t.Errorf("requested model %v, temperature %v, want %s, %v", request["model"], request["temperature"], tt.wantModel, tt.wantTemperature)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+)
+
+// phoneRegion describes how national phone numbers are written in a region
+type phoneRegion struct {
+	CallingCode string
+	TrunkPrefix string // dropped from national numbers before adding the calling code
+	MinDigits   int    // national significant number length bounds
+	MaxDigits   int
+}
+
+var phoneRegions = map[string]phoneRegion{
+	"US": {"1", "1", 10, 10},
+	"CA": {"1", "1", 10, 10},
+	"MX": {"52", "", 10, 10},
+	"GB": {"44", "0", 9, 10},
+	"IE": {"353", "0", 7, 9},
+	"DE": {"49", "0", 6, 13},
+	"FR": {"33", "0", 9, 9},
+	"ES": {"34", "", 9, 9},
+	"IT": {"39", "", 6, 11},
+	"NL": {"31", "0", 9, 9},
+	"BR": {"55", "0", 10, 11},
+	"IN": {"91", "0", 10, 10},
+	"CN": {"86", "0", 7, 11},
+	"JP": {"81", "0", 9, 10},
+	"PH": {"63", "0", 8, 10},
+	"AU": {"61", "0", 9, 9},
+	"NZ": {"64", "0", 8, 10},
+}
+
+// Region used when neither the form nor the configuration sets one
+const defaultPhoneRegion = "US"
+
+func validRegion(region string) bool {
+	_, ok := phoneRegions[strings.ToUpper(region)]
+	return ok
+}
+
+// normalizePhone converts a phone number to E.164, reading numbers without an
+// international prefix as local to region. It reports false if the number
+// can't be interpreted, in which case the caller should keep the original.
+func normalizePhone(value, region string) (string, bool) {
+	value = strings.TrimSpace(value)
+	international := strings.HasPrefix(value, "+")
+
+	var digits strings.Builder
+	for _, r := range value {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+	number := digits.String()
+
+	if !international && strings.HasPrefix(number, "00") {
+		international = true
+		number = strings.TrimPrefix(number, "00")
+	}
+
+	if international {
+		if len(number) < 8 || len(number) > 15 {
+			return "", false
+		}
+		return "+" + number, true
+	}
+
+	info, ok := phoneRegions[strings.ToUpper(region)]
+	if !ok {
+		return "", false
+	}
+	// A "0" trunk prefix never starts a national number, but NANP's "1" only counts on an 11 digit number
+	if info.TrunkPrefix != "" && strings.HasPrefix(number, info.TrunkPrefix) &&
+		(info.TrunkPrefix == "0" || len(number) > info.MaxDigits) {
+		number = strings.TrimPrefix(number, info.TrunkPrefix)
+	}
+	if len(number) < info.MinDigits || len(number) > info.MaxDigits {
+		return "", false
+	}
+	return "+" + info.CallingCode + number, true
+}
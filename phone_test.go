@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		value  string
+		region string
+		want   string
+		wantOK bool
+	}{
+		{"(555) 123-4567", "US", "+15551234567", true},
+		{"1-555-123-4567", "US", "+15551234567", true},
+		{"020 7946 0958", "GB", "+442079460958", true},
+		{"+44 20 7946 0958", "US", "+442079460958", true},
+		{"0044 20 7946 0958", "US", "+442079460958", true},
+		{"030 123456", "de", "+4930123456", true},
+		{"12345", "US", "", false},
+		{"555 123 4567", "ZZ", "", false},
+		{"+1 23", "US", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := normalizePhone(tt.value, tt.region)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("normalizePhone(%q, %q) = %q, %v, want %q, %v", tt.value, tt.region, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PipelineStep is one action run after a form is saved. Steps run in order,
+// and each sees the results of the steps before it.
+type PipelineStep struct {
+	// "pdf", "email" or "webhook"
+	Type string `xml:"type,attr"`
+	// Key the step's result is kept under, for later steps (defaults to the type)
+	Name string `xml:"name,attr"`
+	// A failed fatal step stops the remaining steps and the user is told; the
+	// record stays saved. Other failures are only logged.
+	Fatal bool `xml:"fatal,attr"`
+	// webhook: where the record and results are POSTed
+	URL string `xml:"url"`
+	// email: recipient and subject, templates over the form data
+	To      string `xml:"to"`
+	Subject string `xml:"subject"`
+	// email: the earlier step whose file is attached, e.g. a pdf step
+	Attach string `xml:"attach"`
+}
+
+// Key is the name the step's result is stored under
+func (s PipelineStep) Key() string {
+	return firstNonEmpty(s.Name, s.Type)
+}
+
+// SMTPConfig is the mail server used by email pipeline steps. The password is
+// read from GOCHAT_SMTP_PASSWORD so it stays out of the configuration file.
+type SMTPConfig struct {
+	Addr     string `xml:"addr"`
+	From     string `xml:"from"`
+	Username string `xml:"username"`
+}
+
+const smtpPasswordEnv = "GOCHAT_SMTP_PASSWORD"
+
+// pipelineFile is a document produced by a step
+type pipelineFile struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Path        string `json:"path,omitempty"`
+	Data        []byte `json:"-"`
+}
+
+// pipelineRun is what the steps share: the saved values and the result of
+// each step so far, by step key
+type pipelineRun struct {
+	config  Configuration
+	form    ConfigurationForm
+	data    map[string]string
+	record  string
+	results map[string]interface{}
+}
+
+// pipelineStepFunc runs one step, returning its result
+type pipelineStepFunc func(run *pipelineRun, step PipelineStep) (interface{}, error)
+
+// Built-in step types
+var pipelineSteps = map[string]pipelineStepFunc{
+	"pdf":     pdfStep,
+	"email":   emailStep,
+	"webhook": webhookStep,
+}
+
+var errPipelineFailed = errors.New("post-save step failed")
+
+// runPipeline runs the form's post-save steps for the record saved at
+// filename, returning errPipelineFailed when a fatal step fails. The record
+// is already written and delivered by then, so the caller reports the failure
+// without treating the save as failed.
+func runPipeline(config Configuration, form ConfigurationForm, filename string, formData map[string]string, submissionID string) error {
+	if len(form.Pipeline.Step) == 0 {
+		return nil
+	}
+	run := &pipelineRun{
+		config:  config,
+		form:    form,
+		data:    make(map[string]string, len(formData)+1),
+		record:  filename,
+		results: make(map[string]interface{}),
+	}
+	for k, v := range formData {
+		run.data[k] = v
+	}
+	run.data["_submission_id"] = submissionID
+
+	for _, step := range form.Pipeline.Step {
+		result, err := pipelineSteps[step.Type](run, step)
+		if err != nil {
+			log.Printf("❌ PIPELINE [%s]: step %s failed: %v", form.Name, step.Key(), err)
+			if step.Fatal {
+				return fmt.Errorf("%w: %s: %v", errPipelineFailed, step.Key(), err)
+			}
+			continue
+		}
+		run.results[step.Key()] = result
+		log.Printf("⛓️ PIPELINE [%s]: step %s done", form.Name, step.Key())
+	}
+	return nil
+}
+
+// pdfStep renders the saved values as a one-page PDF next to the record
+func pdfStep(run *pipelineRun, step PipelineStep) (interface{}, error) {
+	lines := []string{run.config.BrandingFor(run.form).SiteTitle, "Submission " + run.data["_submission_id"], ""}
+	for _, field := range formFields(run.form) {
+		lines = append(lines, fmt.Sprintf("%s: %s", field.Label, run.data[field.Name]))
+	}
+	file := pipelineFile{
+		Name:        strings.TrimSuffix(filepath.Base(run.record), filepath.Ext(run.record)) + ".pdf",
+		ContentType: "application/pdf",
+		Data:        textPDF(lines),
+	}
+	file.Path = filepath.Join(filepath.Dir(run.record), file.Name)
+	if err := os.WriteFile(file.Path, file.Data, 0644); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// pdfEscape escapes a string for a PDF literal, dropping characters the
+// standard fonts cannot show
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		default:
+			b.WriteRune('?')
+		}
+	}
+	return b.String()
+}
+
+// textPDF builds a minimal single-page PDF showing lines of text
+func textPDF(lines []string) []byte {
+	var content bytes.Buffer
+	content.WriteString("BT /F1 12 Tf 16 TL 50 780 Td\n")
+	for _, line := range lines {
+		fmt.Fprintf(&content, "(%s) Tj T*\n", pdfEscape(line))
+	}
+	content.WriteString("ET")
+
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 842] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+// Sends mail through the configured server; replaced in tests
+var sendMail = smtp.SendMail
+
+// emailStep mails the saved values, attaching the file of an earlier step
+func emailStep(run *pipelineRun, step PipelineStep) (interface{}, error) {
+	smtpConfig := run.config.SMTP
+	if smtpConfig.Addr == "" || smtpConfig.From == "" {
+		return nil, fmt.Errorf("smtp addr and from are not configured")
+	}
+	// Both come from form data, which the user and the model control, so a
+	// line break must not be able to start a header of its own
+	to, _ := renderFormDataTemplate(run.config, run.form, "email to", step.To, run.data)
+	if strings.TrimSpace(to) == "" {
+		return nil, fmt.Errorf("no recipient")
+	}
+	if strings.ContainsAny(to, "\r\n") {
+		return nil, fmt.Errorf("recipient contains a line break")
+	}
+	recipients, err := mail.ParseAddressList(to)
+	if err != nil {
+		return nil, fmt.Errorf("bad recipient %q: %v", to, err)
+	}
+	var addrs, toHeader []string
+	for _, recipient := range recipients {
+		addrs = append(addrs, recipient.Address)
+		toHeader = append(toHeader, recipient.String())
+	}
+	subject, ok := renderFormDataTemplate(run.config, run.form, "email subject", step.Subject, run.data)
+	if !ok {
+		subject = run.form.Name + " submission " + run.data["_submission_id"]
+	}
+	subject = mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(subject), " "))
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		smtpConfig.From, strings.Join(toHeader, ", "), subject, mw.Boundary())
+	text, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	for _, field := range formFields(run.form) {
+		fmt.Fprintf(text, "%s: %s\r\n", field.Label, run.data[field.Name])
+	}
+	if step.Attach != "" {
+		file, ok := run.results[step.Attach].(pipelineFile)
+		if !ok {
+			return nil, fmt.Errorf("step %q left no file to attach", step.Attach)
+		}
+		part, _ := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {file.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", file.Name)},
+		})
+		encoded := base64.StdEncoding.EncodeToString(file.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+	mw.Close()
+
+	var auth smtp.Auth
+	if smtpConfig.Username != "" {
+		host := strings.Split(smtpConfig.Addr, ":")[0]
+		auth = smtp.PlainAuth("", smtpConfig.Username, os.Getenv(smtpPasswordEnv), host)
+	}
+	if err := sendMail(smtpConfig.Addr, auth, smtpConfig.From, addrs, body.Bytes()); err != nil {
+		return nil, err
+	}
+	return map[string]string{"to": strings.Join(addrs, ", "), "subject": subject}, nil
+}
+
+// webhookStep POSTs the record and the results so far as JSON
+func webhookStep(run *pipelineRun, step PipelineStep) (interface{}, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"form":     run.form.Name,
+		"data":     run.data,
+		"results":  run.results,
+		"saved_at": time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := sinkClient.Post(strings.TrimSpace(step.URL), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("webhook %s returned %s", step.URL, resp.Status)
+	}
+	return map[string]int{"status": resp.StatusCode}, nil
+}
+
+// validatePipeline checks each step's type and settings
+func validatePipeline(config Configuration, form ConfigurationForm) error {
+	seen := make(map[string]bool)
+	for _, step := range form.Pipeline.Step {
+		if _, ok := pipelineSteps[step.Type]; !ok {
+			return fmt.Errorf("pipeline step %q: unknown type %q", step.Key(), step.Type)
+		}
+		switch {
+		case step.Type == "webhook" && strings.TrimSpace(step.URL) == "":
+			return fmt.Errorf("pipeline step %q: webhook needs a url", step.Key())
+		case step.Type == "email" && strings.TrimSpace(step.To) == "":
+			return fmt.Errorf("pipeline step %q: email needs a to address", step.Key())
+		case step.Type == "email" && (config.SMTP.Addr == "" || config.SMTP.From == ""):
+			return fmt.Errorf("pipeline step %q: email needs smtp addr and from", step.Key())
+		case step.Attach != "" && !seen[step.Attach]:
+			return fmt.Errorf("pipeline step %q: attaches %q, which is not an earlier step", step.Key(), step.Attach)
+		}
+		seen[step.Key()] = true
+	}
+	return nil
+}
+
+// Shown when the record was written but a fatal pipeline step failed
+const pipelineErrorMessage = "Your form was saved, but a follow-up step failed"
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sentMail is one message handed to sendMail
+type sentMail struct {
+	addr string
+	from string
+	to   []string
+	msg  string
+}
+
+// useSendMail records mail instead of sending it, failing with err, for the rest of the test
+func useSendMail(t *testing.T, err error) *[]sentMail {
+	var sent []sentMail
+	saved := sendMail
+	t.Cleanup(func() { sendMail = saved })
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, sentMail{addr, from, to, string(msg)})
+		return err
+	}
+	return &sent
+}
+
+// pipelineForm is testForm with an Email field and the given steps
+func pipelineForm(steps ...PipelineStep) ConfigurationForm {
+	form := testForm("f")
+	form.Fields += "\nEmail: {{.Email}}"
+	form.Pipeline.Step = steps
+	return form
+}
+
+func TestEmailStep(t *testing.T) {
+	tests := []struct {
+		name        string
+		email       string
+		subject     string
+		wantErr     bool
+		wantTo      []string
+		wantSubject string
+	}{
+		{"sent", "Ann <ann@example.com>", "Thanks {{.FirstName}}", false, []string{"ann@example.com"}, "Subject: Thanks Ann\r\n"},
+		{"several recipients", "ann@example.com, bo@example.com", "", false, []string{"ann@example.com", "bo@example.com"}, "Subject: f submission S1\r\n"},
+		{"encoded subject", "ann@example.com", "Résumé for\n{{.FirstName}}", false, []string{"ann@example.com"}, "Subject: =?utf-8?q?R=C3=A9sum=C3=A9_for_Ann?=\r\n"},
+		{"subject cannot add headers", "ann@example.com", "Hi\r\nBcc: evil@example.com", false, []string{"ann@example.com"}, "Subject: Hi Bcc: evil@example.com\r\n"},
+		{"recipient cannot add headers", "ann@example.com\r\nBcc: evil@example.com", "", true, nil, ""},
+		{"no recipient", "", "", true, nil, ""},
+		{"bad recipient", "not an address", "", true, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := useSendMail(t, nil)
+			config := testConfig(t)
+			config.SMTP = SMTPConfig{Addr: "smtp.example.com:587", From: "forms@example.com"}
+			run := &pipelineRun{
+				config: config,
+				form:   pipelineForm(),
+				data:   map[string]string{"FirstName": "Ann", "Email": tt.email, "_submission_id": "S1"},
+			}
+			_, err := emailStep(run, PipelineStep{Type: "email", To: "{{.Email}}", Subject: tt.subject})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("emailStep = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if len(*sent) != 0 {
+					t.Errorf("sent %d messages", len(*sent))
+				}
+				return
+			}
+			if len(*sent) != 1 {
+				t.Fatalf("sent %d messages, want 1", len(*sent))
+			}
+			mail := (*sent)[0]
+			if !reflect.DeepEqual(mail.to, tt.wantTo) || mail.from != "forms@example.com" || !strings.Contains(mail.msg, tt.wantSubject) {
+				t.Errorf("sent to %v from %s:\n%s", mail.to, mail.from, mail.msg)
+			}
+			if strings.Contains(mail.msg, "\r\nBcc:") || !strings.Contains(mail.msg, "FirstName: Ann\r\n") {
+				t.Errorf("message:\n%s", mail.msg)
+			}
+		})
+	}
+}
+
+func TestRunPipeline(t *testing.T) {
+	var posted map[string]interface{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&posted)
+	}))
+	defer webhook.Close()
+
+	pdf := PipelineStep{Type: "pdf"}
+	email := PipelineStep{Type: "email", To: "{{.Email}}", Attach: "pdf"}
+	hook := PipelineStep{Type: "webhook", URL: webhook.URL}
+	tests := []struct {
+		name        string
+		steps       []PipelineStep
+		mailErr     error
+		wantErr     error
+		wantMail    bool
+		wantResults []string
+	}{
+		{"all steps", []PipelineStep{pdf, email, hook}, nil, nil, true, []string{"email", "pdf"}},
+		{"failed step carries on", []PipelineStep{pdf, email, hook}, errors.New("smtp down"), nil, true, []string{"pdf"}},
+		{"failed fatal step stops", []PipelineStep{pdf, {Type: "email", To: "{{.Email}}", Attach: "pdf", Fatal: true}, hook}, errors.New("smtp down"), errPipelineFailed, true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			posted = nil
+			sent := useSendMail(t, tt.mailErr)
+			config := testConfig(t)
+			config.SMTP = SMTPConfig{Addr: "smtp.example.com:587", From: "forms@example.com"}
+			filename := filepath.Join(t.TempDir(), "A1.json")
+			data := map[string]string{"FirstName": "Ann", "License": "A1", "Email": "ann@example.com"}
+
+			err := runPipeline(config, pipelineForm(tt.steps...), filename, data, "S1")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("runPipeline = %v, want %v", err, tt.wantErr)
+			}
+			if pdf, err := os.ReadFile(filepath.Join(filepath.Dir(filename), "A1.pdf")); err != nil || !strings.HasPrefix(string(pdf), "%PDF-") {
+				t.Errorf("pdf not written: %v", err)
+			}
+			if (len(*sent) == 1) != tt.wantMail || tt.wantMail && !strings.Contains((*sent)[0].msg, `filename="A1.pdf"`) {
+				t.Errorf("sent %+v", *sent)
+			}
+			if tt.wantResults == nil {
+				if posted != nil {
+					t.Errorf("webhook ran after a fatal failure: %v", posted)
+				}
+				return
+			}
+			var results []string
+			for key := range posted["results"].(map[string]interface{}) {
+				results = append(results, key)
+			}
+			sort.Strings(results)
+			if !reflect.DeepEqual(results, tt.wantResults) {
+				t.Errorf("webhook saw results %v, want %v", results, tt.wantResults)
+			}
+		})
+	}
+}
+
+func TestValidatePipeline(t *testing.T) {
+	smtpConfig := SMTPConfig{Addr: "smtp.example.com:587", From: "forms@example.com"}
+	tests := []struct {
+		name    string
+		smtp    SMTPConfig
+		steps   []PipelineStep
+		wantErr bool
+	}{
+		{"valid", smtpConfig, []PipelineStep{{Type: "pdf", Name: "receipt"}, {Type: "email", To: "{{.Email}}", Attach: "receipt"}}, false},
+		{"unknown type", smtpConfig, []PipelineStep{{Type: "fax"}}, true},
+		{"webhook without a url", smtpConfig, []PipelineStep{{Type: "webhook"}}, true},
+		{"email without a recipient", smtpConfig, []PipelineStep{{Type: "email"}}, true},
+		{"email without smtp", SMTPConfig{}, []PipelineStep{{Type: "email", To: "{{.Email}}"}}, true},
+		{"attaches a later step", smtpConfig, []PipelineStep{{Type: "email", To: "{{.Email}}", Attach: "pdf"}, {Type: "pdf"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{SMTP: tt.smtp}
+			if err := validatePipeline(config, pipelineForm(tt.steps...)); (err != nil) != tt.wantErr {
+				t.Errorf("validatePipeline = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Used when prefill_expiry is not configured
+const defaultPrefillTTL = 24 * time.Hour
+
+// PrefillTTL is how long a link returned by the inbound prefill endpoint can be opened
+func (c Configuration) PrefillTTL() time.Duration {
+	if d, err := time.ParseDuration(c.PrefillExpiry); err == nil && d > 0 {
+		return d
+	}
+	return defaultPrefillTTL
+}
+
+const prefillPrompt = `Another system has already collected some of this form's values. They are shown in the context above and are already set.
+Confirm them with the user, ask for the fields that are still missing, SET any the user corrects, and SAVE when the form is complete.`
+
+// pendingPrefill is form data posted by an external system, waiting for the
+// user to open the link it was given
+type pendingPrefill struct {
+	form      string
+	data      map[string]string
+	expiresAt time.Time
+}
+
+// Prefills not yet opened, by token
+var (
+	prefillsMu sync.Mutex
+	prefills   = make(map[string]pendingPrefill)
+)
+
+// prefillReply is the body returned to the external system
+type prefillReply struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Posted keys that are not fields of the form
+	Ignored []string `json:"ignored,omitempty"`
+}
+
+// handleInboundPrefill takes a JSON object of field values for the form and
+// answers with a link that opens a session already holding them
+func handleInboundPrefill(w http.ResponseWriter, r *http.Request, config Configuration, formName string) {
+	if !allowAdmin(w, r) {
+		return
+	}
+	limitBody(w, r, config)
+	var payload map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		http.Error(w, "Bad request: expected a JSON object of field values", http.StatusBadRequest)
+		return
+	}
+
+	form := config.FormByName(formName)
+	data := make(map[string]string, len(payload))
+	var ignored []string
+	for key, raw := range payload {
+		value, ok := contextValue(raw)
+		if _, known := formFieldByName(form, key); !known || !ok {
+			ignored = append(ignored, key)
+			continue
+		}
+		data[key] = normalizeFieldValue(config, form, key, value)
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b[:])
+	now := time.Now()
+	prefillsMu.Lock()
+	for t, p := range prefills {
+		if now.After(p.expiresAt) {
+			delete(prefills, t)
+		}
+	}
+	prefills[token] = pendingPrefill{form: formName, data: data, expiresAt: now.Add(config.PrefillTTL())}
+	prefillsMu.Unlock()
+	log.Printf("📥 PREFILL [%s]: %d fields received, %d ignored", formName, len(data), len(ignored))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(prefillReply{
+		URL:       config.FormURL(formName) + "?prefill=" + token,
+		Token:     token,
+		ExpiresAt: now.Add(config.PrefillTTL()).UTC(),
+		Ignored:   ignored,
+	})
+}
+
+// takePrefill claims the form's pending prefill with the given token; each
+// link opens one session
+func takePrefill(formName, token string) (map[string]string, bool) {
+	prefillsMu.Lock()
+	defer prefillsMu.Unlock()
+	p, ok := prefills[token]
+	if !ok || p.form != formName {
+		return nil, false
+	}
+	delete(prefills, token)
+	if time.Now().After(p.expiresAt) {
+		return nil, false
+	}
+	return p.data, true
+}
+
+// openPrefill replaces the caller's session of the form with one holding the
+// prefill named by ?prefill=, returning the prefilled values as JSON for the page
+func openPrefill(w http.ResponseWriter, config Configuration, formName string, r *http.Request) (string, bool) {
+	token := r.URL.Query().Get("prefill")
+	if token == "" {
+		return "", false
+	}
+	data, ok := takePrefill(formName, token)
+	if !ok {
+		log.Printf("⚠️ PREFILL [%s]: unknown or expired token", formName)
+		return "", false
+	}
+	record, _ := json.Marshal(data)
+	session := &ChatSession{
+		Messages: []ChatMessage{
+			{Role: "system", Content: buildSystemPrompt(config, config.FormByName(formName), string(record))},
+			{Role: "system", Content: prefillPrompt},
+		},
+		FormData:   make(map[string]string),
+		Locale:     requestLocale(r),
+		CreatedAt:  time.Now(),
+		LastActive: time.Now(),
+	}
+	session.prefill(string(record), "inbound prefill")
+	replaceSession(w, r, config, formName, session)
+	log.Printf("📥 PREFILL [%s]: new session with %d fields", formName, len(data))
+	return string(record), true
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInboundPrefill(t *testing.T) {
+	t.Setenv(adminTokenEnv, "letmein")
+	config := testConfig(t, testForm("f"))
+	config.BaseURL = "https://forms.example.com"
+	tests := []struct {
+		name        string
+		auth        string
+		body        string
+		wantStatus  int
+		wantData    map[string]string
+		wantIgnored []string
+	}{
+		{"no token", "", `{"FirstName": "Ann"}`, http.StatusUnauthorized, nil, nil},
+		{"not an object", "Bearer letmein", `["Ann"]`, http.StatusBadRequest, nil, nil},
+		{"fields", "Bearer letmein", `{"FirstName": "Ann", "License": "A1"}`, http.StatusOK, map[string]string{"FirstName": "Ann", "License": "A1"}, nil},
+		{"unknown keys", "Bearer letmein", `{"FirstName": "Ann", "Shoe": "9"}`, http.StatusOK, map[string]string{"FirstName": "Ann"}, []string{"Shoe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := postJSON("/form/f/prefill", tt.body)
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			w := httptest.NewRecorder()
+			handleInboundPrefill(w, r, config, "f")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var reply prefillReply
+			json.Unmarshal(w.Body.Bytes(), &reply)
+			if reply.URL != "https://forms.example.com/form/f?prefill="+reply.Token || !reflect.DeepEqual(reply.Ignored, tt.wantIgnored) {
+				t.Errorf("reply %+v", reply)
+			}
+			data, ok := takePrefill("f", reply.Token)
+			if !ok || !reflect.DeepEqual(data, tt.wantData) {
+				t.Errorf("pending prefill %v, want %v", data, tt.wantData)
+			}
+		})
+	}
+}
+
+func TestOpenPrefill(t *testing.T) {
+	config := testConfig(t, testForm("f"), testForm("g"))
+	add := func(form string, expiresAt time.Time) string {
+		token := newClientID()
+		prefillsMu.Lock()
+		prefills[token] = pendingPrefill{form: form, data: map[string]string{"FirstName": "Ann"}, expiresAt: expiresAt}
+		prefillsMu.Unlock()
+		return token
+	}
+	used := add("f", time.Now().Add(time.Hour))
+	takePrefill("f", used)
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"pending", add("f", time.Now().Add(time.Hour)), true},
+		{"already opened", used, false},
+		{"another form's", add("g", time.Now().Add(time.Hour)), false},
+		{"expired", add("f", time.Now().Add(-time.Minute)), false},
+		{"no token", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/form/f?prefill="+tt.token, nil)
+			r.Header.Set(sessionIDHeader, newClientID())
+			record, ok := openPrefill(httptest.NewRecorder(), config, "f", r)
+			if ok != tt.want {
+				t.Fatalf("openPrefill = %q, %v, want %v", record, ok, tt.want)
+			}
+			session := callerSession(config, "f", r)
+			if got := session != nil && session.FormData["FirstName"] == "Ann"; got != tt.want {
+				t.Errorf("session %+v, want prefilled %v", session, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PromptSnippet is a reusable block of instructions that forms can include
+type PromptSnippet struct {
+	Name string `xml:"name,attr"`
+	Text string `xml:",chardata"`
+}
+
+func (c Configuration) snippetByName(name string) (PromptSnippet, bool) {
+	for _, snippet := range c.PromptSnippets.Snippet {
+		if snippet.Name == name {
+			return snippet, true
+		}
+	}
+	return PromptSnippet{}, false
+}
+
+const suggestPrompt = "To offer the user choices for a field, send a line like: SUGGEST fieldName option1|option2|option3\n" +
+	"Suggestions are only shown to the user; SET the field once they pick one."
+
+const quickRepliesPrompt = "To offer the user replies they can send with one tap, send a line like: QUICKREPLIES Yes|No|Tell me more\n" +
+	"Use it for short, likely answers to your question. A tapped reply arrives as the user's next message."
+
+// buildSystemPrompt assembles a session's system message: the form's prompt
+// filled with the global prompt, fields and context, followed by the form's
+// included snippets in the order listed.
+func buildSystemPrompt(config Configuration, form ConfigurationForm, contextData string) string {
+	parts := []string{
+		fmt.Sprintf(form.Prompt, config.SystemPrompt, form.Fields, contextData),
+	}
+	if config.Timezone != "" {
+		parts = append(parts, fmt.Sprintf("It is %s for the user; greet them accordingly.", localTimeOfDay(config)))
+	}
+	if form.Suggestions && !config.ChatOnly() {
+		parts = append(parts, suggestPrompt)
+	}
+	if form.SessionVars && !config.ChatOnly() {
+		parts = append(parts, varPrompt)
+	}
+	if form.QuickReplies && !config.ChatOnly() {
+		parts = append(parts, quickRepliesPrompt)
+	}
+	if examples, ok := fieldExamplesPrompt(form); ok {
+		parts = append(parts, examples)
+	}
+	if lists := listFieldNames(form); len(lists) > 0 {
+		parts = append(parts, fmt.Sprintf(
+			"These fields are lists that can hold several values: %s.\n"+
+				"Add one item at a time with a line like: APPEND %s value",
+			strings.Join(lists, ", "), lists[0],
+		))
+	}
+	if addresses, ok := addressPrompt(form); ok {
+		parts = append(parts, addresses)
+	}
+	if form.RequireReauth {
+		parts = append(parts, reauthPrompt(config, form))
+	}
+	if consent, ok := consentPrompt(form); ok {
+		parts = append(parts, consent)
+	}
+	if commands, ok := customCommandPrompt(form); ok {
+		parts = append(parts, commands)
+	}
+	for _, name := range splitFieldList(form.PromptIncludes) {
+		if snippet, ok := config.snippetByName(name); ok {
+			parts = append(parts, strings.TrimSpace(snippet.Text))
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// fieldExamplesPrompt lists the fields' examples as format hints, when the
+// form has prompt_examples and any field gives an example
+func fieldExamplesPrompt(form ConfigurationForm) (string, bool) {
+	if !form.PromptExamples {
+		return "", false
+	}
+	var lines []string
+	for _, field := range formFields(form) {
+		if field.Example != "" {
+			lines = append(lines, fmt.Sprintf("- %s: %s", field.Name, field.Example))
+		}
+	}
+	if len(lines) == 0 {
+		return "", false
+	}
+	return "Format examples. SET values in the same format as these examples:\n" + strings.Join(lines, "\n"), true
+}
+
+// ProtocolReminder re-states the command protocol every few user turns
+type ProtocolReminder struct {
+	EveryTurns int    `xml:"every_turns"`
+	Text       string `xml:"text"`
+}
+
+const defaultProtocolReminder = "Reminder: every line of your response must start with a command: SAY, SET or SAVE."
+
+// protocolReminder returns the reminder when the session is on a reminder turn
+func protocolReminder(config Configuration, session *ChatSession) (string, bool) {
+	every := config.ProtocolReminder.EveryTurns
+	if every <= 0 || config.ChatOnly() || session.Turns == 0 || session.Turns%every != 0 {
+		return "", false
+	}
+	if text := strings.TrimSpace(config.ProtocolReminder.Text); text != "" {
+		return text, true
+	}
+	return defaultProtocolReminder, true
+}
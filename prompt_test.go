@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildSystemPromptIncludesSnippetsInOrder(t *testing.T) {
+	var config Configuration
+	config.SystemPrompt = "GLOBAL"
+	config.PromptSnippets.Snippet = []PromptSnippet{
+		{Name: "tone", Text: "  Be brief.  "},
+		{Name: "privacy", Text: "Never ask for passwords."},
+	}
+	tests := []struct {
+		name     string
+		includes string
+		want     string
+	}{
+		{"none", "", "GLOBAL|FIELDS|CONTEXT"},
+		{"listed order", "privacy, tone", "GLOBAL|FIELDS|CONTEXT\n\nNever ask for passwords.\n\nBe brief."},
+		{"unknown names are skipped", "tone,missing", "GLOBAL|FIELDS|CONTEXT\n\nBe brief."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := ConfigurationForm{Name: "f", Prompt: "%s|%s|%s", Fields: "FIELDS", PromptIncludes: tt.includes}
+			if got := buildSystemPrompt(config, form, "CONTEXT"); got != tt.want {
+				t.Errorf("buildSystemPrompt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtocolReminder(t *testing.T) {
+	tests := []struct {
+		name  string
+		every int
+		text  string
+		turns int
+		want  string
+	}{
+		{"disabled", 0, "", 3, ""},
+		{"before the first turn", 3, "", 0, ""},
+		{"off turn", 3, "", 4, ""},
+		{"on turn with the default text", 3, "", 6, defaultProtocolReminder},
+		{"custom text", 2, "  Use commands.  ", 2, "Use commands."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Configuration
+			config.ProtocolReminder = ProtocolReminder{EveryTurns: tt.every, Text: tt.text}
+			got, ok := protocolReminder(config, &ChatSession{Turns: tt.turns})
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("protocolReminder = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutgoingMessagesDoesNotKeepTheReminder(t *testing.T) {
+	var config Configuration
+	config.ProtocolReminder.EveryTurns = 1
+	session := &ChatSession{FormData: map[string]string{}}
+	session.addUserMessage("hello")
+	messages := outgoingMessages(config, ConfigurationForm{}, session)
+	if len(messages) != 2 || messages[1].Content != defaultProtocolReminder {
+		t.Fatalf("outgoingMessages = %+v, want the history plus the reminder", messages)
+	}
+	if len(session.Messages) != 1 {
+		t.Errorf("session history = %+v, want only the user message", session.Messages)
+	}
+}
+
+func TestSuggestPromptOnlyForFormsThatOfferSuggestions(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        string
+		suggestions bool
+		want        bool
+	}{
+		{"enabled", "", true, true},
+		{"disabled", "", false, false},
+		{"chat mode", "chat", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{Mode: tt.mode}
+			form := ConfigurationForm{Name: "f", Prompt: "%s|%s|%s", Suggestions: tt.suggestions}
+			if got := strings.Contains(buildSystemPrompt(config, form, ""), suggestPrompt); got != tt.want {
+				t.Errorf("prompt includes SUGGEST instructions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldExamplesPrompt(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		fields  string
+		want    string
+	}{
+		{"disabled", false, testForm("f").Fields, ""},
+		{"examples", true, testForm("f").Fields, "Format examples. SET values in the same format as these examples:\n- FirstName: John\n- License: 555-55-5555"},
+		{"no field has an example", true, "FirstName: {{.FirstName}}", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := ConfigurationForm{Name: "f", Fields: tt.fields, PromptExamples: tt.enabled}
+			got, ok := fieldExamplesPrompt(form)
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("fieldExamplesPrompt = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestVarPromptOnlyForFormsWithSessionVars(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		enabled bool
+		want    bool
+	}{
+		{"enabled", "", true, true},
+		{"disabled", "", false, false},
+		{"chat mode", "chat", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{Mode: tt.mode}
+			form := ConfigurationForm{Name: "f", Prompt: "%s|%s|%s", SessionVars: tt.enabled}
+			if got := strings.Contains(buildSystemPrompt(config, form, ""), varPrompt); got != tt.want {
+				t.Errorf("prompt includes VAR instructions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuickRepliesPromptOnlyForFormsThatOfferThem(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		enabled bool
+		want    bool
+	}{
+		{"enabled", "", true, true},
+		{"disabled", "", false, false},
+		{"chat mode", "chat", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{Mode: tt.mode}
+			form := ConfigurationForm{Name: "f", Prompt: "%s|%s|%s", QuickReplies: tt.enabled}
+			if got := strings.Contains(buildSystemPrompt(config, form, ""), quickRepliesPrompt); got != tt.want {
+				t.Errorf("prompt includes QUICKREPLIES instructions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
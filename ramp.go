@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// TemperatureStep sets the temperature from a point in the conversation on:
+// from user turn Turn, or once Complete (0 to 1) of the required fields have
+// values, e.g. <step turn="3">0.6</step> or <step complete="0.8">0.2</step>
+type TemperatureStep struct {
+	Turn        int     `xml:"turn,attr"`
+	Complete    float64 `xml:"complete,attr"`
+	Temperature float64 `xml:",chardata"`
+}
+
+// reached reports whether the conversation has got to the step
+func (s TemperatureStep) reached(turns int, complete float64) bool {
+	if s.Turn > 0 && turns < s.Turn {
+		return false
+	}
+	return complete >= s.Complete
+}
+
+// formCompleteness is the share of the form's required fields that have values
+func formCompleteness(form ConfigurationForm, formData map[string]string) float64 {
+	required := 0
+	for _, field := range formFields(form) {
+		if !field.Optional && fieldApplies(form, field.Name, formData) {
+			required++
+		}
+	}
+	if required == 0 {
+		return 1
+	}
+	return float64(required-len(missingFields(form, formData))) / float64(required)
+}
+
+// scheduledTemperature is the temperature of the last step in the form's
+// temperature_schedule the session has reached, reporting false if none has
+func scheduledTemperature(form ConfigurationForm, session *ChatSession) (float64, bool) {
+	steps := form.TemperatureSchedule.Step
+	if len(steps) == 0 {
+		return 0, false
+	}
+	complete := formCompleteness(form, session.FormData)
+	temperature, ok := 0.0, false
+	for _, step := range steps {
+		if step.reached(session.Turns, complete) {
+			temperature, ok = step.Temperature, true
+		}
+	}
+	return temperature, ok
+}
+
+// ForTurn applies the form's temperature schedule for the session's current
+// turn, unless the request overrode the temperature
+func (c Configuration) ForTurn(form ConfigurationForm, session *ChatSession) Configuration {
+	if c.temperatureOverridden {
+		return c
+	}
+	if t, ok := scheduledTemperature(form, session); ok {
+		log.Printf("🌡️ [%s]: temperature %g at turn %d", form.Name, t, session.Turns)
+		c.Temperature = &t
+	}
+	return c
+}
+
+// validateTemperatureSchedule checks each step's threshold and temperature
+func validateTemperatureSchedule(form ConfigurationForm) error {
+	for i, step := range form.TemperatureSchedule.Step {
+		switch {
+		case step.Turn < 0:
+			return fmt.Errorf("temperature_schedule step %d: turn must not be negative", i+1)
+		case step.Complete < 0 || step.Complete > 1:
+			return fmt.Errorf("temperature_schedule step %d: complete must be between 0 and 1", i+1)
+		case step.Temperature < 0 || step.Temperature > 2:
+			return fmt.Errorf("temperature_schedule step %d: temperature must be between 0 and 2", i+1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestScheduledTemperature(t *testing.T) {
+	form := testForm("f")
+	form.TemperatureSchedule.Step = []TemperatureStep{
+		{Turn: 2, Temperature: 0.6},
+		{Complete: 0.5, Temperature: 0.4},
+		{Turn: 4, Complete: 1, Temperature: 0.1},
+	}
+	half := map[string]string{"FirstName": "Ann"}
+	full := map[string]string{"FirstName": "Ann", "License": "A1"}
+	tests := []struct {
+		name     string
+		turns    int
+		formData map[string]string
+		want     float64
+		wantOK   bool
+	}{
+		{"nothing reached", 1, nil, 0, false},
+		{"turn reached", 2, nil, 0.6, true},
+		{"completeness reached", 1, half, 0.4, true},
+		{"later step wins", 3, half, 0.4, true},
+		{"complete but too early", 3, full, 0.4, true},
+		{"turn and completeness reached", 4, full, 0.1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := scheduledTemperature(form, &ChatSession{Turns: tt.turns, FormData: tt.formData})
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("scheduledTemperature = %g, %v; want %g, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestForTurnKeepsAnOverriddenTemperature(t *testing.T) {
+	form := testForm("f")
+	form.TemperatureSchedule.Step = []TemperatureStep{{Temperature: 0.2}}
+	override := 1.5
+	tests := []struct {
+		name       string
+		overridden bool
+		want       float64
+	}{
+		{"scheduled", false, 0.2},
+		{"overridden", true, 1.5},
+	}
+	for _, tt := range tests {
+		config := testConfig(t, form)
+		config.Temperature = &override
+		config.temperatureOverridden = tt.overridden
+		if got := config.ForTurn(form, &ChatSession{Turns: 1}).Temperature; got == nil || *got != tt.want {
+			t.Errorf("%s: temperature = %v, want %g", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTemperatureSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		step    TemperatureStep
+		wantErr bool
+	}{
+		{"turn", TemperatureStep{Turn: 3, Temperature: 0.6}, false},
+		{"complete", TemperatureStep{Complete: 0.8, Temperature: 0.2}, false},
+		{"negative turn", TemperatureStep{Turn: -1, Temperature: 0.6}, true},
+		{"complete above 1", TemperatureStep{Complete: 1.5, Temperature: 0.2}, true},
+		{"temperature above 2", TemperatureStep{Turn: 1, Temperature: 2.5}, true},
+		{"negative temperature", TemperatureStep{Turn: 1, Temperature: -0.1}, true},
+	}
+	for _, tt := range tests {
+		form := testForm("f")
+		form.TemperatureSchedule.Step = []TemperatureStep{tt.step}
+		if err := validateTemperatureSchedule(form); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateTemperatureSchedule = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTemperatureScheduleIsSentToTheModel(t *testing.T) {
+	fake := fakeChat(t, "SAY Hello")
+	form := testForm("f")
+	form.TemperatureSchedule.Step = []TemperatureStep{{Turn: 2, Temperature: 0.6}}
+	config := testConfig(t, form)
+	client := newClientID()
+	for i := 0; i < 2; i++ {
+		r := postJSON("/form/f/chat", `{"message": "hi"}`)
+		r.Header.Set(sessionIDHeader, client)
+		handleChat(httptest.NewRecorder(), r, config, "f")
+	}
+	if fake.calls() != 2 {
+		t.Fatalf("%d AI calls, want 2", fake.calls())
+	}
+	if got := fake.requests[0]["temperature"]; got != nil {
+		t.Errorf("first turn temperature = %v, want none", got)
+	}
+	if got := fake.requests[1]["temperature"]; got != 0.6 {
+		t.Errorf("second turn temperature = %v, want 0.6", got)
+	}
+}
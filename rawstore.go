@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Used when debug_store_max_bytes is not configured
+const defaultDebugStoreMaxBytes = 50 << 20
+
+// DebugStoreLimit is the most the stored responses may take up in all
+func (c Configuration) DebugStoreLimit() int64 {
+	if c.DebugStoreMaxBytes > 0 {
+		return c.DebugStoreMaxBytes
+	}
+	return defaultDebugStoreMaxBytes
+}
+
+// debugStoreDir holds the raw responses kept by debug_store_responses
+func debugStoreDir(config Configuration) string {
+	return filepath.Join(dataDir(config), "debug")
+}
+
+// Request IDs become file names
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// Guards the debug directory while a response is written and old ones rotated out
+var debugStoreMu sync.Mutex
+
+// storeRawResponse keeps a response body from the AI service exactly as it
+// arrived, in <data_dir>/debug/<request id><ext>, when debug_store_responses
+// is on. The request ID is the service's x-request-id, or the completion's id.
+// The API key is masked should it ever appear; nothing else is changed.
+func storeRawResponse(config Configuration, resp *http.Response, body []byte, ext string) {
+	if !config.DebugStoreResponses {
+		return
+	}
+	id := resp.Header.Get("x-request-id")
+	if id == "" {
+		var completion struct {
+			ID string `json:"id"`
+		}
+		json.Unmarshal(body, &completion)
+		id = completion.ID
+	}
+	if !requestIDPattern.MatchString(id) {
+		id = strings.ReplaceAll(newSubmissionID(), "-", "")
+	}
+	if key := os.Getenv("OPENAI_API_KEY"); key != "" {
+		body = []byte(strings.ReplaceAll(string(body), key, "[REDACTED]"))
+	}
+
+	debugStoreMu.Lock()
+	defer debugStoreMu.Unlock()
+	dir := debugStoreDir(config)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Printf("❌ DEBUG: failed to create %s: %v", dir, err)
+		return
+	}
+	path := filepath.Join(dir, id+ext)
+	if err := os.WriteFile(path, body, 0600); err != nil {
+		log.Printf("❌ DEBUG: failed to store response: %v", err)
+		return
+	}
+	log.Printf("🐞 DEBUG: stored raw response in %s", path)
+	rotateDebugStore(dir, config.DebugStoreLimit())
+}
+
+// rotateDebugStore removes the oldest stored responses until the rest fit in
+// limit bytes; the caller holds debugStoreMu
+func rotateDebugStore(dir string, limit int64) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	type stored struct {
+		path string
+		info os.FileInfo
+	}
+	var files []stored
+	var total int64
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, stored{filepath.Join(dir, entry.Name()), info})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].info.ModTime().Before(files[j].info.ModTime()) })
+	for _, f := range files {
+		if total <= limit {
+			return
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.info.Size()
+		}
+	}
+}
+
+// limitedWriter keeps the first n bytes written to it and drops the rest,
+// still reporting every write as complete so a TeeReader carries on
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		keep := p
+		if int64(len(keep)) > l.n {
+			keep = keep[:l.n]
+		}
+		written, err := l.w.Write(keep)
+		l.n -= int64(written)
+		if err != nil {
+			return written, err
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreRawResponse(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		requestID string
+		body      string
+		wantFile  string // "*" for a generated name
+		wantBody  string
+	}{
+		{"off", false, "req_1", `{"id": "chatcmpl-1"}`, "", ""},
+		{"request id header", true, "req_1", `{"id": "chatcmpl-1"}`, "req_1.json", `{"id": "chatcmpl-1"}`},
+		{"completion id", true, "", `{"id": "chatcmpl-1"}`, "chatcmpl-1.json", `{"id": "chatcmpl-1"}`},
+		{"unsafe id", true, "../../etc/passwd", `{}`, "*", `{}`},
+		{"api key masked", true, "req_2", `{"echo": "sk-test-secret"}`, "req_2.json", `{"echo": "[REDACTED]"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("OPENAI_API_KEY", "sk-test-secret")
+			config := testConfig(t)
+			config.DebugStoreResponses = tt.enabled
+			resp := &http.Response{Header: http.Header{}}
+			resp.Header.Set("x-request-id", tt.requestID)
+
+			storeRawResponse(config, resp, []byte(tt.body), ".json")
+			files, _ := filepath.Glob(filepath.Join(debugStoreDir(config), "*"))
+			if tt.wantFile == "" {
+				if len(files) != 0 {
+					t.Errorf("stored %v", files)
+				}
+				return
+			}
+			if len(files) != 1 || tt.wantFile != "*" && filepath.Base(files[0]) != tt.wantFile {
+				t.Fatalf("stored %v, want %s", files, tt.wantFile)
+			}
+			if data, _ := os.ReadFile(files[0]); string(data) != tt.wantBody {
+				t.Errorf("stored %q, want %q", data, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRotateDebugStore(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Hour)
+	for i, name := range []string{"oldest", "older", "newest"} {
+		path := filepath.Join(dir, name+".json")
+		os.WriteFile(path, bytes.Repeat([]byte("x"), 10), 0600)
+		at := start.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, at, at)
+	}
+	tests := []struct {
+		limit int64
+		want  []string
+	}{
+		{30, []string{"newest.json", "older.json", "oldest.json"}},
+		{25, []string{"newest.json", "older.json"}},
+		{10, []string{"newest.json"}},
+	}
+	for _, tt := range tests {
+		rotateDebugStore(dir, tt.limit)
+		entries, _ := os.ReadDir(dir)
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Name())
+		}
+		sort.Strings(got)
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("limit %d: kept %v, want %v", tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestLimitedWriter(t *testing.T) {
+	var kept bytes.Buffer
+	tee := io.TeeReader(strings.NewReader("data: hello\n\ndata: [DONE]\n\n"), &limitedWriter{w: &kept, n: 8})
+	read, err := io.ReadAll(tee)
+	if err != nil || len(read) != 27 || kept.String() != "data: he" {
+		t.Errorf("read %d bytes (%v), kept %q", len(read), err, kept.String())
+	}
+}
+
+func TestDebugStoreIsNotAFormFolder(t *testing.T) {
+	config := testConfig(t)
+	if name := filepath.Base(debugStoreDir(config)); !reservedFormNames[name] {
+		t.Errorf("form name %q is not reserved but holds the debug store", name)
+	}
+}
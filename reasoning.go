@@ -0,0 +1,32 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// Longest stretch of reasoning written to the log for one reply
+const maxLoggedReasoning = 4000
+
+// Reasoning is the model's thinking channel for the first choice, which
+// providers report as reasoning_content or reasoning next to the answer
+func (r *ChatResponse) Reasoning() string {
+	if len(r.Choices) == 0 {
+		return ""
+	}
+	msg := r.Choices[0].Message
+	return firstNonEmpty(msg.ReasoningContent, msg.Reasoning)
+}
+
+// logReasoning writes a reply's reasoning to the log, scrubbed and shortened,
+// when log_reasoning is on. It is never shown to the user or saved.
+func logReasoning(config Configuration, formName, reasoning string) {
+	if !config.LogReasoning || strings.TrimSpace(reasoning) == "" {
+		return
+	}
+	text := scrubText(strings.TrimSpace(reasoning))
+	if len(text) > maxLoggedReasoning {
+		text = text[:maxLoggedReasoning] + "..."
+	}
+	log.Printf("🧠 REASONING [%s]: %q", formName, text)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReasoning(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"reasoning_content", `{"choices": [{"message": {"content": "SAY hi", "reasoning_content": "greet them"}}]}`, "greet them"},
+		{"reasoning", `{"choices": [{"message": {"content": "SAY hi", "reasoning": "greet them"}}]}`, "greet them"},
+		{"none", `{"choices": [{"message": {"content": "SAY hi"}}]}`, ""},
+		{"no choices", `{"choices": []}`, ""},
+	}
+	for _, tt := range tests {
+		var resp ChatResponse
+		if err := json.Unmarshal([]byte(tt.body), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.Reasoning(); got != tt.want {
+			t.Errorf("%s: Reasoning = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestLogReasoning(t *testing.T) {
+	useScrubRules(t, ScrubRule{Name: "ssn", Pattern: `\d{3}-\d{2}-\d{4}`})
+	tests := []struct {
+		name      string
+		enabled   bool
+		reasoning string
+		want      string
+	}{
+		{"off", false, "greet them", ""},
+		{"blank", true, "  ", ""},
+		{"logged", true, " greet them ", `"greet them"`},
+		{"scrubbed", true, "their license is 555-55-5555", `"their license is [REDACTED]"`},
+		{"shortened", true, strings.Repeat("x", maxLoggedReasoning+10), `"` + strings.Repeat("x", maxLoggedReasoning) + `..."`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			previous := log.Writer()
+			log.SetOutput(&buf)
+			t.Cleanup(func() { log.SetOutput(previous) })
+
+			logReasoning(Configuration{LogReasoning: tt.enabled}, "f", tt.reasoning)
+			got := strings.TrimSpace(buf.String())
+			if tt.want == "" && got != "" || !strings.HasSuffix(got, tt.want) {
+				t.Errorf("logged %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStreamedReasoningIsKeptApart(t *testing.T) {
+	fakeChat(t)
+	chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := `data: {"choices": [{"delta": {"reasoning_content": "They said hi. "}}]}` + "\n\n" +
+			`data: {"choices": [{"delta": {"reasoning": "Greet them."}}]}` + "\n\n" +
+			`data: {"choices": [{"delta": {"content": "SAY Hello"}}]}` + "\n\n" +
+			"data: [DONE]\n\n"
+		return jsonResponse(r, http.StatusOK, body), nil
+	})
+	config := testConfig(t, testForm("f"))
+
+	var deltas []string
+	reply, err := streamChatGPT(context.Background(), config, []ChatMessage{{Role: "user", Content: "hi"}}, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "SAY Hello" || reply.Reasoning != "They said hi. Greet them." || strings.Join(deltas, "") != "SAY Hello" {
+		t.Errorf("reply %+v, deltas %q", reply, deltas)
+	}
+}
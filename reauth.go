@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// reauthPrompt tells the model to ask a returning user for their key, since
+// their saved details are withheld until they give it
+func reauthPrompt(config Configuration, form ConfigurationForm) string {
+	pk := strings.Join(primaryKeyFields(config.FormByName(form.ContextForm)), " and ")
+	return fmt.Sprintf("If the user says they have been here before, ask for their %s before anything else; "+
+		"their earlier details are only shown to you once they give it.", pk)
+}
+
+// restatesKey reports whether a message gives the key, either as the whole
+// message or as one of its words, ignoring case
+func restatesKey(message, key string) bool {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return false
+	}
+	if strings.EqualFold(strings.TrimSpace(message), key) {
+		return true
+	}
+	words := strings.FieldsFunc(message, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_'
+	})
+	for _, word := range words {
+		if strings.EqualFold(word, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// reauthRequired reports whether the form's saved records are only shown after
+// re-identification: it, or a form that loads it as context, has require_reauth
+func reauthRequired(config Configuration, form ConfigurationForm) bool {
+	for _, f := range config.Forms.Form {
+		if f.RequireReauth && (f.Name == form.Name || f.ContextForm == form.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// reidentifiedFor reports whether the caller proved in one of their sessions
+// that key is theirs, by restating it to a form that loads the record or by
+// saving the record themselves
+func reidentifiedFor(config Configuration, form ConfigurationForm, r *http.Request, key string) bool {
+	for _, f := range config.Forms.Form {
+		if f.Name != form.Name && f.ContextForm != form.Name {
+			continue
+		}
+		session := callerSession(config, f.Name, r)
+		if session == nil {
+			continue
+		}
+		session.turnMu.Lock()
+		proved := (session.Reidentified && f.ContextForm == form.Name) ||
+			(f.Name == form.Name && session.SubmissionID != "" && recordKey(form, session.FormData) == key)
+		session.turnMu.Unlock()
+		if proved {
+			return true
+		}
+	}
+	return false
+}
+
+// reidentify loads the context withheld under require_reauth once a message
+// restates the key held in the user's cookie, so the cookie alone never
+// reveals a saved record. The caller must hold the session's turn lock.
+func reidentify(config Configuration, formName string, session *ChatSession, r *http.Request, message string) {
+	form := config.FormByName(formName)
+	if !form.RequireReauth || session.Reidentified {
+		return
+	}
+	key, err := identityCookieValue(config, config.FormByName(form.ContextForm), r)
+	if err != nil || !restatesKey(message, key) {
+		return
+	}
+
+	session.Reidentified = true
+	contextData := loadContextData(config, formName, r)
+	if contextData == "" {
+		return
+	}
+	session.Messages = append(session.Messages, ChatMessage{
+		Role:    "system",
+		Content: fmt.Sprintf("The user has confirmed who they are. Context data from previous forms: %s\nThese values are already set.", contextData),
+	})
+	session.prefill(contextData, "context")
+	log.Printf("🔓 [%s]: context loaded after re-identification", formName)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRestatesKey(t *testing.T) {
+	tests := []struct {
+		message string
+		key     string
+		want    bool
+	}{
+		{"555-55-5555", "555-55-5555", true},
+		{"  my license is 555-55-5555.", "555-55-5555", true},
+		{"It's ab12", "AB12", true},
+		{"555-55-55556", "555-55-5555", false},
+		{"I forgot it", "555-55-5555", false},
+		{"anything", "", false},
+	}
+	for _, tt := range tests {
+		if got := restatesKey(tt.message, tt.key); got != tt.want {
+			t.Errorf("restatesKey(%q, %q) = %v, want %v", tt.message, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestContextIsWithheldUntilTheKeyIsRestated(t *testing.T) {
+	fakeChat(t, "SAY Hello")
+	form := testForm("f")
+	form.RequireReauth = true
+	config := testConfig(t, form)
+	identity := savedRecord(t, config, "f", map[string]string{"FirstName": "Ann", "License": "A1"})
+	client := newClientID()
+
+	tests := []struct {
+		message       string
+		wantFirstName string
+	}{
+		{"hi, I was here before", ""},
+		{"my license is A1", "Ann"},
+	}
+	for _, tt := range tests {
+		r := postJSON("/form/f/chat", `{"message": "`+tt.message+`"}`)
+		r.AddCookie(identity)
+		r.Header.Set(sessionIDHeader, client)
+		handleChat(httptest.NewRecorder(), r, config, "f")
+
+		session := callerSession(config, "f", r)
+		if session == nil {
+			t.Fatal("no session")
+		}
+		if got := session.FormData["FirstName"]; got != tt.wantFirstName {
+			t.Errorf("after %q: FirstName = %q, want %q", tt.message, got, tt.wantFirstName)
+		}
+	}
+
+	// The cookie alone doesn't open the saved record to another client
+	r := httptest.NewRequest(http.MethodGet, "/form/f/confirmation", nil)
+	r.AddCookie(identity)
+	r.Header.Set(sessionIDHeader, newClientID())
+	if _, ok := recordOwner(config, form, r); ok {
+		t.Error("recordOwner trusted the cookie without re-identification")
+	}
+}
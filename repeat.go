@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// Used when repeated_reply_note is not configured
+const defaultRepeatedReplyNote = "I may have repeated myself. Is there anything I can clarify?"
+
+// Sent once, in rephrase mode, when a reply repeats the previous one
+const rephrasePrompt = "Your reply is word for word the same as your previous one. Don't repeat yourself: rephrase it, or move the conversation on."
+
+// RepeatedReplyNoteText is shown in place of a repeated reply
+func (c Configuration) RepeatedReplyNoteText() string {
+	if c.RepeatedReplyNote == "" {
+		return defaultRepeatedReplyNote
+	}
+	return c.RepeatedReplyNote
+}
+
+// lastAssistantMessage returns the content of the session's latest assistant message
+func (s *ChatSession) lastAssistantMessage() string {
+	for i := len(s.Messages) - 1; i >= 0; i-- {
+		if s.Messages[i].Role == "assistant" {
+			return s.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// isRepeatedReply reports whether repeated_reply is on and content is the same
+// as the previous assistant turn, ignoring surrounding whitespace
+func isRepeatedReply(config Configuration, session *ChatSession, content string) bool {
+	content = strings.TrimSpace(content)
+	return config.RepeatedReply != "" && content != "" &&
+		content == strings.TrimSpace(session.lastAssistantMessage())
+}
+
+// rephraseReply takes the wording of a rephrased reply for the turn. The first
+// reply's commands were already applied to the session, so only the
+// rephrase's SAY lines are used; its other commands are dropped rather than
+// applied a second time. It returns the content to keep in the history and
+// reports false if the rephrase said nothing.
+func rephraseReply(config Configuration, turn *turnResult, content, rephrased string) (string, bool) {
+	if config.ChatOnly() {
+		rephrased = strings.TrimSpace(rephrased)
+		if rephrased == "" {
+			return content, false
+		}
+		turn.Messages = []string{guardPromptLeak(config, turn.form.Name, turn.session, rephrased)}
+		return rephrased, true
+	}
+	var says []string
+	for _, line := range strings.Split(rephrased, "\n") {
+		for _, cmd := range parseCommands(config, turn.form, line) {
+			if cmd.Verb == "SAY" {
+				says = append(says, guardPromptLeak(config, turn.form.Name, turn.session, cmd.Value))
+			}
+		}
+	}
+	if len(says) == 0 {
+		return content, false
+	}
+	// Keep the first reply's commands in the history, with the new wording
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if cmds := parseCommands(config, turn.form, line); len(cmds) > 0 && cmds[0].Verb != "SAY" {
+			lines = append(lines, line)
+		}
+	}
+	for _, say := range says {
+		lines = append(lines, "SAY "+say)
+	}
+	turn.Messages = says
+	return strings.Join(lines, "\n"), true
+}
+
+// collapseRepeat replaces a repeated reply with the note, returning the
+// content to keep in the history so the repeat isn't stored twice
+func collapseRepeat(config Configuration, formName string, turn *turnResult) string {
+	note := config.RepeatedReplyNoteText()
+	log.Printf("🔂 REPEAT [%s]: replying with the repeat note", formName)
+	turn.Messages = []string{note}
+	if config.ChatOnly() {
+		return note
+	}
+	return "SAY " + note
+}
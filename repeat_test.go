@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsRepeatedReply(t *testing.T) {
+	session := &ChatSession{Messages: []ChatMessage{
+		{Role: "assistant", Content: "SAY Hello"},
+		{Role: "user", Content: "hi"},
+	}}
+	tests := []struct {
+		name    string
+		mode    string
+		content string
+		want    bool
+	}{
+		{"off", "", "SAY Hello", false},
+		{"same reply", "note", "SAY Hello", true},
+		{"surrounding whitespace", "note", "  SAY Hello\n", true},
+		{"different reply", "note", "SAY Hello there", false},
+		{"empty reply", "note", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{RepeatedReply: tt.mode}
+			if got := isRepeatedReply(config, session, tt.content); got != tt.want {
+				t.Errorf("isRepeatedReply = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepeatedReplies(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		rephrase  string
+		want      string
+		wantCalls int
+	}{
+		{"off", "", "", "Hello", 2},
+		{"note", "note", "", defaultRepeatedReplyNote, 2},
+		{"rephrase keeps only the new wording", "rephrase", "SAY Hi again\nSET FirstName Bob", "Hi again", 3},
+		{"rephrase that says nothing", "rephrase", "SET FirstName Bob", defaultRepeatedReplyNote, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replies := []string{"SAY Hello", "SAY Hello"}
+			if tt.rephrase != "" {
+				replies = append(replies, tt.rephrase)
+			}
+			fake := fakeChat(t, replies...)
+			config := testConfig(t, testForm("f"))
+			config.RepeatedReply = tt.mode
+			client := newClientID()
+
+			var reply map[string]interface{}
+			for _, message := range []string{"hi", "hi again"} {
+				r := postJSON("/form/f/chat", `{"message": "`+message+`"}`)
+				r.Header.Set(sessionIDHeader, client)
+				w := httptest.NewRecorder()
+				handleChat(w, r, config, "f")
+				reply = nil
+				json.Unmarshal(w.Body.Bytes(), &reply)
+			}
+			if reply["message"] != tt.want || fake.calls() != tt.wantCalls {
+				t.Errorf("reply %v after %d AI calls, want %q after %d", reply["message"], fake.calls(), tt.want, tt.wantCalls)
+			}
+			if updates, _ := reply["updates"].(map[string]interface{}); len(updates) != 0 {
+				t.Errorf("rephrase applied %v", updates)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"net/http"
+	"time"
+)
+
+const resumePrompt = `The user is amending a submission they already saved. Its current values are shown in the context above and are already set.
+Show the user what is on file, ask what they want to change, SET only the fields they correct, and SAVE when they are done.`
+
+// resumeSession starts a session whose form data is a saved record, with the
+// record in the system prompt so the model continues from it
+func resumeSession(config Configuration, formName string, r *http.Request, record string) *ChatSession {
+	session := &ChatSession{
+		Messages: []ChatMessage{
+			{Role: "system", Content: buildSystemPrompt(config, config.FormByName(formName), record)},
+			{Role: "system", Content: resumePrompt},
+		},
+		FormData:   make(map[string]string),
+		Locale:     requestLocale(r),
+		CreatedAt:  time.Now(),
+		LastActive: time.Now(),
+	}
+	session.prefill(record, "saved record")
+	return session
+}
+
+// handleResume replaces the caller's session of the form with one pre-loaded
+// from their saved record, for correcting a prior submission conversationally.
+// Only the owner shown by the signed identity cookie may resume a record, so
+// ?key= must match the cookie when given. The values are not sent back; the
+// model walks the user through them.
+func handleResume(w http.ResponseWriter, r *http.Request, config Configuration, formName string) {
+	key, ok := recordOwner(config, config.FormByName(formName), r)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if requested := r.URL.Query().Get("key"); requested != "" && requested != key {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	filename, err := storedRecordPath(config, formName, key)
+	if err != nil {
+		http.Error(w, "Invalid record key", http.StatusBadRequest)
+		return
+	}
+	saved, err := readRecord(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "Record not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to read %s: %v", formName, filename, err)
+		http.Error(w, "Failed to read record", http.StatusInternalServerError)
+		return
+	}
+
+	record := migrateRecordJSON(config.FormByName(formName), stripRecordMetadata(saved))
+	session := resumeSession(config, formName, r, record)
+	replaceSession(w, r, config, formName, session)
+	log.Printf("⏯️ RESUME [%s]: new session from %s", formName, filename)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"resumed": true})
+}
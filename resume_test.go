@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// savedRecord saves formData as a record of the form, returning the identity
+// cookie that proves ownership of it
+func savedRecord(t *testing.T, config Configuration, formName string, formData map[string]string) *http.Cookie {
+	t.Helper()
+	session := &ChatSession{FormData: formData}
+	if err := saveSession(config, formName, session, newTurnResult(config, formName, session)); err != nil {
+		t.Fatal(err)
+	}
+	return identityCookie(config, formName, session)
+}
+
+func TestResume(t *testing.T) {
+	config := testConfig(t, testForm("f"))
+	owner := savedRecord(t, config, "f", map[string]string{"FirstName": "Ann", "License": "A1"})
+	stranger := identityCookie(config, "f", &ChatSession{FormData: map[string]string{"License": "B2"}})
+	tests := []struct {
+		name       string
+		cookie     *http.Cookie
+		query      string
+		wantStatus int
+	}{
+		{"owner", owner, "", http.StatusOK},
+		{"owner naming their key", owner, "?key=A1", http.StatusOK},
+		{"owner naming another key", owner, "?key=B2", http.StatusForbidden},
+		{"no identity", nil, "", http.StatusForbidden},
+		{"nothing saved", stranger, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/form/f/resume"+tt.query, nil)
+			r.Header.Set(sessionIDHeader, newClientID())
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			w := httptest.NewRecorder()
+			handleResume(w, r, config, "f")
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			session := callerSession(config, "f", r)
+			if session == nil || session.FormData["FirstName"] != "Ann" || session.Messages[1].Content != resumePrompt {
+				t.Errorf("resumed session = %+v, want the saved record loaded", session)
+			}
+		})
+	}
+}
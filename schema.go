@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Fields loaded from each form's fields_schema, keyed by form name
+var schemaFields = make(map[string][]FormField)
+
+// formFields returns a form's fields, from its JSON Schema when one is
+// configured and from the form_fields grammar otherwise
+func formFields(form ConfigurationForm) []FormField {
+	if fields, ok := schemaFields[form.Name]; ok {
+		return fields
+	}
+	return parseFormFields(form.Fields)
+}
+
+type jsonSchemaProperty struct {
+	Type        string        `json:"type"`
+	Format      string        `json:"format"`
+	Title       string        `json:"title"`
+	Description string        `json:"description"`
+	Examples    []interface{} `json:"examples"`
+	Enum        []interface{} `json:"enum"`
+}
+
+// loadSchemaFields translates a JSON Schema object's properties into form
+// fields, keeping the order the properties are written in
+func loadSchemaFields(path string) ([]FormField, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var schema struct {
+		Properties json.RawMessage `json:"properties"`
+		Required   []string        `json:"required"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("parsing schema %s: %v", path, err)
+	}
+	names, err := orderedKeys(schema.Properties)
+	if err != nil {
+		return nil, fmt.Errorf("parsing schema %s properties: %v", path, err)
+	}
+	var properties map[string]jsonSchemaProperty
+	if err := json.Unmarshal(schema.Properties, &properties); err != nil {
+		return nil, fmt.Errorf("parsing schema %s properties: %v", path, err)
+	}
+
+	required := make(map[string]bool)
+	for _, name := range schema.Required {
+		required[name] = true
+	}
+
+	fields := make([]FormField, 0, len(names))
+	for _, name := range names {
+		prop := properties[name]
+		field := FormField{
+			Label:    prop.Title,
+			Name:     name,
+			Example:  prop.Description,
+			Type:     schemaFieldType(prop),
+			Optional: !required[name],
+		}
+		if field.Label == "" {
+			field.Label = name
+		}
+		if len(prop.Examples) > 0 {
+			field.Example = fmt.Sprint(prop.Examples[0])
+		}
+		for _, option := range prop.Enum {
+			field.Options = append(field.Options, fmt.Sprint(option))
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// schemaFieldType maps a JSON Schema type and format onto the field types used here
+func schemaFieldType(prop jsonSchemaProperty) string {
+	switch prop.Type {
+	case "array":
+		return "list"
+	case "integer", "number":
+		return "number"
+	case "boolean":
+		return "boolean"
+	}
+	switch prop.Format {
+	case "tel", "phone":
+		return "tel"
+	case "date", "email":
+		return prop.Format
+	}
+	return ""
+}
+
+// orderedKeys lists the keys of a JSON object in document order
+func orderedKeys(raw json.RawMessage) ([]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("expected an object")
+	}
+	var keys []string
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, tok.(string))
+		var skip json.RawMessage
+		if err := dec.Decode(&skip); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// fieldsText renders fields in the form_fields grammar for the system prompt
+func fieldsText(fields []FormField) string {
+	var b strings.Builder
+	for _, f := range fields {
+		fmt.Fprintf(&b, "%s: {{.%s}}", f.Label, f.Name)
+		if f.Example != "" {
+			fmt.Fprintf(&b, " (like %s)", f.Example)
+		}
+		if len(f.Options) > 0 {
+			fmt.Fprintf(&b, " (one of %s)", strings.Join(f.Options, ", "))
+		}
+		if f.Optional {
+			b.WriteString(" (optional)")
+		}
+		if f.Type != "" {
+			fmt.Fprintf(&b, " [%s]", f.Type)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// loadFormSchemas reads every configured fields_schema, replacing the form's
+// form_fields text so the prompt describes the schema's fields
+func loadFormSchemas(config *Configuration) error {
+	for i := range config.Forms.Form {
+		form := &config.Forms.Form[i]
+		path := strings.TrimSpace(form.FieldsSchema)
+		if path == "" {
+			continue
+		}
+		fields, err := loadSchemaFields(path)
+		if err != nil {
+			return fmt.Errorf("form %s: %v", form.Name, err)
+		}
+		schemaFields[form.Name] = fields
+		form.Fields = fieldsText(fields)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testSchema = `{
+	"type": "object",
+	"required": ["License", "Name"],
+	"properties": {
+		"Name": {"type": "string", "title": "Full Name", "examples": ["John Smith"]},
+		"License": {"type": "string", "description": "555-55-5555"},
+		"Phone": {"type": "string", "format": "tel"},
+		"Visits": {"type": "integer"},
+		"Allergies": {"type": "array", "items": {"type": "string"}},
+		"Plan": {"type": "string", "enum": ["basic", "premium"]}
+	}
+}`
+
+func writeSchema(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "schema.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadSchemaFields(t *testing.T) {
+	fields, err := loadSchemaFields(writeSchema(t, testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FormField{
+		{Label: "Full Name", Name: "Name", Example: "John Smith"},
+		{Label: "License", Name: "License", Example: "555-55-5555"},
+		{Label: "Phone", Name: "Phone", Type: "tel", Optional: true},
+		{Label: "Visits", Name: "Visits", Type: "number", Optional: true},
+		{Label: "Allergies", Name: "Allergies", Type: "list", Optional: true},
+		{Label: "Plan", Name: "Plan", Options: []string{"basic", "premium"}, Optional: true},
+	}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("loadSchemaFields =\n%+v\nwant\n%+v", fields, want)
+	}
+}
+
+func TestLoadSchemaFieldsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"not JSON", "{"},
+		{"properties not an object", `{"properties": []}`},
+	}
+	for _, tt := range tests {
+		if _, err := loadSchemaFields(writeSchema(t, tt.content)); err == nil {
+			t.Errorf("%s: loadSchemaFields succeeded", tt.name)
+		}
+	}
+}
+
+func TestLoadFormSchemasReplacesFormFields(t *testing.T) {
+	previous := schemaFields
+	t.Cleanup(func() { schemaFields = previous })
+	schemaFields = make(map[string][]FormField)
+
+	config := testConfig(t, ConfigurationForm{Name: "f", FieldsSchema: writeSchema(t, testSchema)})
+	if err := loadFormSchemas(&config); err != nil {
+		t.Fatal(err)
+	}
+	form := config.FormByName("f")
+	var names []string
+	for _, f := range parseFormFields(form.Fields) {
+		names = append(names, f.Name)
+	}
+	want := []string{"Name", "License", "Phone", "Visits", "Allergies", "Plan"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("form_fields describes %v, want %v", names, want)
+	}
+	if fields := formFields(form); len(fields) != len(want) || !fields[2].Optional {
+		t.Errorf("formFields = %+v, want the schema's fields", fields)
+	}
+}
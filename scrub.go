@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// ScrubRule masks or flags values matching a pattern before they are saved
+type ScrubRule struct {
+	Name        string `xml:"name,attr"`
+	Action      string `xml:"action,attr"` // "mask" (default) or "flag"
+	Pattern     string `xml:"pattern"`
+	Replacement string `xml:"replacement"`
+}
+
+type ScrubberConfig struct {
+	Rules []ScrubRule `xml:"rule"`
+}
+
+type compiledScrubRule struct {
+	ScrubRule
+	re *regexp.Regexp
+}
+
+// Global compiled scrub rules, empty when scrubbing is not configured
+var scrubRules []compiledScrubRule
+
+func compileScrubRules(config ScrubberConfig) ([]compiledScrubRule, error) {
+	rules := make([]compiledScrubRule, 0, len(config.Rules))
+	for _, rule := range config.Rules {
+		re, err := regexp.Compile(strings.TrimSpace(rule.Pattern))
+		if err != nil {
+			return nil, fmt.Errorf("scrub rule %s: %v", rule.Name, err)
+		}
+		switch rule.Action {
+		case "":
+			rule.Action = "mask"
+		case "mask", "flag":
+		default:
+			return nil, fmt.Errorf("scrub rule %s: unknown action %q", rule.Name, rule.Action)
+		}
+		if rule.Replacement == "" {
+			rule.Replacement = "[REDACTED]"
+		}
+		rules = append(rules, compiledScrubRule{ScrubRule: rule, re: re})
+	}
+	return rules, nil
+}
+
+// splitFieldList parses a comma separated list of field names
+func splitFieldList(list string) []string {
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// scrubFormData masks sensitive values in fields that are not meant to hold them.
+// It returns the fields whose values changed.
+func scrubFormData(form ConfigurationForm, formData map[string]string) map[string]string {
+	changed := make(map[string]string)
+	if len(scrubRules) == 0 {
+		return changed
+	}
+
+	exempt := make(map[string]bool)
+	for _, name := range splitFieldList(form.ScrubExempt) {
+		exempt[name] = true
+	}
+
+	for field, value := range formData {
+		if exempt[field] {
+			continue
+		}
+		scrubbed := value
+		for _, rule := range scrubRules {
+			if !rule.re.MatchString(scrubbed) {
+				continue
+			}
+			if rule.Action == "flag" {
+				log.Printf("🚩 SCRUB [%s]: %s matched %s", form.Name, field, rule.Name)
+				continue
+			}
+			log.Printf("🧽 SCRUB [%s]: masked %s in %s", form.Name, rule.Name, field)
+			scrubbed = rule.re.ReplaceAllLiteralString(scrubbed, rule.Replacement)
+		}
+		if scrubbed != value {
+			formData[field] = scrubbed
+			changed[field] = scrubbed
+		}
+	}
+	return changed
+}
+
+// scrubText masks everything the mask rules match in free text
+func scrubText(text string) string {
+	for _, rule := range scrubRules {
+		if rule.Action == "mask" {
+			text = rule.re.ReplaceAllLiteralString(text, rule.Replacement)
+		}
+	}
+	return text
+}
+
+// sessionTranscript is the user and assistant side of the conversation, scrubbed.
+// System messages are left out since they hold the prompt and context data.
+func sessionTranscript(session *ChatSession) []ChatMessage {
+	transcript := make([]ChatMessage, 0, len(session.Messages))
+	for _, msg := range session.Messages {
+		if msg.Role == "system" {
+			continue
+		}
+		transcript = append(transcript, ChatMessage{Role: msg.Role, Content: scrubText(msg.Content)})
+	}
+	return transcript
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// useScrubRules installs rules as the global scrubber for the rest of the test
+func useScrubRules(t *testing.T, rules ...ScrubRule) {
+	t.Helper()
+	compiled, err := compileScrubRules(ScrubberConfig{Rules: rules})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := scrubRules
+	t.Cleanup(func() { scrubRules = previous })
+	scrubRules = compiled
+}
+
+func TestCompileScrubRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    ScrubRule
+		wantErr bool
+	}{
+		{"mask by default", ScrubRule{Name: "ssn", Pattern: `\d{3}-\d{2}-\d{4}`}, false},
+		{"flag", ScrubRule{Name: "ssn", Action: "flag", Pattern: `\d+`}, false},
+		{"bad pattern", ScrubRule{Name: "bad", Pattern: `(`}, true},
+		{"unknown action", ScrubRule{Name: "x", Action: "drop", Pattern: `x`}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := compileScrubRules(ScrubberConfig{Rules: []ScrubRule{tt.rule}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (rules[0].Action == "" || rules[0].Replacement != "[REDACTED]") {
+				t.Errorf("defaults not applied: %+v", rules[0].ScrubRule)
+			}
+		})
+	}
+}
+
+func TestScrubFormData(t *testing.T) {
+	useScrubRules(t,
+		ScrubRule{Name: "ssn", Pattern: `\d{3}-\d{2}-\d{4}`},
+		ScrubRule{Name: "swear", Action: "flag", Pattern: `(?i)darn`},
+	)
+	form := ConfigurationForm{Name: "f", ScrubExempt: "License"}
+
+	tests := []struct {
+		field       string
+		value       string
+		want        string
+		wantChanged bool
+	}{
+		{"Notes", "my ssn is 555-55-5555", "my ssn is [REDACTED]", true},
+		{"License", "555-55-5555", "555-55-5555", false},
+		{"Notes", "darn it", "darn it", false},
+		{"FirstName", "Ann", "Ann", false},
+	}
+	for _, tt := range tests {
+		data := map[string]string{tt.field: tt.value}
+		changed := scrubFormData(form, data)
+		if data[tt.field] != tt.want {
+			t.Errorf("%s %q scrubbed to %q, want %q", tt.field, tt.value, data[tt.field], tt.want)
+		}
+		if _, ok := changed[tt.field]; ok != tt.wantChanged {
+			t.Errorf("%s %q reported changed = %v, want %v", tt.field, tt.value, ok, tt.wantChanged)
+		}
+	}
+}
+
+func TestSessionTranscriptIsScrubbed(t *testing.T) {
+	useScrubRules(t, ScrubRule{Name: "ssn", Pattern: `\d{3}-\d{2}-\d{4}`})
+	session := &ChatSession{Messages: []ChatMessage{
+		{Role: "system", Content: "prompt with context 555-55-5555"},
+		{Role: "user", Content: "my license is 555-55-5555"},
+		{Role: "assistant", Content: "SAY thanks"},
+	}}
+	want := []ChatMessage{
+		{Role: "user", Content: "my license is [REDACTED]"},
+		{Role: "assistant", Content: "SAY thanks"},
+	}
+	if got := sessionTranscript(session); !reflect.DeepEqual(got, want) {
+		t.Errorf("sessionTranscript = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// SessionSeed is a developer provided starting state for new sessions
+type SessionSeed struct {
+	FormData map[string]string `json:"form_data"`
+	Messages []ChatMessage     `json:"messages"`
+}
+
+func loadSessionSeed(path string) (*SessionSeed, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var seed SessionSeed
+	if err := json.Unmarshal(data, &seed); err != nil {
+		return nil, fmt.Errorf("parsing seed %s: %v", path, err)
+	}
+	return &seed, nil
+}
+
+// applySeed adds the seed's form data and conversation to a freshly created session
+func applySeed(session *ChatSession, seed *SessionSeed) {
+	for k, v := range seed.FormData {
+		session.FormData[k] = v
+	}
+	session.Messages = append(session.Messages, seed.Messages...)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSessionSeed(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     *SessionSeed
+		wantErr  bool
+	}{
+		{
+			"form data and messages",
+			`{"form_data": {"FirstName": "Ann"}, "messages": [{"role": "assistant", "content": "Hi Ann"}]}`,
+			&SessionSeed{FormData: map[string]string{"FirstName": "Ann"}, Messages: []ChatMessage{{Role: "assistant", Content: "Hi Ann"}}},
+			false,
+		},
+		{"empty object", `{}`, &SessionSeed{}, false},
+		{"not json", `FirstName: Ann`, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "seed.json")
+			os.WriteFile(path, []byte(tt.contents), 0644)
+			seed, err := loadSessionSeed(path)
+			if (err != nil) != tt.wantErr || !reflect.DeepEqual(seed, tt.want) {
+				t.Errorf("loadSessionSeed = %+v, %v, want %+v", seed, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplySeedKeepsTheSystemPromptFirst(t *testing.T) {
+	session := &ChatSession{
+		FormData: map[string]string{"License": "A1"},
+		Messages: []ChatMessage{{Role: "system", Content: "prompt"}},
+	}
+	applySeed(session, &SessionSeed{
+		FormData: map[string]string{"FirstName": "Ann"},
+		Messages: []ChatMessage{{Role: "assistant", Content: "Hi Ann"}},
+	})
+	if session.FormData["License"] != "A1" || session.FormData["FirstName"] != "Ann" {
+		t.Errorf("form data %v", session.FormData)
+	}
+	if len(session.Messages) != 2 || session.Messages[0].Role != "system" {
+		t.Errorf("messages %v", session.Messages)
+	}
+}
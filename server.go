@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// Limits applied when the server settings leave them unset
+const (
+	defaultMaxHeaderBytes = 64 << 10
+	defaultMaxBodyBytes   = 1 << 20
+)
+
+// ServerConfig holds listener settings. HTTP/2 is negotiated automatically
+// when a certificate and key are configured; plain HTTP stays on HTTP/1.1.
+type ServerConfig struct {
+	TLSCertFile    string `xml:"tls_cert_file"`
+	TLSKeyFile     string `xml:"tls_key_file"`
+	MaxHeaderBytes int    `xml:"max_header_bytes"`
+	MaxBodyBytes   int64  `xml:"max_body_bytes"`
+	// Plain HTTP listener that redirects every request to HTTPS, e.g. :80 (needs TLS)
+	HTTPRedirectAddr string `xml:"http_redirect_addr"`
+	// Send Strict-Transport-Security on TLS responses
+	HSTS bool `xml:"hsts"`
+	// max-age of the HSTS header (Go duration, default one year)
+	HSTSMaxAge string `xml:"hsts_max_age"`
+	// Add includeSubDomains to the HSTS header
+	HSTSIncludeSubdomains bool `xml:"hsts_include_subdomains"`
+	// Comma separated addresses or CIDR ranges of the reverse proxies in front
+	// of the server, whose X-Forwarded-For is believed, e.g. 127.0.0.1,10.0.0.0/8
+	TrustedProxies string `xml:"trusted_proxies"`
+}
+
+// parseProxyEntry reads one trusted_proxies entry, an address or a CIDR range
+func parseProxyEntry(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// trustsProxy reports whether the address is one of trusted_proxies
+func (c ServerConfig) trustsProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	for _, entry := range splitFieldList(c.TrustedProxies) {
+		if prefix, err := parseProxyEntry(entry); err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Used when hsts_max_age is not configured
+const defaultHSTSMaxAge = 365 * 24 * time.Hour
+
+// HSTSHeader is the Strict-Transport-Security value, empty when HSTS is off
+func (c ServerConfig) HSTSHeader() string {
+	if !c.HSTS || !c.TLSEnabled() {
+		return ""
+	}
+	maxAge := defaultHSTSMaxAge
+	if d, err := time.ParseDuration(c.HSTSMaxAge); err == nil && d > 0 {
+		maxAge = d
+	}
+	header := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if c.HSTSIncludeSubdomains {
+		header += "; includeSubDomains"
+	}
+	return header
+}
+
+// TLSEnabled reports whether the server listens with TLS, and so offers HTTP/2
+func (c ServerConfig) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// HeaderLimit returns the request header limit in bytes
+func (c ServerConfig) HeaderLimit() int {
+	if c.MaxHeaderBytes > 0 {
+		return c.MaxHeaderBytes
+	}
+	return defaultMaxHeaderBytes
+}
+
+// BodyLimit returns the request body limit in bytes
+func (c ServerConfig) BodyLimit() int64 {
+	if c.MaxBodyBytes > 0 {
+		return c.MaxBodyBytes
+	}
+	return defaultMaxBodyBytes
+}
+
+// newServer builds the HTTP server for the configured address and limits
+func newServer(config Configuration, handler http.Handler) *http.Server {
+	if hsts := config.Server.HSTSHeader(); hsts != "" {
+		next := handler
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", hsts)
+			next.ServeHTTP(w, r)
+		})
+	}
+	return &http.Server{
+		Addr:           config.BindAddr,
+		Handler:        handler,
+		MaxHeaderBytes: config.Server.HeaderLimit(),
+	}
+}
+
+// httpsURL is the HTTPS address of a request that arrived over plain HTTP: under
+// base_url when that is an https URL, otherwise on the request's host at the TLS port
+func httpsURL(config Configuration, r *http.Request) string {
+	if strings.HasPrefix(config.BaseURL, "https://") {
+		return strings.TrimRight(config.BaseURL, "/") + r.URL.RequestURI()
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if _, port, err := net.SplitHostPort(config.BindAddr); err == nil && port != "" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	}
+	return "https://" + host + r.URL.RequestURI()
+}
+
+// The redirect server only ever reads a request line and headers and writes a
+// short 301, so its connections are kept on a tight leash
+const (
+	redirectReadTimeout = 5 * time.Second
+	redirectIdleTimeout = 30 * time.Second
+)
+
+// redirectServer answers every plain HTTP request with a 301 to its HTTPS
+// equivalent, or is nil when TLS or http_redirect_addr is not configured
+func redirectServer(config Configuration) *http.Server {
+	if !config.Server.TLSEnabled() || config.Server.HTTPRedirectAddr == "" {
+		return nil
+	}
+	return &http.Server{
+		Addr: config.Server.HTTPRedirectAddr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, httpsURL(config, r), http.StatusMovedPermanently)
+		}),
+		MaxHeaderBytes:    config.Server.HeaderLimit(),
+		ReadHeaderTimeout: redirectReadTimeout,
+		ReadTimeout:       redirectReadTimeout,
+		WriteTimeout:      redirectReadTimeout,
+		IdleTimeout:       redirectIdleTimeout,
+	}
+}
+
+// Path prefixes an absolute app path with the configured base path
+func (c Configuration) Path(p string) string {
+	return strings.TrimRight(c.BasePath, "/") + p
+}
+
+// FormURL is the external URL of a form's page, as encoded in its QR code
+func (c Configuration) FormURL(formName string) string {
+	return strings.TrimRight(c.BaseURL, "/") + c.Path("/form/"+formName)
+}
+
+// mountAt serves the app's routes under the configured base path, stripping
+// it before dispatch so the routes themselves stay rooted at "/"
+func mountAt(config Configuration, handler http.Handler) http.Handler {
+	prefix := strings.TrimRight(config.BasePath, "/")
+	if prefix == "" {
+		return handler
+	}
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, handler))
+	mux.Handle(prefix, http.RedirectHandler(prefix+"/", http.StatusMovedPermanently))
+	return mux
+}
+
+// serve runs the server until it fails or is shut down, with TLS (and HTTP/2)
+// when configured. The redirect server, if any, is bound first so a busy port
+// fails startup; it is shut down along with the server.
+func serve(server, redirect *http.Server, config Configuration) error {
+	if config.Server.TLSEnabled() {
+		if redirect != nil {
+			listener, err := net.Listen("tcp", redirect.Addr)
+			if err != nil {
+				return fmt.Errorf("http_redirect_addr: %v", err)
+			}
+			log.Printf("↪️ Redirecting HTTP on %s to HTTPS", redirect.Addr)
+			go func() {
+				if err := redirect.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+					log.Printf("❌ Redirect server on %s stopped: %v", redirect.Addr, err)
+				}
+			}()
+		}
+		log.Printf("Server starting on %s (TLS, HTTP/2 enabled)", server.Addr)
+		return server.ListenAndServeTLS(config.Server.TLSCertFile, config.Server.TLSKeyFile)
+	}
+	log.Printf("Server starting on %s", server.Addr)
+	return server.ListenAndServe()
+}
+
+// limitBody caps how much of a request body a handler may read
+func limitBody(w http.ResponseWriter, r *http.Request, config Configuration) {
+	r.Body = http.MaxBytesReader(w, r.Body, config.Server.BodyLimit())
+}
+
+// isBodyTooLarge reports whether err came from reading past the body limit
+func isBodyTooLarge(err error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(err, &maxErr)
+}
+
+// handleHealth reports that the server is up and which protocol the request
+// arrived on, so HTTP/2 negotiation can be checked from a client
+func handleHealth(w http.ResponseWriter, r *http.Request, config Configuration) {
+	if !allowMethods(w, r, config, http.MethodGet, http.MethodHead) {
+		return
+	}
+	health := map[string]interface{}{
+		"status":  "ok",
+		"proto":   r.Proto,
+		"http2":   r.ProtoMajor == 2,
+		"tls":     r.TLS != nil,
+		"offered": config.Server.TLSEnabled(),
+		"circuit": aiBreaker.status(time.Now()),
+	}
+	if state := currentMaintenance(); state.Enabled {
+		health["maintenance"] = state
+	}
+	if report := sinkHealthReport(); len(report) > 0 {
+		health["sinks"] = report
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(health)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		config     ServerConfig
+		wantHeader int
+		wantBody   int64
+	}{
+		{"defaults", ServerConfig{}, defaultMaxHeaderBytes, defaultMaxBodyBytes},
+		{"configured", ServerConfig{MaxHeaderBytes: 1024, MaxBodyBytes: 2048}, 1024, 2048},
+	}
+	for _, tt := range tests {
+		if got := tt.config.HeaderLimit(); got != tt.wantHeader {
+			t.Errorf("%s: HeaderLimit = %d, want %d", tt.name, got, tt.wantHeader)
+		}
+		if got := tt.config.BodyLimit(); got != tt.wantBody {
+			t.Errorf("%s: BodyLimit = %d, want %d", tt.name, got, tt.wantBody)
+		}
+	}
+}
+
+func TestValidateConfigServerSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  ServerConfig
+		wantErr bool
+	}{
+		{"plain HTTP", ServerConfig{}, false},
+		{"TLS", ServerConfig{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}, false},
+		{"certificate without a key", ServerConfig{TLSCertFile: "cert.pem"}, true},
+		{"negative body limit", ServerConfig{MaxBodyBytes: -1}, true},
+		{"trusted proxies", ServerConfig{TrustedProxies: "127.0.0.1, 10.0.0.0/8, ::1"}, false},
+		{"bad trusted proxy", ServerConfig{TrustedProxies: "proxy.internal"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateConfig(Configuration{Server: tt.server}); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateConfig = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestChatBodyOverTheLimit(t *testing.T) {
+	fakeChat(t, "SAY hi")
+	config := testConfig(t, testForm("f"))
+	config.Server.MaxBodyBytes = 64
+	tests := []struct {
+		name       string
+		message    string
+		wantStatus int
+	}{
+		{"within the limit", "hi", http.StatusOK},
+		{"over the limit", strings.Repeat("x", 100), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := postJSON("/form/f/chat", `{"message": "`+tt.message+`"}`)
+		limitBody(w, r, config)
+		handleChat(w, r, config, "f")
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+func TestBasePathURLs(t *testing.T) {
+	tests := []struct {
+		basePath string
+		wantPath string
+		wantURL  string
+	}{
+		{"", "/form/f", "https://example.com/form/f"},
+		{"/gochat", "/gochat/form/f", "https://example.com/gochat/form/f"},
+		{"/gochat/", "/gochat/form/f", "https://example.com/gochat/form/f"},
+	}
+	for _, tt := range tests {
+		config := Configuration{BaseURL: "https://example.com/", BasePath: tt.basePath}
+		if got := config.Path("/form/f"); got != tt.wantPath {
+			t.Errorf("Path with %q = %s, want %s", tt.basePath, got, tt.wantPath)
+		}
+		if got := config.FormURL("f"); got != tt.wantURL {
+			t.Errorf("FormURL with %q = %s, want %s", tt.basePath, got, tt.wantURL)
+		}
+	}
+}
+
+func TestMountAt(t *testing.T) {
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+	handler := mountAt(Configuration{BasePath: "/gochat"}, app)
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"/gochat/form/f", http.StatusOK, "/form/f"},
+		{"/gochat", http.StatusMovedPermanently, ""},
+		{"/form/f", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.wantStatus || tt.wantBody != "" && w.Body.String() != tt.wantBody {
+			t.Errorf("%s: %d %q, want %d %q", tt.path, w.Code, w.Body.String(), tt.wantStatus, tt.wantBody)
+		}
+	}
+}
+
+func TestValidateConfigBasePath(t *testing.T) {
+	for path, wantErr := range map[string]bool{"": false, "/gochat": false, "gochat": true, "/gochat?x=1": true} {
+		if err := validateConfig(Configuration{BasePath: path}); (err != nil) != wantErr {
+			t.Errorf("base_path %q: validateConfig = %v, want error %v", path, err, wantErr)
+		}
+	}
+}
+
+func TestHSTSHeader(t *testing.T) {
+	tls := ServerConfig{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem", HSTS: true}
+	withSubdomains := tls
+	withSubdomains.HSTSMaxAge = "1h"
+	withSubdomains.HSTSIncludeSubdomains = true
+	tests := []struct {
+		name   string
+		server ServerConfig
+		want   string
+	}{
+		{"off", ServerConfig{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}, ""},
+		{"without TLS", ServerConfig{HSTS: true}, ""},
+		{"default max-age", tls, "max-age=31536000"},
+		{"configured", withSubdomains, "max-age=3600; includeSubDomains"},
+	}
+	for _, tt := range tests {
+		if got := tt.server.HSTSHeader(); got != tt.want {
+			t.Errorf("%s: HSTSHeader = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	server := newServer(Configuration{Server: withSubdomains}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=3600; includeSubDomains" {
+		t.Errorf("Strict-Transport-Security = %q", got)
+	}
+}
+
+func TestHTTPSURL(t *testing.T) {
+	tests := []struct {
+		name     string
+		baseURL  string
+		bindAddr string
+		target   string
+		want     string
+	}{
+		{"https base url", "https://forms.example.com/", ":8443", "http://other/form/f?x=1", "https://forms.example.com/form/f?x=1"},
+		{"default TLS port", "", ":443", "http://forms.example.com:80/form/f", "https://forms.example.com/form/f"},
+		{"other TLS port", "http://forms.example.com", "0.0.0.0:8443", "http://forms.example.com/form/f", "https://forms.example.com:8443/form/f"},
+	}
+	for _, tt := range tests {
+		config := Configuration{BaseURL: tt.baseURL, BindAddr: tt.bindAddr}
+		if got := httpsURL(config, httptest.NewRequest(http.MethodGet, tt.target, nil)); got != tt.want {
+			t.Errorf("%s: httpsURL = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRedirectServer(t *testing.T) {
+	tls := ServerConfig{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem", HTTPRedirectAddr: ":80"}
+	tests := []struct {
+		name   string
+		server ServerConfig
+		want   bool
+	}{
+		{"redirecting", tls, true},
+		{"no redirect address", ServerConfig{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem"}, false},
+		{"without TLS", ServerConfig{HTTPRedirectAddr: ":80"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{BaseURL: "https://forms.example.com", Server: tt.server}
+			server := redirectServer(config)
+			if (server != nil) != tt.want {
+				t.Fatalf("redirectServer = %v, want a server %v", server, tt.want)
+			}
+			if server == nil {
+				return
+			}
+			if server.ReadHeaderTimeout == 0 || server.ReadTimeout == 0 || server.WriteTimeout == 0 || server.IdleTimeout == 0 {
+				t.Errorf("redirect server without timeouts: %+v", server)
+			}
+			w := httptest.NewRecorder()
+			server.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://forms.example.com/form/f", nil))
+			if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "https://forms.example.com/form/f" {
+				t.Errorf("status %d, Location %q", w.Code, w.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestValidateConfigHTTPSRedirect(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  ServerConfig
+		wantErr bool
+	}{
+		{"redirect with TLS", ServerConfig{TLSCertFile: "cert.pem", TLSKeyFile: "key.pem", HTTPRedirectAddr: ":80"}, false},
+		{"redirect without TLS", ServerConfig{HTTPRedirectAddr: ":80"}, true},
+		{"bad hsts_max_age", ServerConfig{HSTSMaxAge: "a year"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateConfig(Configuration{Server: tt.server}); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateConfig = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
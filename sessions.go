@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Guards chatSessions, which handlers and the sweeper share
+var chatSessionsMu sync.Mutex
+
+// Each client has its own session of each form. Browsers are told apart by a
+// random ID in the session cookie; API clients that keep no cookies can send
+// the ID back in the X-GoChat-Session header instead.
+const (
+	sessionCookieName = "gochat_session"
+	sessionIDHeader   = "X-GoChat-Session"
+)
+
+// sessionKey names one client's session of one form
+type sessionKey struct {
+	Form   string
+	Client string
+}
+
+func newClientID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// validClientID accepts only IDs shaped like the ones newClientID issues
+func validClientID(id string) bool {
+	b, err := hex.DecodeString(id)
+	return err == nil && len(b) == 16
+}
+
+// clientID reads the caller's session ID, reporting false if it has none
+func clientID(r *http.Request) (string, bool) {
+	if id := r.Header.Get(sessionIDHeader); validClientID(id) {
+		return id, true
+	}
+	if c, err := r.Cookie(sessionCookieName); err == nil && validClientID(c.Value) {
+		return c.Value, true
+	}
+	return "", false
+}
+
+// ensureClientID returns the caller's session ID, issuing a new one in the
+// session cookie and header if it has none. It must be called before the
+// response is written.
+func ensureClientID(w http.ResponseWriter, r *http.Request, config Configuration) string {
+	if id, ok := clientID(r); ok {
+		return id
+	}
+	id := newClientID()
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     config.Path("/"),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	w.Header().Set(sessionIDHeader, id)
+	// Later lookups while handling this request find the new ID
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: id})
+	return id
+}
+
+// callerSession finds the caller's live session of the form without starting
+// one, or nil if there is none
+func callerSession(config Configuration, formName string, r *http.Request) *ChatSession {
+	id, ok := clientID(r)
+	if !ok {
+		return nil
+	}
+	chatSessionsMu.Lock()
+	defer chatSessionsMu.Unlock()
+	session := chatSessions[sessionKey{formName, id}]
+	if session == nil || config.sessionLimits().expired(session, time.Now()) {
+		return nil
+	}
+	return session
+}
+
+// replaceSession makes session the caller's session of the form, as resume
+// and prefill do, without touching anyone else's
+func replaceSession(w http.ResponseWriter, r *http.Request, config Configuration, formName string, session *ChatSession) {
+	key := sessionKey{formName, ensureClientID(w, r, config)}
+	chatSessionsMu.Lock()
+	old := chatSessions[key]
+	chatSessions[key] = session
+	chatSessionsMu.Unlock()
+	if old != nil {
+		old.cancelTurn()
+	}
+}
+
+// sessionLimits are the parsed idle and absolute session lifetimes; zero means no limit
+type sessionLimits struct {
+	idle   time.Duration
+	maxAge time.Duration
+}
+
+// Used when session_idle_ttl is not configured, so the sessions of clients
+// that never come back are let go
+const defaultSessionIdleTTL = 24 * time.Hour
+
+func (c Configuration) sessionLimits() sessionLimits {
+	idle, err := time.ParseDuration(c.SessionIdleTTL)
+	if err != nil || idle <= 0 {
+		idle = defaultSessionIdleTTL
+	}
+	maxAge, _ := time.ParseDuration(c.MaxSessionAge)
+	return sessionLimits{idle: idle, maxAge: maxAge}
+}
+
+// expired reports whether a session has been idle too long or has outlived its maximum age
+func (l sessionLimits) expired(session *ChatSession, now time.Time) bool {
+	if l.idle > 0 && now.Sub(session.LastActive) > l.idle {
+		return true
+	}
+	if l.maxAge > 0 && now.Sub(session.CreatedAt) > l.maxAge {
+		return true
+	}
+	return false
+}
+
+// sweepSessions drops every expired session. Callers must not hold chatSessionsMu.
+func sweepSessions(limits sessionLimits, now time.Time) {
+	chatSessionsMu.Lock()
+	defer chatSessionsMu.Unlock()
+	for key, session := range chatSessions {
+		if limits.expired(session, now) {
+			log.Printf("🧹 Expired chat session for form: %s", key.Form)
+			delete(chatSessions, key)
+		}
+	}
+}
+
+// startSessionSweeper periodically evicts expired sessions when any limit is configured
+func startSessionSweeper(config Configuration) {
+	limits := config.sessionLimits()
+	interval := time.Minute
+	for _, limit := range []time.Duration{limits.idle, limits.maxAge} {
+		if limit > 0 && limit/2 < interval {
+			interval = limit / 2
+		}
+	}
+	if limits.idle <= 0 && limits.maxAge <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for now := range ticker.C {
+			sweepSessions(limits, now)
+		}
+	}()
+}
+
+// lockSession takes the session's turn lock for one chat turn, following the
+// form's concurrent_turns policy. When it reports false the request has been
+// answered with 409 Conflict and the lock is not held.
+func lockSession(w http.ResponseWriter, config Configuration, formName string, session *ChatSession) bool {
+	if acquireTurn(config, formName, session) {
+		return true
+	}
+	http.Error(w, "Your previous message is still being processed", http.StatusConflict)
+	return false
+}
+
+// acquireTurn is lockSession for callers that answer a busy session their own
+// way: it waits for a turn in progress, or with concurrent_turns set to
+// reject reports false at once
+func acquireTurn(config Configuration, formName string, session *ChatSession) bool {
+	if config.FormByName(formName).ConcurrentTurns != "reject" {
+		session.turnMu.Lock()
+		return true
+	}
+	if session.turnMu.TryLock() {
+		return true
+	}
+	log.Printf("⏳ BUSY [%s]: rejecting message sent during another turn", formName)
+	return false
+}
+
+// Used when turn_interval_reply is not configured
+const defaultTurnIntervalReply = "One moment please..."
+
+// TurnIntervalReplyText is the reply to a turn sent within min_turn_interval
+func (c Configuration) TurnIntervalReplyText() string {
+	if c.TurnIntervalReply == "" {
+		return defaultTurnIntervalReply
+	}
+	return c.TurnIntervalReply
+}
+
+// throttleTurn reports whether a turn comes sooner than min_turn_interval
+// after the session's last one. Turns that may go ahead are recorded as the
+// last turn. The caller must hold the session's turn lock.
+func (s *ChatSession) throttleTurn(config Configuration, formName string, now time.Time) bool {
+	interval, _ := time.ParseDuration(config.MinTurnInterval)
+	if interval > 0 && !s.LastTurnAt.IsZero() && now.Sub(s.LastTurnAt) < interval {
+		log.Printf("🐇 THROTTLE [%s]: turn %s after the last one", formName, now.Sub(s.LastTurnAt).Round(time.Millisecond))
+		return true
+	}
+	s.LastTurnAt = now
+	return false
+}
+
+// Used when session_token_cap_message is not configured
+const defaultTokenCapMessage = "This conversation has reached its length limit. Please start over to continue."
+
+// TokenCapMessageText is the reply once a session has used max_session_tokens
+func (c Configuration) TokenCapMessageText() string {
+	if c.SessionTokenCapMessage == "" {
+		return defaultTokenCapMessage
+	}
+	return c.SessionTokenCapMessage
+}
+
+// tokenCapReached reports whether the session has used up max_session_tokens.
+// Sessions belong to one client, so one user's spend never ends another's conversation.
+func (s *ChatSession) tokenCapReached(config Configuration) bool {
+	return config.MaxSessionTokens > 0 && s.TokensUsed >= config.MaxSessionTokens
+}
+
+// addTokens counts a turn's tokens against the session, reporting whether
+// they took it to max_session_tokens. The caller must hold the session's turn lock.
+func (s *ChatSession) addTokens(config Configuration, formName string, tokens int) bool {
+	s.TokensUsed += tokens
+	if !s.tokenCapReached(config) {
+		return false
+	}
+	log.Printf("🪙 TOKEN CAP [%s]: session used %d tokens of %d, ending the conversation", formName, s.TokensUsed, config.MaxSessionTokens)
+	return true
+}
+
+// handleReset discards the caller's session of the form, so their next
+// message starts a new one
+func handleReset(w http.ResponseWriter, r *http.Request, formName string) {
+	var session *ChatSession
+	if id, ok := clientID(r); ok {
+		chatSessionsMu.Lock()
+		session = chatSessions[sessionKey{formName, id}]
+		delete(chatSessions, sessionKey{formName, id})
+		chatSessionsMu.Unlock()
+	}
+
+	if session != nil {
+		session.cancelTurn()
+		log.Printf("🔄 RESET [%s]: session discarded by client", formName)
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"reset": session != nil})
+}
+
+// beginTurn derives the context for a turn that handleCancel can cancel.
+// The returned func must be called when the turn ends.
+func (s *ChatSession) beginTurn(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	s.cancelMu.Lock()
+	s.cancel = cancel
+	s.cancelMu.Unlock()
+	return ctx, func() {
+		s.cancelMu.Lock()
+		s.cancel = nil
+		s.cancelMu.Unlock()
+		cancel()
+	}
+}
+
+// cancelTurn cancels the in-flight turn, reporting whether there was one
+func (s *ChatSession) cancelTurn() bool {
+	s.cancelMu.Lock()
+	defer s.cancelMu.Unlock()
+	if s.cancel == nil {
+		return false
+	}
+	s.cancel()
+	return true
+}
+
+// handleCancel aborts the pending model call of the caller's session. The
+// cancelled request answers with "cancelled": true and its user message
+// leaves the history.
+func handleCancel(w http.ResponseWriter, r *http.Request, config Configuration, formName string) {
+	session := callerSession(config, formName, r)
+
+	cancelled := session != nil && session.cancelTurn()
+	if cancelled {
+		log.Printf("🛑 CANCEL [%s]: cancel requested by client", formName)
+	}
+	json.NewEncoder(w).Encode(map[string]bool{"cancelled": cancelled})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionLimitsExpired(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		idle      string
+		maxAge    string
+		idleFor   time.Duration
+		createdAt time.Duration
+		want      bool
+	}{
+		{"active", "30m", "", time.Minute, 2 * time.Hour, false},
+		{"idle too long", "30m", "", time.Hour, 2 * time.Hour, true},
+		{"too old though active", "30m", "1h", time.Minute, 2 * time.Hour, true},
+		{"idle limit defaults to a day", "", "", 23 * time.Hour, 48 * time.Hour, false},
+		{"past the default idle limit", "", "", 25 * time.Hour, 48 * time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := Configuration{SessionIdleTTL: tt.idle, MaxSessionAge: tt.maxAge}.sessionLimits()
+			session := &ChatSession{LastActive: now.Add(-tt.idleFor), CreatedAt: now.Add(-tt.createdAt)}
+			if got := limits.expired(session, now); got != tt.want {
+				t.Errorf("expired = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSweepSessionsDropsOnlyExpired(t *testing.T) {
+	now := time.Now()
+	previous := chatSessions
+	t.Cleanup(func() { chatSessions = previous })
+	fresh := sessionKey{Form: "f", Client: "fresh"}
+	stale := sessionKey{Form: "f", Client: "stale"}
+	chatSessions = map[sessionKey]*ChatSession{
+		fresh: {LastActive: now, CreatedAt: now},
+		stale: {LastActive: now.Add(-time.Hour), CreatedAt: now.Add(-time.Hour)},
+	}
+
+	sweepSessions(sessionLimits{idle: 30 * time.Minute}, now)
+	if _, ok := chatSessions[fresh]; !ok {
+		t.Error("the active session was swept")
+	}
+	if _, ok := chatSessions[stale]; ok {
+		t.Error("the idle session was kept")
+	}
+}
+
+func TestLockSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		busy       bool
+		wantLocked bool
+		wantStatus int
+	}{
+		{"free", "reject", false, true, http.StatusOK},
+		{"busy, reject", "reject", true, false, http.StatusConflict},
+		{"busy, queue", "", true, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.ConcurrentTurns = tt.policy
+			config := testConfig(t, form)
+			session := &ChatSession{}
+			if tt.busy {
+				session.turnMu.Lock()
+				// A queued turn waits for the running one
+				time.AfterFunc(20*time.Millisecond, session.turnMu.Unlock)
+			}
+
+			w := httptest.NewRecorder()
+			locked := lockSession(w, config, "f", session)
+			if locked != tt.wantLocked || w.Code != tt.wantStatus {
+				t.Fatalf("lockSession = %v with status %d, want %v, %d", locked, w.Code, tt.wantLocked, tt.wantStatus)
+			}
+			if locked {
+				session.turnMu.Unlock()
+			}
+		})
+	}
+}
+
+func TestCancelInFlightTurn(t *testing.T) {
+	fakeChat(t)
+	arrived := make(chan struct{}, 1)
+	chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		arrived <- struct{}{}
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	})
+	config := testConfig(t, testForm("f"))
+	client := newClientID()
+	request := func(path, body string) map[string]interface{} {
+		r := postJSON(path, body)
+		r.Header.Set(sessionIDHeader, client)
+		w := httptest.NewRecorder()
+		if strings.HasSuffix(path, "/cancel") {
+			handleCancel(w, r, config, "f")
+		} else {
+			handleChat(w, r, config, "f")
+		}
+		var reply map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &reply)
+		return reply
+	}
+
+	if reply := request("/form/f/chat/cancel", ""); reply["cancelled"] != false {
+		t.Errorf("cancel with no turn running = %v", reply)
+	}
+
+	chat := make(chan map[string]interface{})
+	go func() { chat <- request("/form/f/chat", `{"message": "hello"}`) }()
+	<-arrived
+	if reply := request("/form/f/chat/cancel", ""); reply["cancelled"] != true {
+		t.Errorf("cancel during a turn = %v", reply)
+	}
+	if reply := <-chat; reply["cancelled"] != true {
+		t.Errorf("cancelled turn answered %v", reply)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/form/f", nil)
+	r.Header.Set(sessionIDHeader, client)
+	session := callerSession(config, "f", r)
+	if session == nil {
+		t.Fatal("the session is gone")
+	}
+	for _, msg := range session.Messages {
+		if msg.Role == "user" {
+			t.Errorf("cancelled message %q was kept in the history", msg.Content)
+		}
+	}
+}
+
+func TestThrottleTurn(t *testing.T) {
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		interval string
+		after    []time.Duration
+		want     []bool
+	}{
+		{"no interval", "", []time.Duration{0, 10 * time.Millisecond}, []bool{false, false}},
+		{"too soon", "1s", []time.Duration{0, 500 * time.Millisecond}, []bool{false, true}},
+		{"measured from the last allowed turn", "1s", []time.Duration{0, 500 * time.Millisecond, 1100 * time.Millisecond}, []bool{false, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{MinTurnInterval: tt.interval}
+			session := &ChatSession{}
+			for i, after := range tt.after {
+				if got := session.throttleTurn(config, "f", start.Add(after)); got != tt.want[i] {
+					t.Errorf("turn at +%s throttled = %v, want %v", after, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestThrottledTurnGetsTheIntervalReply(t *testing.T) {
+	fake := fakeChat(t, "SAY Hello")
+	config := testConfig(t, testForm("f"))
+	config.MinTurnInterval = "1m"
+	config.TurnIntervalReply = "Slow down"
+	client := newClientID()
+
+	var replies []interface{}
+	for i := 0; i < 2; i++ {
+		r := postJSON("/form/f/chat", `{"message": "hi"}`)
+		r.Header.Set(sessionIDHeader, client)
+		w := httptest.NewRecorder()
+		handleChat(w, r, config, "f")
+		var reply map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &reply)
+		replies = append(replies, reply["message"])
+	}
+	if replies[0] != "Hello" || replies[1] != "Slow down" || fake.calls() != 1 {
+		t.Errorf("replies %v after %d AI calls", replies, fake.calls())
+	}
+}
+
+func TestAddTokens(t *testing.T) {
+	tests := []struct {
+		name      string
+		cap       int
+		used      int
+		tokens    int
+		wantEnded bool
+	}{
+		{"no cap", 0, 1000, 500, false},
+		{"under the cap", 100, 10, 50, false},
+		{"reaches the cap", 100, 60, 40, true},
+		{"goes over the cap", 100, 60, 90, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Configuration{MaxSessionTokens: tt.cap}
+			session := &ChatSession{TokensUsed: tt.used}
+			if ended := session.addTokens(config, "f", tt.tokens); ended != tt.wantEnded || session.TokensUsed != tt.used+tt.tokens {
+				t.Errorf("addTokens = %v with %d used, want %v", ended, session.TokensUsed, tt.wantEnded)
+			}
+		})
+	}
+}
+
+func TestSessionTokenCapEndsTheConversation(t *testing.T) {
+	fake := fakeCompletions(t, `{"choices": [{"message": {"role": "assistant", "content": "SAY Hi"}, "finish_reason": "stop"}], "usage": {"total_tokens": 60}}`)
+	config := testConfig(t, testForm("f"))
+	config.MaxSessionTokens = 100
+	client := newClientID()
+
+	var replies []map[string]interface{}
+	for i := 0; i < 3; i++ {
+		r := postJSON("/form/f/chat", `{"message": "hi"}`)
+		r.Header.Set(sessionIDHeader, client)
+		w := httptest.NewRecorder()
+		handleChat(w, r, config, "f")
+		var reply map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &reply)
+		replies = append(replies, reply)
+	}
+	if replies[0]["ended"] != nil || replies[1]["ended"] != true || replies[1]["end_message"] != defaultTokenCapMessage {
+		t.Errorf("first turns %v, %v, want the second to end the conversation", replies[0], replies[1])
+	}
+	if replies[2]["message"] != defaultTokenCapMessage || replies[2]["ended"] != true || fake.calls() != 2 {
+		t.Errorf("capped turn %v after %d AI calls, want the cap message without a call", replies[2], fake.calls())
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variable holding the key share tokens are signed with
+const shareSecretEnv = "GOCHAT_SHARE_SECRET"
+
+// Used when share_expiry is not configured
+const defaultShareTTL = 7 * 24 * time.Hour
+
+// ShareTTL is how long a share link stays valid
+func (c Configuration) ShareTTL() time.Duration {
+	if d, err := time.ParseDuration(c.ShareExpiry); err == nil && d > 0 {
+		return d
+	}
+	return defaultShareTTL
+}
+
+// Share scopes: the record alone, or the record with its stored transcript
+const (
+	shareScopeRecord     = "record"
+	shareScopeTranscript = "transcript"
+)
+
+// shareClaims is what a share token grants: a read-only view of one record
+type shareClaims struct {
+	Form    string `json:"f"`
+	Key     string `json:"k"`
+	Scope   string `json:"s"`
+	Expires int64  `json:"e"`
+	// Identifies the token for revocation
+	ID string `json:"n"`
+}
+
+var errShareExpired = errors.New("share link expired")
+
+// The signing key, from GOCHAT_SHARE_SECRET or random for the life of the process
+var (
+	shareKeyOnce sync.Once
+	shareKey     []byte
+)
+
+func shareSigningKey() []byte {
+	shareKeyOnce.Do(func() {
+		if secret := os.Getenv(shareSecretEnv); secret != "" {
+			shareKey = []byte(secret)
+			return
+		}
+		log.Printf("⚠️ SHARE: %s is not set, share links will stop working on restart", shareSecretEnv)
+		shareKey = make([]byte, 32)
+		if _, err := rand.Read(shareKey); err != nil {
+			panic(err)
+		}
+	})
+	return shareKey
+}
+
+func shareSignature(payload string) string {
+	mac := hmac.New(sha256.New, shareSigningKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signShareToken encodes claims as <payload>.<signature>
+func signShareToken(claims shareClaims) string {
+	data, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + shareSignature(payload)
+}
+
+// parseShareToken checks a token's signature and expiry, returning
+// errShareExpired for a genuine token past its time
+func parseShareToken(token string, now time.Time) (shareClaims, error) {
+	var claims shareClaims
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(shareSignature(payload))) {
+		return claims, errors.New("invalid share token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err == nil {
+		err = json.Unmarshal(data, &claims)
+	}
+	if err != nil {
+		return claims, errors.New("invalid share token")
+	}
+	if now.Unix() >= claims.Expires {
+		return claims, errShareExpired
+	}
+	return claims, nil
+}
+
+// shareRevocations holds the IDs of revoked tokens until they would have
+// expired anyway, saved in <data_dir>/share_revoked.json
+type shareRevocations struct {
+	mu      sync.Mutex
+	path    string
+	revoked map[string]int64
+}
+
+var revokedShares = &shareRevocations{revoked: make(map[string]int64)}
+
+// configureShareLinks loads the revoked tokens when any form offers share links
+func configureShareLinks(config Configuration) {
+	for _, form := range config.Forms.Form {
+		if form.ShareLinks {
+			revokedShares.load(filepath.Join(dataDir(config), "share_revoked.json"))
+			shareSigningKey()
+			return
+		}
+	}
+}
+
+func (s *shareRevocations) load(path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.path = path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	if err := json.Unmarshal(data, &s.revoked); err != nil {
+		log.Printf("⚠️ SHARE: ignoring unreadable %s: %v", path, err)
+	}
+}
+
+func (s *shareRevocations) isRevoked(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.revoked[id]
+	return ok
+}
+
+// revoke records a token as revoked, dropping entries that have expired
+func (s *shareRevocations) revoke(claims shareClaims, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, expires := range s.revoked {
+		if now.Unix() >= expires {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[claims.ID] = claims.Expires
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(s.revoked)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0600)
+}
+
+// shareReply is returned when a share link is created
+type shareReply struct {
+	URL       string    `json:"url"`
+	Token     string    `json:"token"`
+	Scope     string    `json:"scope"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// handleCreateShare gives the owner of a saved record, shown by their signed
+// identity cookie, a link to a read-only view of it; ?scope=transcript adds
+// the conversation
+func handleCreateShare(w http.ResponseWriter, r *http.Request, config Configuration, formName string) {
+	form := config.FormByName(formName)
+	if !form.ShareLinks {
+		http.NotFound(w, r)
+		return
+	}
+	key, ok := recordOwner(config, form, r)
+	if !ok {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	scope := firstNonEmpty(r.URL.Query().Get("scope"), shareScopeRecord)
+	if scope != shareScopeRecord && (scope != shareScopeTranscript || !form.StoreTranscript) {
+		http.Error(w, "Bad request: scope must be record, or transcript for forms that store it", http.StatusBadRequest)
+		return
+	}
+	filename, err := storedRecordPath(config, formName, key)
+	if err != nil {
+		http.Error(w, "Invalid record key", http.StatusBadRequest)
+		return
+	}
+	if _, err := os.Stat(filename); err != nil {
+		http.Error(w, "Record not found", http.StatusNotFound)
+		return
+	}
+
+	var id [12]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		panic(err)
+	}
+	expiresAt := time.Now().Add(config.ShareTTL())
+	token := signShareToken(shareClaims{
+		Form:    formName,
+		Key:     key,
+		Scope:   scope,
+		Expires: expiresAt.Unix(),
+		ID:      hex.EncodeToString(id[:]),
+	})
+	log.Printf("🔗 SHARE [%s]: %s link created, expires %s", formName, scope, expiresAt.Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(shareReply{
+		URL:       strings.TrimRight(config.BaseURL, "/") + config.Path("/share/"+token),
+		Token:     token,
+		Scope:     scope,
+		ExpiresAt: expiresAt.UTC(),
+	})
+}
+
+// handleShare serves /share/{token}: GET renders the shared record read-only,
+// DELETE revokes the token for the record's owner or an admin. Expired and
+// revoked tokens get a 410.
+func handleShare(w http.ResponseWriter, r *http.Request, config Configuration) {
+	token := strings.TrimPrefix(r.URL.Path, "/share/")
+	claims, err := parseShareToken(token, time.Now())
+	if errors.Is(err, errShareExpired) {
+		http.Error(w, "This share link has expired", http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	var form ConfigurationForm
+	for _, f := range config.Forms.Form {
+		if f.Name == claims.Form {
+			form = f
+		}
+	}
+	if !form.ShareLinks {
+		http.NotFound(w, r)
+		return
+	}
+	if !formAvailable(w, r, config, form.Name) {
+		return
+	}
+	if revokedShares.isRevoked(claims.ID) {
+		http.Error(w, "This share link has been revoked", http.StatusGone)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		held, owner := recordOwner(config, form, r)
+		if !(owner && held == claims.Key) && !adminAuthorized(r) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err := revokedShares.revoke(claims, time.Now()); err != nil {
+			log.Printf("❌ SHARE [%s]: failed to save revocation: %v", form.Name, err)
+			http.Error(w, "Failed to revoke link", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("🔗 SHARE [%s]: link revoked", form.Name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"revoked": true})
+		return
+	}
+
+	filename, err := storedRecordPath(config, form.Name, claims.Key)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	record, err := readRecord(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "Record not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to read %s: %v", form.Name, filename, err)
+		http.Error(w, "Failed to read record", http.StatusInternalServerError)
+		return
+	}
+
+	pageHTML := templateHTML(config, "share_page", r)
+	if pageHTML == "" {
+		http.Error(w, "No share page configured", http.StatusNotFound)
+		return
+	}
+	var transcript []ChatMessage
+	if claims.Scope == shareScopeTranscript {
+		// Round trip so JSON and YAML records decode the same way
+		if data, err := json.Marshal(record["_transcript"]); err == nil {
+			json.Unmarshal(data, &transcript)
+		}
+	}
+	tmpl, err := template.New("share").Parse(pageHTML)
+	if err != nil {
+		log.Printf("Template error: %v", err)
+		http.Error(w, "Template error", http.StatusInternalServerError)
+		return
+	}
+	// Shared pages are for the people the link was sent to, not for search engines or caches
+	w.Header().Set("X-Robots-Tag", "noindex")
+	w.Header().Set("Cache-Control", "private, no-store")
+	if err := tmpl.Execute(w, map[string]interface{}{
+		"SiteTitle":    config.BrandingFor(form).SiteTitle,
+		"Branding":     config.BrandingFor(form),
+		"Form":         form.Name,
+		"SubmissionID": confirmationValue(record["_submission_id"]),
+		"SavedAt":      recordSavedAt(filename).Format("2006-01-02 15:04"),
+		"Fields":       confirmationFields(form, record),
+		"Transcript":   transcript,
+		"ExpiresAt":    time.Unix(claims.Expires, 0).Format("2006-01-02 15:04"),
+	}); err != nil {
+		log.Printf("Template error: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useRevocations gives the test its own revocation list saved under dir
+func useRevocations(t *testing.T, dir string) {
+	previous := revokedShares
+	revokedShares = &shareRevocations{revoked: make(map[string]int64)}
+	revokedShares.load(filepath.Join(dir, "share_revoked.json"))
+	t.Cleanup(func() { revokedShares = previous })
+}
+
+func TestParseShareToken(t *testing.T) {
+	now := time.Now()
+	claims := shareClaims{Form: "f", Key: "A1", Scope: shareScopeRecord, Expires: now.Add(time.Hour).Unix(), ID: "n1"}
+	valid := signShareToken(claims)
+	payload, signature, _ := strings.Cut(valid, ".")
+	forged := signShareToken(shareClaims{Form: "f", Key: "B2", Expires: claims.Expires})
+	tests := []struct {
+		name    string
+		token   string
+		at      time.Time
+		wantErr string
+	}{
+		{"valid", valid, now, ""},
+		{"expired", valid, now.Add(2 * time.Hour), errShareExpired.Error()},
+		{"payload swapped", strings.SplitN(forged, ".", 2)[0] + "." + signature, now, "invalid share token"},
+		{"signature altered", payload + "." + strings.ToUpper(signature), now, "invalid share token"},
+		{"no signature", payload, now, "invalid share token"},
+		{"garbage", "x.y", now, "invalid share token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseShareToken(tt.token, tt.at)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != claims {
+				t.Errorf("parseShareToken = %+v, %v; want %+v", got, err, claims)
+			}
+		})
+	}
+}
+
+func TestShareRevocationsPruneAndPersist(t *testing.T) {
+	dir := t.TempDir()
+	useRevocations(t, dir)
+	now := time.Now()
+	revokedShares.revoke(shareClaims{ID: "old", Expires: now.Add(-time.Minute).Unix()}, now)
+	revokedShares.revoke(shareClaims{ID: "live", Expires: now.Add(time.Hour).Unix()}, now)
+
+	reloaded := &shareRevocations{revoked: make(map[string]int64)}
+	reloaded.load(filepath.Join(dir, "share_revoked.json"))
+	if reloaded.isRevoked("old") || !reloaded.isRevoked("live") {
+		t.Errorf("reloaded revocations = %v, want only live", reloaded.revoked)
+	}
+}
+
+func TestHandleCreateShare(t *testing.T) {
+	form := testForm("f")
+	form.ShareLinks = true
+	config := testConfig(t, form, testForm("closed"))
+	owner := savedRecord(t, config, "f", map[string]string{"FirstName": "Ann", "License": "A1"})
+	tests := []struct {
+		name       string
+		form       string
+		cookie     *http.Cookie
+		query      string
+		wantStatus int
+	}{
+		{"owner", "f", owner, "", http.StatusOK},
+		{"no identity", "f", nil, "", http.StatusForbidden},
+		{"transcript not stored", "f", owner, "?scope=transcript", http.StatusBadRequest},
+		{"unknown scope", "f", owner, "?scope=everything", http.StatusBadRequest},
+		{"share links off", "closed", owner, "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/"+tt.form+"/share"+tt.query, nil)
+			if tt.cookie != nil {
+				r.AddCookie(tt.cookie)
+			}
+			w := httptest.NewRecorder()
+			handleCreateShare(w, r, config, tt.form)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var reply shareReply
+			json.NewDecoder(w.Body).Decode(&reply)
+			claims, err := parseShareToken(reply.Token, time.Now())
+			if err != nil || claims.Form != "f" || claims.Key != "A1" || claims.Scope != shareScopeRecord {
+				t.Errorf("token claims = %+v, %v", claims, err)
+			}
+			if !strings.HasSuffix(reply.URL, "/share/"+reply.Token) {
+				t.Errorf("url = %q", reply.URL)
+			}
+		})
+	}
+}
+
+func TestHandleShare(t *testing.T) {
+	t.Setenv(adminTokenEnv, "letmein")
+	form := testForm("f")
+	form.ShareLinks = true
+	config := testConfig(t, form, testForm("closed"))
+	page := `<templates><template name="share_page">` +
+		`<![CDATA[{{.Form}}{{range .Fields}}|{{.Label}}={{.Value}}{{end}}]]></template></templates>`
+	if err := xml.Unmarshal([]byte(page), &config.Templates); err != nil {
+		t.Fatal(err)
+	}
+	owner := savedRecord(t, config, "f", map[string]string{"FirstName": "Ann", "License": "A1"})
+	stranger := savedRecord(t, config, "f", map[string]string{"FirstName": "Bob", "License": "B2"})
+	expires := time.Now().Add(time.Hour).Unix()
+	token := func(form, id string, expires int64) string {
+		return signShareToken(shareClaims{Form: form, Key: "A1", Scope: shareScopeRecord, Expires: expires, ID: id})
+	}
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		cookie     *http.Cookie
+		admin      bool
+		wantStatus int
+	}{
+		{"view", http.MethodGet, token("f", "v", expires), nil, false, http.StatusOK},
+		{"expired", http.MethodGet, token("f", "e", time.Now().Add(-time.Minute).Unix()), nil, false, http.StatusGone},
+		{"forged", http.MethodGet, token("f", "x", expires) + "x", nil, false, http.StatusNotFound},
+		{"share links off", http.MethodGet, token("closed", "c", expires), nil, false, http.StatusNotFound},
+		{"revoke by stranger", http.MethodDelete, token("f", "s", expires), stranger, false, http.StatusForbidden},
+		{"revoke anonymously", http.MethodDelete, token("f", "s", expires), nil, false, http.StatusForbidden},
+		{"revoke by owner", http.MethodDelete, token("f", "o", expires), owner, false, http.StatusOK},
+		{"revoke by admin", http.MethodDelete, token("f", "a", expires), nil, true, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useRevocations(t, t.TempDir())
+			serve := func(method string) *httptest.ResponseRecorder {
+				r := httptest.NewRequest(method, "/share/"+tt.token, nil)
+				if tt.cookie != nil {
+					r.AddCookie(tt.cookie)
+				}
+				if tt.admin {
+					r.Header.Set("Authorization", "Bearer letmein")
+				}
+				w := httptest.NewRecorder()
+				handleShare(w, r, config)
+				return w
+			}
+			w := serve(tt.method)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			switch {
+			case tt.method == http.MethodGet && w.Code == http.StatusOK:
+				if body := w.Body.String(); !strings.Contains(body, "=Ann") || strings.Contains(body, "Bob") {
+					t.Errorf("page = %q, want only Ann's record", body)
+				}
+				if w.Header().Get("X-Robots-Tag") != "noindex" {
+					t.Errorf("shared page may be indexed")
+				}
+			case tt.method == http.MethodDelete && w.Code == http.StatusOK:
+				if after := serve(http.MethodGet); after.Code != http.StatusGone {
+					t.Errorf("revoked link status = %d, want %d", after.Code, http.StatusGone)
+				}
+			}
+		})
+	}
+}
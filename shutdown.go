@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Used when shutdown_message and shutdown_timeout are not configured
+const (
+	defaultShutdownMessage = "We're restarting. Your progress is saved, so you can pick up where you left off in a moment."
+	defaultShutdownTimeout = 10 * time.Second
+)
+
+// ShutdownMessageText is what in-flight conversations are told when the server stops
+func (c Configuration) ShutdownMessageText() string {
+	return firstNonEmpty(c.ShutdownMessage, defaultShutdownMessage)
+}
+
+// ShutdownTimeoutDuration is how long in-flight requests get to finish
+func (c Configuration) ShutdownTimeoutDuration() time.Duration {
+	if d, err := time.ParseDuration(c.ShutdownTimeout); err == nil && d > 0 {
+		return d
+	}
+	return defaultShutdownTimeout
+}
+
+// Closed when the server starts shutting down, so in-flight turns can say so
+var (
+	shutdownNotice = make(chan struct{})
+	shutdownOnce   sync.Once
+)
+
+// turnNotice is what the user is told after a turn's reply: the turn's own
+// notice, and the shutdown message once the server is stopping
+func turnNotice(config Configuration, turn *turnResult) string {
+	var notices []string
+	if turn.Notice != "" {
+		notices = append(notices, turn.Notice)
+	}
+	if shuttingDown() {
+		notices = append(notices, config.ShutdownMessageText())
+	}
+	return strings.Join(notices, " ")
+}
+
+// beginShutdown marks the server as stopping
+func beginShutdown() {
+	shutdownOnce.Do(func() { close(shutdownNotice) })
+}
+
+// shuttingDown reports whether beginShutdown has been called
+func shuttingDown() bool {
+	select {
+	case <-shutdownNotice:
+		return true
+	default:
+		return false
+	}
+}
+
+// draftDir holds a form's sessions drafted at shutdown, one file per client,
+// inside the form's folder so they go with its records
+func draftDir(config Configuration, formName string) string {
+	return filepath.Join(formDataDir(config, formName), "drafts")
+}
+
+// draftSessions writes every session holding at least shutdown_draft_min_fields
+// values to a draft, so restoreDrafts can bring it back after the restart.
+// A session whose turn is still running when the shutdown timeout ran out is
+// skipped rather than drafted half-updated.
+func draftSessions(config Configuration) int {
+	if config.ChatOnly() || config.ShutdownDraftMinFields < 0 {
+		return 0
+	}
+	minFields := config.ShutdownDraftMinFields
+	if minFields == 0 {
+		minFields = 1
+	}
+	chatSessionsMu.Lock()
+	defer chatSessionsMu.Unlock()
+	drafted := 0
+	for key, session := range chatSessions {
+		if !session.turnMu.TryLock() {
+			log.Printf("⚠️ SHUTDOWN [%s]: not drafting a session whose turn is still running", key.Form)
+			continue
+		}
+		filled := 0
+		for _, value := range session.FormData {
+			if strings.TrimSpace(value) != "" {
+				filled++
+			}
+		}
+		if filled < minFields {
+			session.turnMu.Unlock()
+			continue
+		}
+		err := writeDraft(config, key, session)
+		session.turnMu.Unlock()
+		if err != nil {
+			log.Printf("❌ SHUTDOWN [%s]: failed to draft session: %v", key.Form, err)
+			continue
+		}
+		drafted++
+		log.Printf("📝 SHUTDOWN [%s]: drafted session with %d values", key.Form, filled)
+	}
+	return drafted
+}
+
+// writeDraft saves one session through a temporary file
+func writeDraft(config Configuration, key sessionKey, session *ChatSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(draftDir(config, key.Form), 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(draftDir(config, key.Form), key.Client+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// restoreDrafts loads the sessions drafted at the last shutdown and removes
+// the drafts; drafts of forms no longer configured are left alone
+func restoreDrafts(config Configuration) {
+	if config.ChatOnly() {
+		return
+	}
+	chatSessionsMu.Lock()
+	defer chatSessionsMu.Unlock()
+	for _, form := range config.Forms.Form {
+		paths, _ := filepath.Glob(filepath.Join(draftDir(config, form.Name), "*.json"))
+		for _, path := range paths {
+			client := strings.TrimSuffix(filepath.Base(path), ".json")
+			if !validClientID(client) {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			var session ChatSession
+			if err == nil {
+				err = json.Unmarshal(data, &session)
+			}
+			if err != nil {
+				log.Printf("⚠️ DRAFT [%s]: ignoring unreadable draft: %v", form.Name, err)
+				continue
+			}
+			session.LastActive = time.Now()
+			chatSessions[sessionKey{form.Name, client}] = &session
+			os.Remove(path)
+			log.Printf("📝 DRAFT [%s]: restored session from the last shutdown", form.Name)
+		}
+	}
+}
+
+// serveUntilSignalled runs the server until SIGINT or SIGTERM, then tells
+// in-flight conversations, lets their requests finish and drafts the sessions
+func serveUntilSignalled(server *http.Server, config Configuration) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	finished := make(chan error, 1)
+	redirect := redirectServer(config)
+	go func() {
+		sig := <-stop
+		log.Printf("🛑 SHUTDOWN: %v received, finishing in-flight requests", sig)
+		beginShutdown()
+		ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeoutDuration())
+		defer cancel()
+		if redirect != nil {
+			redirect.Shutdown(ctx)
+		}
+		err := server.Shutdown(ctx)
+		log.Printf("🛑 SHUTDOWN: drafted %d sessions", draftSessions(config))
+		finished <- err
+	}()
+	if err := serve(server, redirect, config); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-finished
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// useShutdown gives the test its own shutdown state, started when stopping is set
+func useShutdown(t *testing.T, stopping bool) {
+	savedNotice := shutdownNotice
+	t.Cleanup(func() {
+		shutdownNotice = savedNotice
+		shutdownOnce = sync.Once{}
+	})
+	shutdownNotice = make(chan struct{})
+	shutdownOnce = sync.Once{}
+	if stopping {
+		beginShutdown()
+	}
+}
+
+func TestTurnNotice(t *testing.T) {
+	tests := []struct {
+		name     string
+		notice   string
+		stopping bool
+		want     string
+	}{
+		{"nothing to say", "", false, ""},
+		{"turn notice", "Slow today.", false, "Slow today."},
+		{"shutting down", "", true, "Back soon."},
+		{"both", "Slow today.", true, "Slow today. Back soon."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useShutdown(t, tt.stopping)
+			config := Configuration{ShutdownMessage: "Back soon."}
+			if got := turnNotice(config, &turnResult{Notice: tt.notice}); got != tt.want {
+				t.Errorf("turnNotice = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDraftSessionsAreRestored(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		minFields int
+		want      []string
+	}{
+		{"default of one value", "", 0, []string{"one", "two"}},
+		{"two values", "", 2, []string{"two"}},
+		{"drafts off", "", -1, nil},
+		{"chat mode", "chat", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := chatSessions
+			t.Cleanup(func() { chatSessions = previous })
+			clients := map[string]string{"none": newClientID(), "one": newClientID(), "two": newClientID()}
+			chatSessions = map[sessionKey]*ChatSession{
+				{"f", clients["none"]}: {FormData: map[string]string{"FirstName": "  "}},
+				{"f", clients["one"]}:  {FormData: map[string]string{"FirstName": "Ann"}},
+				{"f", clients["two"]}:  {FormData: map[string]string{"FirstName": "Bo", "License": "B2"}},
+			}
+			config := testConfig(t, testForm("f"))
+			config.Mode = tt.mode
+			config.ShutdownDraftMinFields = tt.minFields
+
+			if drafted := draftSessions(config); drafted != len(tt.want) {
+				t.Errorf("drafted %d sessions, want %d", drafted, len(tt.want))
+			}
+			chatSessions = map[sessionKey]*ChatSession{}
+			restoreDrafts(config)
+			var restored []string
+			for name, client := range clients {
+				if session, ok := chatSessions[sessionKey{"f", client}]; ok && !session.LastActive.IsZero() {
+					restored = append(restored, name)
+				}
+			}
+			sort.Strings(restored)
+			if !reflect.DeepEqual(restored, tt.want) {
+				t.Errorf("restored %v, want %v", restored, tt.want)
+			}
+			if left, _ := filepath.Glob(filepath.Join(draftDir(config, "f"), "*")); len(left) != 0 {
+				t.Errorf("drafts left behind: %v", left)
+			}
+		})
+	}
+}
+
+func TestRestoreDraftsSkipsBadFiles(t *testing.T) {
+	previous := chatSessions
+	t.Cleanup(func() { chatSessions = previous })
+	chatSessions = map[sessionKey]*ChatSession{}
+	config := testConfig(t, testForm("f"))
+	os.MkdirAll(draftDir(config, "f"), 0755)
+	os.WriteFile(filepath.Join(draftDir(config, "f"), "not-a-client.json"), []byte(`{}`), 0644)
+	os.WriteFile(filepath.Join(draftDir(config, "f"), newClientID()+".json"), []byte(`not json`), 0644)
+
+	restoreDrafts(config)
+	if len(chatSessions) != 0 {
+		t.Errorf("restored %v", chatSessions)
+	}
+}
+
+// Run with -race: drafting must not read a session while its turn changes it
+func TestDraftSessionsSkipsTurnsInFlight(t *testing.T) {
+	previous := chatSessions
+	t.Cleanup(func() { chatSessions = previous })
+	chatSessions = map[sessionKey]*ChatSession{}
+	fake := fakeChat(t, "SET FirstName Ann\nSAY Your license?")
+	config := testConfig(t, testForm("f"))
+	client := newClientID()
+	chat := func() {
+		r := postJSON("/form/f/chat", `{"message": "Ann"}`)
+		r.Header.Set(sessionIDHeader, client)
+		handleChat(httptest.NewRecorder(), r, config, "f")
+	}
+	chat()
+	if fake.calls() != 1 {
+		t.Fatalf("%d AI calls, want 1", fake.calls())
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		close(started)
+		<-release
+		return jsonResponse(r, http.StatusOK, completion("SET License A1\nSAY Thanks")), nil
+	})
+	done := make(chan struct{})
+	go func() {
+		chat()
+		close(done)
+	}()
+	<-started
+	if drafted := draftSessions(config); drafted != 0 {
+		t.Errorf("drafted %d sessions with a turn in flight, want 0", drafted)
+	}
+	close(release)
+	<-done
+	if drafted := draftSessions(config); drafted != 1 {
+		t.Fatalf("drafted %d sessions after the turn, want 1", drafted)
+	}
+	data, _ := os.ReadFile(filepath.Join(draftDir(config, "f"), client+".json"))
+	var draft ChatSession
+	if err := json.Unmarshal(data, &draft); err != nil || draft.FormData["License"] != "A1" {
+		t.Errorf("draft = %s, %v; want the finished turn's values", data, err)
+	}
+}
+
+func TestDraftsLiveInTheFormFolder(t *testing.T) {
+	config := testConfig(t, testForm("f"))
+	if dir, err := filepath.Rel(formDataDir(config, "f"), draftDir(config, "f")); err != nil || dir != "drafts" {
+		t.Errorf("drafts in %s, outside the form folder %s", draftDir(config, "f"), formDataDir(config, "f"))
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SinkRetryConfig keeps failed sink deliveries in a queue on disk and retries
+// them with exponential backoff, moving them to a dead-letter file once they
+// have failed max_attempts times
+type SinkRetryConfig struct {
+	// Delivery attempts in all, the first one included; zero disables the queue
+	MaxAttempts int `xml:"max_attempts"`
+	// Wait before the first retry, doubled after each failure (default 30s)
+	Backoff string `xml:"backoff"`
+	// Longest wait between retries (default 1h)
+	MaxBackoff string `xml:"max_backoff"`
+}
+
+// Used when the retry waits are not configured
+const (
+	defaultSinkBackoff    = 30 * time.Second
+	defaultSinkMaxBackoff = time.Hour
+)
+
+// BackoffDuration parses the first retry wait, falling back to the default
+func (c SinkRetryConfig) BackoffDuration() time.Duration {
+	if d, err := time.ParseDuration(c.Backoff); err == nil && d > 0 {
+		return d
+	}
+	return defaultSinkBackoff
+}
+
+// MaxBackoffDuration parses the longest retry wait, falling back to the default
+func (c SinkRetryConfig) MaxBackoffDuration() time.Duration {
+	if d, err := time.ParseDuration(c.MaxBackoff); err == nil && d > 0 {
+		return d
+	}
+	return defaultSinkMaxBackoff
+}
+
+// queuedDelivery is one failed delivery waiting to be retried
+type queuedDelivery struct {
+	ID          string     `json:"id"`
+	Sink        string     `json:"sink"`
+	Record      SinkRecord `json:"record"`
+	Attempts    int        `json:"attempts"`
+	NextAttempt time.Time  `json:"next_attempt"`
+	LastError   string     `json:"last_error"`
+}
+
+// deliveryQueue keeps each queued delivery as a JSON file in dir, so the
+// queue survives a restart, and appends given-up deliveries to deadLetter
+type deliveryQueue struct {
+	// Guards the queue files; it is never held while a sink is called, so a
+	// slow sink doesn't hold up saves that queue a failed delivery
+	mu sync.Mutex
+	// Lets one pass of process run at a time, so no item is retried twice
+	draining    sync.Mutex
+	dir         string
+	deadLetter  string
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+}
+
+// Global sink retry queue, nil when sink_retry is not configured
+var sinkQueue *deliveryQueue
+
+// configureSinkQueue creates the retry queue when it is enabled and starts
+// the worker that drains it
+func configureSinkQueue(config Configuration) {
+	retry := config.SinkRetry
+	if retry.MaxAttempts <= 0 || config.ChatOnly() {
+		return
+	}
+	sinkQueue = &deliveryQueue{
+		dir:         filepath.Join(dataDir(config), "sink_queue"),
+		deadLetter:  filepath.Join(dataDir(config), "sink_deadletter.jsonl"),
+		maxAttempts: retry.MaxAttempts,
+		backoff:     retry.BackoffDuration(),
+		maxBackoff:  retry.MaxBackoffDuration(),
+	}
+	go sinkQueue.run(sinkQueue.backoff)
+}
+
+// delay is the wait after a delivery has failed attempts times
+func (q *deliveryQueue) delay(attempts int) time.Duration {
+	d := q.backoff
+	for i := 1; i < attempts && d < q.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > q.maxBackoff {
+		d = q.maxBackoff
+	}
+	return d
+}
+
+// deliverRecord sends a record to the named sink, queueing it for a retry
+// when the delivery fails and the queue is enabled
+func deliverRecord(name string, record SinkRecord) error {
+	err := sinks[name].Deliver(record)
+	if err != nil && sinkQueue != nil {
+		sinkQueue.enqueue(name, record, err, time.Now())
+	}
+	return err
+}
+
+// enqueue stores a delivery whose first attempt failed
+func (q *deliveryQueue) enqueue(name string, record SinkRecord, cause error, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := queuedDelivery{
+		ID:        strings.ReplaceAll(newSubmissionID(), "-", ""),
+		Sink:      name,
+		Record:    record,
+		Attempts:  1,
+		LastError: cause.Error(),
+	}
+	q.reschedule(item, now)
+}
+
+// reschedule writes a failed delivery back to the queue, or to the dead-letter
+// file once it has used up its attempts; the caller holds mu
+func (q *deliveryQueue) reschedule(item queuedDelivery, now time.Time) {
+	if item.Attempts >= q.maxAttempts {
+		log.Printf("☠️ SINK [%s]: giving up on delivery to %s after %d attempts: %s", item.Record.Form, item.Sink, item.Attempts, item.LastError)
+		if err := q.bury(item); err != nil {
+			log.Printf("❌ SINK: failed to write dead letter: %v", err)
+		}
+		os.Remove(q.itemPath(item.ID))
+		return
+	}
+	item.NextAttempt = now.Add(q.delay(item.Attempts))
+	if err := q.write(item); err != nil {
+		log.Printf("❌ SINK [%s]: failed to queue delivery to %s: %v", item.Record.Form, item.Sink, err)
+		return
+	}
+	log.Printf("🔁 SINK [%s]: delivery to %s queued, retry at %s", item.Record.Form, item.Sink, item.NextAttempt.Format(time.RFC3339))
+}
+
+func (q *deliveryQueue) itemPath(id string) string {
+	return filepath.Join(q.dir, id+".json")
+}
+
+// write saves an item through a temporary file so a crash never leaves half of one
+func (q *deliveryQueue) write(item queuedDelivery) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(q.dir, 0755); err != nil {
+		return err
+	}
+	tmp := q.itemPath(item.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.itemPath(item.ID))
+}
+
+// bury appends an item to the dead-letter file
+func (q *deliveryQueue) bury(item queuedDelivery) error {
+	line, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(q.deadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// run retries the due deliveries every interval
+func (q *deliveryQueue) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		q.process(now)
+	}
+}
+
+// process retries each queued delivery that is due, oldest first. The due
+// items are read under mu and delivered without it, so saves that queue a
+// failed delivery don't wait on a slow sink.
+func (q *deliveryQueue) process(now time.Time) {
+	q.draining.Lock()
+	defer q.draining.Unlock()
+	for _, item := range q.due(now) {
+		sink, ok := sinks[item.Sink]
+		if !ok {
+			item.LastError = "sink is no longer configured"
+			item.Attempts = q.maxAttempts
+			q.retryFailed(item, now)
+			continue
+		}
+		item.Attempts++
+		if err := sink.Deliver(item.Record); err != nil {
+			item.LastError = err.Error()
+			q.retryFailed(item, now)
+			continue
+		}
+		q.mu.Lock()
+		os.Remove(q.itemPath(item.ID))
+		q.mu.Unlock()
+		log.Printf("📤 SINK [%s]: delivered to %s from the retry queue after %d attempts", item.Record.Form, item.Sink, item.Attempts)
+	}
+}
+
+// retryFailed reschedules an item whose retry failed
+func (q *deliveryQueue) retryFailed(item queuedDelivery, now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.reschedule(item, now)
+}
+
+// due reads the queued deliveries that are due, oldest first
+func (q *deliveryQueue) due(now time.Time) []queuedDelivery {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	paths, err := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	if err != nil {
+		return nil
+	}
+	var due []queuedDelivery
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var item queuedDelivery
+		if err := json.Unmarshal(data, &item); err != nil {
+			log.Printf("⚠️ SINK: ignoring unreadable queue entry %s: %v", path, err)
+			continue
+		}
+		if !now.Before(item.NextAttempt) {
+			due = append(due, item)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].NextAttempt.Before(due[j].NextAttempt) })
+	return due
+}
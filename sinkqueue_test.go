@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// flakySink fails its first failures deliveries
+type flakySink struct {
+	failures  int
+	attempts  int
+	delivered []SinkRecord
+}
+
+func (s *flakySink) Deliver(record SinkRecord) error {
+	s.attempts++
+	if s.attempts <= s.failures {
+		return errors.New("sink down")
+	}
+	s.delivered = append(s.delivered, record)
+	return nil
+}
+
+func testQueue(t *testing.T, maxAttempts int) *deliveryQueue {
+	dir := t.TempDir()
+	return &deliveryQueue{
+		dir:         filepath.Join(dir, "sink_queue"),
+		deadLetter:  filepath.Join(dir, "sink_deadletter.jsonl"),
+		maxAttempts: maxAttempts,
+		backoff:     time.Second,
+		maxBackoff:  5 * time.Second,
+	}
+}
+
+func TestDeliveryQueueDelay(t *testing.T) {
+	q := testQueue(t, 10)
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 9: 5 * time.Second} {
+		if got := q.delay(attempts); got != want {
+			t.Errorf("delay(%d) = %s, want %s", attempts, got, want)
+		}
+	}
+}
+
+func TestDeliveryQueueRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		failures      int
+		sinkName      string
+		wantDelivered bool
+		wantRetries   int
+		wantBuried    bool
+	}{
+		{"delivered on the first retry", 0, "crm", true, 1, false},
+		{"delivered on the last attempt", 1, "crm", true, 2, false},
+		{"gives up after max attempts", 5, "crm", false, 2, true},
+		{"sink no longer configured", 0, "gone", false, 0, true},
+	}
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &flakySink{failures: tt.failures}
+			useSinks(t, map[string]Sink{"crm": sink})
+			q := testQueue(t, 3)
+			record := SinkRecord{Form: "f", Data: map[string]string{"License": "A1"}}
+			q.enqueue(tt.sinkName, record, errors.New("sink down"), start)
+
+			// Nothing is due before the backoff has passed
+			q.process(start.Add(time.Second / 2))
+			if sink.attempts != 0 {
+				t.Fatalf("retried before the backoff: %d attempts", sink.attempts)
+			}
+			for _, after := range []time.Duration{time.Second, 3 * time.Second, 10 * time.Second} {
+				q.process(start.Add(after))
+			}
+			if (len(sink.delivered) == 1) != tt.wantDelivered || sink.attempts != tt.wantRetries {
+				t.Errorf("delivered %d records in %d retries, want delivered %v in %d", len(sink.delivered), sink.attempts, tt.wantDelivered, tt.wantRetries)
+			}
+			if queued, _ := filepath.Glob(filepath.Join(q.dir, "*.json")); len(queued) != 0 {
+				t.Errorf("still queued: %v", queued)
+			}
+			dead, _ := os.ReadFile(q.deadLetter)
+			if buried := strings.Count(string(dead), "\n") == 1; buried != tt.wantBuried {
+				t.Errorf("dead letters %q, want buried %v", dead, tt.wantBuried)
+			}
+		})
+	}
+}
+
+func TestDeliverRecordQueuesFailures(t *testing.T) {
+	useSinks(t, map[string]Sink{"up": &flakySink{}, "down": &flakySink{failures: 1}})
+	saved := sinkQueue
+	sinkQueue = testQueue(t, 3)
+	t.Cleanup(func() { sinkQueue = saved })
+
+	for _, name := range []string{"up", "down"} {
+		deliverRecord(name, SinkRecord{Form: "f"})
+	}
+	queued := sinkQueue.due(time.Now().Add(time.Minute))
+	if len(queued) != 1 || queued[0].Sink != "down" || queued[0].Attempts != 1 || queued[0].LastError != "sink down" {
+		t.Errorf("queued %+v, want the failed delivery to down", queued)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SinkConfig declares a named destination that saved forms can be delivered to
+type SinkConfig struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"` // "webhook" or "file"
+	URL  string `xml:"url"`
+	Path string `xml:"path"`
+}
+
+// SinkRecord is what a sink receives when a form is saved, or when the model
+// issues a custom command routed to the sink
+type SinkRecord struct {
+	Form     string            `json:"form"`
+	Data     map[string]string `json:"data"`
+	SavedAt  time.Time         `json:"saved_at"`
+	Command  string            `json:"command,omitempty"`
+	Argument string            `json:"argument,omitempty"`
+}
+
+// Sink delivers saved form records somewhere outside the data directory
+type Sink interface {
+	Deliver(record SinkRecord) error
+}
+
+// webhookSink POSTs each record as JSON
+type webhookSink struct {
+	url string
+}
+
+var sinkClient = &http.Client{Timeout: 10 * time.Second}
+
+func (s webhookSink) Deliver(record SinkRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	resp, err := sinkClient.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// Check sends a HEAD request; any answer short of a server error means the
+// endpoint is reachable, since many webhooks only accept POST
+func (s webhookSink) Check() error {
+	req, err := http.NewRequest(http.MethodHead, s.url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("webhook %s returned %s", s.url, resp.Status)
+	}
+	return nil
+}
+
+// fileSink appends each record as a JSON line to <path>/<form>.jsonl
+type fileSink struct {
+	dir string
+}
+
+func (s fileSink) Deliver(record SinkRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, record.Form+".jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// Check makes sure a file can be created in the sink's directory
+func (s fileSink) Check() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.dir, ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func newSink(config SinkConfig) (Sink, error) {
+	switch config.Type {
+	case "webhook":
+		if strings.TrimSpace(config.URL) == "" {
+			return nil, fmt.Errorf("sink %s: webhook needs a url", config.Name)
+		}
+		return webhookSink{url: strings.TrimSpace(config.URL)}, nil
+	case "file":
+		if strings.TrimSpace(config.Path) == "" {
+			return nil, fmt.Errorf("sink %s: file needs a path", config.Name)
+		}
+		return fileSink{dir: strings.TrimSpace(config.Path)}, nil
+	default:
+		return nil, fmt.Errorf("sink %s: unknown type %q", config.Name, config.Type)
+	}
+}
+
+// Global named sink registry, built from configuration at startup
+var sinks = make(map[string]Sink)
+
+// sinkChecker is a sink that can test it is reachable without delivering a record
+type sinkChecker interface {
+	Check() error
+}
+
+// sinkStatus is the result of a sink's last health check
+type sinkStatus struct {
+	Healthy   bool      `json:"healthy"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Last health check of each sink, reported on /healthz
+var (
+	sinkHealthMu sync.Mutex
+	sinkHealth   = make(map[string]sinkStatus)
+)
+
+// checkSinks runs every sink's health check and records the results
+func checkSinks(registry map[string]Sink) {
+	for name, sink := range registry {
+		checker, ok := sink.(sinkChecker)
+		if !ok {
+			continue
+		}
+		status := sinkStatus{Healthy: true, CheckedAt: time.Now().UTC()}
+		if err := checker.Check(); err != nil {
+			status = sinkStatus{Error: err.Error(), CheckedAt: status.CheckedAt}
+			log.Printf("❌ SINK: health check of %s failed: %v", name, err)
+		}
+		sinkHealthMu.Lock()
+		sinkHealth[name] = status
+		sinkHealthMu.Unlock()
+	}
+}
+
+// sinkHealthReport copies the latest sink health results
+func sinkHealthReport() map[string]sinkStatus {
+	sinkHealthMu.Lock()
+	defer sinkHealthMu.Unlock()
+	report := make(map[string]sinkStatus, len(sinkHealth))
+	for name, status := range sinkHealth {
+		report[name] = status
+	}
+	return report
+}
+
+// startSinkHealthChecks checks the sinks at startup and then every
+// sink_health_interval, when one is configured
+func startSinkHealthChecks(config Configuration, registry map[string]Sink) {
+	interval, _ := time.ParseDuration(config.SinkHealthInterval)
+	if interval <= 0 || len(registry) == 0 {
+		return
+	}
+	go func() {
+		checkSinks(registry)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			checkSinks(registry)
+		}
+	}()
+}
+
+func buildSinks(config Configuration) (map[string]Sink, error) {
+	registry := make(map[string]Sink)
+	for _, sc := range config.Sinks.Sink {
+		if _, exists := registry[sc.Name]; exists {
+			return nil, fmt.Errorf("duplicate sink name %q", sc.Name)
+		}
+		sink, err := newSink(sc)
+		if err != nil {
+			return nil, err
+		}
+		registry[sc.Name] = sink
+	}
+	for _, form := range config.Forms.Form {
+		for _, name := range splitFieldList(form.Sinks) {
+			if _, ok := registry[name]; !ok {
+				return nil, fmt.Errorf("form %s: unknown sink %q", form.Name, name)
+			}
+		}
+		for _, c := range form.Commands.Command {
+			if _, ok := registry[c.Sink]; !ok {
+				return nil, fmt.Errorf("form %s: command %s uses unknown sink %q", form.Name, c.Verb, c.Sink)
+			}
+		}
+	}
+	return registry, nil
+}
+
+// deliverToSinks sends a saved record to each of the form's sinks.
+// The record is already saved, so failures are logged, and queued for a retry
+// when sink_retry is configured.
+func deliverToSinks(form ConfigurationForm, formData map[string]string) {
+	names := splitFieldList(form.Sinks)
+	if len(names) == 0 {
+		return
+	}
+
+	data := make(map[string]string, len(formData))
+	for k, v := range formData {
+		data[k] = v
+	}
+	record := SinkRecord{Form: form.Name, Data: data, SavedAt: time.Now().UTC()}
+
+	go func() {
+		for _, name := range names {
+			if err := deliverRecord(name, record); err != nil {
+				log.Printf("❌ SINK [%s]: delivery to %s failed: %v", form.Name, name, err)
+				continue
+			}
+			log.Printf("📤 SINK [%s]: delivered to %s", form.Name, name)
+		}
+	}()
+}
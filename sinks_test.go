@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordingSink hands each delivered record to a channel
+type recordingSink chan SinkRecord
+
+func (s recordingSink) Deliver(record SinkRecord) error {
+	s <- record
+	return nil
+}
+
+// useSinks installs registry as the global sinks for the rest of the test
+func useSinks(t *testing.T, registry map[string]Sink) {
+	t.Helper()
+	previous := sinks
+	t.Cleanup(func() { sinks = previous })
+	sinks = registry
+}
+
+func TestNewSink(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  SinkConfig
+		wantErr string
+	}{
+		{"webhook", SinkConfig{Name: "crm", Type: "webhook", URL: " https://example.com/hook "}, ""},
+		{"webhook without a url", SinkConfig{Name: "crm", Type: "webhook"}, "needs a url"},
+		{"file", SinkConfig{Name: "log", Type: "file", Path: "/tmp/records"}, ""},
+		{"file without a path", SinkConfig{Name: "log", Type: "file", Path: "  "}, "needs a path"},
+		{"unknown type", SinkConfig{Name: "q", Type: "queue"}, "unknown type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink, err := newSink(tt.config)
+			if tt.wantErr == "" {
+				if err != nil || sink == nil {
+					t.Fatalf("newSink = %v, %v, want a sink", sink, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("newSink error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBuildSinksChecksFormReferences(t *testing.T) {
+	tests := []struct {
+		name    string
+		sinks   []SinkConfig
+		form    string
+		wantErr string
+	}{
+		{"known sink", []SinkConfig{{Name: "log", Type: "file", Path: "x"}}, "log", ""},
+		{"no sinks", nil, "", ""},
+		{"unknown sink", []SinkConfig{{Name: "log", Type: "file", Path: "x"}}, "log, crm", `unknown sink "crm"`},
+		{"duplicate name", []SinkConfig{{Name: "log", Type: "file", Path: "x"}, {Name: "log", Type: "file", Path: "y"}}, "", "duplicate sink name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var config Configuration
+			config.Sinks.Sink = tt.sinks
+			config.Forms.Form = []ConfigurationForm{{Name: "f", Sinks: tt.form}}
+			_, err := buildSinks(config)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("buildSinks: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("buildSinks error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFileSinkAppendsJSONLines(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "records")
+	sink := fileSink{dir: dir}
+	for _, license := range []string{"A1", "B2"} {
+		if err := sink.Deliver(SinkRecord{Form: "f", Data: map[string]string{"License": license}}); err != nil {
+			t.Fatalf("Deliver: %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "f.jsonl"))
+	if err != nil {
+		t.Fatalf("reading the sink file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("sink file has %d lines, want 2:\n%s", len(lines), content)
+	}
+	var record SinkRecord
+	if err := json.Unmarshal([]byte(lines[1]), &record); err != nil || record.Data["License"] != "B2" {
+		t.Errorf("second line = %s (%v), want the B2 record", lines[1], err)
+	}
+}
+
+func TestCheckSinks(t *testing.T) {
+	saved := sinkHealth
+	sinkHealth = make(map[string]sinkStatus)
+	t.Cleanup(func() { sinkHealth = saved })
+
+	status := func(code int) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+		t.Cleanup(server.Close)
+		return server.URL
+	}
+	blocked := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(blocked, nil, 0644)
+
+	tests := []struct {
+		name        string
+		sink        Sink
+		wantHealthy bool
+		wantChecked bool
+	}{
+		{"webhook up", webhookSink{url: status(http.StatusOK)}, true, true},
+		{"webhook that only takes POST", webhookSink{url: status(http.StatusMethodNotAllowed)}, true, true},
+		{"webhook failing", webhookSink{url: status(http.StatusBadGateway)}, false, true},
+		{"writable directory", fileSink{dir: filepath.Join(t.TempDir(), "out")}, true, true},
+		{"directory under a file", fileSink{dir: filepath.Join(blocked, "out")}, false, true},
+		{"sink without a check", make(recordingSink), false, false},
+	}
+	registry := make(map[string]Sink)
+	for _, tt := range tests {
+		registry[tt.name] = tt.sink
+	}
+	checkSinks(registry)
+	report := sinkHealthReport()
+	for _, tt := range tests {
+		got, checked := report[tt.name]
+		if checked != tt.wantChecked || got.Healthy != tt.wantHealthy || checked && got.Healthy != (got.Error == "") {
+			t.Errorf("%s: status %+v (checked %v), want healthy %v", tt.name, got, checked, tt.wantHealthy)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+type sitemapURL struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+// publicForms are the forms that opted in to search engine indexing
+func publicForms(config Configuration) []ConfigurationForm {
+	var forms []ConfigurationForm
+	for _, form := range config.Forms.Form {
+		if form.Public && form.IsEnabled() {
+			forms = append(forms, form)
+		}
+	}
+	return forms
+}
+
+// handleSitemap lists the home page and the public forms
+func handleSitemap(w http.ResponseWriter, r *http.Request, config Configuration) {
+	if !allowMethods(w, r, config, http.MethodGet, http.MethodHead) {
+		return
+	}
+	set := sitemapURLSet{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+	set.URLs = append(set.URLs, sitemapURL{Loc: strings.TrimRight(config.BaseURL, "/") + config.Path("/")})
+	for _, form := range publicForms(config) {
+		set.URLs = append(set.URLs, sitemapURL{Loc: config.FormURL(form.Name)})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(set)
+}
+
+// handleRobots allows crawling of the public forms only, keeping the chat,
+// QR and operational endpoints and every other form out of search engines
+func handleRobots(w http.ResponseWriter, r *http.Request, config Configuration) {
+	if !allowMethods(w, r, config, http.MethodGet, http.MethodHead) {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("User-agent: *\n")
+	for _, form := range publicForms(config) {
+		fmt.Fprintf(&b, "Allow: %s$\n", config.Path("/form/"+form.Name))
+	}
+	for _, path := range []string{"/form/", "/qr/", "/healthz"} {
+		fmt.Fprintf(&b, "Disallow: %s\n", config.Path(path))
+	}
+	fmt.Fprintf(&b, "Sitemap: %s\n", strings.TrimRight(config.BaseURL, "/")+config.Path("/sitemap.xml"))
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
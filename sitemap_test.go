@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func sitemapConfig() Configuration {
+	disabled := false
+	config := Configuration{BaseURL: "https://example.com", BasePath: "/gochat"}
+	config.Forms.Form = []ConfigurationForm{
+		{Name: "signup", Public: true},
+		{Name: "internal"},
+		{Name: "retired", Public: true, Enabled: &disabled},
+	}
+	return config
+}
+
+func TestSitemapListsPublicForms(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleSitemap(w, httptest.NewRequest(http.MethodGet, "/sitemap.xml", nil), sitemapConfig())
+	var set sitemapURLSet
+	if err := xml.Unmarshal(w.Body.Bytes(), &set); err != nil {
+		t.Fatalf("sitemap is not XML: %v\n%s", err, w.Body)
+	}
+	var locs []string
+	for _, u := range set.URLs {
+		locs = append(locs, u.Loc)
+	}
+	want := []string{"https://example.com/gochat/", "https://example.com/gochat/form/signup"}
+	if !reflect.DeepEqual(locs, want) {
+		t.Errorf("sitemap lists %v, want %v", locs, want)
+	}
+}
+
+func TestRobots(t *testing.T) {
+	w := httptest.NewRecorder()
+	handleRobots(w, httptest.NewRequest(http.MethodGet, "/robots.txt", nil), sitemapConfig())
+	want := "User-agent: *\n" +
+		"Allow: /gochat/form/signup$\n" +
+		"Disallow: /gochat/form/\n" +
+		"Disallow: /gochat/qr/\n" +
+		"Disallow: /gochat/healthz\n" +
+		"Sitemap: https://example.com/gochat/sitemap.xml\n"
+	if got := w.Body.String(); got != want {
+		t.Errorf("robots.txt =\n%s\nwant\n%s", got, want)
+	}
+}
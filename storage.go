@@ -0,0 +1,215 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default location for saved forms when no data_dir is configured
+const defaultDataDir = "forms"
+
+func dataDir(config Configuration) string {
+	if config.DataDir == "" {
+		return defaultDataDir
+	}
+	return config.DataDir
+}
+
+// formDataDir is the directory holding everything stored for one form,
+// so a single form can be purged by removing its folder.
+func formDataDir(config Configuration, formName string) string {
+	return filepath.Join(dataDir(config), formName)
+}
+
+// Used when a form has no primary_key
+const defaultPrimaryKey = "License"
+
+// primaryKeyFields are the fields a form's records are keyed by; a composite
+// key such as License,VisitDate lists several
+func primaryKeyFields(form ConfigurationForm) []string {
+	return splitFieldList(firstNonEmpty(form.PrimaryKey, defaultPrimaryKey))
+}
+
+// recordKey is the key the session's record is saved under: the primary key's
+// value, or the values of a composite key joined by "_". It is empty while
+// any of them is.
+func recordKey(form ConfigurationForm, formData map[string]string) string {
+	var parts []string
+	for _, field := range primaryKeyFields(form) {
+		value := strings.TrimSpace(formData[field])
+		if value == "" {
+			return ""
+		}
+		parts = append(parts, value)
+	}
+	return strings.Join(parts, "_")
+}
+
+// formRecordPath is the file a record with the given key is saved to.
+// Keys come from cookies and model output, so they must not escape the form directory.
+func formRecordPath(config Configuration, formName, key string) (string, error) {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return "", fmt.Errorf("%w %q", errInvalidRecordKey, key)
+	}
+	return filepath.Join(formDataDir(config, formName), key+config.recordFormat().ext), nil
+}
+
+// storedRecordPath is the file the record with the given key is read from.
+// It is formRecordPath unless only a copy in the other format exists, as it
+// does for records saved before save_format was changed, or only one in the
+// flat layout used before each form had its own directory,
+// <data_dir>/<form>-<key>.json.
+func storedRecordPath(config Configuration, formName, key string) (string, error) {
+	filename, err := formRecordPath(config, formName, key)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nil
+	}
+	for _, format := range recordFormats {
+		other := strings.TrimSuffix(filename, config.recordFormat().ext) + format.ext
+		if _, err := os.Stat(other); err == nil {
+			return other, nil
+		}
+	}
+	legacy := filepath.Join(dataDir(config), formName+"-"+key+".json")
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy, nil
+	}
+	return filename, nil
+}
+
+// isRecordFile reports whether a file in a form's directory is a saved record,
+// in either format
+func isRecordFile(name string) bool {
+	for _, format := range recordFormats {
+		if filepath.Ext(name) == format.ext {
+			return true
+		}
+	}
+	return false
+}
+
+// recordFormat is how saved records are encoded on disk
+type recordFormat struct {
+	ext       string
+	marshal   func(v interface{}) ([]byte, error)
+	unmarshal func(data []byte, v interface{}) error
+}
+
+var recordFormats = map[string]recordFormat{
+	"json": {
+		ext: ".json",
+		marshal: func(v interface{}) ([]byte, error) {
+			return json.MarshalIndent(v, "", "    ")
+		},
+		unmarshal: json.Unmarshal,
+	},
+	"yaml": {ext: ".yaml", marshal: yaml.Marshal, unmarshal: yaml.Unmarshal},
+}
+
+// recordFormat is the configured save_format, JSON unless set to yaml
+func (c Configuration) recordFormat() recordFormat {
+	if format, ok := recordFormats[c.SaveFormat]; ok {
+		return format
+	}
+	return recordFormats["json"]
+}
+
+// readRecord decodes a saved record in the format given by its extension
+func readRecord(filename string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	format := recordFormats["json"]
+	if filepath.Ext(filename) == recordFormats["yaml"].ext {
+		format = recordFormats["yaml"]
+	}
+	var record map[string]interface{}
+	if err := format.unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return record, nil
+}
+
+// checkDataDirWritable creates and removes a scratch file in the data
+// directory, so a read-only deployment is caught at startup rather than when
+// the first user tries to save.
+func checkDataDirWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	_, writeErr := f.Write([]byte("ok"))
+	closeErr := f.Close()
+	removeErr := os.Remove(name)
+	for _, err := range []error{writeErr, closeErr, removeErr} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stripRecordMetadata removes the underscore prefixed keys (such as
+// _transcript) from a saved record, leaving only form fields for use as
+// context, as JSON
+func stripRecordMetadata(record map[string]interface{}) string {
+	fields := make(map[string]interface{}, len(record))
+	for k, v := range record {
+		if !strings.HasPrefix(k, "_") {
+			fields[k] = v
+		}
+	}
+	stripped, err := json.MarshalIndent(fields, "", "    ")
+	if err != nil {
+		return ""
+	}
+	return string(stripped)
+}
+
+// recordSaveCount is how many times a stored record has been saved; records
+// from before the count was kept count once, and a missing record not at all
+func recordSaveCount(record map[string]interface{}) int {
+	if record == nil {
+		return 0
+	}
+	switch n := record["_saves"].(type) {
+	case float64:
+		return int(n)
+	case int:
+		return n
+	}
+	return 1
+}
+
+// Submission IDs use an unambiguous alphabet (no I, L, O or U) so they can be read out
+const submissionIDAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newSubmissionID returns a random reference such as 7K2QD-M9XAF
+func newSubmissionID() string {
+	var b [10]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	id := make([]byte, 0, len(b)+1)
+	for i, c := range b {
+		if i == len(b)/2 {
+			id = append(id, '-')
+		}
+		id = append(id, submissionIDAlphabet[int(c)%len(submissionIDAlphabet)])
+	}
+	return string(id)
+}
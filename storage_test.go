@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRecordKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		primaryKey string
+		data       map[string]string
+		want       string
+	}{
+		{"single field", "License", map[string]string{"License": "555-55-5555"}, "555-55-5555"},
+		{"defaults to License", "", map[string]string{"License": "A1"}, "A1"},
+		{"composite", "License,VisitDate", map[string]string{"License": "A1", "VisitDate": "2024-05-01"}, "A1_2024-05-01"},
+		{"composite with a part missing", "License,VisitDate", map[string]string{"License": "A1"}, ""},
+		{"blank value", "License", map[string]string{"License": "  "}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := ConfigurationForm{PrimaryKey: tt.primaryKey}
+			if got := recordKey(form, tt.data); got != tt.want {
+				t.Errorf("recordKey = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormRecordPathRejectsEscapingKeys(t *testing.T) {
+	config := testConfig(t, testForm("f"))
+	tests := []struct {
+		key     string
+		wantErr bool
+	}{
+		{"A1", false},
+		{"", true},
+		{".", true},
+		{"..", true},
+		{"../other/A1", true},
+		{`..\A1`, true},
+	}
+	for _, tt := range tests {
+		path, err := formRecordPath(config, "f", tt.key)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("formRecordPath(%q) = %q, %v", tt.key, path, err)
+		}
+		if err != nil && !errors.Is(err, errInvalidRecordKey) {
+			t.Errorf("formRecordPath(%q) error %v is not errInvalidRecordKey", tt.key, err)
+		}
+		if err == nil && path != filepath.Join(config.DataDir, "f", tt.key+".json") {
+			t.Errorf("formRecordPath(%q) = %q", tt.key, path)
+		}
+	}
+}
+
+func TestStoredRecordPathFallsBackToOlderLayouts(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		exists []string
+		want   string
+	}{
+		{"configured format", "json", []string{"f/A1.json"}, "f/A1.json"},
+		{"saved before switching to yaml", "yaml", []string{"f/A1.json"}, "f/A1.json"},
+		{"both formats prefer the configured one", "yaml", []string{"f/A1.json", "f/A1.yaml"}, "f/A1.yaml"},
+		{"flat layout", "json", []string{"f-A1.json"}, "f-A1.json"},
+		{"nothing saved", "yaml", nil, "f/A1.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, testForm("f"))
+			config.SaveFormat = tt.format
+			for _, name := range tt.exists {
+				path := filepath.Join(config.DataDir, name)
+				os.MkdirAll(filepath.Dir(path), 0755)
+				if err := os.WriteFile(path, []byte("{}"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := storedRecordPath(config, "f", "A1")
+			if err != nil || got != filepath.Join(config.DataDir, tt.want) {
+				t.Errorf("storedRecordPath = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveSessionKeysRecordsByPrimaryKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		primaryKey string
+		legacy     string
+		want       string
+	}{
+		{"single key", "License", "", "f/A1.json"},
+		{"composite key", "License,VisitDate", "", "f/A1_2024-05-01.json"},
+		{"moves a flat layout record", "License", "f-A1.json", "f/A1.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.PrimaryKey = tt.primaryKey
+			config := testConfig(t, form)
+			if tt.legacy != "" {
+				os.WriteFile(filepath.Join(config.DataDir, tt.legacy), []byte(`{"_submission_id": "OLD-1"}`), 0644)
+			}
+			session := &ChatSession{FormData: map[string]string{"License": "A1", "VisitDate": "2024-05-01"}}
+			if err := saveSession(config, "f", session, newTurnResult(config, "f", session)); err != nil {
+				t.Fatal(err)
+			}
+			record, err := readRecord(filepath.Join(config.DataDir, tt.want))
+			if err != nil {
+				t.Fatalf("record not saved as %s: %v", tt.want, err)
+			}
+			if tt.legacy != "" {
+				if record["_submission_id"] != "OLD-1" {
+					t.Errorf("submission ID %v was not carried over", record["_submission_id"])
+				}
+				if _, err := os.Stat(filepath.Join(config.DataDir, tt.legacy)); !os.IsNotExist(err) {
+					t.Errorf("%s was left behind", tt.legacy)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckDataDirWritable(t *testing.T) {
+	root := t.TempDir()
+	blocker := filepath.Join(root, "file")
+	if err := os.WriteFile(blocker, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		dir     string
+		wantErr bool
+	}{
+		{"existing", root, false},
+		{"created on demand", filepath.Join(root, "forms", "nested"), false},
+		{"under a regular file", filepath.Join(blocker, "forms"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDataDirWritable(tt.dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkDataDirWritable(%s) = %v, want error %v", tt.dir, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			entries, _ := os.ReadDir(tt.dir)
+			for _, e := range entries {
+				if strings.HasPrefix(e.Name(), ".write-check-") {
+					t.Errorf("scratch file %s was left behind", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestSavedTranscriptIsLeftOutOfContext(t *testing.T) {
+	form := testForm("f")
+	form.StoreTranscript = true
+	config := testConfig(t, form)
+	session := &ChatSession{
+		FormData: map[string]string{"License": "A1"},
+		Messages: []ChatMessage{{Role: "user", Content: "hello"}},
+	}
+	if err := saveSession(config, "f", session, newTurnResult(config, "f", session)); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := storedRecordPath(config, "f", "A1")
+	record, err := readRecord(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := record["_transcript"]; !ok {
+		t.Fatalf("record %v has no _transcript", record)
+	}
+	if context := stripRecordMetadata(record); strings.Contains(context, "_transcript") || !strings.Contains(context, "A1") {
+		t.Errorf("context = %s, want the fields without the transcript", context)
+	}
+}
+
+func TestNewSubmissionID(t *testing.T) {
+	format := regexp.MustCompile(`^[0-9ABCDEFGHJKMNPQRSTVWXYZ]{5}-[0-9ABCDEFGHJKMNPQRSTVWXYZ]{5}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := newSubmissionID()
+		if !format.MatchString(id) {
+			t.Fatalf("submission ID %q is not like 7K2QD-M9XAF", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("only %d distinct IDs in 100", len(seen))
+	}
+}
+
+func TestSubmissionIDIsKeptAcrossSaves(t *testing.T) {
+	config := testConfig(t, testForm("f"))
+	session := &ChatSession{FormData: map[string]string{"FirstName": "Ann", "License": "A1"}}
+	var ids []string
+	for _, name := range []string{"Ann", "Anne"} {
+		session.FormData["FirstName"] = name
+		if err := saveSession(config, "f", session, newTurnResult(config, "f", session)); err != nil {
+			t.Fatal(err)
+		}
+		path, _ := storedRecordPath(config, "f", "A1")
+		record, _ := readRecord(path)
+		id, _ := record["_submission_id"].(string)
+		ids = append(ids, id)
+	}
+	if ids[0] == "" || ids[0] != ids[1] || session.SubmissionID != ids[0] {
+		t.Errorf("submission IDs %q, session has %q", ids, session.SubmissionID)
+	}
+	if len(session.Messages) != 1 || !strings.Contains(session.Messages[0].Content, ids[0]) {
+		t.Errorf("history %+v, want one note of the submission ID", session.Messages)
+	}
+}
+
+func TestIsRecordFile(t *testing.T) {
+	for name, want := range map[string]bool{"A1.json": true, "A1.yaml": true, "prompts.jsonl": false, "A1.yml": false, ".write-check-1": false} {
+		if got := isRecordFile(name); got != want {
+			t.Errorf("isRecordFile(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestYAMLRecordsRoundTrip(t *testing.T) {
+	form := testForm("f")
+	form.Fields += "\nAllergies: {{.Allergies}} [list]"
+	config := testConfig(t, form)
+	session := &ChatSession{FormData: map[string]string{"FirstName": "Ann", "License": "A1", "Allergies": `["dust"]`}}
+	if err := saveSession(config, "f", session, newTurnResult(config, "f", session)); err != nil {
+		t.Fatal(err)
+	}
+	jsonID := session.SubmissionID
+
+	// Switching to YAML moves the record on its next save
+	config.SaveFormat = "yaml"
+	if err := saveSession(config, "f", session, newTurnResult(config, "f", session)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(config.DataDir, "f", "A1.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the JSON record was left behind: %v", err)
+	}
+	record, err := readRecord(filepath.Join(config.DataDir, "f", "A1.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record["FirstName"] != "Ann" || record["_submission_id"] != jsonID {
+		t.Errorf("record = %v, want the fields and submission ID %s", record, jsonID)
+	}
+	if allergies, ok := record["Allergies"].([]interface{}); !ok || len(allergies) != 1 || allergies[0] != "dust" {
+		t.Errorf("Allergies = %#v, want a YAML list", record["Allergies"])
+	}
+}
+
+func TestRecordSaveCount(t *testing.T) {
+	tests := []struct {
+		name   string
+		record map[string]interface{}
+		want   int
+	}{
+		{"no record", nil, 0},
+		{"record from before the count", map[string]interface{}{"License": "A1"}, 1},
+		{"decoded from JSON", map[string]interface{}{"_saves": 3.0}, 3},
+		{"decoded from YAML", map[string]interface{}{"_saves": 2}, 2},
+	}
+	for _, tt := range tests {
+		if got := recordSaveCount(tt.record); got != tt.want {
+			t.Errorf("%s: recordSaveCount = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMaxSavesPerKey(t *testing.T) {
+	tests := []struct {
+		name      string
+		limit     int
+		policy    string
+		wantErr   error
+		wantFirst string
+	}{
+		{"no limit", 0, "", nil, "Cy"},
+		{"under the limit", 3, "", nil, "Cy"},
+		{"rejected", 2, "reject", errSaveLimitReached, "Bo"},
+		{"ignored", 2, "ignore", nil, "Bo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.MaxSavesPerKey = tt.limit
+			form.SaveLimitPolicy = tt.policy
+			config := testConfig(t, form)
+			session := &ChatSession{FormData: map[string]string{"License": "A1"}}
+			var err error
+			for _, name := range []string{"Al", "Bo", "Cy"} {
+				session.FormData["FirstName"] = name
+				err = saveSession(config, "f", session, newTurnResult(config, "f", session))
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("third save = %v, want %v", err, tt.wantErr)
+			}
+			path, _ := storedRecordPath(config, "f", "A1")
+			record, _ := readRecord(path)
+			if record["FirstName"] != tt.wantFirst || session.SubmissionID == "" {
+				t.Errorf("stored %v with submission ID %q", record, session.SubmissionID)
+			}
+		})
+	}
+}
+
+func TestSaveOverTheLimitIsAConflict(t *testing.T) {
+	fakeChat(t, "SET FirstName Ann\nSET License A1\nSAVE")
+	form := testForm("f")
+	form.MaxSavesPerKey = 1
+	config := testConfig(t, form)
+	var codes []int
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handleChat(w, postJSON("/form/f/chat", `{"message": "save it"}`), config, "f")
+		codes = append(codes, w.Code)
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusConflict {
+		t.Errorf("statuses %v, want 200 then 409", codes)
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// streamedReply is what a streaming completion adds up to
+type streamedReply struct {
+	Content string
+	// The thinking channel, streamed apart from the content by reasoning models
+	Reasoning string
+	Usage     TokenUsage
+	// Whether it was replayed from the response cache
+	Cached bool
+}
+
+// response is the reply as a completed ChatResponse, for the response cache
+func (r streamedReply) response(model string) *ChatResponse {
+	var choice ChatChoice
+	choice.Message.Role = "assistant"
+	choice.Message.Content = r.Content
+	choice.Message.ReasoningContent = r.Reasoning
+	choice.FinishReason = "stop"
+	return &ChatResponse{Choices: []ChatChoice{choice}, Model: model, Usage: r.Usage, Attempts: 1}
+}
+
+// streamChatGPT calls the completions API in streaming mode, handing each
+// content delta to onDelta as it arrives. It returns the full response.
+// request_timeout bounds the wait for the response and for each chunk after
+// it, rather than the whole stream.
+func streamChatGPT(ctx context.Context, config Configuration, messages []ChatMessage, onDelta func(string)) (reply streamedReply, err error) {
+	keepWaiting := func() {}
+	if timeout := config.RequestTimeoutDuration(); timeout > 0 {
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		timedOut := fmt.Errorf("%w: no data from the AI service for %s", context.DeadlineExceeded, timeout)
+		timer := time.AfterFunc(timeout, func() { cancel(timedOut) })
+		keepWaiting = func() { timer.Reset(timeout) }
+		defer func() {
+			timer.Stop()
+			if err != nil && context.Cause(ctx) == timedOut {
+				err = timedOut
+			}
+			cancel(nil)
+		}()
+	}
+	req, err := newChatGPTRequest(ctx, config, messages, true)
+	if err != nil {
+		return reply, err
+	}
+
+	resp, err := doChatRequest(req)
+	if err != nil {
+		return reply, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		storeRawResponse(config, resp, body, ".json")
+		return reply, fmt.Errorf("streaming request failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	// The raw event stream is kept for debug_store_responses, up to the store's limit
+	var raw bytes.Buffer
+	var body io.Reader = resp.Body
+	if config.DebugStoreResponses {
+		body = io.TeeReader(resp.Body, &limitedWriter{w: &raw, n: config.DebugStoreLimit()})
+		defer func() { storeRawResponse(config, resp, raw.Bytes(), ".sse") }()
+	}
+
+	var content, reasoning strings.Builder
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		keepWaiting()
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		data := strings.TrimPrefix(line, "data: ")
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk struct {
+			Choices []struct {
+				Delta struct {
+					Content          string `json:"content"`
+					ReasoningContent string `json:"reasoning_content"`
+					Reasoning        string `json:"reasoning"`
+				} `json:"delta"`
+			} `json:"choices"`
+			// Only in the last chunk, when stream_options asks for it
+			Usage *TokenUsage `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			reply.Content, reply.Reasoning = content.String(), reasoning.String()
+			return reply, err
+		}
+		if chunk.Usage != nil {
+			reply.Usage = *chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			reasoning.WriteString(firstNonEmpty(choice.Delta.ReasoningContent, choice.Delta.Reasoning))
+			if choice.Delta.Content != "" {
+				content.WriteString(choice.Delta.Content)
+				onDelta(choice.Delta.Content)
+			}
+		}
+	}
+	reply.Content, reply.Reasoning = content.String(), reasoning.String()
+	return reply, scanner.Err()
+}
+
+// commandLineBuffer collects streamed text and releases it one complete line at a time
+type commandLineBuffer struct {
+	partial string
+}
+
+// Write adds a delta and returns any lines it completed
+func (b *commandLineBuffer) Write(delta string) []string {
+	b.partial += delta
+	var lines []string
+	for {
+		idx := strings.IndexByte(b.partial, '\n')
+		if idx == -1 {
+			return lines
+		}
+		lines = append(lines, b.partial[:idx])
+		b.partial = b.partial[idx+1:]
+	}
+}
+
+// Flush returns whatever trailing text never got a newline
+func (b *commandLineBuffer) Flush() string {
+	line := b.partial
+	b.partial = ""
+	return line
+}
+
+// sseWriter writes server-sent events to a streaming response.
+// It is safe for concurrent use so keepalives can interleave with events.
+type sseWriter struct {
+	mu        sync.Mutex
+	w         http.ResponseWriter
+	flusher   http.Flusher
+	nextID    int
+	lastWrite time.Time
+	// Keeps the events for a reconnecting client, when streams are resumable
+	buffer *streamBuffer
+}
+
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseWriter{w: w, flusher: flusher, lastWrite: time.Now()}, true
+}
+
+// Send writes one event with a JSON payload and flushes it to the client
+func (s *sseWriter) Send(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	id := s.nextID
+	if s.buffer != nil {
+		s.buffer.add(bufferedEvent{id: id, event: event, payload: payload})
+	}
+	s.mu.Unlock()
+	return s.writeEvent(id, event, payload)
+}
+
+// writeEvent writes an encoded event with the given id and flushes it
+func (s *sseWriter) writeEvent(id int, event string, payload []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprintf(s.w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, payload); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	s.lastWrite = time.Now()
+	return nil
+}
+
+// keepalive writes an SSE comment whenever the stream has been quiet for
+// interval, until stop is closed. Clients ignore comments, but proxies see
+// traffic and keep the connection open during a slow model response.
+func (s *sseWriter) keepalive(interval time.Duration, stop <-chan struct{}) {
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.mu.Lock()
+			if time.Since(s.lastWrite) >= interval {
+				if _, err := fmt.Fprint(s.w, ": keepalive\n\n"); err == nil {
+					s.flusher.Flush()
+					s.lastWrite = time.Now()
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// handleChatStream is the streaming variant of handleChat. Commands are applied
+// as soon as each line of the response is complete, so the client sees events:
+//
+//	typing  {"typing": true, "resume_token"} as soon as the request is accepted
+//	update  {"Field": "value"}           for each SET
+//	message {"message": "..."}           for each SAY
+//	slow    {"message": "..."}           if nothing has arrived within response_budget
+//	done    {"message", "updates", "saved", "submission_id", "cookie", "meta", "cancelled", "throttled", "suggestions", "quick_replies", "ended", "end_message"} once the response is finished
+//	error   {"message": "..."}           if the turn failed
+//
+// Every stream starts with typing and ends with exactly one done or error.
+//
+// Headers are already sent when SAVE is applied, so the identity cookie is
+// returned in the done event for the client to set.
+//
+// With stream_resume set, the events are buffered under the resume_token and
+// the turn carries on if the client disconnects, so it can reconnect through
+// handleStreamResume and replay what it missed.
+func handleChatStream(w http.ResponseWriter, r *http.Request, config Configuration, formName string) {
+	log.Printf("=== Streaming chat request received for form: %s ===", formName)
+	config = applyModelOverrides(config, formName, r)
+
+	chatReq, err := decodeChatRequest(r, config)
+	if err == nil {
+		err = checkMessageLength(config.FormByName(formName), chatReq.Message)
+	}
+	if err != nil {
+		writeDecodeError(w, r, config, formName, err)
+		return
+	}
+
+	reply, canned := cannedReply(config, formName, chatReq.Message)
+	var session *ChatSession
+	if !canned {
+		session = getOrCreateSession(w, config, formName, r)
+		if !lockSession(w, config, formName, session) {
+			return
+		}
+		defer session.turnMu.Unlock()
+	}
+
+	sse, ok := newSSEWriter(w)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("👤 USER [%s]: %s", formName, chatReq.Message)
+
+	parent := r.Context()
+	typing := map[string]interface{}{"typing": true}
+	if ttl := config.StreamResumeTTL(); ttl > 0 {
+		token, buffer := newStreamBuffer(formName)
+		defer buffer.finish(token, ttl)
+		sse.buffer = buffer
+		typing["resume_token"] = token
+		parent = context.WithoutCancel(parent)
+	}
+	sse.Send("typing", typing)
+
+	if canned {
+		sse.Send("message", map[string]string{"message": reply})
+		sse.Send("done", map[string]interface{}{
+			"message": reply,
+			"updates": map[string]string{},
+			"saved":   false,
+		})
+		return
+	}
+
+	if session.tokenCapReached(config) {
+		reply := config.TokenCapMessageText()
+		sse.Send("message", map[string]string{"message": reply})
+		sse.Send("done", map[string]interface{}{
+			"message": reply,
+			"updates": map[string]string{},
+			"saved":   false,
+			"ended":   true,
+		})
+		return
+	}
+
+	if session.throttleTurn(config, formName, time.Now()) {
+		reply := config.TurnIntervalReplyText()
+		sse.Send("message", map[string]string{"message": reply})
+		sse.Send("done", map[string]interface{}{
+			"message":   reply,
+			"updates":   map[string]string{},
+			"saved":     false,
+			"throttled": true,
+		})
+		return
+	}
+
+	if greeting := session.takeGreeting(); greeting != "" {
+		sse.Send("message", map[string]string{"message": greeting})
+	}
+
+	ctx, endTurn := session.beginTurn(parent)
+	defer endTurn()
+
+	reidentify(config, formName, session, r, chatReq.Message)
+	session.addUserMessage(chatReq.Message)
+	compactHistory(ctx, config, formName, session)
+
+	turn := newTurnResult(config, formName, session)
+	applyLine := func(line string) {
+		if config.ChatOnly() {
+			return
+		}
+		for _, cmd := range parseCommands(config, turn.form, line) {
+			if updates := turn.apply(cmd); len(updates) > 0 {
+				sse.Send("update", updates)
+			}
+			if cmd.Verb == "SAY" {
+				sse.Send("message", map[string]string{"message": turn.Messages[len(turn.Messages)-1]})
+			}
+		}
+	}
+
+	// Background writers must be finished before the handler returns and w goes away
+	stop := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		sse.keepalive(config.KeepaliveInterval(), stop)
+	}()
+	defer func() {
+		close(stop)
+		background.Wait()
+	}()
+
+	// The request carries on past the soft budget; the client is only told it is slow
+	started := make(chan struct{})
+	var startOnce sync.Once
+	markStarted := func() { startOnce.Do(func() { close(started) }) }
+	if budget := config.ResponseBudgetDuration(); budget > 0 {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			select {
+			case <-time.After(budget):
+				log.Printf("🐢 SLOW [%s]: no response within %s", formName, budget)
+				sse.Send("slow", map[string]string{"message": config.SlowResponseMessage()})
+			case <-started:
+			case <-stop:
+			}
+		}()
+	}
+
+	// Tell the user if the server starts shutting down during the turn
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		select {
+		case <-shutdownNotice:
+			sse.Send("shutdown", map[string]string{"message": config.ShutdownMessageText()})
+		case <-stop:
+		}
+	}()
+
+	start := time.Now()
+	lines := &commandLineBuffer{}
+	messages := outgoingMessages(config, turn.form, session)
+	logPrompt(config, formName, "turn", messages)
+	streamed, err := cachedStreamChatGPT(ctx, config.ForForm(turn.form).ForTurn(turn.form, session), turn.form, messages, func(delta string) {
+		markStarted()
+		for _, line := range lines.Write(delta) {
+			applyLine(line)
+		}
+	})
+	markStarted()
+	if ctx.Err() != nil {
+		// Updates already streamed stay applied, but the turn leaves no history
+		log.Printf("🛑 CANCEL [%s]: streaming turn cancelled", formName)
+		session.dropUserMessage()
+		sse.Send("done", map[string]interface{}{
+			"message":   turn.ResponseText(),
+			"updates":   turn.FormUpdates,
+			"saved":     false,
+			"cancelled": true,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: ChatGPT streaming error: %v", formName, err)
+		if isTimeout(err) {
+			if saved, ok := saveOnTimeout(config, formName, session); ok {
+				cookie := identityCookie(config, formName, session)
+				sse.Send("message", map[string]string{"message": timeoutSavedMessage})
+				sse.Send("done", map[string]interface{}{
+					"message":       timeoutSavedMessage,
+					"updates":       saved.FormUpdates,
+					"saved":         true,
+					"degraded":      true,
+					"submission_id": session.SubmissionID,
+					"cookie":        map[string]string{"name": cookie.Name, "value": cookie.Value, "path": cookie.Path, "domain": cookie.Domain},
+				})
+				return
+			}
+		}
+		if message, ok := renderOfflineTemplate(config, turn.form, session.FormData); ok {
+			log.Printf("📴 OFFLINE [%s]: \"%s\"", formName, message)
+			sse.Send("message", map[string]string{"message": message})
+			sse.Send("done", map[string]interface{}{
+				"message": message,
+				"updates": turn.FormUpdates,
+				"saved":   false,
+				"offline": true,
+			})
+			return
+		}
+		sse.Send("error", map[string]string{"message": "AI service error"})
+		return
+	}
+	content, usage := streamed.Content, streamed.Usage
+	turn.Reasoning = streamed.Reasoning
+	applyLine(lines.Flush())
+	if config.ChatOnly() {
+		turn.apply(assistantCommand{Verb: "SAY", Value: strings.TrimSpace(content)})
+		sse.Send("message", map[string]string{"message": turn.ResponseText()})
+	}
+	log.Printf("🤖 AI [%s]: \"%s\"", formName, content)
+	logReasoning(config, formName, turn.Reasoning)
+	// A streamed repeat has already been shown, so it can only be followed by the note
+	if isRepeatedReply(config, session, content) {
+		content = collapseRepeat(config, formName, turn)
+		sse.Send("message", map[string]string{"message": turn.ResponseText()})
+	}
+	session.addAssistantMessage(content)
+	checkReplyLanguage(config, formName, session, turn.Messages)
+	turn.Ended = session.addTokens(config, formName, usage.TotalTokens)
+
+	done := map[string]interface{}{
+		"message": turn.ResponseText(),
+		"updates": turn.FormUpdates,
+		"saved":   false,
+	}
+	if len(turn.Suggestions) > 0 {
+		done["suggestions"] = turn.Suggestions
+	}
+	if len(turn.QuickReplies) > 0 {
+		done["quick_replies"] = turn.QuickReplies
+	}
+	if config.DebugMeta {
+		// Streamed responses only carry token usage when max_session_tokens asks for it
+		done["meta"] = turnMeta{Model: config.Model, LatencyMS: time.Since(start).Milliseconds(), Tokens: usage, Attempts: 1, Cached: streamed.Cached}
+	}
+	if turn.Ended {
+		done["ended"] = true
+		done["end_message"] = config.TokenCapMessageText()
+	}
+	if turn.ShouldSave {
+		var verr *verificationError
+		err := saveSession(config, formName, session, turn)
+		if errors.As(err, &verr) {
+			sse.Send("message", map[string]string{"message": verr.Message(config, session.Locale)})
+			done["verification"] = verr.Reasons
+			sse.Send("done", done)
+			return
+		}
+		if err != nil {
+			message := "Failed to save form"
+			if errors.Is(err, errInvalidRecordKey) {
+				message = "Failed to save form: missing record key"
+			}
+			if errors.Is(err, errSaveLimitReached) {
+				message = "Failed to save form: this record can't be saved again"
+			}
+			sse.Send("error", map[string]string{"message": message})
+			return
+		}
+		cookie := identityCookie(config, formName, session)
+		done["saved"] = true
+		done["submission_id"] = session.SubmissionID
+		done["cookie"] = map[string]string{"name": cookie.Name, "value": cookie.Value, "path": cookie.Path, "domain": cookie.Domain}
+		if turn.Notice != "" {
+			sse.Send("message", map[string]string{"message": turn.Notice})
+			done["notice"] = turn.Notice
+		}
+	}
+	sse.Send("done", done)
+}
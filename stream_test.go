@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseBody is a streamed completion sending each of deltas as a chunk
+func sseBody(deltas ...string) string {
+	var b strings.Builder
+	for _, delta := range deltas {
+		chunk, _ := json.Marshal(map[string]interface{}{
+			"choices": []map[string]interface{}{{"delta": map[string]string{"content": delta}}},
+		})
+		b.WriteString("data: " + string(chunk) + "\n\n")
+	}
+	b.WriteString("data: [DONE]\n\n")
+	return b.String()
+}
+
+// fakeStream answers every completion request with a stream of deltas
+func fakeStream(t *testing.T, deltas ...string) *fakeAI {
+	t.Helper()
+	fake := fakeChat(t)
+	chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, body)
+		fake.mu.Unlock()
+		resp := jsonResponse(r, http.StatusOK, sseBody(deltas...))
+		resp.Header.Set("Content-Type", "text/event-stream")
+		return resp, nil
+	})
+	return fake
+}
+
+func TestCommandLineBuffer(t *testing.T) {
+	tests := []struct {
+		name      string
+		deltas    []string
+		wantLines []string
+		wantRest  string
+	}{
+		{"whole lines", []string{"SAY hi\n", "SET A 1\n"}, []string{"SAY hi", "SET A 1"}, ""},
+		{"split across deltas", []string{"SE", "T A ", "1\nSA", "Y ok"}, []string{"SET A 1"}, "SAY ok"},
+		{"several lines in one delta", []string{"a\nb\nc"}, []string{"a", "b"}, "c"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf commandLineBuffer
+			var lines []string
+			for _, delta := range tt.deltas {
+				lines = append(lines, buf.Write(delta)...)
+			}
+			if !reflect.DeepEqual(lines, tt.wantLines) || buf.Flush() != tt.wantRest {
+				t.Errorf("lines %q, want %q then %q", lines, tt.wantLines, tt.wantRest)
+			}
+		})
+	}
+}
+
+func TestStreamChatGPTHandsOverEachDelta(t *testing.T) {
+	fakeStream(t, "SAY Hel", "lo\nSET A ", "1\n")
+	var deltas []string
+	reply, err := streamChatGPT(context.Background(), testConfig(t), nil, func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Content != "SAY Hello\nSET A 1\n" || len(deltas) != 3 {
+		t.Errorf("content %q from %d deltas", reply.Content, len(deltas))
+	}
+}
+
+func TestCachedStreamChatGPTSharesTheCache(t *testing.T) {
+	fake := fakeStream(t, "SAY cached\n")
+	previous := responseCache
+	t.Cleanup(func() { responseCache = previous })
+	responseCache = NewResponseCache(4, 0)
+
+	zero := 0.0
+	form := ConfigurationForm{Name: "f", CacheResponses: true}
+	config := testConfig(t, form)
+	config.Temperature = &zero
+	messages := []ChatMessage{{Role: "user", Content: "hi"}}
+
+	tests := []struct {
+		name       string
+		wantCached bool
+		wantCalls  int
+	}{
+		{"first stream goes to the service", false, 1},
+		{"repeat is replayed", true, 1},
+	}
+	for _, tt := range tests {
+		var streamed string
+		reply, err := cachedStreamChatGPT(context.Background(), config, form, messages, func(d string) { streamed += d })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.Cached != tt.wantCached || fake.calls() != tt.wantCalls || streamed != "SAY cached\n" {
+			t.Errorf("%s: cached %v after %d calls, streamed %q", tt.name, reply.Cached, fake.calls(), streamed)
+		}
+	}
+	if resp, err := cachedChatGPT(context.Background(), config, form, messages); err != nil || !resp.Cached {
+		t.Errorf("/chat did not get the streamed reply from the cache: %v", err)
+	}
+}
+
+// streamEvents runs a streamed chat turn and returns its events in order
+func streamEvents(t *testing.T, config Configuration, formName, message string) []sseEvent {
+	t.Helper()
+	w := httptest.NewRecorder()
+	body, _ := json.Marshal(map[string]string{"message": message})
+	handleChatStream(w, postJSON("/form/"+formName+"/chat/stream", string(body)), config, formName)
+	var events []sseEvent
+	for _, block := range strings.Split(w.Body.String(), "\n\n") {
+		var event sseEvent
+		for _, line := range strings.Split(block, "\n") {
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				event.Name = name
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok {
+				json.Unmarshal([]byte(data), &event.Data)
+			}
+		}
+		if event.Name != "" {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+type sseEvent struct {
+	Name string
+	Data map[string]interface{}
+}
+
+func TestChatStreamSendsUpdatesAsTheyArrive(t *testing.T) {
+	fakeStream(t, "SET FirstName A", "nn\nSAY Thanks", " Ann\n")
+	config := testConfig(t, testForm("f"))
+
+	var names []string
+	for _, event := range streamEvents(t, config, "f", "I'm Ann") {
+		names = append(names, event.Name)
+		if event.Name == "update" && event.Data["FirstName"] != "Ann" {
+			t.Errorf("update %v, want FirstName Ann", event.Data)
+		}
+	}
+	want := []string{"typing", "update", "message", "done"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("events %v, want %v", names, want)
+	}
+}
+
+// stallingStream sends deltas spaced by gap, then stops sending until the
+// request is cancelled
+func stallingStream(t *testing.T, gap time.Duration, deltas ...string) {
+	t.Helper()
+	fakeChat(t)
+	chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			for _, delta := range deltas {
+				time.Sleep(gap)
+				io.WriteString(pw, strings.TrimSuffix(sseBody(delta), "data: [DONE]\n\n"))
+			}
+			<-r.Context().Done()
+			pw.CloseWithError(r.Context().Err())
+		}()
+		resp := jsonResponse(r, http.StatusOK, "")
+		resp.Body = pr
+		return resp, nil
+	})
+}
+
+func TestStreamChatGPTIdleTimeout(t *testing.T) {
+	config := testConfig(t)
+	config.RequestTimeout = "100ms"
+	// Each chunk arrives within the timeout, though the whole reply takes
+	// longer; only the silence afterwards times out
+	stallingStream(t, 40*time.Millisecond, "SAY a", "b", "c", "d")
+
+	start := time.Now()
+	reply, err := streamChatGPT(context.Background(), config, nil, func(string) {})
+	if !isTimeout(err) {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if reply.Content != "SAY abcd" {
+		t.Errorf("content = %q, want every chunk sent before the silence", reply.Content)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("timed out after %s", elapsed)
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"deadline", context.DeadlineExceeded, true},
+		{"wrapped deadline", fmt.Errorf("%w: no data", context.DeadlineExceeded), true},
+		{"net timeout", &net.DNSError{IsTimeout: true}, true},
+		{"cancelled", context.Canceled, false},
+		{"other", errors.New("boom"), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		if got := isTimeout(tt.err); got != tt.want {
+			t.Errorf("%s: isTimeout = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKeepaliveOnlyWhenQuiet(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval time.Duration
+		want     bool
+	}{
+		{"quiet stream", 20 * time.Millisecond, true},
+		{"disabled", 0, false},
+		{"busier than the interval", time.Hour, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			sse, _ := newSSEWriter(w)
+			stop := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				sse.keepalive(tt.interval, stop)
+				close(done)
+			}()
+			time.Sleep(100 * time.Millisecond)
+			close(stop)
+			<-done
+			if got := strings.Contains(w.Body.String(), ": keepalive\n\n"); got != tt.want {
+				t.Errorf("keepalive sent = %v, want %v; body %q", got, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestChatStreamStartsTypingAndEndsOnce(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{"reply", http.StatusOK},
+		{"AI service failure", http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeChat(t)
+			chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				return jsonResponse(r, tt.status, sseBody("SAY hi\n")), nil
+			})
+			events := streamEvents(t, testConfig(t, testForm("f")), "f", "hello")
+			if len(events) < 2 || events[0].Name != "typing" {
+				t.Fatalf("events = %+v, want typing first", events)
+			}
+			ends := 0
+			for _, event := range events {
+				if event.Name == "done" || event.Name == "error" {
+					ends++
+				}
+			}
+			if last := events[len(events)-1].Name; ends != 1 || (last != "done" && last != "error") {
+				t.Errorf("events = %+v, want exactly one done or error, last", events)
+			}
+		})
+	}
+}
+
+// delayedStream answers with the stream of deltas after delay
+func delayedStream(t *testing.T, delay time.Duration, deltas ...string) {
+	t.Helper()
+	fakeChat(t)
+	chatClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			time.Sleep(delay)
+			io.WriteString(pw, sseBody(deltas...))
+			pw.Close()
+		}()
+		resp := jsonResponse(r, http.StatusOK, "")
+		resp.Body = pr
+		return resp, nil
+	})
+}
+
+func TestChatStreamWarnsWhenSlow(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		wantSlow bool
+	}{
+		{"within the budget", 0, false},
+		{"over the budget", 150 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delayedStream(t, tt.delay, "SAY Hello\n")
+			config := testConfig(t, testForm("f"))
+			config.ResponseBudget = "50ms"
+			config.ResponseBudgetMessage = "Still thinking..."
+
+			var names []string
+			slow := false
+			for _, event := range streamEvents(t, config, "f", "hi") {
+				names = append(names, event.Name)
+				if event.Name == "slow" {
+					slow = event.Data["message"] == "Still thinking..."
+				}
+			}
+			if slow != tt.wantSlow || names[len(names)-1] != "done" {
+				t.Errorf("events %v, want slow %v and the reply still delivered", names, tt.wantSlow)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bufferedEvent is one event already sent on a resumable stream
+type bufferedEvent struct {
+	id      int
+	event   string
+	payload []byte
+}
+
+// streamBuffer keeps a resumable stream's events so a client whose connection
+// dropped can reconnect and replay the ones it missed
+type streamBuffer struct {
+	mu     sync.Mutex
+	form   string
+	events []bufferedEvent
+	done   bool
+	// Closed and replaced whenever an event is added or the stream ends
+	changed chan struct{}
+}
+
+// Buffers of streams in progress or recently finished, by resume token
+var (
+	streamBuffersMu sync.Mutex
+	streamBuffers   = make(map[string]*streamBuffer)
+)
+
+// StreamResumeTTL is how long a finished stream can still be resumed; zero
+// means streams are not resumable
+func (c Configuration) StreamResumeTTL() time.Duration {
+	d, _ := time.ParseDuration(c.StreamResume)
+	return d
+}
+
+// newStreamBuffer registers a buffer for a new stream under a random token
+func newStreamBuffer(formName string) (string, *streamBuffer) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	token := hex.EncodeToString(b[:])
+	buffer := &streamBuffer{form: formName, changed: make(chan struct{})}
+	streamBuffersMu.Lock()
+	streamBuffers[token] = buffer
+	streamBuffersMu.Unlock()
+	return token, buffer
+}
+
+// lookupStreamBuffer finds the form's stream with the given resume token
+func lookupStreamBuffer(formName, token string) (*streamBuffer, bool) {
+	streamBuffersMu.Lock()
+	defer streamBuffersMu.Unlock()
+	buffer, ok := streamBuffers[token]
+	if !ok || buffer.form != formName {
+		return nil, false
+	}
+	return buffer, true
+}
+
+// notify wakes readers waiting for changes; the caller holds mu
+func (b *streamBuffer) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+func (b *streamBuffer) add(event bufferedEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.events = append(b.events, event)
+	b.notify()
+}
+
+// finish marks the stream complete and forgets it once ttl has passed
+func (b *streamBuffer) finish(token string, ttl time.Duration) {
+	b.mu.Lock()
+	b.done = true
+	b.notify()
+	b.mu.Unlock()
+	time.AfterFunc(ttl, func() {
+		streamBuffersMu.Lock()
+		delete(streamBuffers, token)
+		streamBuffersMu.Unlock()
+	})
+}
+
+// since returns the events after lastID, whether the stream has ended, and a
+// channel closed on the next change
+func (b *streamBuffer) since(lastID int) ([]bufferedEvent, bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var missed []bufferedEvent
+	for _, event := range b.events {
+		if event.id > lastID {
+			missed = append(missed, event)
+		}
+	}
+	return missed, b.done, b.changed
+}
+
+// handleStreamResume reconnects to a stream by its resume token, replaying
+// every event after the Last-Event-ID header (or ?last_event_id=) and then
+// following the stream until it ends
+func handleStreamResume(w http.ResponseWriter, r *http.Request, formName string) {
+	buffer, ok := lookupStreamBuffer(formName, r.URL.Query().Get("token"))
+	if !ok {
+		http.Error(w, "Stream not found or expired", http.StatusNotFound)
+		return
+	}
+	lastHeader := r.Header.Get("Last-Event-ID")
+	if lastHeader == "" {
+		lastHeader = r.URL.Query().Get("last_event_id")
+	}
+	lastID, _ := strconv.Atoi(lastHeader)
+
+	sse, ok := newSSEWriter(w)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("🔁 RESUME [%s]: stream resumed after event %d", formName, lastID)
+	for {
+		missed, done, changed := buffer.since(lastID)
+		for _, event := range missed {
+			if err := sse.writeEvent(event.id, event.event, event.payload); err != nil {
+				return
+			}
+			lastID = event.id
+		}
+		if done {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestHandleStreamResume(t *testing.T) {
+	token, buffer := newStreamBuffer("f")
+	for id, event := range []string{"typing", "update", "message", "done"} {
+		buffer.add(bufferedEvent{id: id + 1, event: event, payload: []byte("{}")})
+	}
+	buffer.finish(token, time.Minute)
+
+	tests := []struct {
+		name       string
+		form       string
+		query      string
+		lastID     string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{"from the start", "f", "?token=" + token, "", http.StatusOK, []string{"1", "2", "3", "4"}},
+		{"after Last-Event-ID", "f", "?token=" + token, "2", http.StatusOK, []string{"3", "4"}},
+		{"after last_event_id", "f", "?token=" + token + "&last_event_id=3", "", http.StatusOK, []string{"4"}},
+		{"unknown token", "f", "?token=nope", "", http.StatusNotFound, nil},
+		{"another form's stream", "g", "?token=" + token, "", http.StatusNotFound, nil},
+	}
+	ids := regexp.MustCompile(`(?m)^id: (\d+)$`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/form/"+tt.form+"/chat/stream/resume"+tt.query, nil)
+			if tt.lastID != "" {
+				r.Header.Set("Last-Event-ID", tt.lastID)
+			}
+			w := httptest.NewRecorder()
+			handleStreamResume(w, r, tt.form)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			var got []string
+			for _, match := range ids.FindAllStringSubmatch(w.Body.String(), -1) {
+				got = append(got, match[1])
+			}
+			if !reflect.DeepEqual(got, tt.wantIDs) {
+				t.Errorf("replayed ids %v, want %v", got, tt.wantIDs)
+			}
+		})
+	}
+}
+
+func TestResumableStreamHandsOutAToken(t *testing.T) {
+	fakeStream(t, "SAY Hello\n")
+	config := testConfig(t, testForm("f"))
+	config.StreamResume = "1m"
+
+	events := streamEvents(t, config, "f", "hi")
+	token, _ := events[0].Data["resume_token"].(string)
+	if token == "" {
+		t.Fatalf("typing event %v has no resume_token", events[0].Data)
+	}
+	buffer, ok := lookupStreamBuffer("f", token)
+	if !ok {
+		t.Fatal("no buffer for the resume token")
+	}
+	missed, done, _ := buffer.since(0)
+	if len(missed) != len(events) || !done {
+		t.Errorf("buffered %d events (done %v), want the %d sent", len(missed), done, len(events))
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Used when max_upload_bytes is not configured
+const defaultMaxUploadBytes = 5 << 20
+
+// UploadLimit is the largest file the form accepts
+func (f ConfigurationForm) UploadLimit() int64 {
+	if f.MaxUploadBytes > 0 {
+		return f.MaxUploadBytes
+	}
+	return defaultMaxUploadBytes
+}
+
+// uploadDir holds a form's uploaded files
+func uploadDir(config Configuration, formName string) string {
+	return filepath.Join(formDataDir(config, formName), "uploads")
+}
+
+// mediaType strips parameters such as charset from a content type
+func mediaType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil {
+		return t
+	}
+	return strings.TrimSpace(strings.ToLower(contentType))
+}
+
+// checkUploadType sniffs a file's type from its first bytes and checks it
+// against the form's upload_types. The type the client declared is not
+// trusted: it must match what was detected, unless it is missing or the
+// generic application/octet-stream.
+func checkUploadType(form ConfigurationForm, declared string, head []byte) (string, error) {
+	detected := mediaType(http.DetectContentType(head))
+	allowed := false
+	for _, t := range splitFieldList(form.UploadTypes) {
+		if mediaType(t) == detected {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return detected, fmt.Errorf("file type %s is not accepted", detected)
+	}
+	if declared = mediaType(declared); declared != "" && declared != "application/octet-stream" && declared != detected {
+		return detected, fmt.Errorf("declared type %s does not match the file's content (%s)", declared, detected)
+	}
+	return detected, nil
+}
+
+// handleUpload stores a file for a [file] field of the form, sent as the
+// multipart part "file" with the field named by ?field= or the "field" part.
+// The stored file name becomes the field's value. Protected fields can't be
+// uploaded to, and the file is only written once the session's turn lock is
+// held, so a rejected upload leaves nothing behind.
+func handleUpload(w http.ResponseWriter, r *http.Request, config Configuration, formName string) {
+	form := config.FormByName(formName)
+	if strings.TrimSpace(form.UploadTypes) == "" {
+		http.NotFound(w, r)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, form.UploadLimit()+64<<10)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		if isBodyTooLarge(err) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "Missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	fieldName := firstNonEmpty(r.URL.Query().Get("field"), r.FormValue("field"))
+	if field, ok := formFieldByName(form, fieldName); !ok || field.Type != "file" {
+		http.Error(w, "Unknown file field", http.StatusBadRequest)
+		return
+	}
+	if isProtectedField(form, fieldName) {
+		log.Printf("🚫 UPLOAD [%s]: rejected upload to protected field %s", formName, fieldName)
+		http.Error(w, "Field cannot be changed", http.StatusForbidden)
+		return
+	}
+	if header.Size > form.UploadLimit() {
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	contentType, err := checkUploadType(form, header.Header.Get("Content-Type"), head[:n])
+	if err != nil {
+		log.Printf("🚫 UPLOAD [%s]: rejected %q for %s: %v", formName, header.Filename, fieldName, err)
+		http.Error(w, "Unsupported file: "+err.Error(), http.StatusUnsupportedMediaType)
+		return
+	}
+
+	session := getOrCreateSession(w, config, formName, r)
+	if !lockSession(w, config, formName, session) {
+		return
+	}
+	defer session.turnMu.Unlock()
+
+	name := strings.ReplaceAll(newSubmissionID(), "-", "")
+	if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+		name += exts[0]
+	}
+	if err := os.MkdirAll(uploadDir(config, formName), 0755); err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to create uploads directory: %v", formName, err)
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+	path := filepath.Join(uploadDir(config, formName), name)
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err == nil {
+		_, err = io.Copy(out, file)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}
+	if err != nil {
+		log.Printf("❌ ERROR [%s]: Failed to store upload: %v", formName, err)
+		http.Error(w, "Failed to store file", http.StatusInternalServerError)
+		return
+	}
+
+	session.FormData[fieldName] = name
+	session.Messages = append(session.Messages, ChatMessage{
+		Role:    "system",
+		Content: fmt.Sprintf("The user uploaded a %s file for %s; it is stored and the field is set.", contentType, fieldName),
+	})
+	log.Printf("📎 UPLOAD [%s]: stored %s (%s, %d bytes) for %s", formName, name, contentType, header.Size, fieldName)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(uploadReply{Field: fieldName, File: name, ContentType: contentType, Size: header.Size})
+}
+
+// uploadReply describes a stored upload
+type uploadReply struct {
+	Field       string `json:"field"`
+	File        string `json:"file"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var pngHead = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestCheckUploadType(t *testing.T) {
+	form := ConfigurationForm{UploadTypes: "image/png, application/pdf"}
+	tests := []struct {
+		name     string
+		declared string
+		head     []byte
+		want     string
+		wantErr  bool
+	}{
+		{"png", "image/png", pngHead, "image/png", false},
+		{"no declared type", "", pngHead, "image/png", false},
+		{"generic declared type", "application/octet-stream", []byte("%PDF-1.7\n"), "application/pdf", false},
+		{"type not accepted", "text/plain", []byte("hello"), "text/plain", true},
+		{"html posing as png", "image/png", []byte("<html><script>"), "text/html", true},
+		{"declared type does not match", "application/pdf", pngHead, "image/png", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := checkUploadType(form, tt.declared, tt.head)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("checkUploadType = %q, %v; want %q, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleUpload(t *testing.T) {
+	tests := []struct {
+		name        string
+		uploadTypes string
+		maxBytes    int64
+		protected   bool
+		busy        bool
+		field       string
+		contentType string
+		content     []byte
+		wantStatus  int
+	}{
+		{"stored", "image/png", 0, false, false, "Photo", "image/png", pngHead, http.StatusOK},
+		{"uploads off", "", 0, false, false, "Photo", "image/png", pngHead, http.StatusNotFound},
+		{"unknown field", "image/png", 0, false, false, "Picture", "image/png", pngHead, http.StatusBadRequest},
+		{"not a file field", "image/png", 0, false, false, "FirstName", "image/png", pngHead, http.StatusBadRequest},
+		{"protected field", "image/png", 0, true, false, "Photo", "image/png", pngHead, http.StatusForbidden},
+		{"turn in progress", "image/png", 0, false, true, "Photo", "image/png", pngHead, http.StatusConflict},
+		{"too large", "image/png", 8, false, false, "Photo", "image/png", pngHead, http.StatusRequestEntityTooLarge},
+		{"sniffed type rejected", "image/png", 0, false, false, "Photo", "image/png", []byte("<html>"), http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := chatSessions
+			chatSessions = map[sessionKey]*ChatSession{}
+			t.Cleanup(func() { chatSessions = previous })
+			form := testForm("upload")
+			form.Fields += "\nPhoto: {{.Photo}} [file]"
+			form.UploadTypes = tt.uploadTypes
+			form.MaxUploadBytes = tt.maxBytes
+			form.ConcurrentTurns = "reject"
+			if tt.protected {
+				form.ProtectedFields = "Photo"
+			}
+			config := testConfig(t, form)
+
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			header := textproto.MIMEHeader{}
+			header.Set("Content-Disposition", `form-data; name="file"; filename="me.png"`)
+			header.Set("Content-Type", tt.contentType)
+			part, _ := mw.CreatePart(header)
+			part.Write(tt.content)
+			mw.Close()
+			r := httptest.NewRequest(http.MethodPost, "/upload/upload?field="+tt.field, &body)
+			r.Header.Set("Content-Type", mw.FormDataContentType())
+			r.Header.Set(sessionIDHeader, newClientID())
+			if tt.busy {
+				session := getOrCreateSession(httptest.NewRecorder(), config, form.Name, r)
+				session.turnMu.Lock()
+				defer session.turnMu.Unlock()
+			}
+			w := httptest.NewRecorder()
+
+			handleUpload(w, r, config, form.Name)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			stored, _ := filepath.Glob(filepath.Join(uploadDir(config, form.Name), "*"))
+			if tt.wantStatus != http.StatusOK {
+				if len(stored) != 0 {
+					t.Errorf("stored %v for a rejected upload", stored)
+				}
+				return
+			}
+			if len(stored) != 1 || filepath.Ext(stored[0]) != ".png" {
+				t.Fatalf("stored %v, want one .png file", stored)
+			}
+			if data, _ := os.ReadFile(stored[0]); !bytes.Equal(data, tt.content) {
+				t.Errorf("stored %q, want %q", data, tt.content)
+			}
+			if got := callerSession(config, form.Name, r).FormData[tt.field]; got != filepath.Base(stored[0]) {
+				t.Errorf("field %s = %q, want %q", tt.field, got, filepath.Base(stored[0]))
+			}
+		})
+	}
+}
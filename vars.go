@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const varPrompt = "To remember something that is not a form field, such as the user's intent, send a line like: VAR name value\n" +
+	"Variables are never saved with the form; their current values are shown to you each turn."
+
+// setVar stores a session variable set by the VAR command
+func (s *ChatSession) setVar(name, value string) {
+	if s.Vars == nil {
+		s.Vars = make(map[string]string)
+	}
+	s.Vars[name] = value
+}
+
+// varsInstruction lists the session's variables for the model, when it has any
+func varsInstruction(session *ChatSession) (string, bool) {
+	if len(session.Vars) == 0 {
+		return "", false
+	}
+	names := make([]string, 0, len(session.Vars))
+	for name := range session.Vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("- %s: %s", name, session.Vars[name]))
+	}
+	return "Session variables:\n" + strings.Join(lines, "\n"), true
+}
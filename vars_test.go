@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVarsInstruction(t *testing.T) {
+	tests := []struct {
+		name string
+		vars map[string]string
+		want string
+	}{
+		{"none", nil, ""},
+		{"sorted by name", map[string]string{"intent": "renew", "channel": "web"}, "Session variables:\n- channel: web\n- intent: renew"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := varsInstruction(&ChatSession{Vars: tt.vars})
+			if got != tt.want || ok != (tt.want != "") {
+				t.Errorf("varsInstruction = %q, %v, want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestVarCommand(t *testing.T) {
+	tests := []struct {
+		name        string
+		enabled     bool
+		wantVars    map[string]string
+		wantUpdates map[string]string
+	}{
+		{"session vars on", true, map[string]string{"intent": "renew"}, map[string]string{"Plan": "renew"}},
+		{"session vars off", false, nil, map[string]string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := testForm("f")
+			form.SessionVars = tt.enabled
+			form.ComputedFields.Field = []ComputedField{{Name: "Plan", Template: `{{var "intent"}}`}}
+			config := testConfig(t, form)
+			session := &ChatSession{FormData: map[string]string{}}
+			turn := applyResponse(config, "f", session, "VAR intent renew")
+			if !reflect.DeepEqual(session.Vars, tt.wantVars) || !reflect.DeepEqual(turn.FormUpdates, tt.wantUpdates) {
+				t.Errorf("vars %v, updates %v, want %v, %v", session.Vars, turn.FormUpdates, tt.wantVars, tt.wantUpdates)
+			}
+			if _, ok := session.FormData["intent"]; ok {
+				t.Error("VAR set a form field")
+			}
+		})
+	}
+}
+
+func TestVarsAreShownButNotSaved(t *testing.T) {
+	form := testForm("f")
+	form.SessionVars = true
+	config := testConfig(t, form)
+	session := &ChatSession{FormData: map[string]string{"FirstName": "Ann", "License": "A1"}, Vars: map[string]string{"intent": "renew"}}
+
+	var shown bool
+	for _, m := range outgoingMessages(config, form, session) {
+		shown = shown || m.Role == "system" && strings.Contains(m.Content, "- intent: renew")
+	}
+	if !shown {
+		t.Error("the model is not shown the session variables")
+	}
+	if err := saveSession(config, "f", session, newTurnResult(config, "f", session)); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := storedRecordPath(config, "f", "A1")
+	record, _ := readRecord(path)
+	for key, value := range record {
+		if key == "intent" || value == "renew" {
+			t.Errorf("saved record has %s: %v", key, value)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// verificationError blocks a SAVE that the model's self-check failed
+type verificationError struct {
+	Reasons []string
+}
+
+func (e *verificationError) Error() string {
+	return "save verification failed: " + strings.Join(e.Reasons, "; ")
+}
+
+// Message is what the user is told when the save is blocked, in their language
+func (e *verificationError) Message(config Configuration, locale string) string {
+	return config.localize(locale, "verification_failed", map[string]interface{}{
+		"Reasons": strings.Join(e.Reasons, "; "),
+	})
+}
+
+const verificationPrompt = `You are reviewing a completed form before it is saved.
+Check that every field has a plausible, correctly formatted value that is consistent with the conversation.
+Reply with PASS alone on the first line if the form can be saved.
+Otherwise reply with FAIL on the first line, followed by one line per problem starting with REASON.
+
+` + "```Form fields\n%s\n```\n\n```Form data\n%s\n```"
+
+// parseVerification reads a PASS/FAIL reply, treating anything unrecognized as a failure
+func parseVerification(content string) (bool, []string) {
+	var verdict string
+	var reasons []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if verdict == "" {
+			verdict = strings.ToUpper(strings.Trim(line, ".*` "))
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "REASON"))
+		line = strings.TrimSpace(strings.TrimLeft(line, ":-* "))
+		if line != "" {
+			reasons = append(reasons, line)
+		}
+	}
+	if verdict == "PASS" {
+		return true, nil
+	}
+	if len(reasons) == 0 {
+		reasons = []string{"the form could not be verified"}
+	}
+	return false, reasons
+}
+
+// verifyBeforeSave asks the model to self-check the form data, returning a
+// *verificationError when it does not PASS. The reasons are also added to the
+// session so the model follows up on them.
+func verifyBeforeSave(config Configuration, form ConfigurationForm, session *ChatSession) error {
+	if !form.VerifyBeforeSave {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(session.FormData, "", "    ")
+	if err != nil {
+		return err
+	}
+	transcript := append(sessionTranscript(session), ChatMessage{
+		Role:    "system",
+		Content: fmt.Sprintf(verificationPrompt, form.Fields, data),
+	})
+
+	var verr *verificationError
+	unavailable := config.localize(session.Locale, "verification_unavailable", nil)
+	resp, err := callChatGPT(context.Background(), config, transcript)
+	switch {
+	case err != nil:
+		log.Printf("❌ VERIFY [%s]: self-check failed: %v", form.Name, err)
+		verr = &verificationError{Reasons: []string{unavailable}}
+	case len(resp.Choices) == 0:
+		verr = &verificationError{Reasons: []string{unavailable}}
+	default:
+		if ok, reasons := parseVerification(resp.Choices[0].Message.Content); !ok {
+			verr = &verificationError{Reasons: reasons}
+		}
+	}
+
+	if verr == nil {
+		log.Printf("✅ VERIFY [%s]: PASS", form.Name)
+		return nil
+	}
+	log.Printf("🚫 VERIFY [%s]: FAIL: %s", form.Name, strings.Join(verr.Reasons, "; "))
+	session.Messages = append(session.Messages, ChatMessage{
+		Role:    "system",
+		Content: "The form was not saved. Resolve these problems with the user, then SAVE again: " + strings.Join(verr.Reasons, "; "),
+	})
+	return verr
+}
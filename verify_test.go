@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseVerification(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		wantOK      bool
+		wantReasons []string
+	}{
+		{"pass", "PASS", true, nil},
+		{"decorated pass", "**Pass.**", true, nil},
+		{"fail with reasons", "FAIL\nREASON: License looks invented\nREASON - phone is short", false, []string{"License looks invented", "phone is short"}},
+		{"fail without reasons", "FAIL", false, []string{"the form could not be verified"}},
+		{"unrecognized", "Looks fine to me", false, []string{"the form could not be verified"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, reasons := parseVerification(tt.content)
+			if ok != tt.wantOK || !reflect.DeepEqual(reasons, tt.wantReasons) {
+				t.Errorf("parseVerification = %v, %q, want %v, %q", ok, reasons, tt.wantOK, tt.wantReasons)
+			}
+		})
+	}
+}
+
+func TestVerifyBeforeSaveGatesTheSave(t *testing.T) {
+	tests := []struct {
+		name     string
+		verdict  string
+		wantSave bool
+	}{
+		{"pass", "PASS", true},
+		{"fail", "FAIL\nREASON License looks invented", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeChat(t, tt.verdict)
+			form := testForm("f")
+			form.VerifyBeforeSave = true
+			config := testConfig(t, form)
+			session := &ChatSession{FormData: map[string]string{"FirstName": "Ann", "License": "A1"}}
+
+			err := saveSession(config, "f", session, newTurnResult(config, "f", session))
+			var verr *verificationError
+			if tt.wantSave != (err == nil) || !tt.wantSave && !errors.As(err, &verr) {
+				t.Fatalf("saveSession = %v, want saved %v", err, tt.wantSave)
+			}
+			path, _ := storedRecordPath(config, "f", "A1")
+			if _, err := os.Stat(path); (err == nil) != tt.wantSave {
+				t.Errorf("record exists = %v, want %v", err == nil, tt.wantSave)
+			}
+			if !tt.wantSave && (len(session.Messages) != 1 || session.Messages[0].Role != "system") {
+				t.Errorf("the reasons were not added to the history: %+v", session.Messages)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WarehouseExportConfig uploads saved records in batches to an S3-compatible
+// bucket for analytics. Each run exports the records saved since the last
+// one, as JSON lines partitioned by form and day. The access keys are read
+// from GOCHAT_EXPORT_ACCESS_KEY and GOCHAT_EXPORT_SECRET_KEY.
+type WarehouseExportConfig struct {
+	// Bucket to upload to; empty disables the export
+	Bucket string `xml:"bucket"`
+	// S3 endpoint, e.g. https://s3.us-east-1.amazonaws.com or a MinIO URL
+	Endpoint string `xml:"endpoint"`
+	// Region the requests are signed for (default us-east-1)
+	Region string `xml:"region"`
+	// Key prefix for the uploaded objects
+	Prefix string `xml:"prefix"`
+	// How often to export (default 1h)
+	Interval string `xml:"interval"`
+	// Also export the stored transcripts (store_transcript)
+	IncludeTranscripts bool `xml:"include_transcripts"`
+}
+
+// Used when the export settings are not configured
+const (
+	defaultExportInterval = time.Hour
+	defaultExportRegion   = "us-east-1"
+)
+
+const (
+	exportAccessKeyEnv = "GOCHAT_EXPORT_ACCESS_KEY"
+	exportSecretKeyEnv = "GOCHAT_EXPORT_SECRET_KEY"
+)
+
+// IntervalDuration parses the export interval, falling back to the default
+func (c WarehouseExportConfig) IntervalDuration() time.Duration {
+	if d, err := time.ParseDuration(c.Interval); err == nil && d > 0 {
+		return d
+	}
+	return defaultExportInterval
+}
+
+// RegionName is the signing region, falling back to the default
+func (c WarehouseExportConfig) RegionName() string {
+	return firstNonEmpty(c.Region, defaultExportRegion)
+}
+
+// objectStore uploads one object; s3Store in production, replaced in tests
+type objectStore interface {
+	Put(key, contentType string, body []byte) error
+}
+
+// s3Store PUTs objects to a bucket with path-style URLs, signed with AWS
+// Signature Version 4
+type s3Store struct {
+	endpoint  string
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	now       func() time.Time
+}
+
+// awsEscape percent-encodes everything but the unreserved characters, as
+// SigV4 requires; slashes are kept when path is set
+func awsEscape(s string, path bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', path && c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func (s s3Store) Put(key, contentType string, body []byte) error {
+	path := "/" + awsEscape(s.bucket, false) + "/" + awsEscape(key, true)
+	req, err := http.NewRequest(http.MethodPut, strings.TrimRight(s.endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	signedHeaders := "content-type;host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		http.MethodPut,
+		path,
+		"",
+		"content-type:" + contentType,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), day)
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSHA256(signingKey, stringToSign))))
+
+	resp, err := sinkClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("upload of %s returned %s", key, resp.Status)
+	}
+	return nil
+}
+
+// exportedRecord is one line of an exported batch
+type exportedRecord struct {
+	Form         string                 `json:"form"`
+	Key          string                 `json:"key"`
+	SubmissionID string                 `json:"submission_id,omitempty"`
+	SavedAt      time.Time              `json:"saved_at"`
+	Data         map[string]interface{} `json:"data"`
+	Transcript   interface{}            `json:"transcript,omitempty"`
+}
+
+// warehouseExporter uploads the records saved after its watermark, the
+// cutoff of the last run that finished, which is kept in a file so a restart
+// does not export everything again
+type warehouseExporter struct {
+	mu        sync.Mutex
+	config    Configuration
+	store     objectStore
+	watermark string
+}
+
+// configureWarehouseExport starts the periodic export when a bucket is configured
+func configureWarehouseExport(config Configuration) {
+	export := config.WarehouseExport
+	if export.Bucket == "" || config.ChatOnly() {
+		return
+	}
+	accessKey, secretKey := os.Getenv(exportAccessKeyEnv), os.Getenv(exportSecretKeyEnv)
+	if accessKey == "" || secretKey == "" {
+		log.Printf("❌ EXPORT: %s and %s must be set to export to %s, export disabled", exportAccessKeyEnv, exportSecretKeyEnv, export.Bucket)
+		return
+	}
+	exporter := &warehouseExporter{
+		config: config,
+		store: s3Store{
+			endpoint:  export.Endpoint,
+			bucket:    export.Bucket,
+			region:    export.RegionName(),
+			accessKey: accessKey,
+			secretKey: secretKey,
+			now:       time.Now,
+		},
+		watermark: filepath.Join(dataDir(config), "export_watermark.json"),
+	}
+	go exporter.run(export.IntervalDuration())
+}
+
+// run exports on every tick of interval
+func (e *warehouseExporter) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if _, err := e.export(now); err != nil {
+			log.Printf("❌ EXPORT: %v", err)
+		}
+	}
+}
+
+// readWatermark is the cutoff of the last finished export; every record saved
+// up to it has been uploaded
+func (e *warehouseExporter) readWatermark() time.Time {
+	var mark struct {
+		SavedAt time.Time `json:"saved_at"`
+	}
+	if data, err := os.ReadFile(e.watermark); err == nil {
+		json.Unmarshal(data, &mark)
+	}
+	return mark.SavedAt
+}
+
+func (e *warehouseExporter) writeWatermark(savedAt time.Time) error {
+	data, err := json.Marshal(map[string]time.Time{"saved_at": savedAt})
+	if err != nil {
+		return err
+	}
+	tmp := e.watermark + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, e.watermark)
+}
+
+// export uploads the records saved after the watermark and no later than now,
+// one object per form and day, and moves the watermark up to now once every
+// object is stored. The cutoff is fixed before the scan, so a record saved
+// while it runs is left for the next run rather than skipped. Object names
+// come from the watermark, so a run retried after a failed upload replaces
+// what the failed one stored instead of adding the same rows again. It
+// returns the number of records exported.
+func (e *warehouseExporter) export(now time.Time) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	since := e.readWatermark()
+	batches := make(map[string][]exportedRecord)
+	for _, form := range e.config.Forms.Form {
+		entries, err := os.ReadDir(formDataDir(e.config, form.Name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !isRecordFile(entry.Name()) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil || !info.ModTime().After(since) || info.ModTime().After(now) {
+				continue
+			}
+			record, err := readRecord(filepath.Join(formDataDir(e.config, form.Name), entry.Name()))
+			if err != nil {
+				log.Printf("⚠️ EXPORT [%s]: skipping %s: %v", form.Name, entry.Name(), err)
+				continue
+			}
+			line := exportedRecord{
+				Form:         form.Name,
+				Key:          strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name())),
+				SubmissionID: confirmationValue(record["_submission_id"]),
+				SavedAt:      info.ModTime().UTC(),
+				Data:         record,
+			}
+			if e.config.WarehouseExport.IncludeTranscripts {
+				line.Transcript = record["_transcript"]
+			}
+			delete(record, "_transcript")
+			partition := fmt.Sprintf("form=%s/dt=%s", form.Name, line.SavedAt.Format("2006-01-02"))
+			batches[partition] = append(batches[partition], line)
+		}
+	}
+
+	partitions := make([]string, 0, len(batches))
+	for partition := range batches {
+		partitions = append(partitions, partition)
+	}
+	sort.Strings(partitions)
+	prefix := strings.Trim(e.config.WarehouseExport.Prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	exported := 0
+	for _, partition := range partitions {
+		var body bytes.Buffer
+		enc := json.NewEncoder(&body)
+		for _, line := range batches[partition] {
+			if err := enc.Encode(line); err != nil {
+				return 0, err
+			}
+		}
+		key := fmt.Sprintf("%s%s/part-%s.jsonl", prefix, partition, since.UTC().Format("20060102T150405.000000000Z"))
+		if err := e.store.Put(key, "application/x-ndjson", body.Bytes()); err != nil {
+			// The watermark stays put, so the whole batch is tried again next run
+			return 0, fmt.Errorf("uploading %s: %v", key, err)
+		}
+		exported += len(batches[partition])
+	}
+	if err := e.writeWatermark(now); err != nil {
+		return exported, fmt.Errorf("saving watermark: %v", err)
+	}
+	if exported > 0 {
+		log.Printf("📦 EXPORT: uploaded %d records in %d objects to %s", exported, len(partitions), e.config.WarehouseExport.Bucket)
+	}
+	return exported, nil
+}
+
+// validateWarehouseExport checks the export settings when a bucket is set
+func validateWarehouseExport(export WarehouseExportConfig) error {
+	if export.Bucket == "" {
+		return nil
+	}
+	if err := validateBaseURL(export.Endpoint); err != nil {
+		return fmt.Errorf("invalid warehouse_export endpoint: %v", err)
+	}
+	if export.Interval != "" {
+		if d, err := time.ParseDuration(export.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("invalid warehouse_export interval %q", export.Interval)
+		}
+	}
+	return nil
+}
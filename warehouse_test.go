@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// memoryStore keeps uploaded objects in memory, failing every Put with err
+type memoryStore struct {
+	err     error
+	objects map[string][]byte
+}
+
+func (s *memoryStore) Put(key, contentType string, body []byte) error {
+	if s.objects == nil {
+		s.objects = make(map[string][]byte)
+	}
+	s.objects[key] = body
+	return s.err
+}
+
+// exportedKeys lists the records in each uploaded object by key
+func (s *memoryStore) exportedKeys(t *testing.T) map[string][]string {
+	t.Helper()
+	got := make(map[string][]string)
+	for key, body := range s.objects {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			var line exportedRecord
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				t.Fatalf("%s: %v", key, err)
+			}
+			got[key] = append(got[key], line.Key)
+		}
+		sort.Strings(got[key])
+	}
+	return got
+}
+
+func TestWarehouseExport(t *testing.T) {
+	now := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	const firstRun, sinceYesterday = "00010101T000000.000000000Z", "20261014T120000.000000000Z"
+	tests := []struct {
+		name          string
+		watermark     time.Time
+		uploadErr     error
+		want          map[string][]string
+		wantExported  int
+		wantWatermark time.Time
+	}{
+		{"first run", time.Time{}, nil, map[string][]string{
+			"exports/form=f/dt=2026-10-13/part-" + firstRun + ".jsonl": {"A1"},
+			"exports/form=f/dt=2026-10-15/part-" + firstRun + ".jsonl": {"B2", "C3"},
+		}, 3, now},
+		{"since the watermark", now.Add(-24 * time.Hour), nil, map[string][]string{
+			"exports/form=f/dt=2026-10-15/part-" + sinceYesterday + ".jsonl": {"B2", "C3"},
+		}, 2, now},
+		{"upload fails", now.Add(-24 * time.Hour), errors.New("503"), map[string][]string{
+			"exports/form=f/dt=2026-10-15/part-" + sinceYesterday + ".jsonl": {"B2", "C3"},
+		}, 0, now.Add(-24 * time.Hour)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := testConfig(t, testForm("f"))
+			config.WarehouseExport = WarehouseExportConfig{Bucket: "analytics", Prefix: "/exports/"}
+			for key, savedAt := range map[string]time.Time{
+				"A1": now.Add(-48 * time.Hour),
+				"B2": now.Add(-time.Hour),
+				"C3": now,
+				"D4": now.Add(time.Minute), // saved while the export runs
+			} {
+				savedRecord(t, config, "f", map[string]string{"FirstName": "Ann", "License": key})
+				path, _ := storedRecordPath(config, "f", key)
+				os.Chtimes(path, savedAt, savedAt)
+			}
+			store := &memoryStore{err: tt.uploadErr}
+			exporter := &warehouseExporter{config: config, store: store, watermark: filepath.Join(t.TempDir(), "export_watermark.json")}
+			if !tt.watermark.IsZero() {
+				exporter.writeWatermark(tt.watermark)
+			}
+
+			exported, err := exporter.export(now)
+			if (err != nil) != (tt.uploadErr != nil) || exported != tt.wantExported {
+				t.Errorf("export = %d, %v; want %d", exported, err, tt.wantExported)
+			}
+			if got := store.exportedKeys(t); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("uploaded %v, want %v", got, tt.want)
+			}
+			if got := exporter.readWatermark(); !got.Equal(tt.wantWatermark) {
+				t.Errorf("watermark = %v, want %v", got, tt.wantWatermark)
+			}
+		})
+	}
+}
+
+func TestWarehouseExportTranscripts(t *testing.T) {
+	for _, include := range []bool{false, true} {
+		form := testForm("f")
+		form.StoreTranscript = true
+		config := testConfig(t, form)
+		config.WarehouseExport = WarehouseExportConfig{Bucket: "analytics", IncludeTranscripts: include}
+		session := &ChatSession{
+			FormData: map[string]string{"FirstName": "Ann", "License": "A1"},
+			Messages: []ChatMessage{{Role: "user", Content: "I'm Ann"}},
+		}
+		if err := saveSession(config, "f", session, newTurnResult(config, "f", session)); err != nil {
+			t.Fatal(err)
+		}
+		store := &memoryStore{}
+		exporter := &warehouseExporter{config: config, store: store, watermark: filepath.Join(t.TempDir(), "mark.json")}
+		if _, err := exporter.export(time.Now().Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if len(store.objects) != 1 {
+			t.Fatalf("uploaded %d objects, want 1", len(store.objects))
+		}
+		for key, body := range store.objects {
+			var line exportedRecord
+			json.Unmarshal(body, &line)
+			if _, inData := line.Data["_transcript"]; inData || (line.Transcript != nil) != include {
+				t.Errorf("include_transcripts %v: %s has transcript %v, data %v", include, key, line.Transcript, line.Data)
+			}
+		}
+	}
+}
+
+func TestS3StorePut(t *testing.T) {
+	var got *http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		if strings.Contains(r.URL.EscapedPath(), "fail") {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer server.Close()
+	store := s3Store{
+		endpoint:  server.URL + "/",
+		bucket:    "analytics",
+		region:    "eu-west-1",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "secret",
+		now:       func() time.Time { return time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC) },
+	}
+	tests := []struct {
+		key      string
+		wantPath string
+		wantErr  bool
+	}{
+		{"form=f/dt=2026-10-15/part-1.jsonl", "/analytics/form%3Df/dt%3D2026-10-15/part-1.jsonl", false},
+		{"a b/fail.jsonl", "/analytics/a%20b/fail.jsonl", true},
+	}
+	for _, tt := range tests {
+		err := store.Put(tt.key, "application/x-ndjson", []byte("{}\n"))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: Put = %v, want error %v", tt.key, err, tt.wantErr)
+		}
+		if got.Method != http.MethodPut || got.URL.EscapedPath() != tt.wantPath {
+			t.Errorf("%s: %s %s, want PUT %s", tt.key, got.Method, got.URL.EscapedPath(), tt.wantPath)
+		}
+		auth := got.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20261015/eu-west-1/s3/aws4_request, ") ||
+			got.Header.Get("X-Amz-Date") != "20261015T120000Z" {
+			t.Errorf("%s: Authorization %q, X-Amz-Date %q", tt.key, auth, got.Header.Get("X-Amz-Date"))
+		}
+	}
+}
+
+func TestValidateWarehouseExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		export  WarehouseExportConfig
+		wantErr bool
+	}{
+		{"off", WarehouseExportConfig{}, false},
+		{"minio", WarehouseExportConfig{Bucket: "b", Endpoint: "http://minio:9000", Interval: "15m"}, false},
+		{"no endpoint", WarehouseExportConfig{Bucket: "b"}, true},
+		{"bad interval", WarehouseExportConfig{Bucket: "b", Endpoint: "https://s3.amazonaws.com", Interval: "hourly"}, true},
+		{"negative interval", WarehouseExportConfig{Bucket: "b", Endpoint: "https://s3.amazonaws.com", Interval: "-1h"}, true},
+	}
+	for _, tt := range tests {
+		if err := validateWarehouseExport(tt.export); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateWarehouseExport = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}